@@ -0,0 +1,275 @@
+package simplehttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ACCESS_LOG_JSON     = "json"
+	ACCESS_LOG_COMMON   = "common"   // Apache common log format
+	ACCESS_LOG_COMBINED = "combined" // Apache combined log format
+
+	APACHE_TIME_FORMAT = "02/Jan/2006:15:04:05 -0700"
+)
+
+// Templates of the Apache formats, see AccessLogConfig.Format
+var (
+	AccessLogCommonTemplate   = `${remote_ip} - ${user} [${time}] "${request}" ${status} ${bytes_clf}`
+	AccessLogCombinedTemplate = AccessLogCommonTemplate + ` "${referer}" "${user_agent}"`
+)
+
+// AccessLogConfig configures MiddlewareAccessLog
+type AccessLogConfig struct {
+	// Format is "json" (default), "common", "combined" or a template of
+	// ${field} placeholders: time, request_id, remote_ip, method, path, uri
+	// (with query), protocol, request ("GET /uri HTTP/1.1"), status, bytes,
+	// bytes_clf ("-" for 0), latency, latency_ms, user_agent, referer, user,
+	// error and header:<Name> for request headers
+	Format string
+	// TimeFormat of ${time} and JSON, default time.RFC3339 and the Apache
+	// format for "common" and "combined"
+	TimeFormat string
+	Output     io.Writer // default os.Stdout
+	File       string    // appended to instead of Output when set
+	Clock      Clock     // for the timestamps, nil means the one of the server
+	Skipper    Skipper
+}
+
+// AccessLogEntry is one request of the access log, and the JSON format
+type AccessLogEntry struct {
+	Time      string  `json:"time"`
+	RequestID string  `json:"request_id,omitempty"`
+	RemoteIP  string  `json:"remote_ip"`
+	Method    string  `json:"method"`
+	URI       string  `json:"uri"`
+	Protocol  string  `json:"protocol"`
+	Status    int     `json:"status"`
+	Bytes     int64   `json:"bytes"`
+	LatencyMs float64 `json:"latency_ms"`
+	UserAgent string  `json:"user_agent,omitempty"`
+	Referer   string  `json:"referer,omitempty"`
+	User      string  `json:"user,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// MiddlewareAccessLog writes one line per request, as JSON, in the Apache
+// common or combined format, or in a custom template:
+//
+//	server.Use(simplehttp.MiddlewareAccessLog(simplehttp.AccessLogConfig{
+//		Format: `${remote_ip} ${method} ${uri} ${status} ${bytes} ${latency_ms}ms ${request_id}`,
+//		File:   "/var/log/orders/access.log",
+//	}))
+//
+// It panics on an unknown template field or a File that can't be opened.
+func MiddlewareAccessLog(config AccessLogConfig) Middleware {
+	return Skip(WithName("access log", AccessLog(config)), config.Skipper)
+}
+
+// AccessLog logs the requests after the handler ran
+func AccessLog(config AccessLogConfig) MiddlewareFunc {
+	if config.Format == "" {
+		config.Format = ACCESS_LOG_JSON
+	}
+	template := config.Format
+	switch config.Format {
+	case ACCESS_LOG_COMMON:
+		template = AccessLogCommonTemplate
+	case ACCESS_LOG_COMBINED:
+		template = AccessLogCombinedTemplate
+	}
+	if config.TimeFormat == "" {
+		config.TimeFormat = time.RFC3339
+		if template != config.Format {
+			config.TimeFormat = APACHE_TIME_FORMAT
+		}
+	}
+	out := config.Output
+	if config.File != "" {
+		f, err := os.OpenFile(config.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			panic(fmt.Sprintf("simplehttp: access log: %v", err))
+		}
+		out = f
+	}
+	if out == nil {
+		out = os.Stdout
+	}
+
+	var format func(c Context, entry *AccessLogEntry) []byte
+	if config.Format == ACCESS_LOG_JSON {
+		format = func(_ Context, entry *AccessLogEntry) []byte {
+			b, _ := json.Marshal(entry)
+			return b
+		}
+	} else {
+		segments, err := parseAccessLogTemplate(template)
+		if err != nil {
+			panic("simplehttp: access log: " + err.Error())
+		}
+		format = func(c Context, entry *AccessLogEntry) []byte {
+			var b []byte
+			for _, s := range segments {
+				b = s(b, c, entry)
+			}
+			return b
+		}
+	}
+	var mu sync.Mutex
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			start := time.Now()
+			err := next(c)
+			entry := newAccessLogEntry(c, err, time.Since(start), clockFor(c, config.Clock).Now().Format(config.TimeFormat))
+			line := append(format(c, entry), '\n')
+			mu.Lock()
+			out.Write(line)
+			mu.Unlock()
+			return err
+		}
+	}
+}
+
+func newAccessLogEntry(c Context, err error, latency time.Duration, timestamp string) *AccessLogEntry {
+	entry := &AccessLogEntry{
+		Time:      timestamp,
+		RequestID: RequestIDOf(c),
+		Method:    c.GetMethod(),
+		URI:       c.GetPath(),
+		Protocol:  "HTTP/1.1",
+		Status:    statusOf(c, err),
+		Bytes:     c.GetResponseSize(),
+		LatencyMs: float64(latency.Microseconds()) / 1000,
+		UserAgent: c.GetHeader(HEADER_USER_AGENT),
+		Referer:   c.GetHeader("Referer"),
+	}
+	if r := c.Request(); r != nil {
+		entry.URI = r.URL.RequestURI()
+		if r.Proto != "" {
+			entry.Protocol = r.Proto
+		}
+	}
+	if headers := c.GetHeaders(); headers != nil {
+		entry.RemoteIP = StripPort(headers.IP())
+	}
+	if user, ok := GetPrincipal(c).(string); ok {
+		entry.User = user
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	return entry
+}
+
+type accessLogSegment func(b []byte, c Context, e *AccessLogEntry) []byte
+
+// parseAccessLogTemplate compiles the template once, every request only
+// appends the segments
+func parseAccessLogTemplate(template string) ([]accessLogSegment, error) {
+	var segments []accessLogSegment
+	for template != "" {
+		start := strings.Index(template, "${")
+		if start < 0 {
+			segments = append(segments, literalSegment(template))
+			break
+		}
+		end := strings.Index(template[start:], "}")
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed ${ in %q", template)
+		}
+		if start > 0 {
+			segments = append(segments, literalSegment(template[:start]))
+		}
+		field := template[start+2 : start+end]
+		segment, err := fieldSegment(field)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, segment)
+		template = template[start+end+1:]
+	}
+	return segments, nil
+}
+
+func literalSegment(s string) accessLogSegment {
+	return func(b []byte, _ Context, _ *AccessLogEntry) []byte { return append(b, s...) }
+}
+
+func fieldSegment(field string) (accessLogSegment, error) {
+	if name, ok := strings.CutPrefix(field, "header:"); ok {
+		return func(b []byte, c Context, _ *AccessLogEntry) []byte { return appendDash(b, c.GetHeader(name)) }, nil
+	}
+	text := func(get func(e *AccessLogEntry) string) accessLogSegment {
+		return func(b []byte, _ Context, e *AccessLogEntry) []byte { return appendDash(b, get(e)) }
+	}
+	switch field {
+	case "time":
+		return text(func(e *AccessLogEntry) string { return e.Time }), nil
+	case "request_id":
+		return text(func(e *AccessLogEntry) string { return e.RequestID }), nil
+	case "remote_ip":
+		return text(func(e *AccessLogEntry) string { return e.RemoteIP }), nil
+	case "method":
+		return text(func(e *AccessLogEntry) string { return e.Method }), nil
+	case "path":
+		return text(func(e *AccessLogEntry) string { path, _, _ := strings.Cut(e.URI, "?"); return path }), nil
+	case "uri":
+		return text(func(e *AccessLogEntry) string { return e.URI }), nil
+	case "protocol":
+		return text(func(e *AccessLogEntry) string { return e.Protocol }), nil
+	case "request":
+		return text(func(e *AccessLogEntry) string { return e.Method + " " + e.URI + " " + e.Protocol }), nil
+	case "status":
+		return text(func(e *AccessLogEntry) string { return strconv.Itoa(e.Status) }), nil
+	case "bytes":
+		return text(func(e *AccessLogEntry) string { return strconv.FormatInt(e.Bytes, 10) }), nil
+	case "bytes_clf":
+		return text(func(e *AccessLogEntry) string {
+			if e.Bytes == 0 {
+				return "-"
+			}
+			return strconv.FormatInt(e.Bytes, 10)
+		}), nil
+	case "latency":
+		return text(func(e *AccessLogEntry) string {
+			return (time.Duration(e.LatencyMs * float64(time.Millisecond))).String()
+		}), nil
+	case "latency_ms":
+		return text(func(e *AccessLogEntry) string { return strconv.FormatFloat(e.LatencyMs, 'f', 3, 64) }), nil
+	case "user_agent":
+		return text(func(e *AccessLogEntry) string { return e.UserAgent }), nil
+	case "referer":
+		return text(func(e *AccessLogEntry) string { return e.Referer }), nil
+	case "user":
+		return text(func(e *AccessLogEntry) string { return e.User }), nil
+	case "error":
+		return text(func(e *AccessLogEntry) string { return e.Error }), nil
+	}
+	return nil, fmt.Errorf("unknown field ${%s}", field)
+}
+
+// appendDash appends "-" for empty values as in the Apache formats, quotes
+// and control characters are escaped so a value can't forge a log line
+func appendDash(b []byte, s string) []byte {
+	if s == "" {
+		return append(b, '-')
+	}
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; {
+		case ch == '"' || ch == '\\':
+			b = append(b, '\\', ch)
+		case ch < ' ' || ch == 0x7f:
+			b = append(b, fmt.Sprintf(`\x%02x`, ch)...)
+		default:
+			b = append(b, ch)
+		}
+	}
+	return b
+}
@@ -0,0 +1,337 @@
+package simplehttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DEFAULT_ACL_RELOAD_INTERVAL = 2 * time.Second
+	CONTENT_TYPE_YAML           = "application/yaml"
+
+	ACL_ALLOW = "allow"
+	ACL_DENY  = "deny"
+)
+
+// ACLRule lets the requests of Path and Methods through when they come from
+// any of Roles, Keys or IPs. A rule without any of them is open to all,
+// Deny blocks everyone it matches.
+type ACLRule struct {
+	// Path of the request, "*" is one segment and a trailing "/**" anything
+	// below, e.g. "/admin/**" or "/users/*/orders"
+	Path    string   `json:"path" yaml:"path"`
+	Methods []string `json:"methods,omitempty" yaml:"methods,omitempty"` // empty means all
+	Roles   []string `json:"roles,omitempty" yaml:"roles,omitempty"`     // checked by ACLConfig.Authorizer
+	// Keys are identities, the API key principal or the "sub" claim
+	Keys []string `json:"keys,omitempty" yaml:"keys,omitempty"`
+	IPs  []string `json:"ips,omitempty" yaml:"ips,omitempty"` // CIDRs or plain IPs
+	Deny bool     `json:"deny,omitempty" yaml:"deny,omitempty"`
+
+	nets      []*net.IPNet
+	lowerPath string // for the match ignoring case
+}
+
+// ACLPolicy is the content of the ACL file. The first rule matching the
+// path and method decides, requests matching none get Default, "deny"
+// unless "allow". Routers normalize paths differently (fiber ignores case
+// and trailing slashes), so the path must pass as sent, cleaned of "..",
+// "//" and trailing slashes, and matched ignoring case: a Deny rule can't
+// be worked around, keep Default "deny" so allow rules can't either.
+//
+//	{
+//	  "default": "deny",
+//	  "rules": [
+//	    {"path": "/health"},
+//	    {"path": "/admin/**", "roles": ["admin"], "ips": ["10.0.0.0/8"]},
+//	    {"path": "/reports/**", "methods": ["GET"], "keys": ["billing-service"]},
+//	    {"path": "/legacy/**", "deny": true}
+//	  ]
+//	}
+type ACLPolicy struct {
+	Default string    `json:"default" yaml:"default"`
+	Rules   []ACLRule `json:"rules" yaml:"rules"`
+}
+
+// ACLConfig configures NewACL
+type ACLConfig struct {
+	// File holds the ACLPolicy in JSON, or YAML (.yaml, .yml) with a codec
+	// registered for application/yaml, required
+	File string
+	// ReloadInterval is how often the file is checked for changes, default
+	// 2s, negative disables. A file that doesn't parse is logged and the
+	// rules loaded before stay.
+	ReloadInterval time.Duration
+	Authorizer     Authorizer // checks Roles, defaults to DefaultAuthorizer
+	Skipper        Skipper
+}
+
+// ACL enforces the rules of a file, reloaded when it changes so policies are
+// adjusted without a redeploy. It runs after the authentication middleware,
+// Keys and Roles need the identity.
+type ACL struct {
+	config ACLConfig
+	policy atomic.Pointer[ACLPolicy]
+
+	mu      sync.Mutex // serializes reloads
+	modTime time.Time
+	size    int64
+	done    chan struct{}
+	closed  sync.Once
+}
+
+func NewACL(config ACLConfig) (*ACL, error) {
+	if config.File == "" {
+		panic("simplehttp: ACLConfig.File is required")
+	}
+	if config.ReloadInterval == 0 {
+		config.ReloadInterval = DEFAULT_ACL_RELOAD_INTERVAL
+	}
+	if config.Authorizer == nil {
+		config.Authorizer = DefaultAuthorizer
+	}
+	a := &ACL{config: config, done: make(chan struct{})}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	if config.ReloadInterval > 0 {
+		go a.watch()
+	}
+	return a, nil
+}
+
+// MiddlewareACL panics when the file can't be loaded, use NewACL to handle
+// the error
+//
+//	server.Use(simplehttp.MiddlewareOIDC(oidcConfig))
+//	server.Use(simplehttp.MiddlewareACL(simplehttp.ACLConfig{File: "acl.json"}))
+func MiddlewareACL(config ACLConfig) Middleware {
+	acl, err := NewACL(config)
+	if err != nil {
+		panic("simplehttp: " + err.Error())
+	}
+	return Skip(acl.Middleware(), config.Skipper)
+}
+
+// Middleware refuses requests the rules don't allow, with 401 when a rule
+// needs an identity the request doesn't have and 403 otherwise
+func (a *ACL) Middleware() Middleware {
+	return WithName("acl", func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			if err := a.Check(c); err != nil {
+				return err
+			}
+			return next(c)
+		}
+	})
+}
+
+// Check returns nil when the current rules allow the request, see ACLPolicy
+// for the paths checked
+func (a *ACL) Check(c Context) error {
+	policy := a.policy.Load()
+	method, requestPath := c.GetMethod(), c.Request().URL.Path
+	if err := a.check(c, policy, method, requestPath, false); err != nil {
+		return err
+	}
+	cleaned := cleanACLPath(requestPath)
+	if cleaned != requestPath {
+		if err := a.check(c, policy, method, cleaned, false); err != nil {
+			return err
+		}
+	}
+	return a.check(c, policy, method, strings.ToLower(cleaned), true)
+}
+
+// cleanACLPath resolves "." and ".." segments, double slashes and the
+// trailing slash
+func cleanACLPath(requestPath string) string {
+	return path.Clean("/" + requestPath)
+}
+
+func (a *ACL) check(c Context, policy *ACLPolicy, method, requestPath string, foldCase bool) error {
+	for i := range policy.Rules {
+		rule := &policy.Rules[i]
+		if !rule.matches(method, requestPath, foldCase) {
+			continue
+		}
+		if rule.Deny {
+			return NewError(http.StatusForbidden, ErrForbidden.Error())
+		}
+		return a.allowed(c, rule)
+	}
+	if policy.Default == ACL_ALLOW {
+		return nil
+	}
+	return NewError(http.StatusForbidden, ErrForbidden.Error())
+}
+
+func (a *ACL) allowed(c Context, rule *ACLRule) error {
+	if len(rule.Roles) == 0 && len(rule.Keys) == 0 && len(rule.nets) == 0 {
+		return nil
+	}
+	if len(rule.nets) > 0 {
+		if ip := net.ParseIP(StripPort(c.GetHeaders().IP())); ip != nil && IPInNets(ip, rule.nets) {
+			return nil
+		}
+	}
+	subject := requestSubject(c)
+	if len(rule.Keys) > 0 && subject != "" && containsAny([]string{subject}, rule.Keys) {
+		return nil
+	}
+	anonymous := GetPrincipal(c) == nil && requestClaims(c) == nil
+	if len(rule.Roles) > 0 && !anonymous {
+		allowed, err := a.config.Authorizer.Authorize(c, AuthorizePolicy{Roles: rule.Roles})
+		if err != nil && !errors.Is(err, ErrUnauthorized) {
+			return NewError(http.StatusInternalServerError, "authorization failed", err.Error())
+		}
+		if allowed {
+			return nil
+		}
+	}
+	if anonymous && (len(rule.Roles) > 0 || len(rule.Keys) > 0) {
+		return NewError(http.StatusUnauthorized, ErrUnauthorized.Error())
+	}
+	return NewError(http.StatusForbidden, ErrForbidden.Error())
+}
+
+// Policy returns the rules in use
+func (a *ACL) Policy() ACLPolicy {
+	return *a.policy.Load()
+}
+
+// Reload reads the file now, the rules in use stay when it fails
+func (a *ACL) Reload() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	stat, err := os.Stat(a.config.File)
+	if err != nil {
+		return fmt.Errorf("acl: %w", err)
+	}
+	policy, err := readACLPolicy(a.config.File)
+	if err != nil {
+		return err
+	}
+	a.policy.Store(policy)
+	a.modTime, a.size = stat.ModTime(), stat.Size()
+	return nil
+}
+
+// Close stops watching the file
+func (a *ACL) Close() {
+	a.closed.Do(func() { close(a.done) })
+}
+
+func (a *ACL) watch() {
+	ticker := time.NewTicker(a.config.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			stat, err := os.Stat(a.config.File)
+			if err != nil {
+				continue
+			}
+			a.mu.Lock()
+			changed := !stat.ModTime().Equal(a.modTime) || stat.Size() != a.size
+			a.mu.Unlock()
+			if !changed {
+				continue
+			}
+			if err := a.Reload(); err != nil {
+				NewDefaultLogger().Errorf("%v, keeping the previous rules", err)
+				// don't report it again until the file changes
+				a.mu.Lock()
+				a.modTime, a.size = stat.ModTime(), stat.Size()
+				a.mu.Unlock()
+				continue
+			}
+			NewDefaultLogger().Infof("acl: reloaded %s", a.config.File)
+		case <-a.done:
+			return
+		}
+	}
+}
+
+func readACLPolicy(file string) (*ACLPolicy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("acl: %w", err)
+	}
+	var policy ACLPolicy
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		codec, ok := CodecFor(CONTENT_TYPE_YAML)
+		if !ok {
+			return nil, fmt.Errorf("acl: %s: register a codec for %s to read YAML", file, CONTENT_TYPE_YAML)
+		}
+		err = codec.Unmarshal(data, &policy)
+	default:
+		err = json.Unmarshal(data, &policy)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("acl: %s: %w", file, err)
+	}
+
+	switch policy.Default {
+	case "":
+		policy.Default = ACL_DENY
+	case ACL_ALLOW, ACL_DENY:
+	default:
+		return nil, fmt.Errorf("acl: %s: default must be %q or %q", file, ACL_ALLOW, ACL_DENY)
+	}
+	for i := range policy.Rules {
+		rule := &policy.Rules[i]
+		if !strings.HasPrefix(rule.Path, "/") {
+			return nil, fmt.Errorf("acl: %s: rule %d: path must start with /", file, i+1)
+		}
+		if _, err := path.Match(strings.TrimSuffix(rule.Path, "/**"), ""); err != nil {
+			return nil, fmt.Errorf("acl: %s: rule %d: %w", file, i+1, err)
+		}
+		if rule.nets, err = ParseCIDRs(rule.IPs); err != nil {
+			return nil, fmt.Errorf("acl: %s: rule %d: %w", file, i+1, err)
+		}
+		for j, method := range rule.Methods {
+			rule.Methods[j] = strings.ToUpper(method)
+		}
+		rule.lowerPath = strings.ToLower(rule.Path)
+	}
+	return &policy, nil
+}
+
+// matches reports whether the rule applies, requestPath is lower case when
+// foldCase
+func (r *ACLRule) matches(method, requestPath string, foldCase bool) bool {
+	if len(r.Methods) > 0 && !containsAny([]string{method}, r.Methods) {
+		return false
+	}
+	rulePath := r.Path
+	if foldCase {
+		rulePath = r.lowerPath
+	}
+	if prefix, ok := strings.CutSuffix(rulePath, "/**"); ok {
+		// the prefix itself and anything below it
+		if matched, _ := path.Match(prefix, requestPath); matched {
+			return true
+		}
+		segments := strings.Count(prefix, "/")
+		parts := strings.SplitAfterN(requestPath, "/", segments+2)
+		if len(parts) <= segments+1 {
+			return false
+		}
+		head := strings.TrimSuffix(strings.Join(parts[:segments+1], ""), "/")
+		matched, _ := path.Match(prefix, head)
+		return matched
+	}
+	matched, _ := path.Match(rulePath, requestPath)
+	return matched
+}
@@ -0,0 +1,107 @@
+package simplehttp
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	ACME_CHALLENGE_PATH = "/.well-known/acme-challenge/"
+)
+
+// ACMEChallengeConfig tells where HTTP-01 challenge responses come from when
+// an external cert manager (certbot, lego, ...) does the renewals while
+// simplehttp owns port 80. The first source that has the token wins.
+type ACMEChallengeConfig struct {
+	// Dir is the webroot given to the cert manager, e.g. certbot --webroot -w Dir
+	// writes tokens to Dir/.well-known/acme-challenge/<token>
+	Dir string
+	// Store holds key authorizations under "acme:<token>", for manual auth
+	// hooks that push tokens instead of writing files
+	Store CacheStore
+	// Upstream is a cert manager listening elsewhere, e.g. certbot --standalone
+	// --http-01-port 8402 with Upstream "http://127.0.0.1:8402"
+	Upstream string
+	Client   *http.Client // for Upstream, defaults to a client with a 10s timeout
+}
+
+// MountACMEChallenge registers the challenge route, put it on the server
+// listening on port 80:
+//
+//	simplehttp.MountACMEChallenge(server, simplehttp.ACMEChallengeConfig{Dir: "/var/www/certbot"})
+func MountACMEChallenge(r Router, config ACMEChallengeConfig) {
+	r.GET(ACME_CHALLENGE_PATH+":token", ACMEChallenge(config))
+}
+
+// ACMEChallenge answers HTTP-01 challenges from the configured sources
+func ACMEChallenge(config ACMEChallengeConfig) HandlerFunc {
+	if config.Dir == "" && config.Store == nil && config.Upstream == "" {
+		panic("simplehttp: ACMEChallengeConfig needs a Dir, Store or Upstream")
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	upstream := strings.TrimSuffix(config.Upstream, "/")
+
+	return func(c Context) error {
+		// echo reports the route pattern as path, take the token from the URL
+		token := strings.TrimPrefix(c.Request().URL.Path, ACME_CHALLENGE_PATH)
+		if !validACMEToken(token) {
+			return NewError(http.StatusNotFound, "challenge not found")
+		}
+
+		if config.Store != nil {
+			if cached, found := config.Store.Get("acme:" + token); found {
+				switch v := cached.(type) {
+				case string:
+					return c.Blob(http.StatusOK, "text/plain", []byte(v))
+				case []byte:
+					return c.Blob(http.StatusOK, "text/plain", v)
+				}
+			}
+		}
+
+		if config.Dir != "" {
+			data, err := os.ReadFile(filepath.Join(config.Dir, filepath.FromSlash(ACME_CHALLENGE_PATH), token))
+			if err == nil {
+				return c.Blob(http.StatusOK, "text/plain", data)
+			}
+		}
+
+		if upstream != "" {
+			resp, err := config.Client.Get(upstream + ACME_CHALLENGE_PATH + token)
+			if err != nil {
+				return NewError(http.StatusBadGateway, "challenge upstream unavailable", err.Error())
+			}
+			defer resp.Body.Close()
+			data, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+			if err != nil {
+				return NewError(http.StatusBadGateway, "challenge upstream unavailable", err.Error())
+			}
+			if resp.StatusCode == http.StatusOK {
+				return c.Blob(http.StatusOK, "text/plain", data)
+			}
+		}
+		return NewError(http.StatusNotFound, "challenge not found")
+	}
+}
+
+// validACMEToken allows only the base64url alphabet ACME tokens use, which
+// also keeps the token from escaping Dir
+func validACMEToken(token string) bool {
+	if token == "" {
+		return false
+	}
+	for _, r := range token {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
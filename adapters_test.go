@@ -0,0 +1,167 @@
+package simplehttp_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/medatechnology/simplehttp"
+	"github.com/medatechnology/simplehttp/framework/echo"
+	"github.com/medatechnology/simplehttp/framework/fasthttp"
+	"github.com/medatechnology/simplehttp/framework/fiber"
+)
+
+// adapters builds a server of every framework package, options apply after
+// the quiet config
+var adapters = map[string]func(options ...simplehttp.ServerOption) simplehttp.Server{
+	"echo": func(options ...simplehttp.ServerOption) simplehttp.Server {
+		return echo.NewServer(nil, append([]simplehttp.ServerOption{simplehttp.WithConfig(quiet)}, options...)...)
+	},
+	"fiber": func(options ...simplehttp.ServerOption) simplehttp.Server {
+		return fiber.NewServer(nil, append([]simplehttp.ServerOption{simplehttp.WithConfig(quiet)}, options...)...)
+	},
+	"fasthttp": func(options ...simplehttp.ServerOption) simplehttp.Server {
+		return fasthttp.NewServer(nil, append([]simplehttp.ServerOption{simplehttp.WithConfig(quiet)}, options...)...)
+	},
+}
+
+func quiet(c *simplehttp.Config) {
+	c.FrameworkStartupMessage = false
+}
+
+// TestResponseHeaderContract runs the same handler and middleware on every
+// adapter, c.Response().Header() and SetResponseHeader must behave the same
+// before and after next, and when the handler fails
+func TestResponseHeaderContract(t *testing.T) {
+	ok := func(c simplehttp.Context) error {
+		c.Response().Header().Set("X-Handler", "handler")
+		return c.String(http.StatusOK, "ok")
+	}
+	fail := func(c simplehttp.Context) error {
+		c.Response().Header().Set("X-Handler", "handler")
+		return simplehttp.NewError(http.StatusTeapot, "teapot")
+	}
+	around := func(before, after func(h http.Header)) simplehttp.Middleware {
+		return simplehttp.WithName("contract", func(next simplehttp.HandlerFunc) simplehttp.HandlerFunc {
+			return func(c simplehttp.Context) error {
+				if before != nil {
+					before(c.Response().Header())
+				}
+				err := next(c)
+				if after != nil {
+					after(c.Response().Header())
+				}
+				return err
+			}
+		})
+	}
+
+	tests := []struct {
+		name    string
+		handler simplehttp.HandlerFunc
+		mw      simplehttp.Middleware
+		status  int
+		want    map[string][]string // nil values must be absent
+	}{
+		{
+			name:    "set in handler",
+			handler: ok,
+			mw:      around(nil, nil),
+			status:  http.StatusOK,
+			want:    map[string][]string{"X-Handler": {"handler"}},
+		},
+		{
+			name:    "set before next",
+			handler: ok,
+			mw:      around(func(h http.Header) { h.Set("X-Before", "1") }, nil),
+			status:  http.StatusOK,
+			want:    map[string][]string{"X-Before": {"1"}, "X-Handler": {"handler"}},
+		},
+		{
+			name:    "add before next",
+			handler: ok,
+			mw:      around(func(h http.Header) { h.Add("X-Multi", "a"); h.Add("X-Multi", "b") }, nil),
+			status:  http.StatusOK,
+			want:    map[string][]string{"X-Multi": {"a", "b"}},
+		},
+		{
+			name:    "del before next",
+			handler: ok,
+			mw:      around(func(h http.Header) { h.Set("X-Gone", "1"); h.Del("X-Gone") }, nil),
+			status:  http.StatusOK,
+			want:    map[string][]string{"X-Gone": nil, "X-Handler": {"handler"}},
+		},
+		{
+			name:    "set after next",
+			handler: ok,
+			mw:      around(nil, func(h http.Header) { h.Set("X-After", "1") }),
+			status:  http.StatusOK,
+			want:    map[string][]string{"X-After": {"1"}, "X-Handler": {"handler"}},
+		},
+		{
+			name:    "add after next",
+			handler: ok,
+			mw:      around(nil, func(h http.Header) { h.Add("X-Handler", "after") }),
+			status:  http.StatusOK,
+			want:    map[string][]string{"X-Handler": {"handler", "after"}},
+		},
+		{
+			name:    "del after next",
+			handler: ok,
+			mw:      around(nil, func(h http.Header) { h.Del("X-Handler") }),
+			status:  http.StatusOK,
+			want:    map[string][]string{"X-Handler": nil},
+		},
+		{
+			name:    "set before and after next on error",
+			handler: fail,
+			mw: around(func(h http.Header) { h.Set("X-Before", "1") },
+				func(h http.Header) { h.Set("X-After", "1") }),
+			status: http.StatusTeapot,
+			want:   map[string][]string{"X-Before": {"1"}, "X-After": {"1"}, "X-Handler": {"handler"}},
+		},
+		{
+			name:    "del after next on error",
+			handler: fail,
+			mw:      around(nil, func(h http.Header) { h.Del("X-Handler") }),
+			status:  http.StatusTeapot,
+			want:    map[string][]string{"X-Handler": nil},
+		},
+		{
+			name:    "SetResponseHeader after next",
+			handler: ok,
+			mw: simplehttp.WithName("contract", func(next simplehttp.HandlerFunc) simplehttp.HandlerFunc {
+				return func(c simplehttp.Context) error {
+					err := next(c)
+					c.SetResponseHeader("X-After", "1")
+					return err
+				}
+			}),
+			status: http.StatusOK,
+			want:   map[string][]string{"X-After": {"1"}, "X-Handler": {"handler"}},
+		},
+	}
+
+	for name, newServer := range adapters {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				server := newServer()
+				server.GET("/contract", tt.handler, tt.mw)
+				resp, err := server.(simplehttp.Dispatcher).Dispatch(httptest.NewRequest(http.MethodGet, "/contract", nil))
+				if err != nil {
+					t.Fatalf("dispatch: %v", err)
+				}
+				defer resp.Body.Close()
+				if resp.StatusCode != tt.status {
+					t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+				}
+				for key, want := range tt.want {
+					if got := resp.Header.Values(key); !slices.Equal(got, want) {
+						t.Errorf("%s = %q, want %q", key, got, want)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package simplehttp
+
+import (
+	"context"
+	_ "embed"
+	"net/http"
+	"runtime"
+	"sort"
+	"time"
+)
+
+const (
+	DEFAULT_ADMIN_TITLE          = "simplehttp admin"
+	DEFAULT_ADMIN_HEALTH_TIMEOUT = DEFAULT_HEALTH_TIMEOUT
+)
+
+var (
+	PathInternalAdmin string = "/admin"
+
+	//go:embed admin/index.html
+	adminPage []byte
+
+	adminStarted = time.Now()
+)
+
+// AdminConfig tells the admin UI what to show, every source is optional
+// except Auth
+type AdminConfig struct {
+	Title         string
+	Auth          Middleware // required, e.g. MiddlewareBasicAuth or an OIDC middleware
+	Server        Server     // routes and middleware, when it implements ServerInspector
+	HealthChecks  map[string]func(context.Context) error
+	HealthTimeout time.Duration                 // per check, default 5s
+	Metrics       map[string]func() interface{} // snapshots shown as JSON, e.g. tracker.Report
+	Caches        map[string]CacheStore         // stores implementing CacheStatsProvider show their stats
+	WebSockets    *WebSocketStats               // defaults to DefaultWebSocketStats
+}
+
+// AdminSnapshot is the data behind the admin UI, served as JSON with ?format=json
+type AdminSnapshot struct {
+	Title      string                 `json:"title"`
+	Time       time.Time              `json:"time"`
+	Uptime     string                 `json:"uptime"`
+	Goroutines int                    `json:"goroutines"`
+	HeapAlloc  uint64                 `json:"heap_alloc"`
+	Routes     []RouteInfo            `json:"routes"`
+	Middleware []string               `json:"middleware"`
+	Health     map[string]string      `json:"health"` // "ok", "degraded: " and the error, or the error
+	Metrics    map[string]interface{} `json:"metrics"`
+	Caches     map[string]CacheStats  `json:"caches"`
+	WebSockets []WebSocketRoute       `json:"websockets"`
+}
+
+// MountAdminUI registers the admin UI behind config.Auth, mount it on the
+// internal API:
+//
+//	internal := simplehttp.CreateInternalAPI(server)
+//	simplehttp.MountAdminUI(internal, simplehttp.AdminConfig{
+//		Auth:   simplehttp.MiddlewareBasicAuth("admin", os.Getenv("ADMIN_PASSWORD")),
+//		Server: server,
+//	})
+func MountAdminUI(r Router, config AdminConfig) {
+	r.GET(PathInternalAdmin, AdminUI(config), config.Auth)
+}
+
+// AdminUI serves the embedded page, and the AdminSnapshot it polls with
+// ?format=json. The handler has no auth of its own.
+func AdminUI(config AdminConfig) HandlerFunc {
+	if config.Auth == nil {
+		panic("simplehttp: AdminConfig.Auth is required")
+	}
+	if config.Title == "" {
+		config.Title = DEFAULT_ADMIN_TITLE
+	}
+	if config.HealthTimeout == 0 {
+		config.HealthTimeout = DEFAULT_ADMIN_HEALTH_TIMEOUT
+	}
+	if config.WebSockets == nil {
+		config.WebSockets = DefaultWebSocketStats
+	}
+
+	return func(c Context) error {
+		c.SetResponseHeader("Cache-Control", "no-store")
+		if c.GetQueryParam("format") != "json" {
+			return c.Blob(http.StatusOK, "text/html; charset=utf-8", adminPage)
+		}
+		return c.JSON(http.StatusOK, adminSnapshot(c.Context(), config))
+	}
+}
+
+func adminSnapshot(ctx context.Context, config AdminConfig) AdminSnapshot {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	snapshot := AdminSnapshot{
+		Title:      config.Title,
+		Time:       time.Now(),
+		Uptime:     time.Since(adminStarted).Round(time.Second).String(),
+		Goroutines: runtime.NumGoroutine(),
+		HeapAlloc:  mem.HeapAlloc,
+		Health:     runHealthChecks(ctx, config.HealthChecks, config.HealthTimeout),
+		Metrics:    make(map[string]interface{}, len(config.Metrics)),
+		Caches:     make(map[string]CacheStats, len(config.Caches)),
+		WebSockets: config.WebSockets.Snapshot(),
+	}
+	if inspector, ok := config.Server.(ServerInspector); ok {
+		snapshot.Routes = inspector.Routes()
+		snapshot.Middleware = inspector.MiddlewareNames()
+	}
+	for name, metric := range config.Metrics {
+		snapshot.Metrics[name] = metric()
+	}
+	for name, store := range config.Caches {
+		if provider, ok := store.(CacheStatsProvider); ok {
+			snapshot.Caches[name] = provider.Stats()
+		}
+	}
+	return snapshot
+}
+
+// runHealthChecks runs the checks in parallel, each with its own timeout
+func runHealthChecks(ctx context.Context, checks map[string]func(context.Context) error, timeout time.Duration) map[string]string {
+	results := make(map[string]string, len(checks))
+	for name, err := range runChecks(ctx, checks, timeout) {
+		switch healthStatus(err, false) {
+		case HEALTH_UP:
+			results[name] = "ok"
+		case HEALTH_DEGRADED:
+			results[name] = HEALTH_DEGRADED + ": " + err.Error()
+		default:
+			results[name] = err.Error()
+		}
+	}
+	return results
+}
+
+// SortRoutes orders routes by path then method, used by the servers'
+// ServerInspector implementations
+func SortRoutes(routes []RouteInfo) {
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+}
@@ -0,0 +1,311 @@
+package simplehttp
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_ANOMALY_WINDOW   = time.Minute
+	DEFAULT_ANOMALY_COOLDOWN = time.Minute
+
+	// buckets per window, the window slides by window/ANOMALY_BUCKETS
+	ANOMALY_BUCKETS = 6
+)
+
+// TrafficEvent is one finished request as seen by the profiler
+type TrafficEvent struct {
+	Time   time.Time
+	IP     string
+	Method string
+	Path   string
+	Status int
+}
+
+// Anomaly is reported by a detector. IP is empty for anomalies that are
+// not caused by a single client (e.g. a route spike).
+type Anomaly struct {
+	Detector string        `json:"detector"`
+	IP       string        `json:"ip,omitempty"`
+	Route    string        `json:"route,omitempty"`
+	Count    int64         `json:"count"`
+	Window   time.Duration `json:"window"`
+	Message  string        `json:"message"`
+	Time     time.Time     `json:"time"`
+}
+
+// AnomalyDetector looks at every request with the profiler counters already
+// updated, and returns an anomaly or nil
+type AnomalyDetector interface {
+	Name() string
+	Detect(p *TrafficProfiler, e TrafficEvent) *Anomaly
+}
+
+// AnomalyConfig configures a TrafficProfiler
+type AnomalyConfig struct {
+	Window    time.Duration     // sliding window of the counters, default 1m
+	Detectors []AnomalyDetector // e.g. IPSpikeDetector, ScanDetector, RouteSpikeDetector
+	Actions   []func(Anomaly)   // called for each anomaly, e.g. BanAction, LogAction
+	Cooldown  time.Duration     // an anomaly of the same detector and subject is reported once per Cooldown, default 1m
+	Skipper   Skipper
+}
+
+// TrafficProfiler counts requests per IP and per route over a sliding window
+// and runs the detectors on them
+type TrafficProfiler struct {
+	config   AnomalyConfig
+	bucket   time.Duration
+	mu       sync.Mutex
+	counters map[string]*windowCounter
+	reported map[string]time.Time
+	sweep    time.Time
+}
+
+func NewTrafficProfiler(config AnomalyConfig) *TrafficProfiler {
+	if config.Window == 0 {
+		config.Window = DEFAULT_ANOMALY_WINDOW
+	}
+	if config.Cooldown == 0 {
+		config.Cooldown = DEFAULT_ANOMALY_COOLDOWN
+	}
+	return &TrafficProfiler{
+		config:   config,
+		bucket:   config.Window / ANOMALY_BUCKETS,
+		counters: make(map[string]*windowCounter),
+		reported: make(map[string]time.Time),
+		sweep:    time.Now(),
+	}
+}
+
+func MiddlewareAnomaly(config AnomalyConfig) Middleware {
+	return NewTrafficProfiler(config).Middleware()
+}
+
+// Middleware records every request after the handler ran
+func (p *TrafficProfiler) Middleware() Middleware {
+	return Skip(WithName("anomaly detection", func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			err := next(c)
+			p.Record(TrafficEvent{
+				Time:   time.Now(),
+				IP:     StripPort(c.GetHeaders().IP()),
+				Method: c.GetMethod(),
+				Path:   c.GetPath(),
+				Status: statusOf(c, err),
+			})
+			return err
+		}
+	}), p.config.Skipper)
+}
+
+// Record counts the event and runs the detectors
+func (p *TrafficProfiler) Record(e TrafficEvent) {
+	p.add("ip:"+e.IP, e.Time)
+	p.add("route:"+e.Method+" "+e.Path, e.Time)
+	if isScanStatus(e.Status) {
+		p.add("ipfail:"+e.IP, e.Time)
+	}
+
+	for _, d := range p.config.Detectors {
+		anomaly := d.Detect(p, e)
+		if anomaly == nil || !p.report(anomaly) {
+			continue
+		}
+		for _, action := range p.config.Actions {
+			action(*anomaly)
+		}
+	}
+}
+
+// IPRequests is the number of requests of ip in the current window
+func (p *TrafficProfiler) IPRequests(ip string) int64 {
+	current, _ := p.count("ip:" + ip)
+	return current
+}
+
+// IPFailures is the number of 401/403/404/405 responses of ip in the current window
+func (p *TrafficProfiler) IPFailures(ip string) int64 {
+	current, _ := p.count("ipfail:" + ip)
+	return current
+}
+
+// RouteRequests returns the requests of a route ("GET /users") in the
+// current and in the previous window
+func (p *TrafficProfiler) RouteRequests(route string) (current, previous int64) {
+	return p.count("route:" + route)
+}
+
+func (p *TrafficProfiler) Window() time.Duration {
+	return p.config.Window
+}
+
+func (p *TrafficProfiler) add(key string, now time.Time) {
+	slot := now.UnixNano() / int64(p.bucket)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	counter, ok := p.counters[key]
+	if !ok {
+		counter = &windowCounter{}
+		p.counters[key] = counter
+	}
+	counter.add(slot)
+
+	// drop counters of clients that went quiet, the map would grow with
+	// every IP ever seen otherwise
+	if now.Sub(p.sweep) > 2*p.config.Window {
+		p.sweep = now
+		for k, c := range p.counters {
+			if slot-c.last >= 2*ANOMALY_BUCKETS {
+				delete(p.counters, k)
+			}
+		}
+		for k, t := range p.reported {
+			if now.Sub(t) > p.config.Cooldown {
+				delete(p.reported, k)
+			}
+		}
+	}
+}
+
+func (p *TrafficProfiler) count(key string) (current, previous int64) {
+	slot := time.Now().UnixNano() / int64(p.bucket)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	counter, ok := p.counters[key]
+	if !ok {
+		return 0, 0
+	}
+	return counter.count(slot)
+}
+
+func (p *TrafficProfiler) report(a *Anomaly) bool {
+	if a.Time.IsZero() {
+		a.Time = time.Now()
+	}
+	if a.Window == 0 {
+		a.Window = p.config.Window
+	}
+	key := a.Detector + "|" + a.IP + "|" + a.Route
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if last, ok := p.reported[key]; ok && a.Time.Sub(last) < p.config.Cooldown {
+		return false
+	}
+	p.reported[key] = a.Time
+	return true
+}
+
+// windowCounter keeps two windows of buckets, the current and the previous
+type windowCounter struct {
+	slots  [2 * ANOMALY_BUCKETS]int64
+	counts [2 * ANOMALY_BUCKETS]int64
+	last   int64
+}
+
+func (w *windowCounter) add(slot int64) {
+	i := slot % int64(len(w.slots))
+	if w.slots[i] != slot {
+		w.slots[i] = slot
+		w.counts[i] = 0
+	}
+	w.counts[i]++
+	w.last = slot
+}
+
+func (w *windowCounter) count(slot int64) (current, previous int64) {
+	for i, s := range w.slots {
+		switch age := slot - s; {
+		case age >= 0 && age < ANOMALY_BUCKETS:
+			current += w.counts[i]
+		case age >= ANOMALY_BUCKETS && age < 2*ANOMALY_BUCKETS:
+			previous += w.counts[i]
+		}
+	}
+	return current, previous
+}
+
+func isScanStatus(status int) bool {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusMethodNotAllowed:
+		return true
+	}
+	return false
+}
+
+// IPSpikeDetector reports clients sending more than Threshold requests per window
+type IPSpikeDetector struct {
+	Threshold int64
+}
+
+func (d IPSpikeDetector) Name() string { return "ip spike" }
+
+func (d IPSpikeDetector) Detect(p *TrafficProfiler, e TrafficEvent) *Anomaly {
+	if n := p.IPRequests(e.IP); n > d.Threshold {
+		return &Anomaly{Detector: d.Name(), IP: e.IP, Count: n,
+			Message: fmt.Sprintf("%d requests in %s", n, p.Window())}
+	}
+	return nil
+}
+
+// ScanDetector reports clients collecting more than Threshold 401, 403, 404
+// or 405 per window: path scanning, id enumeration or credential guessing.
+// Paths without a route never reach middleware, so only failures of existing
+// routes are counted.
+type ScanDetector struct {
+	Threshold int64
+}
+
+func (d ScanDetector) Name() string { return "scan" }
+
+func (d ScanDetector) Detect(p *TrafficProfiler, e TrafficEvent) *Anomaly {
+	if !isScanStatus(e.Status) {
+		return nil
+	}
+	if n := p.IPFailures(e.IP); n > d.Threshold {
+		return &Anomaly{Detector: d.Name(), IP: e.IP, Count: n,
+			Message: fmt.Sprintf("%d failed requests in %s", n, p.Window())}
+	}
+	return nil
+}
+
+// RouteSpikeDetector reports routes whose traffic grows more than Factor
+// times from the previous window, once there are at least MinRequests
+type RouteSpikeDetector struct {
+	Factor      float64
+	MinRequests int64
+}
+
+func (d RouteSpikeDetector) Name() string { return "route spike" }
+
+func (d RouteSpikeDetector) Detect(p *TrafficProfiler, e TrafficEvent) *Anomaly {
+	route := e.Method + " " + e.Path
+	current, previous := p.RouteRequests(route)
+	if current < d.MinRequests || float64(current) <= d.Factor*float64(max(previous, 1)) {
+		return nil
+	}
+	return &Anomaly{Detector: d.Name(), Route: route, Count: current,
+		Message: fmt.Sprintf("%d requests in %s, %d in the previous one", current, p.Window(), previous)}
+}
+
+// BanAction bans the client of the anomaly in bans for d, pass the same bans
+// to IPFilterConfig.Bans
+func BanAction(bans *IPBans, d time.Duration) func(Anomaly) {
+	return func(a Anomaly) {
+		if a.IP != "" {
+			bans.Ban(a.IP, d)
+		}
+	}
+}
+
+// LogAction writes the anomaly as a warning
+func LogAction(log Logger) func(Anomaly) {
+	return func(a Anomaly) {
+		subject := a.IP
+		if subject == "" {
+			subject = a.Route
+		}
+		log.Warnf("anomaly %s: %s %s", a.Detector, subject, a.Message)
+	}
+}
@@ -0,0 +1,90 @@
+package simplehttp
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var REQUEST_PRINCIPAL_STRING string = "principal"
+
+// APIKeyConfig configures MiddlewareAPIKey
+type APIKeyConfig struct {
+	// Headers to read the key from, first non empty wins. Defaults to
+	// API_KEY, MEDA_API_KEY and PRIVATE_TOKEN.
+	Headers []string
+	// QueryParams to read the key from when no header has it, e.g. "api_key".
+	// Empty means the query string is never used (keys end up in logs there).
+	QueryParams []string
+	// Validator checks the key and returns who it belongs to, the principal is
+	// stored in the context (see GetPrincipal). Returning a *SimpleHttpError
+	// sends that error as is, any other error becomes a 401.
+	Validator func(c Context, key string) (principal interface{}, err error)
+	Skipper   Skipper
+}
+
+func MiddlewareAPIKey(config APIKeyConfig) Middleware {
+	return Skip(WithName("api key", APIKey(config)), config.Skipper)
+}
+
+// APIKey rejects requests without a valid API key with 401 Unauthorized
+func APIKey(config APIKeyConfig) MiddlewareFunc {
+	if config.Validator == nil {
+		panic("simplehttp: APIKeyConfig.Validator is required")
+	}
+	headers := config.Headers
+	if len(headers) == 0 {
+		headers = []string{HEADER_API_KEY, HEADER_MEDA_API_KEY, HEADER_PRIVATE_TOKEN}
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			key := apiKeyFromRequest(c, headers, config.QueryParams)
+			if key == "" {
+				return NewError(http.StatusUnauthorized, "missing API key")
+			}
+
+			principal, err := config.Validator(c, key)
+			if err != nil {
+				var httpErr *SimpleHttpError
+				if errors.As(err, &httpErr) {
+					return httpErr
+				}
+				return NewError(http.StatusUnauthorized, ErrUnauthorized.Error())
+			}
+
+			c.Set(REQUEST_PRINCIPAL_STRING, principal)
+			return next(c)
+		}
+	}
+}
+
+// GetPrincipal returns what the APIKey validator returned for this request
+func GetPrincipal(c Context) interface{} {
+	return c.Get(REQUEST_PRINCIPAL_STRING)
+}
+
+// StaticAPIKeys is a Validator for a fixed set of keys, mapping each key to
+// its principal
+func StaticAPIKeys(keys map[string]interface{}) func(c Context, key string) (interface{}, error) {
+	return func(c Context, key string) (interface{}, error) {
+		if principal, ok := keys[key]; ok {
+			return principal, nil
+		}
+		return nil, ErrUnauthorized
+	}
+}
+
+func apiKeyFromRequest(c Context, headers, queryParams []string) string {
+	for _, h := range headers {
+		if key := strings.TrimSpace(c.GetHeader(h)); key != "" {
+			return key
+		}
+	}
+	for _, q := range queryParams {
+		if key := strings.TrimSpace(c.GetQueryParam(q)); key != "" {
+			return key
+		}
+	}
+	return ""
+}
@@ -0,0 +1,255 @@
+package simplehttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/medatechnology/simplehttp/client"
+)
+
+const (
+	AUDIT_OUTCOME_SUCCESS = "success" // status below 400
+	AUDIT_OUTCOME_DENIED  = "denied"  // 401 and 403
+	AUDIT_OUTCOME_FAILURE = "failure" // any other error
+)
+
+var (
+	REQUEST_AUDIT_DETAILS_STRING = "request_audit_details"
+
+	// Query parameters never written to the audit trail in clear
+	DefaultAuditRedactParams = []string{"password", "token", "secret", "api_key", "access_token", "refresh_token", "code"}
+)
+
+// AuditEvent records who did what, when, from where and how it went
+type AuditEvent struct {
+	Time      time.Time              `json:"time"`
+	RequestID string                 `json:"request_id,omitempty"`
+	Principal interface{}            `json:"principal,omitempty"` // set by the auth middleware, see GetPrincipal
+	Method    string                 `json:"method"`
+	Path      string                 `json:"path"`
+	Params    map[string]string      `json:"params,omitempty"` // query parameters, redacted
+	IP        string                 `json:"ip,omitempty"`
+	UserAgent string                 `json:"user_agent,omitempty"`
+	Status    int                    `json:"status"`
+	Outcome   string                 `json:"outcome"` // AUDIT_OUTCOME_SUCCESS, _DENIED or _FAILURE
+	Duration  time.Duration          `json:"duration"`
+	Error     string                 `json:"error,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"` // from AuditConfig.Details and AddAuditDetail
+}
+
+// AuditSink stores audit events. An error is passed to AuditConfig.OnError,
+// the request has already been handled by then.
+type AuditSink interface {
+	WriteAudit(event AuditEvent) error
+}
+
+// AuditSinkFunc adapts a function to an AuditSink, e.g. to insert the
+// events into a database table
+type AuditSinkFunc func(event AuditEvent) error
+
+func (f AuditSinkFunc) WriteAudit(event AuditEvent) error { return f(event) }
+
+// AuditConfig configures MiddlewareAudit
+type AuditConfig struct {
+	Sink AuditSink // required
+	// RedactParams are query parameters replaced by [REDACTED], compared
+	// case-insensitively, defaults to DefaultAuditRedactParams
+	RedactParams []string
+	// Details adds application fields to every event, e.g. the tenant
+	Details func(c Context) map[string]interface{}
+	// OnError is called when the sink fails, defaults to logging it on the
+	// DefaultLogger
+	OnError func(err error, event AuditEvent)
+	Clock   Clock // for AuditEvent.Time, nil means the one of the server
+	Skipper Skipper
+}
+
+// MiddlewareAudit writes an audit event for every request after the handler
+// ran. Put it after the authentication middleware so the principal is known:
+//
+//	admin := server.Group("/admin")
+//	admin.Use(
+//		simplehttp.MiddlewareAPIKey(apiKeyConfig),
+//		simplehttp.MiddlewareAudit(simplehttp.AuditConfig{Sink: sink}),
+//	)
+func MiddlewareAudit(config AuditConfig) Middleware {
+	return Skip(WithName("audit", Audit(config)), config.Skipper)
+}
+
+// Audit records the requests to config.Sink
+func Audit(config AuditConfig) MiddlewareFunc {
+	if config.Sink == nil {
+		panic("simplehttp: AuditConfig.Sink is required")
+	}
+	if config.RedactParams == nil {
+		config.RedactParams = DefaultAuditRedactParams
+	}
+	if config.OnError == nil {
+		logger := NewDefaultLogger()
+		config.OnError = func(err error, event AuditEvent) {
+			logger.Errorf("audit: %s %s %s: %v", event.RequestID, event.Method, event.Path, err)
+		}
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			now, start := clockFor(c, config.Clock).Now(), time.Now()
+			err := next(c)
+			event := newAuditEvent(c, &config, err)
+			event.Time, event.Duration = now, time.Since(start)
+			if sinkErr := config.Sink.WriteAudit(event); sinkErr != nil {
+				config.OnError(sinkErr, event)
+			}
+			return err
+		}
+	}
+}
+
+func newAuditEvent(c Context, config *AuditConfig, err error) AuditEvent {
+	event := AuditEvent{
+		RequestID: RequestIDOf(c),
+		Principal: GetPrincipal(c),
+		Method:    c.GetMethod(),
+		Path:      c.GetPath(),
+		Status:    statusOf(c, err),
+	}
+	if r := c.Request(); r != nil {
+		event.Path = r.URL.Path
+	}
+	for key, values := range c.GetQueryParams() {
+		if event.Params == nil {
+			event.Params = make(map[string]string)
+		}
+		value := strings.Join(values, ",")
+		if containsFold(config.RedactParams, key) {
+			value = REDACTED
+		}
+		event.Params[key] = value
+	}
+	if headers := c.GetHeaders(); headers != nil {
+		event.IP = StripPort(headers.IP())
+		event.UserAgent = headers.UserAgent
+	}
+	switch {
+	case event.Status == http.StatusUnauthorized || event.Status == http.StatusForbidden:
+		event.Outcome = AUDIT_OUTCOME_DENIED
+	case event.Status >= http.StatusBadRequest:
+		event.Outcome = AUDIT_OUTCOME_FAILURE
+	default:
+		event.Outcome = AUDIT_OUTCOME_SUCCESS
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	var details []map[string]interface{}
+	if config.Details != nil {
+		details = append(details, config.Details(c))
+	}
+	if added, ok := c.Get(REQUEST_AUDIT_DETAILS_STRING).(map[string]interface{}); ok {
+		details = append(details, added)
+	}
+	for _, d := range details {
+		for k, v := range d {
+			if event.Details == nil {
+				event.Details = make(map[string]interface{})
+			}
+			event.Details[k] = v
+		}
+	}
+	return event
+}
+
+// AddAuditDetail adds a field to the audit event of the request, e.g. the ID
+// of the record a handler deleted
+func AddAuditDetail(c Context, key string, value interface{}) {
+	details, ok := c.Get(REQUEST_AUDIT_DETAILS_STRING).(map[string]interface{})
+	if !ok {
+		details = make(map[string]interface{})
+		c.Set(REQUEST_AUDIT_DETAILS_STRING, details)
+	}
+	details[key] = value
+}
+
+// WriterAuditSink writes events as JSON lines
+type WriterAuditSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterAuditSink(w io.Writer) *WriterAuditSink {
+	return &WriterAuditSink{w: w}
+}
+
+// NewFileAuditSink appends JSON lines to the file, created with mode 0600
+func NewFileAuditSink(path string) (*WriterAuditSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriterAuditSink(f), nil
+}
+
+func (s *WriterAuditSink) WriteAudit(event AuditEvent) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(b, '\n'))
+	return err
+}
+
+// Close closes the underlying writer when it is an io.Closer, e.g. the file
+// of NewFileAuditSink
+func (s *WriterAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if closer, ok := s.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// WebhookAuditSink POSTs every event as JSON to a URL. It runs within the
+// request, keep the endpoint fast or wrap it in an AuditSinkFunc that queues.
+type WebhookAuditSink struct {
+	url    string
+	client *client.Client
+}
+
+func NewWebhookAuditSink(url string, options ...client.ClientOption) *WebhookAuditSink {
+	options = append([]client.ClientOption{client.WithTimeout(5 * time.Second), client.WithMaxRetries(2)}, options...)
+	return &WebhookAuditSink{url: url, client: client.NewClient(options...)}
+}
+
+func (s *WebhookAuditSink) WriteAudit(event AuditEvent) error {
+	resp, err := s.client.Request(http.MethodPost, s.url, event)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("audit webhook: %s", resp.Status)
+	}
+	return nil
+}
+
+// MultiAuditSink writes every event to all sinks, e.g. a file and a webhook,
+// the errors of the sinks are joined
+func MultiAuditSink(sinks ...AuditSink) AuditSink {
+	return AuditSinkFunc(func(event AuditEvent) error {
+		var errs []error
+		for _, sink := range sinks {
+			errs = append(errs, sink.WriteAudit(event))
+		}
+		return errors.Join(errs...)
+	})
+}
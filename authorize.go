@@ -0,0 +1,223 @@
+package simplehttp
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// AuthorizePolicy is what a route group requires from the caller. Any of the
+// Roles and all of the Permissions must be granted, empty means no check.
+type AuthorizePolicy struct {
+	Roles       []string
+	Permissions []string
+	Authorizer  Authorizer // defaults to DefaultAuthorizer
+}
+
+// Authorizer decides whether the (already authenticated) request satisfies
+// the policy. Return ErrUnauthorized when there is no identity at all (401),
+// false when the identity lacks the rights (403).
+type Authorizer interface {
+	Authorize(c Context, policy AuthorizePolicy) (bool, error)
+}
+
+// AuthorizerFunc adapts a function to the Authorizer interface
+type AuthorizerFunc func(c Context, policy AuthorizePolicy) (bool, error)
+
+func (f AuthorizerFunc) Authorize(c Context, policy AuthorizePolicy) (bool, error) {
+	return f(c, policy)
+}
+
+// DefaultAuthorizer reads roles and permissions from the token claims
+var DefaultAuthorizer Authorizer = ClaimsAuthorizer{}
+
+func MiddlewareAuthorize(policy AuthorizePolicy) Middleware {
+	return WithName("authorize", Authorize(policy))
+}
+
+// RequireRoles lets through callers with any of the roles
+//
+//	admin.Use(simplehttp.RequireRoles("admin"))
+func RequireRoles(roles ...string) Middleware {
+	return MiddlewareAuthorize(AuthorizePolicy{Roles: roles})
+}
+
+// RequirePermissions lets through callers with all of the permissions
+func RequirePermissions(permissions ...string) Middleware {
+	return MiddlewareAuthorize(AuthorizePolicy{Permissions: permissions})
+}
+
+// Authorize must run after the authentication middleware (OIDC, API key, ...)
+func Authorize(policy AuthorizePolicy) MiddlewareFunc {
+	authorizer := policy.Authorizer
+	if authorizer == nil {
+		authorizer = DefaultAuthorizer
+	}
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			allowed, err := authorizer.Authorize(c, policy)
+			if err != nil {
+				var httpErr *SimpleHttpError
+				switch {
+				case errors.As(err, &httpErr):
+					return httpErr
+				case errors.Is(err, ErrUnauthorized):
+					return NewError(http.StatusUnauthorized, ErrUnauthorized.Error())
+				}
+				return NewError(http.StatusInternalServerError, "authorization failed", err.Error())
+			}
+			if !allowed {
+				return NewError(http.StatusForbidden, ErrForbidden.Error())
+			}
+			return next(c)
+		}
+	}
+}
+
+// ClaimsAuthorizer checks roles and permissions found in JWT claims (from
+// MiddlewareOIDC) or in a claims map returned as principal by an API key
+// validator. Claim names may be dotted paths, e.g. "realm_access.roles".
+type ClaimsAuthorizer struct {
+	RolesClaim       string // defaults to "roles"
+	PermissionsClaim string // defaults to "permissions", "scope" also works (space separated)
+	Claims           func(c Context) map[string]interface{}
+}
+
+func (a ClaimsAuthorizer) Authorize(c Context, policy AuthorizePolicy) (bool, error) {
+	var claims map[string]interface{}
+	if a.Claims != nil {
+		claims = a.Claims(c)
+	} else {
+		claims = requestClaims(c)
+	}
+	if claims == nil {
+		return false, ErrUnauthorized
+	}
+
+	rolesClaim, permissionsClaim := a.RolesClaim, a.PermissionsClaim
+	if rolesClaim == "" {
+		rolesClaim = "roles"
+	}
+	if permissionsClaim == "" {
+		permissionsClaim = "permissions"
+	}
+
+	if len(policy.Roles) > 0 && !containsAny(claimStrings(claims, rolesClaim), policy.Roles) {
+		return false, nil
+	}
+	if len(policy.Permissions) > 0 && !containsAll(claimStrings(claims, permissionsClaim), policy.Permissions) {
+		return false, nil
+	}
+	return true, nil
+}
+
+// Enforcer is the subset of a Casbin enforcer used by EnforcerAuthorizer
+type Enforcer interface {
+	Enforce(rvals ...interface{}) (bool, error)
+}
+
+// EnforcerAuthorizer delegates to a Casbin style enforcer with
+// (subject, path, method), the policy roles/permissions are not used.
+//
+//	e, _ := casbin.NewEnforcer("model.conf", "policy.csv")
+//	api.Use(simplehttp.MiddlewareAuthorize(simplehttp.AuthorizePolicy{
+//		Authorizer: simplehttp.EnforcerAuthorizer{Enforcer: e},
+//	}))
+type EnforcerAuthorizer struct {
+	Enforcer Enforcer
+	Subject  func(c Context) string // defaults to the "sub" claim or a string principal
+}
+
+func (a EnforcerAuthorizer) Authorize(c Context, policy AuthorizePolicy) (bool, error) {
+	var subject string
+	if a.Subject != nil {
+		subject = a.Subject(c)
+	} else {
+		subject = requestSubject(c)
+	}
+	if subject == "" {
+		return false, ErrUnauthorized
+	}
+	return a.Enforcer.Enforce(subject, c.GetPath(), c.GetMethod())
+}
+
+// requestSubject is the "sub" claim or a string principal, "" when the
+// request has no identity
+func requestSubject(c Context) string {
+	if claims := requestClaims(c); claims != nil {
+		subject, _ := claims["sub"].(string)
+		return subject
+	}
+	subject, _ := GetPrincipal(c).(string)
+	return subject
+}
+
+func requestClaims(c Context) map[string]interface{} {
+	if claims := GetOIDCClaims(c); claims != nil {
+		return claims
+	}
+	switch p := GetPrincipal(c).(type) {
+	case jwt.MapClaims:
+		return p
+	case map[string]interface{}:
+		return p
+	}
+	return nil
+}
+
+// claimStrings reads a claim that is either a list or a space/comma separated string
+func claimStrings(claims map[string]interface{}, path string) []string {
+	var value interface{} = claims
+	for _, part := range strings.Split(path, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[part]
+	}
+
+	switch v := value.(type) {
+	case []string:
+		return v
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	case string:
+		return strings.FieldsFunc(v, func(r rune) bool { return r == ' ' || r == ',' })
+	case nil:
+		// OAuth2 access tokens usually carry permissions as "scope"
+		if path == "permissions" {
+			if scope, ok := claims["scope"].(string); ok {
+				return strings.Fields(scope)
+			}
+		}
+	}
+	return nil
+}
+
+func containsAny(have, want []string) bool {
+	for _, w := range want {
+		for _, h := range have {
+			if h == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsAll(have, want []string) bool {
+	for _, w := range want {
+		if !containsAny(have, []string{w}) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,209 @@
+package simplehttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	DEFAULT_BATCH_MAX_REQUESTS = 20
+	DEFAULT_BATCH_CONCURRENCY  = 1
+)
+
+// DefaultBatchForwardHeaders are copied from the batch request to every sub
+// request, so they pass the same authentication
+var DefaultBatchForwardHeaders = []string{"Authorization", "Cookie", "Accept-Language", HEADER_REQUEST_ID}
+
+// batchProxyHeaders tell the client address and scheme. Sub requests always
+// carry those of the batch request and can't set their own, so the IP checks
+// see the same client.
+var batchProxyHeaders = []string{
+	HEADER_FORWARDED_FOR, HEADER_REAL_IP, HEADER_CONNECTING_IP, HEADER_TRUE_CLIENT_IP,
+	HEADER_FORWARDED, HEADER_X_FORWARDED_PROTO, HEADER_X_FORWARDED_HOST, HEADER_X_FORWARDED_SSL, HEADER_FRONT_END_HTTPS,
+}
+
+// ContextKeyDispatch is the fasthttp user value holding the context of a
+// dispatched *http.Request, the fiber and fasthttp contexts start from it
+const ContextKeyDispatch = "simplehttp.dispatch_context"
+
+// Dispatcher is implemented by the servers of the framework packages, it runs
+// a request through the routes and middleware in memory. The context of the
+// request is the one of the Context the handlers get.
+type Dispatcher interface {
+	Dispatch(req *http.Request) (*http.Response, error)
+}
+
+// batchKey marks the context of sub requests, a batch can't run in one
+type batchKey struct{}
+
+// BatchRequest is one sub request of the batch envelope
+type BatchRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"` // with query string
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"` // sent as JSON
+}
+
+// BatchResponse is the response of one sub request, in the order of the batch.
+// JSON bodies are embedded as is, anything else as a string.
+type BatchResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// BatchConfig configures BatchHandler
+type BatchConfig struct {
+	Server         Server   // must implement Dispatcher, the framework servers do
+	MaxRequests    int      // per batch, default 20
+	Concurrency    int      // sub requests run at the same time, default 1 (in order)
+	ForwardHeaders []string // defaults to DefaultBatchForwardHeaders
+}
+
+// BatchHandler accepts a JSON array of BatchRequest and answers with the
+// array of BatchResponse. Every sub request goes through the server routes
+// and middleware like a request of its own, from the same client:
+//
+//	server.POST("/batch", simplehttp.BatchHandler(simplehttp.BatchConfig{Server: server}))
+func BatchHandler(config BatchConfig) HandlerFunc {
+	dispatcher, ok := config.Server.(Dispatcher)
+	if !ok {
+		panic("simplehttp: BatchConfig.Server must implement Dispatcher")
+	}
+	if config.MaxRequests <= 0 {
+		config.MaxRequests = DEFAULT_BATCH_MAX_REQUESTS
+	}
+	if config.Concurrency <= 0 {
+		config.Concurrency = DEFAULT_BATCH_CONCURRENCY
+	}
+	if config.ForwardHeaders == nil {
+		config.ForwardHeaders = DefaultBatchForwardHeaders
+	}
+
+	return func(c Context) error {
+		// whatever path reached it, routers differ on case and slashes
+		if c.Context().Value(batchKey{}) != nil {
+			return NewError(http.StatusBadRequest, "invalid batch", "batches can't be nested")
+		}
+		var batch []BatchRequest
+		if err := json.Unmarshal(c.GetBody(), &batch); err != nil {
+			return NewError(http.StatusBadRequest, "invalid batch", err.Error())
+		}
+		if len(batch) > config.MaxRequests {
+			return NewError(http.StatusRequestEntityTooLarge, "too many requests in batch",
+				fmt.Sprintf("limit is %d", config.MaxRequests))
+		}
+
+		for i, sub := range batch {
+			if !strings.HasPrefix(sub.Path, "/") {
+				return NewError(http.StatusBadRequest, "invalid batch", fmt.Sprintf("request %d: path must start with /", i))
+			}
+		}
+
+		// read the parent request once, its context is not safe for concurrent use
+		ctx := context.WithValue(c.Context(), batchKey{}, true)
+		parent := batchParent{ctx: ctx, host: requestHost(c), remoteAddr: c.GetHeaders().RemoteIP, headers: make(http.Header)}
+		if _, _, err := net.SplitHostPort(parent.remoteAddr); err != nil {
+			parent.remoteAddr = net.JoinHostPort(parent.remoteAddr, "0")
+		}
+		for _, key := range config.ForwardHeaders {
+			if value := c.GetHeader(key); value != "" {
+				parent.headers.Set(key, value)
+			}
+		}
+		for _, key := range batchProxyHeaders {
+			if value := c.GetHeader(key); value != "" {
+				parent.headers.Set(key, value)
+			}
+		}
+
+		responses := make([]BatchResponse, len(batch))
+		slots := make(chan struct{}, config.Concurrency)
+		var wg sync.WaitGroup
+		for i, sub := range batch {
+			wg.Add(1)
+			slots <- struct{}{}
+			go func(i int, sub BatchRequest) {
+				defer func() { <-slots; wg.Done() }()
+				responses[i] = dispatchBatch(parent, dispatcher, sub)
+			}(i, sub)
+		}
+		wg.Wait()
+		return c.JSON(http.StatusOK, responses)
+	}
+}
+
+// batchParent holds what sub requests take over from the batch request
+type batchParent struct {
+	ctx        context.Context
+	host       string
+	remoteAddr string
+	headers    http.Header
+}
+
+func dispatchBatch(parent batchParent, dispatcher Dispatcher, sub BatchRequest) BatchResponse {
+	method := strings.ToUpper(sub.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	req, err := http.NewRequestWithContext(parent.ctx, method, sub.Path, bytes.NewReader(sub.Body))
+	if err != nil {
+		return batchError(http.StatusBadRequest, err.Error())
+	}
+	req.Host = parent.host
+	req.RemoteAddr = parent.remoteAddr
+	req.Header = parent.headers.Clone()
+	for key, value := range sub.Headers {
+		if isBatchProxyHeader(key) {
+			continue
+		}
+		req.Header.Set(key, value)
+	}
+	if len(sub.Body) > 0 && req.Header.Get(HEADER_CONTENT_TYPE) == "" {
+		req.Header.Set(HEADER_CONTENT_TYPE, CONTENT_TYPE_JSON)
+	}
+
+	resp, err := dispatcher.Dispatch(req)
+	if err != nil {
+		return batchError(http.StatusBadGateway, err.Error())
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return batchError(http.StatusBadGateway, err.Error())
+	}
+
+	out := BatchResponse{Status: resp.StatusCode, Headers: make(map[string]string, len(resp.Header))}
+	for key := range resp.Header {
+		out.Headers[key] = resp.Header.Get(key)
+	}
+	switch {
+	case len(body) == 0:
+	case isJSONContentType(resp.Header.Get(HEADER_CONTENT_TYPE)) && json.Valid(body):
+		out.Body = body
+	default:
+		out.Body, _ = json.Marshal(string(body))
+	}
+	return out
+}
+
+func isBatchProxyHeader(key string) bool {
+	for _, header := range batchProxyHeaders {
+		if strings.EqualFold(key, header) {
+			return true
+		}
+	}
+	return false
+}
+
+func batchError(status int, message string) BatchResponse {
+	body, _ := json.Marshal(NewError(status, message))
+	return BatchResponse{Status: status, Body: body}
+}
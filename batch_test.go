@@ -0,0 +1,120 @@
+package simplehttp_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/medatechnology/simplehttp"
+)
+
+// TestBatch runs batches through BatchHandler on every adapter
+func TestBatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		batch  string
+		status int
+		want   []simplehttp.BatchResponse // status and body only
+	}{
+		{
+			name: "in order",
+			batch: `[{"path": "/users/1"}, {"method": "post", "path": "/echo", "body": {"name": "ann"}},
+				{"path": "/missing"}, {"path": "/users/2?format=text"}]`,
+			status: http.StatusOK,
+			want: []simplehttp.BatchResponse{
+				{Status: http.StatusOK, Body: json.RawMessage(`{"id":"1"}`)},
+				{Status: http.StatusOK, Body: json.RawMessage(`{"name":"ann"}`)},
+				{Status: http.StatusNotFound},
+				{Status: http.StatusOK, Body: json.RawMessage(`"user 2"`)},
+			},
+		},
+		{
+			name:   "proxy headers of sub requests are ignored",
+			batch:  `[{"path": "/ip", "headers": {"X-Forwarded-For": "203.0.113.9", "x-real-ip": "203.0.113.9"}}]`,
+			status: http.StatusOK,
+			want:   []simplehttp.BatchResponse{{Status: http.StatusOK, Body: json.RawMessage(`"198.51.100.1,"`)}},
+		},
+		{
+			name:   "nested",
+			batch:  `[{"path": "/users/1"}, {"method": "POST", "path": "/batch", "body": [{"path": "/users/1"}]}]`,
+			status: http.StatusOK,
+			want: []simplehttp.BatchResponse{
+				{Status: http.StatusOK, Body: json.RawMessage(`{"id":"1"}`)},
+				{Status: http.StatusBadRequest},
+			},
+		},
+		{
+			name:   "too many requests",
+			batch:  `[{"path": "/users/1"}, {"path": "/users/2"}, {"path": "/users/3"}, {"path": "/users/4"}, {"path": "/users/5"}]`,
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:   "relative path",
+			batch:  `[{"path": "/users/1"}, {"path": "users/2"}]`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "not an array",
+			batch:  `{"path": "/users/1"}`,
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for name, newServer := range adapters {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				server := newServer()
+				server.GET("/users/:id", func(c simplehttp.Context) error {
+					if c.GetQueryParam("format") == "text" {
+						return c.String(http.StatusOK, "user "+c.GetParam("id"))
+					}
+					return c.JSON(http.StatusOK, map[string]string{"id": c.GetParam("id")})
+				})
+				server.POST("/echo", func(c simplehttp.Context) error {
+					var body map[string]string
+					if err := json.Unmarshal(c.GetBody(), &body); err != nil {
+						return simplehttp.NewError(http.StatusBadRequest, err.Error())
+					}
+					return c.JSON(http.StatusOK, body)
+				})
+				server.GET("/ip", func(c simplehttp.Context) error {
+					return c.String(http.StatusOK, c.GetHeader(simplehttp.HEADER_FORWARDED_FOR)+","+c.GetHeader(simplehttp.HEADER_REAL_IP))
+				})
+				server.POST("/batch", simplehttp.BatchHandler(simplehttp.BatchConfig{Server: server, MaxRequests: 4}))
+
+				req := httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(tt.batch))
+				req.Header.Set(simplehttp.HEADER_CONTENT_TYPE, simplehttp.CONTENT_TYPE_JSON)
+				req.Header.Set(simplehttp.HEADER_FORWARDED_FOR, "198.51.100.1")
+				resp, err := server.(simplehttp.Dispatcher).Dispatch(req)
+				if err != nil {
+					t.Fatalf("dispatch: %v", err)
+				}
+				defer resp.Body.Close()
+				if resp.StatusCode != tt.status {
+					t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+				}
+				if tt.want == nil {
+					return
+				}
+
+				var got []simplehttp.BatchResponse
+				if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+					t.Fatal(err)
+				}
+				if len(got) != len(tt.want) {
+					t.Fatalf("%d responses, want %d", len(got), len(tt.want))
+				}
+				for i, want := range tt.want {
+					if got[i].Status != want.Status {
+						t.Errorf("response %d: status = %d, want %d", i, got[i].Status, want.Status)
+					}
+					if want.Body != nil && string(got[i].Body) != string(want.Body) {
+						t.Errorf("response %d: body = %s, want %s", i, got[i].Body, want.Body)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package simplehttp_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/medatechnology/simplehttp"
+)
+
+type bindQuery struct {
+	Name   string  `json:"name" form:"name" query:"name"`
+	Page   int     `json:"page" form:"page" query:"page"`
+	Limit  *uint   `json:"limit" form:"limit" query:"limit"`
+	Ratio  float64 `json:"ratio" form:"ratio" query:"ratio"`
+	Active bool    `json:"active" form:"active" query:"active"`
+}
+
+// TestBindScalars binds query and form strings to number and bool fields on
+// every adapter
+func TestBindScalars(t *testing.T) {
+	limit := uint(10)
+	tests := []struct {
+		name    string
+		query   string
+		form    string
+		want    bindQuery
+		wantErr bool
+	}{
+		{
+			name:  "query",
+			query: "name=ann&page=2&limit=10&ratio=0.5&active=true",
+			want:  bindQuery{Name: "ann", Page: 2, Limit: &limit, Ratio: 0.5, Active: true},
+		},
+		{
+			name: "form",
+			form: "name=ann&page=2&active=1",
+			want: bindQuery{Name: "ann", Page: 2, Active: true},
+		},
+		{
+			name:  "empty values",
+			query: "name=&page=&active=",
+			want:  bindQuery{},
+		},
+		{
+			name:  "numeric name stays a string",
+			query: "name=42",
+			want:  bindQuery{Name: "42"},
+		},
+		{
+			name:    "not a number",
+			query:   "page=two",
+			wantErr: true,
+		},
+	}
+
+	for name, newServer := range adapters {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				var got bindQuery
+				var bindErr error
+				server := newServer()
+				handler := func(c simplehttp.Context) error {
+					bindErr = c.Bind(&got)
+					return c.String(http.StatusOK, "ok")
+				}
+				server.GET("/bind", handler)
+				server.POST("/bind", handler)
+				req := httptest.NewRequest(http.MethodGet, "/bind?"+tt.query, nil)
+				if tt.form != "" {
+					req = httptest.NewRequest(http.MethodPost, "/bind", strings.NewReader(tt.form))
+					req.Header.Set(simplehttp.HEADER_CONTENT_TYPE, "application/x-www-form-urlencoded")
+				}
+				resp, err := server.(simplehttp.Dispatcher).Dispatch(req)
+				if err != nil {
+					t.Fatalf("dispatch: %v", err)
+				}
+				resp.Body.Close()
+				if (bindErr != nil) != tt.wantErr {
+					t.Fatalf("Bind error = %v, want error %v", bindErr, tt.wantErr)
+				}
+				if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("bound %+v, want %+v", got, tt.want)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package simplehttp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// WithMaxBody limits the request body of the routes it is applied to, larger
+// bodies get 413 before the handler (and binding) runs. It can only tighten
+// the server wide Config.MaxRequestSize, not raise it.
+//
+//	server.POST("/avatar", simplehttp.WithMaxBody(2<<20).Handle(uploadAvatar))
+func WithMaxBody(maxBytes int64) Middleware {
+	return WithName("max body", MaxBody(maxBytes))
+}
+
+// MiddlewareBodyLimit limits the request body for a group (or the whole
+// server) independent of Config.MaxRequestSize, e.g. 1MB for the API while
+// the upload group allows 100MB. The server limit must be at least as large
+// as the biggest group limit, requests above it never reach the middleware.
+func MiddlewareBodyLimit(maxBytes int64) Middleware {
+	return WithName("body limit", MaxBody(maxBytes))
+}
+
+// MaxBody rejects requests with a body larger than maxBytes with 413
+func MaxBody(maxBytes int64) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			tooLarge := NewError(http.StatusRequestEntityTooLarge, "request body too large",
+				fmt.Sprintf("limit is %d bytes", maxBytes))
+
+			if length := c.GetHeader("Content-Length"); length != "" {
+				if n, err := strconv.ParseInt(length, 10, 64); err == nil {
+					if n > maxBytes {
+						return tooLarge
+					}
+					return next(c)
+				}
+			}
+
+			// unknown length (chunked), read at most maxBytes+1 and put the body
+			// back so binding still works
+			req := c.Request()
+			if req == nil || req.Body == nil || req.Body == http.NoBody {
+				return next(c)
+			}
+			body, err := io.ReadAll(io.LimitReader(req.Body, maxBytes+1))
+			if err != nil {
+				return NewError(http.StatusBadRequest, "failed to read request body", err.Error())
+			}
+			if int64(len(body)) > maxBytes {
+				return tooLarge
+			}
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			return next(c)
+		}
+	}
+}
+
+// WithAllowedContentTypes restricts the Content-Type of request bodies for the
+// routes it is applied to, anything else gets 415. Wildcards like "image/*"
+// are allowed, requests without a body are not checked.
+//
+//	api.POST("/import", simplehttp.WithAllowedContentTypes("text/csv", "application/json").Handle(importRows))
+func WithAllowedContentTypes(contentTypes ...string) Middleware {
+	return WithName("allowed content types", AllowedContentTypes(contentTypes...))
+}
+
+// AllowedContentTypes rejects request bodies of other content types with 415
+func AllowedContentTypes(contentTypes ...string) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			if !hasRequestBody(c) {
+				return next(c)
+			}
+			contentType := c.GetHeader(HEADER_CONTENT_TYPE)
+			mediaType := normalizeContentType(contentType)
+			if mediaType == "" || !matchContentType(contentTypes, mediaType) {
+				return NewError(http.StatusUnsupportedMediaType, "unsupported content type "+contentType, contentTypes)
+			}
+			return next(c)
+		}
+	}
+}
+
+// hasRequestBody reports whether the request has a body, also chunked or on
+// HTTP/2 without Content-Length: net/http takes Transfer-Encoding out of the
+// headers and sets ContentLength to -1
+func hasRequestBody(c Context) bool {
+	if r := c.Request(); r.ContentLength != 0 || len(r.TransferEncoding) > 0 {
+		return true
+	}
+	if length := c.GetHeader("Content-Length"); length != "" {
+		n, err := strconv.ParseInt(length, 10, 64)
+		return err != nil || n > 0
+	}
+	return c.GetHeader("Transfer-Encoding") != ""
+}
@@ -0,0 +1,188 @@
+package simplehttp
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var REQUEST_AUTH_FAILED_STRING string = "auth_failed"
+
+const (
+	DEFAULT_BRUTE_FORCE_ATTEMPTS = 5
+	DEFAULT_BRUTE_FORCE_WINDOW   = 15 * time.Minute
+	DEFAULT_BRUTE_FORCE_LOCKOUT  = time.Minute
+	DEFAULT_BRUTE_FORCE_MAX_LOCK = time.Hour
+)
+
+// BruteForceConfig configures MiddlewareBruteForce
+type BruteForceConfig struct {
+	Store        CacheStore             // attempt counters, defaults to a new MemoryCache
+	MaxAttempts  int                    // failures allowed within Window before locking, default 5
+	Window       time.Duration          // how long failures are remembered, default 15m
+	BaseLockout  time.Duration          // first lockout, doubled on each next one, default 1m
+	MaxLockout   time.Duration          // lockout cap, default 1h
+	IdentityFunc func(c Context) string // optional, e.g. the username being logged into
+	Clock        Clock                  // times the lockouts, nil means DefaultClock
+	Skipper      Skipper
+}
+
+// BruteForce tracks failed authentication attempts per IP and per identity
+type BruteForce struct {
+	config BruteForceConfig
+	mu     sync.Mutex // concurrent failures of a key all count
+}
+
+type bruteForceState struct {
+	Failures    int
+	Lockouts    int
+	LockedUntil time.Time
+}
+
+func NewBruteForce(config BruteForceConfig) *BruteForce {
+	if config.Store == nil {
+		config.Store = NewMemoryCache()
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = DEFAULT_BRUTE_FORCE_ATTEMPTS
+	}
+	if config.Window == 0 {
+		config.Window = DEFAULT_BRUTE_FORCE_WINDOW
+	}
+	if config.BaseLockout == 0 {
+		config.BaseLockout = DEFAULT_BRUTE_FORCE_LOCKOUT
+	}
+	if config.MaxLockout == 0 {
+		config.MaxLockout = DEFAULT_BRUTE_FORCE_MAX_LOCK
+	}
+	config.Clock = clockOr(config.Clock)
+	return &BruteForce{config: config}
+}
+
+func MiddlewareBruteForce(config BruteForceConfig) Middleware {
+	return Skip(NewBruteForce(config).Middleware(), config.Skipper)
+}
+
+// AuthFailed signals a failed authentication to MiddlewareBruteForce, for
+// handlers that don't return a 401 (e.g. a login form rendered again)
+func AuthFailed(c Context) {
+	c.Set(REQUEST_AUTH_FAILED_STRING, true)
+}
+
+// Middleware must be placed before the auth middleware (or login handler) it
+// protects. A 401 from it, or AuthFailed, counts as a failure; locked out
+// clients get 429 with Retry-After.
+func (b *BruteForce) Middleware() Middleware {
+	return WithName("brute force", func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			keys := b.keys(c)
+			for _, key := range keys {
+				if wait := b.lockedFor(key); wait > 0 {
+					c.SetResponseHeader("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+					return NewError(http.StatusTooManyRequests, "too many failed attempts, try again later")
+				}
+			}
+
+			err := next(c)
+			if isAuthFailure(c, err) {
+				for _, key := range keys {
+					b.fail(key)
+				}
+			} else if err == nil && len(keys) > 1 {
+				// a successful login clears the identity, never the IP, otherwise
+				// logging into an own account would reset an attacker's counter
+				b.config.Store.Delete(bruteForceKey(keys[1]))
+			}
+			return err
+		}
+	})
+}
+
+// Clear removes the lock and counters of an IP ("ip:1.2.3.4") or identity
+// ("id:alice")
+func (b *BruteForce) Clear(key string) error {
+	return b.config.Store.Delete(bruteForceKey(key))
+}
+
+// ClearHandler is the admin endpoint to lift a lock, mount it on the internal
+// API: DELETE /bruteforce?ip=1.2.3.4 or ?identity=alice
+func (b *BruteForce) ClearHandler() HandlerFunc {
+	return func(c Context) error {
+		var key string
+		if ip := c.GetQueryParam("ip"); ip != "" {
+			key = "ip:" + ip
+		} else if id := c.GetQueryParam("identity"); id != "" {
+			key = "id:" + id
+		} else {
+			return NewError(http.StatusBadRequest, "ip or identity is required")
+		}
+		if err := b.Clear(key); err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, map[string]string{"cleared": key})
+	}
+}
+
+func (b *BruteForce) keys(c Context) []string {
+	keys := []string{"ip:" + StripPort(c.GetHeaders().IP())}
+	if b.config.IdentityFunc != nil {
+		if id := b.config.IdentityFunc(c); id != "" {
+			keys = append(keys, "id:"+id)
+		}
+	}
+	return keys
+}
+
+// state returns a copy of the state of key, the store keeps its own
+func (b *BruteForce) state(key string) bruteForceState {
+	if cached, found := b.config.Store.Get(bruteForceKey(key)); found {
+		if state, ok := cached.(*bruteForceState); ok {
+			return *state
+		}
+	}
+	return bruteForceState{}
+}
+
+func (b *BruteForce) lockedFor(key string) time.Duration {
+	return b.state(key).LockedUntil.Sub(b.config.Clock.Now())
+}
+
+// fail counts a failure of key, one at a time. Servers sharing a store can
+// still lose a failure between them.
+func (b *BruteForce) fail(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state := b.state(key)
+	state.Failures++
+	ttl := b.config.Window
+	if state.Failures >= b.config.MaxAttempts {
+		// exponential lockout: base, 2x base, 4x base, ... up to MaxLockout
+		lockout := b.config.BaseLockout << min(state.Lockouts, 20)
+		if lockout <= 0 || lockout > b.config.MaxLockout {
+			lockout = b.config.MaxLockout
+		}
+		state.Lockouts++
+		state.Failures = 0
+		state.LockedUntil = b.config.Clock.Now().Add(lockout)
+		ttl = max(ttl, lockout+b.config.Window)
+	}
+	b.config.Store.Set(bruteForceKey(key), &state, ttl)
+}
+
+func bruteForceKey(key string) string {
+	return "bruteforce:" + key
+}
+
+func isAuthFailure(c Context, err error) bool {
+	if failed, _ := c.Get(REQUEST_AUTH_FAILED_STRING).(bool); failed {
+		return true
+	}
+	var httpErr *SimpleHttpError
+	if errors.As(err, &httpErr) {
+		return httpErr.Code == http.StatusUnauthorized
+	}
+	return err == nil && c.GetResponseStatus() == http.StatusUnauthorized
+}
@@ -2,7 +2,11 @@
 package simplehttp
 
 import (
+	"container/list"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -17,73 +21,563 @@ type CacheStore interface {
 
 // Cache middleware configuration
 type CacheConfig struct {
-	TTL           time.Duration
-	KeyPrefix     string
-	KeyFunc       func(Context) string
-	Store         CacheStore
+	TTL       time.Duration
+	KeyPrefix string
+	// KeyFunc defaults to the method and URI. With the default, responses
+	// to requests with Authorization are only cached when marked public.
+	KeyFunc func(Context) string
+	Store   CacheStore
+	// IgnoreHeaders are response headers not stored with the response
 	IgnoreHeaders []string
+	Methods       []string // cached request methods, default GET and HEAD
+	// Coalesce makes concurrent misses of one key wait for the first one,
+	// so an expired popular entry runs the handler once instead of once
+	// per waiting request
+	Coalesce bool
+	// StaleWhileRevalidate keeps serving an expired response this long
+	// while one request refreshes it, the Cache-Control directive
+	// stale-while-revalidate of a response overrides it
+	StaleWhileRevalidate time.Duration
+	// Tags of every response stored, e.g. by path, added to the ones of
+	// CacheTag. Needs a TaggedCacheStore, see CacheInvalidate.
+	Tags    func(Context) []string
+	Clock   Clock // ages and expires the responses, nil means the one of the server
+	Skipper Skipper
+}
+
+const (
+	HEADER_CACHE_CONTROL = "Cache-Control"
+
+	DEFAULT_CACHE_CLEANUP_INTERVAL = time.Minute
+)
+
+// Response headers never replayed from the cache
+var cacheSkipHeaders = []string{
+	HEADER_CONTENT_TYPE, "Content-Length", "Date", "Age", "Connection",
+	"Transfer-Encoding", "Keep-Alive", "Set-Cookie",
 }
 
 func MiddlewareCache(config CacheConfig) Middleware {
-	return WithName("cache", SimpleCache(config))
+	return Skip(WithName("cache", SimpleCache(config)), config.Skipper)
+}
+
+var REQUEST_CACHE_DIRECTIVE_STRING string = "cache_directive"
+
+// CacheDirective is how a handler controls caching of its own response, set
+// through c.SetCacheTTL and c.NoStore
+type CacheDirective struct {
+	TTL     time.Duration // overrides CacheConfig.TTL when > 0
+	NoStore bool          // never cache this response
+	Tags    []string      // see CacheTag
+}
+
+// CacheDirectiveOf returns the (mutable) cache directive of the request
+func CacheDirectiveOf(c Context) *CacheDirective {
+	if d, ok := c.Get(REQUEST_CACHE_DIRECTIVE_STRING).(*CacheDirective); ok {
+		return d
+	}
+	d := &CacheDirective{}
+	c.Set(REQUEST_CACHE_DIRECTIVE_STRING, d)
+	return d
+}
+
+// CachedResponse is what the cache middleware stores for a response
+type CachedResponse struct {
+	Status      int
+	ContentType string
+	Header      http.Header // set by the handler, see cacheSkipHeaders
+	Body        []byte
+	Stored      time.Time // for the Age header
+	Expires     time.Time // stale after, see StaleWhileRevalidate
+}
+
+// CachedVary is stored under the key of a response with a Vary header, the
+// response itself is stored per value of those request headers
+type CachedVary struct {
+	Headers []string
 }
 
-// SimpleCache returns a caching middleware
+// SimpleCache returns a caching middleware. Successful GET and HEAD
+// responses are stored with their status, headers and body for TTL:
+//   - handlers change the TTL with c.SetCacheTTL or opt out with c.NoStore
+//   - Cache-Control: no-store, no-cache or private responses are not stored,
+//     s-maxage overrides TTL
+//   - responses with Set-Cookie or Vary: * are not stored, other Vary
+//     headers store one response per value of those request headers
+//   - error responses are never stored
+//
+// Headers set before the cache middleware, like the request ID, are not
+// stored, so put it after the middleware setting per-request headers.
+//
+// With StaleWhileRevalidate the first request finding an expired response
+// refreshes it, the requests meanwhile get the expired one. Coalesce makes
+// the requests missing the same key wait for the first one instead.
 func SimpleCache(config CacheConfig) MiddlewareFunc {
+	keyFunc := config.KeyFunc
+	if keyFunc == nil {
+		keyFunc = func(c Context) string {
+			return c.GetMethod() + ":" + c.Request().URL.RequestURI()
+		}
+	}
+	if len(config.Methods) == 0 {
+		config.Methods = []string{http.MethodGet, http.MethodHead}
+	}
+	skip := append(append([]string(nil), cacheSkipHeaders...), config.IgnoreHeaders...)
+	flights := &cacheFlights{calls: make(map[string]chan struct{})}
+
+	// store runs the handler and keeps its response when allowed
+	store := func(c Context, next HandlerFunc, key string) error {
+		before := c.Response().Header().Clone()
+		c.BufferResponse()
+		if err := next(c); err != nil {
+			c.FlushResponse()
+			return err
+		}
+
+		header := c.Response().Header()
+		ttl, stale, ok := cacheTTL(c, &config, header)
+		if ok {
+			now := clockFor(c, config.Clock).Now()
+			resp := &CachedResponse{
+				Status:      c.GetResponseStatus(),
+				ContentType: header.Get(HEADER_CONTENT_TYPE),
+				Header:      make(http.Header),
+				Body:        append([]byte(nil), c.GetResponseBody()...),
+				Stored:      now,
+				Expires:     now.Add(ttl),
+			}
+			// only what the handler chain set, not the headers of the
+			// middleware before
+			for name, values := range header {
+				if !containsFold(skip, name) && !slices.Equal(values, before[name]) {
+					resp.Header[name] = append([]string(nil), values...)
+				}
+			}
+			tags := CacheDirectiveOf(c).Tags
+			if config.Tags != nil {
+				tags = append(append([]string(nil), config.Tags(c)...), tags...)
+			}
+			if vary := cacheVaryHeaders(header); len(vary) > 0 {
+				cacheSet(config.Store, key, &CachedVary{Headers: vary}, ttl+stale, tags)
+				key = cacheVaryKey(c, key, vary)
+			}
+			cacheSet(config.Store, key, resp, ttl+stale, tags)
+		}
+		return c.FlushResponse()
+	}
+
 	return func(next HandlerFunc) HandlerFunc {
 		return func(c Context) error {
-			// fmt.Println("--- cache middleware")
+			useCacheStore(c, config.Store)
+			if !containsFold(config.Methods, c.GetMethod()) {
+				return next(c)
+			}
+
+			key := config.KeyPrefix + keyFunc(c)
+			if cached, found := cacheLookup(c, config.Store, key); found {
+				resp, ok := cached.(*CachedResponse)
+				if !ok || resp.Expires.IsZero() || !clockFor(c, config.Clock).Now().After(resp.Expires) {
+					return serveCached(c, cached, config.Clock)
+				}
+				// stale: one request refreshes, the others get it meanwhile
+				done, leader := flights.join(key)
+				if !leader {
+					return serveCached(c, cached, config.Clock)
+				}
+				defer flights.leave(key, done)
+				return store(c, next, key)
+			}
+
+			if config.Coalesce {
+				done, leader := flights.join(key)
+				if !leader {
+					select {
+					case <-done:
+					case <-c.Context().Done():
+						return c.Context().Err()
+					}
+					// the first response may vary or not be cacheable,
+					// look again and run the handler if it isn't there
+					if cached, found := cacheLookup(c, config.Store, key); found {
+						return serveCached(c, cached, config.Clock)
+					}
+					return store(c, next, key)
+				}
+				defer flights.leave(key, done)
+			}
+			return store(c, next, key)
+		}
+	}
+}
+
+// cacheLookup returns the response stored for the request, following the
+// CachedVary marker
+func cacheLookup(c Context, store CacheStore, key string) (interface{}, bool) {
+	cached, found := store.Get(key)
+	if vary, ok := cached.(*CachedVary); found && ok {
+		cached, found = store.Get(cacheVaryKey(c, key, vary.Headers))
+	}
+	return cached, found
+}
+
+// cacheFlights tracks the keys having a request running the handler
+type cacheFlights struct {
+	mu    sync.Mutex
+	calls map[string]chan struct{}
+}
+
+// join returns the channel closed when the request running key is done,
+// leader reports that the caller is that request and must call leave
+func (f *cacheFlights) join(key string) (done chan struct{}, leader bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if done, ok := f.calls[key]; ok {
+		return done, false
+	}
+	done = make(chan struct{})
+	f.calls[key] = done
+	return done, true
+}
+
+func (f *cacheFlights) leave(key string, done chan struct{}) {
+	f.mu.Lock()
+	delete(f.calls, key)
+	f.mu.Unlock()
+	close(done)
+}
+
+// cacheTTL decides whether the response can be stored, for how long and
+// how long it can be served stale after
+func cacheTTL(c Context, config *CacheConfig, header http.Header) (time.Duration, time.Duration, bool) {
+	directive := CacheDirectiveOf(c)
+	status := c.GetResponseStatus()
+	if directive.NoStore || status < 200 || status >= 300 {
+		return 0, 0, false
+	}
+	if header.Get("Set-Cookie") != "" || header.Get(HEADER_VARY) == "*" {
+		return 0, 0, false
+	}
+	control := parseCacheControl(header.Get(HEADER_CACHE_CONTROL))
+	if _, ok := control["no-store"]; ok {
+		return 0, 0, false
+	}
+	if _, ok := control["no-cache"]; ok {
+		return 0, 0, false
+	}
+	if _, ok := control["private"]; ok {
+		return 0, 0, false
+	}
+	_, public := control["public"]
+	sMaxAge, hasSMaxAge := control["s-maxage"]
+	// a shared cache keyed without the credentials must not mix users
+	if config.KeyFunc == nil && c.GetHeader("Authorization") != "" && !public && !hasSMaxAge {
+		return 0, 0, false
+	}
+
+	ttl := config.TTL
+	if seconds, err := strconv.Atoi(sMaxAge); hasSMaxAge && err == nil {
+		ttl = time.Duration(seconds) * time.Second
+	}
+	if directive.TTL > 0 {
+		ttl = directive.TTL
+	}
+	stale := config.StaleWhileRevalidate
+	if seconds, err := strconv.Atoi(control["stale-while-revalidate"]); err == nil {
+		stale = time.Duration(seconds) * time.Second
+	}
+	return ttl, max(stale, 0), ttl > 0
+}
+
+func serveCached(c Context, cached interface{}, clock Clock) error {
+	resp, ok := cached.(*CachedResponse)
+	if !ok {
+		return c.JSON(http.StatusOK, cached)
+	}
+	header := c.Response().Header()
+	for name, values := range resp.Header {
+		header[name] = append([]string(nil), values...)
+	}
+	if !resp.Stored.IsZero() {
+		header.Set("Age", strconv.Itoa(int(clockFor(c, clock).Now().Sub(resp.Stored).Seconds())))
+	}
+	return c.Blob(resp.Status, resp.ContentType, resp.Body)
+}
 
-			key := config.KeyFunc(c)
-			if cached, found := config.Store.Get(key); found {
-				return c.JSON(http.StatusOK, cached)
+// cacheVaryHeaders returns the canonical, sorted names of the Vary headers
+func cacheVaryHeaders(header http.Header) []string {
+	var names []string
+	for _, value := range header.Values(HEADER_VARY) {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
 			}
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// cacheVaryKey extends key with the values of the vary request headers
+func cacheVaryKey(c Context, key string, vary []string) string {
+	var b strings.Builder
+	b.WriteString(key)
+	for _, name := range vary {
+		b.WriteString("\x00")
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(c.GetHeader(name))
+	}
+	return b.String()
+}
 
-			// Continue with request
-			return next(c)
+// parseCacheControl returns the directives of a Cache-Control header, names
+// lower case, values unquoted
+func parseCacheControl(value string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name != "" {
+			directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
 		}
 	}
+	return directives
 }
 
-// MemoryCache provides a simple in-memory cache implementation
+// CacheStats is reported by stores implementing CacheStatsProvider
+type CacheStats struct {
+	Items   int   `json:"items"`
+	Bytes   int64 `json:"bytes"`
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+	Expired int64 `json:"expired"` // dropped after their TTL
+	Evicted int64 `json:"evicted"` // dropped over MaxEntries or MaxBytes
+}
+
+// CacheStatsProvider is an optional CacheStore interface, shown in the admin UI
+type CacheStatsProvider interface {
+	Stats() CacheStats
+}
+
+// MemoryCache keeps the items in process. Expired items are dropped when
+// read and by a janitor every CleanupInterval, the least recently used ones
+// when the cache is over MaxEntries or MaxBytes. Stop the janitor of a
+// cache no longer used.
 type MemoryCache struct {
 	sync.RWMutex
-	data map[string]cacheItem
+	config MemoryCacheConfig
+	data   map[string]*list.Element
+	lru    *list.List                     // *cacheItem, most recently used first
+	tags   map[string]map[string]struct{} // keys per tag
+	bytes  int64
+	stats  CacheStats
+	stop   chan struct{}
+	once   sync.Once
+}
+
+// MemoryCacheConfig configures NewMemoryCache
+type MemoryCacheConfig struct {
+	Clock Clock // expires the items, nil means DefaultClock
+	// MaxEntries drops the least recently used items beyond it, 0 means
+	// no limit
+	MaxEntries int
+	// MaxBytes drops the least recently used items while the items are
+	// larger, as measured by SizeOf. 0 means no limit.
+	MaxBytes int64
+	// SizeOf measures an item for MaxBytes, default the length of the key
+	// and of []byte, string and CachedResponse values
+	SizeOf func(key string, value interface{}) int64
+	// CleanupInterval of the janitor dropping expired items, default 1m,
+	// negative means no janitor
+	CleanupInterval time.Duration
 }
 
 type cacheItem struct {
+	key        string
 	value      interface{}
+	tags       []string
+	size       int64
 	expiration time.Time
 }
 
-func NewMemoryCache() CacheStore {
-	return &MemoryCache{
-		data: make(map[string]cacheItem),
+func NewMemoryCache(config ...MemoryCacheConfig) *MemoryCache {
+	c := &MemoryCache{
+		data: make(map[string]*list.Element),
+		lru:  list.New(),
+		tags: make(map[string]map[string]struct{}),
+		stop: make(chan struct{}),
+	}
+	if len(config) > 0 {
+		c.config = config[0]
+	}
+	if c.config.SizeOf == nil {
+		c.config.SizeOf = cacheItemSize
+	}
+	if c.config.CleanupInterval == 0 {
+		c.config.CleanupInterval = DEFAULT_CACHE_CLEANUP_INTERVAL
+	}
+	if c.config.CleanupInterval > 0 {
+		go c.janitor(c.config.CleanupInterval)
 	}
+	return c
 }
 
 func (c *MemoryCache) Get(key string) (interface{}, bool) {
-	item, exists := c.data[key]
-	if !exists || time.Now().After(item.expiration) {
+	c.Lock()
+	defer c.Unlock()
+	element, exists := c.data[key]
+	if !exists {
+		c.stats.Misses++
 		return nil, false
 	}
+	item := element.Value.(*cacheItem)
+	if clockOr(c.config.Clock).Now().After(item.expiration) {
+		c.remove(element)
+		c.stats.Expired++
+		c.stats.Misses++
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	c.stats.Hits++
 	return item.value, true
 }
 
 func (c *MemoryCache) Set(key string, value interface{}, ttl time.Duration) error {
-	c.data[key] = cacheItem{
+	return c.SetWithTags(key, value, ttl)
+}
+
+// SetWithTags stores an item dropped by InvalidateTag of any of tags
+func (c *MemoryCache) SetWithTags(key string, value interface{}, ttl time.Duration, tags ...string) error {
+	item := &cacheItem{
+		key:        key,
 		value:      value,
-		expiration: time.Now().Add(ttl),
+		tags:       tags,
+		size:       c.config.SizeOf(key, value),
+		expiration: clockOr(c.config.Clock).Now().Add(ttl),
+	}
+	c.Lock()
+	defer c.Unlock()
+	if element, exists := c.data[key]; exists {
+		c.remove(element)
+	}
+	c.data[key] = c.lru.PushFront(item)
+	c.bytes += item.size
+	for _, tag := range tags {
+		if c.tags[tag] == nil {
+			c.tags[tag] = make(map[string]struct{})
+		}
+		c.tags[tag][key] = struct{}{}
+	}
+	for c.lru.Len() > 0 && (c.config.MaxEntries > 0 && c.lru.Len() > c.config.MaxEntries ||
+		c.config.MaxBytes > 0 && c.bytes > c.config.MaxBytes) {
+		c.remove(c.lru.Back())
+		c.stats.Evicted++
 	}
 	return nil
 }
 
 func (c *MemoryCache) Delete(key string) error {
-	delete(c.data, key)
+	c.Lock()
+	defer c.Unlock()
+	if element, exists := c.data[key]; exists {
+		c.remove(element)
+	}
+	return nil
+}
+
+// InvalidateTag deletes the items stored with tag
+func (c *MemoryCache) InvalidateTag(tag string) error {
+	c.Lock()
+	defer c.Unlock()
+	for key := range c.tags[tag] {
+		c.remove(c.data[key])
+	}
 	return nil
 }
 
 func (c *MemoryCache) Clear() error {
-	c.data = make(map[string]cacheItem)
+	c.Lock()
+	defer c.Unlock()
+	c.data = make(map[string]*list.Element)
+	c.tags = make(map[string]map[string]struct{})
+	c.lru.Init()
+	c.bytes = 0
 	return nil
 }
+
+func (c *MemoryCache) Stats() CacheStats {
+	c.RLock()
+	defer c.RUnlock()
+	stats := c.stats
+	stats.Items = c.lru.Len()
+	stats.Bytes = c.bytes
+	return stats
+}
+
+// Stop stops the janitor, the cache keeps working without it
+func (c *MemoryCache) Stop() {
+	c.once.Do(func() { close(c.stop) })
+}
+
+// DeleteExpired drops the expired items, run by the janitor
+func (c *MemoryCache) DeleteExpired() {
+	now := clockOr(c.config.Clock).Now()
+	c.Lock()
+	defer c.Unlock()
+	for element := c.lru.Back(); element != nil; {
+		prev := element.Prev()
+		if now.After(element.Value.(*cacheItem).expiration) {
+			c.remove(element)
+			c.stats.Expired++
+		}
+		element = prev
+	}
+}
+
+func (c *MemoryCache) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.DeleteExpired()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+func (c *MemoryCache) remove(element *list.Element) {
+	item := c.lru.Remove(element).(*cacheItem)
+	delete(c.data, item.key)
+	c.bytes -= item.size
+	for _, tag := range item.tags {
+		delete(c.tags[tag], item.key)
+		if len(c.tags[tag]) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+}
+
+// cacheItemSize is the default MemoryCacheConfig.SizeOf
+func cacheItemSize(key string, value interface{}) int64 {
+	size := int64(len(key))
+	switch v := value.(type) {
+	case []byte:
+		size += int64(len(v))
+	case string:
+		size += int64(len(v))
+	case *CachedResponse:
+		size += int64(len(v.Body) + len(v.ContentType))
+		for name, values := range v.Header {
+			size += int64(len(name))
+			for _, value := range values {
+				size += int64(len(value))
+			}
+		}
+	case *CachedVary:
+		for _, name := range v.Headers {
+			size += int64(len(name))
+		}
+	}
+	return size
+}
@@ -0,0 +1,79 @@
+package simplehttp
+
+import (
+	"errors"
+	"time"
+)
+
+var REQUEST_CACHE_STORES_STRING string = "cache_stores"
+
+// ErrCacheTagsUnsupported is returned by CacheInvalidate when none of the
+// stores of the request implements TaggedCacheStore
+var ErrCacheTagsUnsupported = errors.New("cache store doesn't support tags")
+
+// TaggedCacheStore is an optional CacheStore interface, the cache
+// middleware stores tagged responses with it so CacheInvalidate can drop
+// them. MemoryCache implements it.
+type TaggedCacheStore interface {
+	CacheStore
+	SetWithTags(key string, value interface{}, ttl time.Duration, tags ...string) error
+	// InvalidateTag deletes the items stored with tag
+	InvalidateTag(tag string) error
+}
+
+// CacheTag tags the response of the request, added to CacheConfig.Tags. A
+// later CacheInvalidate of one of its tags drops it from the cache.
+//
+//	server.GET("/users/:id", func(c simplehttp.Context) error {
+//		simplehttp.CacheTag(c, "user:"+userID(c))
+//		return c.JSON(http.StatusOK, user)
+//	})
+func CacheTag(c Context, tags ...string) {
+	directive := CacheDirectiveOf(c)
+	directive.Tags = append(directive.Tags, tags...)
+}
+
+// CacheInvalidate drops the responses tagged with any of tags from the
+// stores of the cache middleware the request went through, e.g. after a
+// mutation:
+//
+//	server.PUT("/users/:id", func(c simplehttp.Context) error {
+//		// update the user ...
+//		simplehttp.CacheInvalidate(c, "user:"+userID(c), "users")
+//		return c.JSON(http.StatusOK, user)
+//	})
+func CacheInvalidate(c Context, tags ...string) error {
+	stores, _ := c.Get(REQUEST_CACHE_STORES_STRING).([]CacheStore)
+	var errs []error
+	tagged := false
+	for _, store := range stores {
+		if store, ok := store.(TaggedCacheStore); ok {
+			tagged = true
+			for _, tag := range tags {
+				if err := store.InvalidateTag(tag); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+	}
+	if !tagged && len(tags) > 0 {
+		return ErrCacheTagsUnsupported
+	}
+	return errors.Join(errs...)
+}
+
+// useCacheStore makes the store of a cache middleware known to
+// CacheInvalidate for the request
+func useCacheStore(c Context, store CacheStore) {
+	stores, _ := c.Get(REQUEST_CACHE_STORES_STRING).([]CacheStore)
+	c.Set(REQUEST_CACHE_STORES_STRING, append(stores, store))
+}
+
+// cacheSet stores with the tags when there are some and the store supports
+// them
+func cacheSet(store CacheStore, key string, value interface{}, ttl time.Duration, tags []string) error {
+	if tagged, ok := store.(TaggedCacheStore); ok && len(tags) > 0 {
+		return tagged.SetWithTags(key, value, ttl, tags...)
+	}
+	return store.Set(key, value, ttl)
+}
@@ -0,0 +1,204 @@
+package simplehttp_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/medatechnology/simplehttp"
+)
+
+func TestMemoryCacheTags(t *testing.T) {
+	clock := simplehttp.NewFakeClock(time.Now())
+	cache := simplehttp.NewMemoryCache(simplehttp.MemoryCacheConfig{Clock: clock})
+	defer cache.Stop()
+
+	cache.SetWithTags("user:1", "ann", time.Minute, "users", "user:1")
+	cache.SetWithTags("user:2", "bob", time.Minute, "users", "user:2")
+	cache.Set("config", "on", time.Minute)
+
+	cache.InvalidateTag("user:1")
+	if _, found := cache.Get("user:1"); found {
+		t.Error("user:1 kept after its tag was invalidated")
+	}
+	if _, found := cache.Get("user:2"); !found {
+		t.Error("user:2 dropped with another tag")
+	}
+
+	cache.InvalidateTag("users")
+	if _, found := cache.Get("user:2"); found {
+		t.Error("user:2 kept after a shared tag was invalidated")
+	}
+	if _, found := cache.Get("config"); !found {
+		t.Error("untagged item dropped")
+	}
+	// a key stored again without the tag isn't dropped by it
+	cache.Set("user:1", "ann", time.Minute)
+	cache.InvalidateTag("user:1")
+	if _, found := cache.Get("user:1"); !found {
+		t.Error("item dropped by a tag it no longer has")
+	}
+
+	clock.Advance(2 * time.Minute)
+	if _, found := cache.Get("config"); found {
+		t.Error("item kept after its TTL")
+	}
+}
+
+// cacheClient counts the handler runs and dispatches requests
+type cacheClient struct {
+	t      *testing.T
+	server simplehttp.Server
+	mu     sync.Mutex
+	runs   map[string]int
+}
+
+// run counts a handler run of key and returns the body telling it
+func (r *cacheClient) run(key string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.runs[key]++
+	return key + " " + strconv.Itoa(r.runs[key])
+}
+
+func (r *cacheClient) do(method, path string) string {
+	r.t.Helper()
+	resp, err := r.server.(simplehttp.Dispatcher).Dispatch(httptest.NewRequest(method, path, nil))
+	if err != nil {
+		r.t.Fatalf("dispatch: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+// TestCacheInvalidate drops tagged responses on every adapter
+func TestCacheInvalidate(t *testing.T) {
+	for name, newServer := range adapters {
+		t.Run(name, func(t *testing.T) {
+			cache := simplehttp.NewMemoryCache()
+			defer cache.Stop()
+			server := newServer()
+			client := &cacheClient{t: t, server: server, runs: make(map[string]int)}
+			server.Use(simplehttp.MiddlewareCache(simplehttp.CacheConfig{
+				TTL:   time.Minute,
+				Store: cache,
+				Tags:  func(c simplehttp.Context) []string { return []string{"all"} },
+			}))
+			server.GET("/users/:id", func(c simplehttp.Context) error {
+				simplehttp.CacheTag(c, "user:"+c.GetParam("id"))
+				return c.String(http.StatusOK, client.run("user "+c.GetParam("id")))
+			})
+			server.POST("/users/:id", func(c simplehttp.Context) error {
+				if err := simplehttp.CacheInvalidate(c, "user:"+c.GetParam("id")); err != nil {
+					return err
+				}
+				return c.String(http.StatusOK, "ok")
+			})
+			server.POST("/reset", func(c simplehttp.Context) error {
+				if err := simplehttp.CacheInvalidate(c, "all"); err != nil {
+					return err
+				}
+				return c.String(http.StatusOK, "ok")
+			})
+
+			steps := []struct {
+				method, path, want string
+			}{
+				{http.MethodGet, "/users/1", "user 1 1"},
+				{http.MethodGet, "/users/2", "user 2 1"},
+				{http.MethodGet, "/users/1", "user 1 1"}, // cached
+				{http.MethodPost, "/users/1", "ok"},
+				{http.MethodGet, "/users/1", "user 1 2"}, // invalidated
+				{http.MethodGet, "/users/2", "user 2 1"}, // another tag
+				{http.MethodPost, "/reset", "ok"},
+				{http.MethodGet, "/users/1", "user 1 3"}, // config tag
+				{http.MethodGet, "/users/2", "user 2 2"},
+			}
+			for i, step := range steps {
+				if got := client.do(step.method, step.path); got != step.want {
+					t.Errorf("step %d: %s %s = %q, want %q", i, step.method, step.path, got, step.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheInvalidateUnsupported(t *testing.T) {
+	server := adapters["echo"]()
+	var invalidateErr error
+	server.POST("/users", func(c simplehttp.Context) error {
+		invalidateErr = simplehttp.CacheInvalidate(c, "users")
+		return c.String(http.StatusOK, "ok")
+	}, simplehttp.MiddlewareCache(simplehttp.CacheConfig{TTL: time.Minute, Store: untaggedCache{}}))
+	(&cacheClient{t: t, server: server}).do(http.MethodPost, "/users")
+	if invalidateErr != simplehttp.ErrCacheTagsUnsupported {
+		t.Errorf("CacheInvalidate = %v, want ErrCacheTagsUnsupported", invalidateErr)
+	}
+}
+
+// untaggedCache is a CacheStore without tags
+type untaggedCache struct{}
+
+func (untaggedCache) Get(string) (interface{}, bool)               { return nil, false }
+func (untaggedCache) Set(string, interface{}, time.Duration) error { return nil }
+func (untaggedCache) Delete(string) error                          { return nil }
+func (untaggedCache) Clear() error                                 { return nil }
+
+// TestCacheStaleWhileRevalidate serves the expired response while one
+// request refreshes it, on every adapter
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	for name, newServer := range adapters {
+		t.Run(name, func(t *testing.T) {
+			clock := simplehttp.NewFakeClock(time.Now())
+			cache := simplehttp.NewMemoryCache(simplehttp.MemoryCacheConfig{Clock: clock})
+			defer cache.Stop()
+			server := newServer(simplehttp.WithConfig(func(c *simplehttp.Config) { c.Clock = clock }))
+			client := &cacheClient{t: t, server: server, runs: make(map[string]int)}
+			refreshing := make(chan struct{})
+			release := make(chan struct{})
+			server.GET("/report", func(c simplehttp.Context) error {
+				body := client.run("report")
+				if body == "report 2" {
+					close(refreshing)
+					<-release
+				}
+				return c.String(http.StatusOK, body)
+			}, simplehttp.MiddlewareCache(simplehttp.CacheConfig{
+				TTL:                  10 * time.Second,
+				StaleWhileRevalidate: 30 * time.Second,
+				Store:                cache,
+			}))
+
+			if got := client.do(http.MethodGet, "/report"); got != "report 1" {
+				t.Fatalf("first = %q, want report 1", got)
+			}
+			clock.Advance(20 * time.Second)
+
+			refreshed := make(chan string)
+			go func() { refreshed <- client.do(http.MethodGet, "/report") }()
+			<-refreshing
+			// the refresh is running, the others get the stale response
+			if got := client.do(http.MethodGet, "/report"); got != "report 1" {
+				t.Errorf("during the refresh = %q, want report 1", got)
+			}
+			close(release)
+			if got := <-refreshed; got != "report 2" {
+				t.Errorf("refreshing request = %q, want report 2", got)
+			}
+			if got := client.do(http.MethodGet, "/report"); got != "report 2" {
+				t.Errorf("after the refresh = %q, want report 2", got)
+			}
+
+			// past the stale window the handler runs again
+			clock.Advance(time.Minute)
+			if got := client.do(http.MethodGet, "/report"); got != "report 3" {
+				t.Errorf("past the stale window = %q, want report 3", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package simplehttp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/medatechnology/simplehttp/client"
+)
+
+const (
+	RECAPTCHA_VERIFY_URL = "https://www.google.com/recaptcha/api/siteverify"
+	HCAPTCHA_VERIFY_URL  = "https://api.hcaptcha.com/siteverify"
+	TURNSTILE_VERIFY_URL = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+
+	HEADER_CAPTCHA_TOKEN = "X-Captcha-Token"
+)
+
+// CaptchaResult is the outcome of a captcha verification
+type CaptchaResult struct {
+	Success    bool     `json:"success"`
+	Score      float64  `json:"score,omitempty"`  // reCAPTCHA v3 / hCaptcha enterprise
+	Action     string   `json:"action,omitempty"` // reCAPTCHA v3 / Turnstile
+	Hostname   string   `json:"hostname,omitempty"`
+	ErrorCodes []string `json:"error-codes,omitempty"`
+}
+
+// CaptchaProvider verifies a captcha token
+type CaptchaProvider interface {
+	// FormField is where the provider widget puts the token in HTML forms
+	FormField() string
+	Verify(ctx context.Context, token, remoteIP string) (*CaptchaResult, error)
+}
+
+// SiteVerifyProvider implements the siteverify protocol shared by reCAPTCHA,
+// hCaptcha and Cloudflare Turnstile
+type SiteVerifyProvider struct {
+	VerifyURL string
+	Secret    string
+	Field     string
+	Client    *client.Client // defaults to a client with a 10s timeout
+}
+
+func ReCaptcha(secret string) *SiteVerifyProvider {
+	return &SiteVerifyProvider{VerifyURL: RECAPTCHA_VERIFY_URL, Secret: secret, Field: "g-recaptcha-response"}
+}
+
+func HCaptcha(secret string) *SiteVerifyProvider {
+	return &SiteVerifyProvider{VerifyURL: HCAPTCHA_VERIFY_URL, Secret: secret, Field: "h-captcha-response"}
+}
+
+func Turnstile(secret string) *SiteVerifyProvider {
+	return &SiteVerifyProvider{VerifyURL: TURNSTILE_VERIFY_URL, Secret: secret, Field: "cf-turnstile-response"}
+}
+
+func (p *SiteVerifyProvider) FormField() string {
+	return p.Field
+}
+
+func (p *SiteVerifyProvider) Verify(ctx context.Context, token, remoteIP string) (*CaptchaResult, error) {
+	cl := p.Client
+	if cl == nil {
+		cl = defaultCaptchaClient()
+	}
+	form := url.Values{"secret": {p.Secret}, "response": {token}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	result, err := client.PostAs[CaptchaResult](cl, p.VerifyURL, form,
+		client.WithFormContentType(), client.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+var (
+	captchaClient     *client.Client
+	captchaClientOnce sync.Once
+)
+
+func defaultCaptchaClient() *client.Client {
+	captchaClientOnce.Do(func() {
+		captchaClient = client.NewClient(client.WithTimeout(10*time.Second), client.WithMaxRetries(1))
+	})
+	return captchaClient
+}
+
+// CaptchaConfig configures MiddlewareCaptcha
+type CaptchaConfig struct {
+	Provider   CaptchaProvider
+	Header     string   // token header, defaults to X-Captcha-Token
+	FormField  string   // token form field, defaults to the provider's
+	MinScore   float64  // minimum score for score based captchas, 0 disables
+	Action     string   // expected action, empty disables the check
+	Bypass     []string // CIDRs/IPs that skip verification (monitoring, internal tools)
+	BypassFunc func(c Context) bool
+	Skipper    Skipper
+}
+
+func MiddlewareCaptcha(config CaptchaConfig) Middleware {
+	return Skip(WithName("captcha", Captcha(config)), config.Skipper)
+}
+
+// Captcha verifies the captcha token before the handler runs, 403 when it is
+// missing, invalid or scores below MinScore
+func Captcha(config CaptchaConfig) MiddlewareFunc {
+	if config.Provider == nil {
+		panic("simplehttp: CaptchaConfig.Provider is required")
+	}
+	if config.Header == "" {
+		config.Header = HEADER_CAPTCHA_TOKEN
+	}
+	if config.FormField == "" {
+		config.FormField = config.Provider.FormField()
+	}
+	bypass, err := ParseCIDRs(config.Bypass)
+	if err != nil {
+		panic("simplehttp: invalid bypass CIDR for Captcha: " + err.Error())
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			remoteIP := StripPort(c.GetHeaders().IP())
+			if IPInNets(net.ParseIP(remoteIP), bypass) || (config.BypassFunc != nil && config.BypassFunc(c)) {
+				return next(c)
+			}
+
+			token := c.GetHeader(config.Header)
+			if token == "" && config.FormField != "" {
+				token = c.Request().FormValue(config.FormField)
+			}
+			if token == "" {
+				return NewError(http.StatusForbidden, "captcha required")
+			}
+
+			result, err := config.Provider.Verify(c.Context(), token, remoteIP)
+			if err != nil {
+				return NewError(http.StatusServiceUnavailable, "captcha verification unavailable", err.Error())
+			}
+			if !result.Success {
+				return NewError(http.StatusForbidden, "captcha verification failed", result.ErrorCodes)
+			}
+			if config.MinScore > 0 && result.Score < config.MinScore {
+				return NewError(http.StatusForbidden, "captcha verification failed",
+					fmt.Sprintf("score %.2f below %.2f", result.Score, config.MinScore))
+			}
+			if config.Action != "" && result.Action != config.Action {
+				return NewError(http.StatusForbidden, "captcha verification failed", "unexpected action "+result.Action)
+			}
+			return next(c)
+		}
+	}
+}
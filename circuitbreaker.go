@@ -0,0 +1,203 @@
+package simplehttp
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_BREAKER_FAILURE_RATIO = 0.5
+	DEFAULT_BREAKER_MIN_REQUESTS  = 20
+	DEFAULT_BREAKER_WINDOW        = 10 * time.Second
+	DEFAULT_BREAKER_OPEN_TIMEOUT  = 30 * time.Second
+)
+
+// CircuitState is the state of one breaker
+type CircuitState string
+
+const (
+	CircuitClosed   CircuitState = "closed"    // requests pass, failures are counted
+	CircuitOpen     CircuitState = "open"      // requests fail fast with 503
+	CircuitHalfOpen CircuitState = "half-open" // a few probes pass to test the backend
+)
+
+// CircuitBreakerConfig configures MiddlewareCircuitBreaker
+type CircuitBreakerConfig struct {
+	// KeyFunc gives every key its own breaker, e.g. one per upstream. Keep
+	// the number of keys bounded, breakers are never dropped.
+	KeyFunc          func(Context) string
+	FailureRatio     float64       // opens when failures/requests reaches it, default 0.5
+	MinRequests      int           // requests in the window before the ratio counts, default 20
+	Window           time.Duration // the counts start over every Window, default 10s
+	OpenTimeout      time.Duration // time open before probing, default 30s
+	HalfOpenRequests int           // probes in half-open, all must succeed to close, default 1
+	// IsFailure decides what counts as a failure, default a 5xx status
+	IsFailure     func(status int, err error) bool
+	OnStateChange func(key string, from, to CircuitState)
+	Skipper       Skipper
+}
+
+// CircuitBreaker holds the breakers of one middleware
+type CircuitBreaker struct {
+	config   CircuitBreakerConfig
+	mu       sync.Mutex
+	breakers map[string]*breaker
+	changes  []func()
+}
+
+type breaker struct {
+	state    CircuitState
+	since    time.Time // start of the counting window, or when it opened
+	requests int
+	failures int
+	probes   int // half-open requests in flight
+	passed   int // half-open requests that succeeded
+}
+
+func NewCircuitBreaker(config CircuitBreakerConfig) *CircuitBreaker {
+	if config.FailureRatio <= 0 {
+		config.FailureRatio = DEFAULT_BREAKER_FAILURE_RATIO
+	}
+	if config.MinRequests <= 0 {
+		config.MinRequests = DEFAULT_BREAKER_MIN_REQUESTS
+	}
+	if config.Window == 0 {
+		config.Window = DEFAULT_BREAKER_WINDOW
+	}
+	if config.OpenTimeout == 0 {
+		config.OpenTimeout = DEFAULT_BREAKER_OPEN_TIMEOUT
+	}
+	if config.HalfOpenRequests <= 0 {
+		config.HalfOpenRequests = 1
+	}
+	if config.IsFailure == nil {
+		config.IsFailure = func(status int, err error) bool { return status >= http.StatusInternalServerError }
+	}
+	return &CircuitBreaker{config: config, breakers: make(map[string]*breaker)}
+}
+
+// MiddlewareCircuitBreaker fails fast with 503 while the routes it wraps keep
+// failing, instead of tying up workers on a dead backend
+func MiddlewareCircuitBreaker(config CircuitBreakerConfig) Middleware {
+	return NewCircuitBreaker(config).Middleware()
+}
+
+func (cb *CircuitBreaker) Middleware() Middleware {
+	return Skip(WithName("circuit breaker", func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			key := ""
+			if cb.config.KeyFunc != nil {
+				key = cb.config.KeyFunc(c)
+			}
+			probe, wait, ok := cb.allow(key)
+			if !ok {
+				c.SetResponseHeader("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				return NewError(http.StatusServiceUnavailable, "service unavailable, circuit open")
+			}
+			// a panic counts as a failure, and must not leave a probe in flight
+			failed := true
+			defer func() { cb.done(key, probe, failed) }()
+			err := next(c)
+			failed = cb.config.IsFailure(statusOf(c, err), err)
+			return err
+		}
+	}), cb.config.Skipper)
+}
+
+// States returns the state of every breaker, e.g. for the admin UI metrics
+func (cb *CircuitBreaker) States() map[string]CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	states := make(map[string]CircuitState, len(cb.breakers))
+	for key, b := range cb.breakers {
+		states[key] = b.state
+	}
+	return states
+}
+
+// allow reports whether the request may pass, probe when it is a half-open
+// probe, and otherwise how long the breaker stays open
+func (cb *CircuitBreaker) allow(key string) (probe bool, wait time.Duration, ok bool) {
+	now := time.Now()
+	cb.mu.Lock()
+	defer cb.unlock()
+	b, found := cb.breakers[key]
+	if !found {
+		b = &breaker{state: CircuitClosed, since: now}
+		cb.breakers[key] = b
+	}
+
+	switch b.state {
+	case CircuitOpen:
+		if now.Sub(b.since) < cb.config.OpenTimeout {
+			return false, cb.config.OpenTimeout - now.Sub(b.since), false
+		}
+		cb.setState(key, b, CircuitHalfOpen, now)
+		fallthrough
+	case CircuitHalfOpen:
+		if b.probes+b.passed >= cb.config.HalfOpenRequests {
+			return false, time.Second, false
+		}
+		b.probes++
+		return true, 0, true
+	default:
+		if now.Sub(b.since) >= cb.config.Window {
+			b.since, b.requests, b.failures = now, 0, 0
+		}
+		return false, 0, true
+	}
+}
+
+func (cb *CircuitBreaker) done(key string, probe, failed bool) {
+	now := time.Now()
+	cb.mu.Lock()
+	defer cb.unlock()
+	b := cb.breakers[key]
+
+	if probe {
+		if b.state != CircuitHalfOpen {
+			return
+		}
+		b.probes--
+		if failed {
+			cb.setState(key, b, CircuitOpen, now)
+		} else if b.passed++; b.passed >= cb.config.HalfOpenRequests {
+			cb.setState(key, b, CircuitClosed, now)
+		}
+		return
+	}
+
+	if b.state != CircuitClosed {
+		return
+	}
+	b.requests++
+	if failed {
+		b.failures++
+	}
+	if b.requests >= cb.config.MinRequests && float64(b.failures)/float64(b.requests) >= cb.config.FailureRatio {
+		cb.setState(key, b, CircuitOpen, now)
+	}
+}
+
+// setState resets the counts for the new state, OnStateChange runs once the
+// lock is released
+func (cb *CircuitBreaker) setState(key string, b *breaker, state CircuitState, now time.Time) {
+	from := b.state
+	*b = breaker{state: state, since: now}
+	if cb.config.OnStateChange != nil {
+		cb.changes = append(cb.changes, func() { cb.config.OnStateChange(key, from, state) })
+	}
+}
+
+// unlock releases the lock and reports the state changes made under it
+func (cb *CircuitBreaker) unlock() {
+	changes := cb.changes
+	cb.changes = nil
+	cb.mu.Unlock()
+	for _, change := range changes {
+		change()
+	}
+}
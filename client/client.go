@@ -2,13 +2,13 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strings"
-	"time"
 )
 
 // Get performs an HTTP GET request and returns the result as a JSON map
@@ -71,7 +71,7 @@ func RequestAs[T any](c *Client, method, endpoint string, body interface{}, opti
 	}
 	defer resp.Body.Close()
 
-	return DecodeResponse[T](resp)
+	return DecodeResponse[T](resp, c.Config.JSONCodec)
 }
 
 // Request performs an HTTP request and returns the raw response
@@ -88,7 +88,7 @@ func (c *Client) Request(method, endpoint string, body interface{}, options ...C
 	fullURL := buildURL(reqConfig.BaseURL, endpoint, reqConfig.QueryParams)
 
 	// Prepare the request body once
-	bodyData, contentType, err := prepareRequestBody(body, reqConfig.ContentType)
+	bodyData, contentType, err := prepareRequestBody(body, reqConfig.ContentType, reqConfig.JSONCodec)
 	if err != nil {
 		return nil, fmt.Errorf("failed to prepare request body: %w", err)
 	}
@@ -98,23 +98,64 @@ func (c *Client) Request(method, endpoint string, body interface{}, options ...C
 		reqConfig.ContentType = contentType
 	}
 
-	// Create a new request with a fresh body reader
-	var bodyReader io.Reader
-	if bodyData != nil {
-		bodyReader = bytes.NewReader(bodyData)
+	compressed, ok, err := compressRequestBody(bodyData, reqConfig.ContentType, &reqConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress request body: %w", err)
+	}
+	if ok {
+		// a copy, the headers map is shared with the client
+		headers := http.Header(reqConfig.Headers).Clone()
+		headers.Set("Content-Encoding", ENCODING_GZIP)
+		reqConfig.Headers, bodyData = headers, compressed
+	}
+
+	return c.send(method, fullURL, &reqConfig, func() (io.Reader, error) {
+		// a fresh body reader per attempt
+		if bodyData == nil {
+			return nil, nil
+		}
+		return bytes.NewReader(bodyData), nil
+	})
+}
+
+// send executes the request with retries, newBody is called for every
+// attempt
+func (c *Client) send(method, fullURL string, reqConfig *ClientConfig, newBody func() (io.Reader, error)) (*http.Response, error) {
+	ctx := reqConfig.Context
+	if ctx == nil {
+		ctx = context.Background()
 	}
 
+	// One key for all attempts, so the server can tell a retry from a new
+	// request
+	idempotency := idempotencyKey(method, reqConfig)
+
 	// Execute request with retries
 	var resp *http.Response
 	var lastErr error
 
+	// cancels the attempt, so a stalled body read can be aborted
+	cancel := context.CancelFunc(func() {})
+
 	for attempt := 0; attempt < reqConfig.MaxRetries; attempt++ {
 		if attempt > 0 {
-			time.Sleep(reqConfig.RetryDelay)
+			// do not retry past the deadline of the inbound request
+			if err := waitRetry(ctx, reqConfig.RetryDelay); err != nil {
+				lastErr = err
+				break
+			}
+		}
+
+		bodyReader, err := newBody()
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare request body: %w", err)
 		}
 
-		req, err := http.NewRequest(method, fullURL, bodyReader)
+		var attemptCtx context.Context
+		attemptCtx, cancel = attemptContext(ctx, reqConfig)
+		req, err := http.NewRequestWithContext(attemptCtx, method, fullURL, bodyReader)
 		if err != nil {
+			cancel()
 			return nil, fmt.Errorf("failed to create request: %w", err)
 		}
 
@@ -124,13 +165,22 @@ func (c *Client) Request(method, endpoint string, body interface{}, options ...C
 				req.Header.Add(key, value)
 			}
 		}
+		if idempotency != "" && req.Header.Get(HEADER_IDEMPOTENCY_KEY) == "" {
+			req.Header.Set(HEADER_IDEMPOTENCY_KEY, idempotency)
+		}
 		// Set content type if needed
 		if req.Header.Get("Content-Type") == "" {
 			req.Header.Set("Content-Type", reqConfig.ContentType)
 		}
 
+		// Advertise extra encodings, decoding is done below since the transport
+		// stops decompressing once Accept-Encoding is set explicitly
+		if len(reqConfig.AcceptEncoding) > 0 && req.Header.Get("Accept-Encoding") == "" {
+			req.Header.Set("Accept-Encoding", strings.Join(reqConfig.AcceptEncoding, ", "))
+		}
+
 		// Apply authentication
-		applyAuth(req, &reqConfig)
+		applyAuth(req, reqConfig)
 
 		// Execute the request
 		resp, err = c.HTTPClient.Do(req)
@@ -141,12 +191,14 @@ func (c *Client) Request(method, endpoint string, body interface{}, options ...C
 			// Check if we should retry based on response
 			if reqConfig.RetryPolicy != nil && reqConfig.RetryPolicy(resp, nil) {
 				resp.Body.Close()
+				cancel()
 				continue
 			}
 			// No need to retry
 			break
 		}
 
+		cancel()
 		// Check if we should retry, if no retrypolicy then we also do not retry!
 		if attempt >= reqConfig.MaxRetries || reqConfig.RetryPolicy == nil || !reqConfig.RetryPolicy(nil, err) {
 			return nil, fmt.Errorf("request failed: %w(%d)", err, attempt)
@@ -155,9 +207,26 @@ func (c *Client) Request(method, endpoint string, body interface{}, options ...C
 	}
 
 	if resp == nil {
+		cancel()
 		return nil, fmt.Errorf("all request attempts failed: %w", lastErr)
 	}
 
+	if reqConfig.StallTimeout > 0 {
+		resp.Body = newStalledBody(resp.Body, reqConfig.StallTimeout, cancel)
+	}
+
+	if len(reqConfig.AcceptEncoding) > 0 {
+		if err := decompressResponse(resp); err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to decompress response: %w", err)
+		}
+	}
+
+	if err := limitResponse(resp, reqConfig); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
 	// Check for error status codes
 	// if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 	// 	errorBody, _ := io.ReadAll(resp.Body)
@@ -168,8 +237,9 @@ func (c *Client) Request(method, endpoint string, body interface{}, options ...C
 	return resp, nil
 }
 
-// DecodeResponse is a generic function to decode an HTTP response into the specified type
-func DecodeResponse[T any](resp *http.Response) (T, error) {
+// DecodeResponse is a generic function to decode an HTTP response into the specified type.
+// Optionally pass a JSONCodec, otherwise encoding/json is used.
+func DecodeResponse[T any](resp *http.Response, codecs ...JSONCodec) (T, error) {
 	var result T
 
 	if resp == nil {
@@ -201,6 +271,16 @@ func DecodeResponse[T any](resp *http.Response) (T, error) {
 	}
 
 	// For all other types, try to decode as JSON
+	if len(codecs) > 0 && codecs[0] != nil {
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return result, fmt.Errorf("failed to read response body: %w", err)
+		}
+		if err := codecs[0].Unmarshal(data, &result); err != nil {
+			return result, fmt.Errorf("failed to decode response: %w", err)
+		}
+		return result, nil
+	}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return result, fmt.Errorf("failed to decode response: %w", err)
 	}
@@ -254,10 +334,14 @@ func buildURL(baseURL, endpoint string, queryParams map[string]string) string {
 }
 
 // prepareRequestBody prepares the request body and returns the byte data and appropriate content type
-func prepareRequestBody(body interface{}, contentType string) ([]byte, string, error) {
+func prepareRequestBody(body interface{}, contentType string, codec JSONCodec) ([]byte, string, error) {
 	if body == nil {
 		return nil, contentType, nil
 	}
+	marshal, unmarshal := json.Marshal, json.Unmarshal
+	if codec != nil {
+		marshal, unmarshal = codec.Marshal, codec.Unmarshal
+	}
 
 	// Handle byte slice and string directly
 	switch v := body.(type) {
@@ -285,12 +369,12 @@ func prepareRequestBody(body interface{}, contentType string) ([]byte, string, e
 			formData = v
 		default:
 			// Try to marshal to JSON and unmarshal to map
-			jsonData, err := json.Marshal(body)
+			jsonData, err := marshal(body)
 			if err != nil {
 				return nil, contentType, fmt.Errorf("failed to marshal data: %w", err)
 			}
 
-			if err := json.Unmarshal(jsonData, &formData); err != nil {
+			if err := unmarshal(jsonData, &formData); err != nil {
 				return nil, contentType, fmt.Errorf("failed to convert to form data: %w", err)
 			}
 		}
@@ -305,7 +389,7 @@ func prepareRequestBody(body interface{}, contentType string) ([]byte, string, e
 	}
 
 	// Default to JSON
-	jsonData, err := json.Marshal(body)
+	jsonData, err := marshal(body)
 	if err != nil {
 		return nil, contentType, fmt.Errorf("failed to marshal JSON: %w", err)
 	}
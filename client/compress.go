@@ -0,0 +1,135 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	ENCODING_ZSTD    = "zstd"
+	ENCODING_BROTLI  = "br"
+	ENCODING_GZIP    = "gzip"
+	ENCODING_DEFLATE = "deflate"
+)
+
+// AllEncodings is the Accept-Encoding list used by WithCompression, in
+// preference order.
+var AllEncodings = []string{ENCODING_ZSTD, ENCODING_BROTLI, ENCODING_GZIP, ENCODING_DEFLATE}
+
+// WithAcceptEncoding advertises the given encodings in Accept-Encoding and
+// transparently decompresses the response body. Without this option the
+// standard transport only negotiates gzip.
+func WithAcceptEncoding(encodings ...string) ClientOption {
+	return func(c *ClientConfig) {
+		c.AcceptEncoding = encodings
+	}
+}
+
+// WithCompression accepts zstd, br, gzip and deflate responses
+func WithCompression() ClientOption {
+	return WithAcceptEncoding(AllEncodings...)
+}
+
+// WithCompressRequests gzips request bodies of at least minSize bytes and
+// sets Content-Encoding: gzip, for large JSON, XML, text or form payloads
+// sent over slow links. Other content types, usually compressed already,
+// are sent as they are. The server must accept gzip request bodies.
+func WithCompressRequests(minSize int) ClientOption {
+	return func(c *ClientConfig) {
+		c.CompressRequestsMinSize = max(minSize, 1)
+	}
+}
+
+// compressRequestBody gzips data when the config asks for it, reporting
+// whether it did
+func compressRequestBody(data []byte, contentType string, config *ClientConfig) ([]byte, bool, error) {
+	if config.CompressRequestsMinSize <= 0 || len(data) < config.CompressRequestsMinSize || !compressibleType(contentType) {
+		return data, false, nil
+	}
+	if encoding := http.Header(config.Headers).Get("Content-Encoding"); encoding != "" {
+		return data, false, nil
+	}
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(data); err != nil {
+		return nil, false, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, false, err
+	}
+	return buf.Bytes(), true, nil
+}
+
+func compressibleType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(strings.ToLower(contentType), ";")
+	mediaType = strings.TrimSpace(mediaType)
+	switch {
+	case mediaType == CONTENT_TYPE_JSON, mediaType == CONTENT_TYPE_XML, mediaType == CONTENT_TYPE_FORM,
+		strings.HasPrefix(mediaType, "text/"), strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	return false
+}
+
+// decompressResponse replaces resp.Body with a decoding reader based on the
+// Content-Encoding header. Unknown encodings are left untouched.
+func decompressResponse(resp *http.Response) error {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if encoding == "" || encoding == "identity" {
+		return nil
+	}
+
+	var reader io.Reader
+	var closer func()
+	switch encoding {
+	case ENCODING_GZIP:
+		gr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return err
+		}
+		reader, closer = gr, func() { gr.Close() }
+	case ENCODING_DEFLATE:
+		zr, err := zlib.NewReader(resp.Body)
+		if err != nil {
+			return err
+		}
+		reader, closer = zr, func() { zr.Close() }
+	case ENCODING_BROTLI:
+		reader = brotli.NewReader(resp.Body)
+	case ENCODING_ZSTD:
+		zr, err := zstd.NewReader(resp.Body)
+		if err != nil {
+			return err
+		}
+		reader, closer = zr, zr.Close
+	default:
+		return nil
+	}
+
+	resp.Body = &decodedBody{Reader: reader, body: resp.Body, closer: closer}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+type decodedBody struct {
+	io.Reader
+	body   io.ReadCloser
+	closer func()
+}
+
+func (d *decodedBody) Close() error {
+	if d.closer != nil {
+		d.closer()
+	}
+	return d.body.Close()
+}
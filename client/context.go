@@ -0,0 +1,53 @@
+package client
+
+import (
+	"context"
+	"time"
+)
+
+// ContextProvider is anything that carries a request context, a
+// simplehttp.Context satisfies it.
+type ContextProvider interface {
+	Context() context.Context
+}
+
+// WithContext attaches ctx to the request, the call (including retries) is
+// cancelled when ctx is done or its deadline passes.
+func WithContext(ctx context.Context) ClientOption {
+	return func(cfg *ClientConfig) {
+		cfg.Context = ctx
+	}
+}
+
+// FromContext propagates the inbound request budget to an outbound call, so
+// the downstream call never outlives the request that triggered it.
+//
+//	resp, err := api.Request("GET", "/users", nil, client.FromContext(c))
+func FromContext(c ContextProvider) ClientOption {
+	return WithContext(c.Context())
+}
+
+// Remaining returns the time left before the deadline of ctx, false when ctx
+// has no deadline.
+func Remaining(ctx context.Context) (time.Duration, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	return time.Until(deadline), true
+}
+
+// waitRetry sleeps for delay unless ctx is done first
+func waitRetry(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+)
+
+const HEADER_IDEMPOTENCY_KEY = "Idempotency-Key"
+
+// WithIdempotencyKey sends key as the Idempotency-Key header of a POST, PUT,
+// PATCH or DELETE request. Every retry of the request carries the same key,
+// so a server deduplicating on it executes the request once even when a
+// response was lost. Use it per request, a client-wide key would make
+// different requests look like retries of one, see WithAutoIdempotencyKey.
+//
+//	resp, err := api.Request(http.MethodPost, "/payments", payment, client.WithIdempotencyKey(payment.ID))
+func WithIdempotencyKey(key string) ClientOption {
+	return func(c *ClientConfig) {
+		c.IdempotencyKey = key
+	}
+}
+
+// WithAutoIdempotencyKey generates a random Idempotency-Key for every POST,
+// PUT, PATCH or DELETE request without one, kept across its retries. The
+// key sent is in resp.Request.Header.
+func WithAutoIdempotencyKey() ClientOption {
+	return func(c *ClientConfig) {
+		c.AutoIdempotencyKey = true
+	}
+}
+
+// idempotencyKey returns the key for a request, empty for safe methods
+func idempotencyKey(method string, config *ClientConfig) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return ""
+	}
+	if config.IdempotencyKey != "" {
+		return config.IdempotencyKey
+	}
+	if config.AutoIdempotencyKey {
+		return newIdempotencyKey()
+	}
+	return ""
+}
+
+// newIdempotencyKey returns a random UUID v4
+func newIdempotencyKey() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrResponseTooLarge is returned by the reads of a response body past
+	// MaxResponseBytes, and by Request when Content-Length already says so
+	ErrResponseTooLarge = errors.New("response body too large")
+	// ErrResponseStalled is returned by the reads of a response body that
+	// made no progress for StallTimeout
+	ErrResponseStalled = errors.New("response body stalled")
+)
+
+// WithMaxResponseBytes fails responses larger than n bytes, after
+// decompression, so a misbehaving upstream can't exhaust the memory of the
+// service. The body reads fail with ErrResponseTooLarge, so do the typed
+// helpers like GetAs and DecodeResponse.
+func WithMaxResponseBytes(n int64) ClientOption {
+	return func(c *ClientConfig) {
+		c.MaxResponseBytes = n
+	}
+}
+
+// WithStallTimeout cancels the request when reading its body makes no
+// progress for d. Unlike WithTimeout it doesn't limit slow but steady
+// downloads.
+func WithStallTimeout(d time.Duration) ClientOption {
+	return func(c *ClientConfig) {
+		c.StallTimeout = d
+	}
+}
+
+// limitedBody fails the read past max bytes
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, ErrResponseTooLarge
+	}
+	// read one byte past the limit to tell "exactly max" from "more"
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n + int(b.remaining), ErrResponseTooLarge
+	}
+	return n, err
+}
+
+// stalledBody cancels the request when a read blocks for timeout, the time
+// spent by the caller between reads doesn't count
+type stalledBody struct {
+	io.ReadCloser
+	timer   *time.Timer
+	timeout time.Duration
+	cancel  context.CancelFunc
+	stalled atomic.Bool
+}
+
+func newStalledBody(body io.ReadCloser, timeout time.Duration, cancel context.CancelFunc) *stalledBody {
+	b := &stalledBody{ReadCloser: body, timeout: timeout, cancel: cancel}
+	b.timer = time.AfterFunc(timeout, func() {
+		b.stalled.Store(true)
+		cancel()
+	})
+	b.timer.Stop()
+	return b
+}
+
+func (b *stalledBody) Read(p []byte) (int, error) {
+	b.timer.Reset(b.timeout)
+	n, err := b.ReadCloser.Read(p)
+	if !b.timer.Stop() && b.stalled.Load() {
+		return n, fmt.Errorf("%w: no data for %v", ErrResponseStalled, b.timeout)
+	}
+	return n, err
+}
+
+func (b *stalledBody) Close() error {
+	b.timer.Stop()
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// attemptContext returns the context of one attempt, cancellable when a
+// stalled body read must be aborted. The body returned to the caller owns
+// the cancel, see stalledBody.
+func attemptContext(ctx context.Context, config *ClientConfig) (context.Context, context.CancelFunc) {
+	if config.StallTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithCancel(ctx)
+}
+
+// limitResponse applies MaxResponseBytes to a response ready for the caller
+func limitResponse(resp *http.Response, config *ClientConfig) error {
+	if config.MaxResponseBytes <= 0 {
+		return nil
+	}
+	if resp.ContentLength > config.MaxResponseBytes {
+		return fmt.Errorf("%w: %d bytes, limit %d", ErrResponseTooLarge, resp.ContentLength, config.MaxResponseBytes)
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: config.MaxResponseBytes}
+	return nil
+}
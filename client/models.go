@@ -1,6 +1,8 @@
 package client
 
 import (
+	"context"
+	"encoding/json"
 	"net"
 	"net/http"
 	"time"
@@ -40,6 +42,7 @@ type StatusCode int
 type ClientConfig struct {
 	// Basic settings
 	BaseURL     string
+	HealthPath  string // requested by WarmUp and KeepWarm, empty means BaseURL
 	Headers     map[string][]string
 	QueryParams map[string]string
 	ContentType string
@@ -50,9 +53,35 @@ type ClientConfig struct {
 	Token     string
 	TokenType string
 
+	// Idempotency-Key of unsafe requests, the same on every retry. A fixed
+	// key is meant per request, AutoIdempotencyKey generates one per request.
+	IdempotencyKey     string
+	AutoIdempotencyKey bool
+
+	// Response protection, 0 disables: the most bytes read from a body
+	// and the longest a body read may block
+	MaxResponseBytes int64
+	StallTimeout     time.Duration
+
+	// Progress of UploadFile
+	UploadProgress UploadProgress
+
 	// Error handling
 	ErrorResult interface{}
 
+	// JSON encoding/decoding of bodies, nil means encoding/json
+	JSONCodec JSONCodec
+
+	// Response compression, empty means the transport default (gzip only)
+	AcceptEncoding []string
+
+	// Request bodies of at least this many bytes are gzipped, 0 disables
+	CompressRequestsMinSize int
+
+	// Context of the request, usually the inbound request context so its
+	// deadline is propagated (see FromContext). Nil means context.Background.
+	Context context.Context
+
 	// Timeout settings
 	Timeout               time.Duration
 	DialTimeout           time.Duration
@@ -73,6 +102,13 @@ type ClientConfig struct {
 	RetryPolicy RetryPolicy
 }
 
+// JSONCodec marshals request bodies and decodes responses. simplehttp.JSONCodec
+// values satisfy this interface, so the same codec can be shared with the server.
+type JSONCodec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
 // RetryPolicy determines if a request should be retried
 type RetryPolicy func(resp *http.Response, err error) bool
 
@@ -225,6 +261,36 @@ func WithErrorResult(result interface{}) ClientOption {
 	}
 }
 
+// WithJSONCodec sets the JSON codec (jsoniter, go-json, sonic, ...) for bodies
+func WithJSONCodec(codec JSONCodec) ClientOption {
+	return func(c *ClientConfig) {
+		c.JSONCodec = codec
+	}
+}
+
+// WithJSONFuncs sets the JSON marshal/unmarshal functions, e.g. sonic.Marshal
+// and sonic.Unmarshal. Either one may be nil to keep encoding/json for it.
+func WithJSONFuncs(marshal func(v interface{}) ([]byte, error), unmarshal func(data []byte, v interface{}) error) ClientOption {
+	return func(c *ClientConfig) {
+		if marshal == nil {
+			marshal = json.Marshal
+		}
+		if unmarshal == nil {
+			unmarshal = json.Unmarshal
+		}
+		c.JSONCodec = jsonFuncs{marshal: marshal, unmarshal: unmarshal}
+	}
+}
+
+type jsonFuncs struct {
+	marshal   func(v interface{}) ([]byte, error)
+	unmarshal func(data []byte, v interface{}) error
+}
+
+func (j jsonFuncs) Marshal(v interface{}) ([]byte, error) { return j.marshal(v) }
+
+func (j jsonFuncs) Unmarshal(data []byte, v interface{}) error { return j.unmarshal(data, v) }
+
 // WithTimeout sets the overall request timeout
 func WithTimeout(timeout time.Duration) ClientOption {
 	return func(c *ClientConfig) {
@@ -0,0 +1,110 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// WithHealthPath sets the path requested by WarmUp and KeepWarm, default the
+// base URL itself. A cheap endpoint like /health keeps the warm-up out of
+// the server's real work.
+func WithHealthPath(path string) ClientOption {
+	return func(c *ClientConfig) {
+		c.HealthPath = path
+	}
+}
+
+// WarmUp opens n keep-alive connections to the base URL, TLS handshake
+// included, so the first real requests don't pay for them. It sends n
+// concurrent HEAD requests to the HealthPath and holds every response until
+// all arrived, forcing distinct connections, which then stay idle in the
+// pool. n above MaxIdleConnsPerHost is capped to it, the pool would close
+// the rest. Any status counts, only connection errors are returned.
+//
+//	api := client.NewClient(client.WithBaseURL("https://payments.internal"), client.WithHealthPath("/health"))
+//	if err := api.WarmUp(ctx, 8); err != nil {
+//		log.Printf("warm-up: %v", err)
+//	}
+func (c *Client) WarmUp(ctx context.Context, n int) error {
+	if limit := c.Config.MaxIdleConnsPerHost; limit > 0 && n > limit {
+		n = limit
+	}
+	if n <= 0 {
+		return nil
+	}
+	target := buildURL(c.Config.BaseURL, c.Config.HealthPath, nil)
+
+	var (
+		arrived sync.WaitGroup // released when every request has its response
+		done    sync.WaitGroup
+		mu      sync.Mutex
+		errs    []error
+	)
+	arrived.Add(n)
+	done.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer done.Done()
+			resp, err := c.ping(ctx, target)
+			arrived.Done()
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+				return
+			}
+			arrived.Wait()
+			// drained so the connection goes back to the pool
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}()
+	}
+	done.Wait()
+	if len(errs) > 0 {
+		return fmt.Errorf("warm-up: %d of %d connections failed: %w", len(errs), n, errors.Join(errs...))
+	}
+	return nil
+}
+
+// KeepWarm runs WarmUp every interval until ctx is done, so connections are
+// reused before the server or a load balancer closes them as idle. Pick an
+// interval below IdleConnectionTimeout and the server's idle timeout.
+// onError, when not nil, gets the failed rounds, e.g. to mark the upstream
+// unhealthy.
+//
+//	go api.KeepWarm(ctx, 4, 20*time.Second, func(err error) { log.Printf("payments: %v", err) })
+func (c *Client) KeepWarm(ctx context.Context, n int, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := c.WarmUp(ctx, n); err != nil && onError != nil && ctx.Err() == nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ping sends a HEAD request with the headers and authentication of the
+// client, without retries
+func (c *Client) ping(ctx context.Context, target string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range c.Config.Headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	applyAuth(req, &c.Config)
+	return c.HTTPClient.Do(req)
+}
@@ -0,0 +1,89 @@
+package simplehttp
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time to the rate limiter, cache TTLs, sessions, remember-me
+// expiry and log timestamps, so tests can control it with a FakeClock
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the real time
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time { return time.Now() }
+
+// DefaultClock is used by components whose Clock is not set
+var DefaultClock Clock = SystemClock{}
+
+// ClockOf returns the clock of the config, DefaultClock when it has none
+func ClockOf(config *Config) Clock {
+	if config == nil || config.Clock == nil {
+		return DefaultClock
+	}
+	return config.Clock
+}
+
+// clockOr returns clock, DefaultClock when it is nil
+func clockOr(clock Clock) Clock {
+	if clock == nil {
+		return DefaultClock
+	}
+	return clock
+}
+
+// ClockContext is implemented by the contexts of the framework packages, they
+// return ClockOf the server config
+type ClockContext interface {
+	Clock() Clock
+}
+
+// clockFor returns clock, the Clock of the server of c when it is nil, so the
+// middleware follow Config.Clock without being passed it
+func clockFor(c Context, clock Clock) Clock {
+	if clock != nil {
+		return clock
+	}
+	if cc, ok := c.(ClockContext); ok {
+		return cc.Clock()
+	}
+	return DefaultClock
+}
+
+// FakeClock is a Clock that only moves when told to, safe for concurrent use
+//
+//	clock := simplehttp.NewFakeClock(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+//	cache := simplehttp.NewMemoryCache(simplehttp.MemoryCacheConfig{Clock: clock})
+//	cache.Set("k", "v", time.Minute)
+//	clock.Advance(2 * time.Minute) // "k" is expired now
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (f *FakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Advance moves the clock forward by d
+func (f *FakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	f.now = f.now.Add(d)
+	f.mu.Unlock()
+}
+
+// Set moves the clock to t
+func (f *FakeClock) Set(t time.Time) {
+	f.mu.Lock()
+	f.now = t
+	f.mu.Unlock()
+}
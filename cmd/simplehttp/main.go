@@ -0,0 +1,166 @@
+// Command simplehttp scaffolds new services on the simplehttp package:
+//
+//	go run github.com/medatechnology/simplehttp/cmd/simplehttp new -module github.com/acme/orders -framework fiber -preset api orders
+package main
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates/*.tmpl
+var templates embed.FS
+
+var (
+	frameworks = []string{"fiber", "echo", "fasthttp"}
+	presets    = []string{"minimal", "api", "web"}
+)
+
+// files maps the templates to the generated files
+var files = map[string]string{
+	"go.mod.tmpl":           "go.mod",
+	"main.go.tmpl":          "main.go",
+	"middleware.go.tmpl":    "middleware.go",
+	"handlers.go.tmpl":      "handlers.go",
+	"handlers_test.go.tmpl": "handlers_test.go",
+	"env.sample.tmpl":       ".env.sample",
+	"README.md.tmpl":        "README.md",
+}
+
+type project struct {
+	Name      string
+	Module    string
+	Framework string
+	Preset    string
+	Port      string
+	GoVersion string
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "new" {
+		usage()
+		os.Exit(2)
+	}
+	if err := newProject(os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "simplehttp:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: simplehttp new [flags] <name>
+
+Scaffolds a service in ./<name> (or -dir). Flags:
+  -module     Go module path, defaults to <name>
+  -framework  %s (default fiber)
+  -preset     middleware preset: minimal (recover, request id, logger),
+              api (+ concurrency limit, timeout, body limit, security, CORS),
+              web (api + compression) (default api)
+  -port       default port in .env.sample (default 8080)
+  -dir        target directory, defaults to <name>
+  -force      overwrite existing files
+`, strings.Join(frameworks, ", "))
+}
+
+func newProject(args []string) error {
+	fs := flag.NewFlagSet("new", flag.ContinueOnError)
+	fs.Usage = usage
+	p := project{GoVersion: "1.23"}
+	fs.StringVar(&p.Module, "module", "", "")
+	fs.StringVar(&p.Framework, "framework", "fiber", "")
+	fs.StringVar(&p.Preset, "preset", "api", "")
+	fs.StringVar(&p.Port, "port", "8080", "")
+	dir := fs.String("dir", "", "")
+	force := fs.Bool("force", false, "")
+	// flags may come after the name too
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(positional) != 1 {
+		usage()
+		return errors.New("expected exactly one service name")
+	}
+
+	p.Name = positional[0]
+	if p.Module == "" {
+		p.Module = p.Name
+	}
+	if *dir == "" {
+		*dir = p.Name
+	}
+	if !contains(frameworks, p.Framework) {
+		return fmt.Errorf("unknown framework %q, use one of %s", p.Framework, strings.Join(frameworks, ", "))
+	}
+	if !contains(presets, p.Preset) {
+		return fmt.Errorf("unknown preset %q, use one of %s", p.Preset, strings.Join(presets, ", "))
+	}
+
+	if !*force {
+		for _, name := range files {
+			if _, err := os.Stat(filepath.Join(*dir, name)); err == nil {
+				return fmt.Errorf("%s exists, use -force to overwrite", filepath.Join(*dir, name))
+			}
+		}
+	}
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		return err
+	}
+	for tmpl, name := range files {
+		out, err := render(tmpl, p)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(*dir, name), out, 0o644); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("created %s (%s, %s preset) in %s\n\n", p.Name, p.Framework, p.Preset, *dir)
+	fmt.Printf("  cd %s\n  cp .env.sample .env\n  go mod tidy\n  go run .\n", *dir)
+	return nil
+}
+
+// render executes one template, Go files are gofmt'ed
+func render(name string, p project) ([]byte, error) {
+	t, err := template.ParseFS(templates, "templates/"+name)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, p); err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(name, ".go.tmpl") {
+		return buf.Bytes(), nil
+	}
+	out, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return out, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
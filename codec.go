@@ -0,0 +1,104 @@
+package simplehttp
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	CONTENT_TYPE_XML      = "application/xml"
+	CONTENT_TYPE_MSGPACK  = "application/msgpack"
+	CONTENT_TYPE_PROTOBUF = "application/x-protobuf"
+)
+
+// Codec marshals and unmarshals request/response payloads for one or more
+// content types. Register codecs for binary formats (msgpack, protobuf, ...)
+// with RegisterCodec, then use c.Encode and c.BindCodec from handlers.
+//
+//	type msgpackCodec struct{}
+//	func (msgpackCodec) ContentTypes() []string { return []string{simplehttp.CONTENT_TYPE_MSGPACK} }
+//	func (msgpackCodec) Marshal(v interface{}) ([]byte, error) { return msgpack.Marshal(v) }
+//	func (msgpackCodec) Unmarshal(b []byte, v interface{}) error { return msgpack.Unmarshal(b, v) }
+//
+//	simplehttp.RegisterCodec(msgpackCodec{})
+type Codec interface {
+	ContentTypes() []string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	codecMu  sync.RWMutex
+	codecMap = map[string]Codec{}
+)
+
+func init() {
+	RegisterCodec(jsonCodec{})
+	RegisterCodec(xmlCodec{})
+}
+
+// RegisterCodec adds (or replaces) the codec for all of its content types
+func RegisterCodec(codec Codec) {
+	codecMu.Lock()
+	defer codecMu.Unlock()
+	for _, ct := range codec.ContentTypes() {
+		codecMap[normalizeContentType(ct)] = codec
+	}
+}
+
+// CodecFor returns the codec registered for the content type, parameters
+// like charset are ignored.
+func CodecFor(contentType string) (Codec, bool) {
+	codecMu.RLock()
+	defer codecMu.RUnlock()
+	codec, ok := codecMap[normalizeContentType(contentType)]
+	return codec, ok
+}
+
+// MarshalCodec encodes v with the codec registered for contentType
+func MarshalCodec(contentType string, v interface{}) ([]byte, error) {
+	codec, ok := CodecFor(contentType)
+	if !ok {
+		return nil, NewError(http.StatusNotAcceptable, "no codec registered for "+contentType)
+	}
+	return codec.Marshal(v)
+}
+
+// UnmarshalCodec decodes data into v with the codec registered for contentType
+func UnmarshalCodec(contentType string, data []byte, v interface{}) error {
+	codec, ok := CodecFor(contentType)
+	if !ok {
+		return NewError(http.StatusUnsupportedMediaType, "unsupported content type "+contentType)
+	}
+	if err := codec.Unmarshal(data, v); err != nil {
+		return NewError(http.StatusBadRequest, "invalid request payload", err.Error())
+	}
+	return nil
+}
+
+func normalizeContentType(contentType string) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mediaType
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentTypes() []string { return []string{CONTENT_TYPE_JSON} }
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type xmlCodec struct{}
+
+func (xmlCodec) ContentTypes() []string { return []string{CONTENT_TYPE_XML, "text/xml"} }
+
+func (xmlCodec) Marshal(v interface{}) ([]byte, error) { return xml.Marshal(v) }
+
+func (xmlCodec) Unmarshal(data []byte, v interface{}) error { return xml.Unmarshal(data, v) }
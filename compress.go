@@ -0,0 +1,307 @@
+package simplehttp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression middleware configuration
+type CompressionConfig struct {
+	Level   int      // Compression level (1-9)
+	MinSize int64    // Minimum size to compress
+	Types   []string // Content types to compress
+	Skipper Skipper
+}
+
+func MiddlewareCompress(config CompressionConfig) Middleware {
+	return Skip(WithName("compression", Compress(config)), config.Skipper)
+}
+
+// Compress returns a compression middleware. The response is buffered, then
+// compressed with the best encoding from Accept-Encoding (zstd, br, gzip,
+// deflate) when it matches Types and is at least MinSize bytes. Ranges (206,
+// Content-Range) and responses with Accept-Ranges, e.g. of ServeFile, are
+// sent as they are.
+func Compress(config CompressionConfig) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			encoding := NegotiateEncoding(c.GetHeader(HEADER_ACCEPT_ENCODING), SupportedEncodings)
+			if encoding == "" || c.GetMethod() == http.MethodHead {
+				return next(c)
+			}
+
+			c.BufferResponse()
+			if err := next(c); err != nil {
+				c.FlushResponse()
+				return err
+			}
+			if err := compressResponse(c, config, encoding); err != nil {
+				c.FlushResponse()
+				return err
+			}
+			return c.FlushResponse()
+		}
+	}
+}
+
+func compressResponse(c Context, config CompressionConfig, encoding string) error {
+	if c.GetResponseHeader(HEADER_CONTENT_ENCODING) != "" || isRangeResponse(c) {
+		return nil
+	}
+	body := c.GetResponseBody()
+	if !config.ShouldCompress(c.GetResponseHeader(HEADER_CONTENT_TYPE), int64(len(body))) {
+		return nil
+	}
+
+	compressed, err := CompressBytes(encoding, body, config.Level)
+	if err != nil {
+		return err
+	}
+	// not worth it, tiny or incompressible payload
+	if len(compressed) >= len(body) {
+		return nil
+	}
+
+	c.SetResponseBody(compressed)
+	c.SetResponseHeader(HEADER_CONTENT_ENCODING, encoding)
+	if vary := c.GetResponseHeader(HEADER_VARY); vary != "" {
+		c.SetResponseHeader(HEADER_VARY, vary+", "+HEADER_ACCEPT_ENCODING)
+	} else {
+		c.SetResponseHeader(HEADER_VARY, HEADER_ACCEPT_ENCODING)
+	}
+	return nil
+}
+
+// isRangeResponse reports whether the response is a range or offers ranges,
+// like those of ServeFile. Their byte offsets are the ones of the
+// uncompressed body, so it must go out as it is.
+func isRangeResponse(c Context) bool {
+	if c.GetResponseStatus() == http.StatusPartialContent || c.GetResponseHeader(HEADER_CONTENT_RANGE) != "" {
+		return true
+	}
+	acceptRanges := c.GetResponseHeader(HEADER_ACCEPT_RANGES)
+	return acceptRanges != "" && !strings.EqualFold(acceptRanges, "none")
+}
+
+// Content types that are already compressed, compressing them again only
+// burns CPU (and sometimes makes them bigger). These are always skipped.
+var alreadyCompressedTypes = []string{
+	"image/*",
+	"video/*",
+	"audio/*",
+	"font/woff",
+	"font/woff2",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/x-bzip2",
+	"application/x-xz",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/zstd",
+	"application/pdf",
+	"application/octet-stream",
+}
+
+// Exceptions to alreadyCompressedTypes, text based formats with an image/ prefix
+var compressibleImageTypes = []string{
+	"image/svg+xml",
+	"image/x-icon",
+	"image/bmp",
+}
+
+// ShouldCompress reports whether a response with the given content type and
+// size should be compressed according to MinSize and Types. When Types is
+// empty every type is allowed except the ones that are already compressed.
+func (cc CompressionConfig) ShouldCompress(contentType string, size int64) bool {
+	if size <= 0 || size < cc.MinSize {
+		return false
+	}
+	mediaType := normalizeContentType(contentType)
+	if mediaType == "" {
+		return false
+	}
+	if isAlreadyCompressed(mediaType) {
+		return false
+	}
+	if len(cc.Types) == 0 {
+		return true
+	}
+	return matchContentType(cc.Types, mediaType)
+}
+
+func isAlreadyCompressed(mediaType string) bool {
+	for _, t := range compressibleImageTypes {
+		if t == mediaType {
+			return false
+		}
+	}
+	return matchContentType(alreadyCompressedTypes, mediaType)
+}
+
+// matchContentType matches mediaType against patterns like "text/*" or "application/json"
+func matchContentType(patterns []string, mediaType string) bool {
+	for _, p := range patterns {
+		p = normalizeContentType(p)
+		if p == "*/*" || p == mediaType {
+			return true
+		}
+		if strings.HasSuffix(p, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(p, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	ENCODING_ZSTD     = "zstd"
+	ENCODING_BROTLI   = "br"
+	ENCODING_GZIP     = "gzip"
+	ENCODING_DEFLATE  = "deflate"
+	ENCODING_IDENTITY = "identity"
+
+	HEADER_ACCEPT_ENCODING  = "Accept-Encoding"
+	HEADER_CONTENT_ENCODING = "Content-Encoding"
+	HEADER_VARY             = "Vary"
+)
+
+// SupportedEncodings in server preference order, used to break ties when the
+// client gives several encodings the same quality value.
+var SupportedEncodings = []string{ENCODING_ZSTD, ENCODING_BROTLI, ENCODING_GZIP, ENCODING_DEFLATE}
+
+var (
+	zstdEncoders   = map[zstd.EncoderLevel]*zstd.Encoder{}
+	zstdEncodersMu sync.Mutex
+)
+
+// NegotiateEncoding picks the best encoding from the Accept-Encoding header
+// using quality values, e.g. "gzip;q=0.8, br". Returns "" when nothing
+// acceptable is supported (send the response uncompressed).
+func NegotiateEncoding(acceptEncoding string, supported []string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	qualities := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, q := parseQualityValue(part)
+		if name == "" {
+			continue
+		}
+		if name == "*" {
+			wildcard = q
+			continue
+		}
+		qualities[name] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, enc := range supported {
+		q, ok := qualities[enc]
+		if !ok {
+			if wildcard < 0 {
+				continue
+			}
+			q = wildcard
+		}
+		// strictly greater keeps the server preference order on ties
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+func parseQualityValue(part string) (string, float64) {
+	fields := strings.Split(part, ";")
+	name := strings.ToLower(strings.TrimSpace(fields[0]))
+	q := 1.0
+	for _, param := range fields[1:] {
+		param = strings.TrimSpace(param)
+		if strings.HasPrefix(param, "q=") {
+			if v, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+				q = v
+			}
+		}
+	}
+	return name, q
+}
+
+// CompressBytes compresses data with the given content-coding. Level follows
+// the gzip scale (1-9), 0 means the default level of each encoder.
+func CompressBytes(encoding string, data []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	switch encoding {
+	case ENCODING_GZIP:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		w, err := gzip.NewWriterLevel(&buf, level)
+		if err != nil {
+			return nil, err
+		}
+		return writeAndClose(&buf, w, data)
+	case ENCODING_DEFLATE:
+		// HTTP deflate is zlib wrapped, see RFC 9110 8.4.1.2
+		if level == 0 {
+			level = zlib.DefaultCompression
+		}
+		w, err := zlib.NewWriterLevel(&buf, level)
+		if err != nil {
+			return nil, err
+		}
+		return writeAndClose(&buf, w, data)
+	case ENCODING_BROTLI:
+		if level == 0 {
+			level = brotli.DefaultCompression
+		}
+		return writeAndClose(&buf, brotli.NewWriterLevel(&buf, level), data)
+	case ENCODING_ZSTD:
+		zlevel := zstd.SpeedDefault
+		if level != 0 {
+			zlevel = zstd.EncoderLevelFromZstd(level)
+		}
+		enc, err := zstdEncoder(zlevel)
+		if err != nil {
+			return nil, err
+		}
+		return enc.EncodeAll(data, make([]byte, 0, len(data))), nil
+	}
+	return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+}
+
+func writeAndClose(buf *bytes.Buffer, w io.WriteCloser, data []byte) ([]byte, error) {
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// zstd encoders are expensive to create but safe for concurrent EncodeAll
+func zstdEncoder(level zstd.EncoderLevel) (*zstd.Encoder, error) {
+	zstdEncodersMu.Lock()
+	defer zstdEncodersMu.Unlock()
+	if enc, ok := zstdEncoders[level]; ok {
+		return enc, nil
+	}
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(level))
+	if err != nil {
+		return nil, err
+	}
+	zstdEncoders[level] = enc
+	return enc, nil
+}
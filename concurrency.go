@@ -0,0 +1,145 @@
+package simplehttp
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_CONCURRENCY_RETRY_AFTER = time.Second
+)
+
+// ConcurrencyLimitConfig holds the optional settings of MiddlewareConcurrencyLimit
+type ConcurrencyLimitConfig struct {
+	// KeyFunc limits per key (client IP, API key, tenant, ...) instead of for
+	// every request the middleware sees
+	KeyFunc    func(Context) string
+	RetryAfter time.Duration // sent with the 503, default 1s
+	Skipper    Skipper
+}
+
+// MiddlewareConcurrencyLimit caps the requests in flight at max. Up to queue
+// more wait for a slot, at most timeout, everything beyond gets 503 with
+// Retry-After. A zero timeout waits as long as the request context allows.
+//
+//	server.Use(simplehttp.MiddlewareConcurrencyLimit(100, 50, 2*time.Second))
+//	api.Use(simplehttp.MiddlewareConcurrencyLimit(5, 0, 0, simplehttp.ConcurrencyLimitConfig{
+//		KeyFunc: func(c simplehttp.Context) string { return c.GetHeaders().IP() },
+//	}))
+func MiddlewareConcurrencyLimit(max, queue int, timeout time.Duration, config ...ConcurrencyLimitConfig) Middleware {
+	var cfg ConcurrencyLimitConfig
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+	return Skip(WithName("concurrency limit", ConcurrencyLimit(max, queue, timeout, cfg)), cfg.Skipper)
+}
+
+// ConcurrencyLimit sheds load above max in flight plus queue waiting requests
+func ConcurrencyLimit(max, queue int, timeout time.Duration, config ConcurrencyLimitConfig) MiddlewareFunc {
+	if max <= 0 {
+		panic("simplehttp: ConcurrencyLimit max must be positive")
+	}
+	if config.RetryAfter == 0 {
+		config.RetryAfter = DEFAULT_CONCURRENCY_RETRY_AFTER
+	}
+	retryAfter := strconv.Itoa(int(math.Ceil(config.RetryAfter.Seconds())))
+	limiters := &concurrencyLimiters{max: max, queue: queue, limiters: make(map[string]*concurrencyLimiter)}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			key := ""
+			if config.KeyFunc != nil {
+				key = config.KeyFunc(c)
+			}
+			limiter := limiters.get(key)
+			defer limiters.put(key, limiter)
+
+			if !limiter.acquire(c, timeout) {
+				c.SetResponseHeader("Retry-After", retryAfter)
+				return NewError(http.StatusServiceUnavailable, "server busy, retry later")
+			}
+			defer limiter.release()
+			return next(c)
+		}
+	}
+}
+
+// concurrencyLimiters keeps one limiter per key while it has requests,
+// idle keys are dropped so the map doesn't grow with every client
+type concurrencyLimiters struct {
+	max      int
+	queue    int
+	mu       sync.Mutex
+	limiters map[string]*concurrencyLimiter
+}
+
+type concurrencyLimiter struct {
+	slots   chan struct{}
+	queue   int
+	mu      sync.Mutex
+	waiting int
+	users   int // requests holding this limiter, guarded by concurrencyLimiters.mu
+}
+
+func (l *concurrencyLimiters) get(key string) *concurrencyLimiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limiter, ok := l.limiters[key]
+	if !ok {
+		limiter = &concurrencyLimiter{slots: make(chan struct{}, l.max), queue: l.queue}
+		l.limiters[key] = limiter
+	}
+	limiter.users++
+	return limiter
+}
+
+func (l *concurrencyLimiters) put(key string, limiter *concurrencyLimiter) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limiter.users--; limiter.users == 0 {
+		delete(l.limiters, key)
+	}
+}
+
+func (l *concurrencyLimiter) acquire(c Context, timeout time.Duration) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	l.mu.Lock()
+	if l.waiting >= l.queue {
+		l.mu.Unlock()
+		return false
+	}
+	l.waiting++
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.waiting--
+		l.mu.Unlock()
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-expired:
+		return false
+	case <-c.Context().Done():
+		return false
+	}
+}
+
+func (l *concurrencyLimiter) release() {
+	<-l.slots
+}
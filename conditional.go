@@ -0,0 +1,169 @@
+package simplehttp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	HEADER_ETAG                = "ETag"
+	HEADER_LAST_MODIFIED       = "Last-Modified"
+	HEADER_IF_MATCH            = "If-Match"
+	HEADER_IF_UNMODIFIED_SINCE = "If-Unmodified-Since"
+)
+
+// ResourceVersion identifies the current version of a resource, either or
+// both fields may be set. The zero value means the resource does not exist.
+type ResourceVersion struct {
+	ETag         string // quoted, e.g. `"v42"` or `W/"v42"`, see ETagOf
+	LastModified time.Time
+}
+
+func (v ResourceVersion) exists() bool {
+	return v.ETag != "" || !v.LastModified.IsZero()
+}
+
+// ETagOf returns a strong ETag of the JSON encoding of v, e.g. for a row
+// without a version column
+func ETagOf(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// SetResourceVersion sets the ETag and Last-Modified response headers, call it
+// before writing the response of GET and of successful PUT/PATCH so clients
+// have the version to send back in If-Match
+func SetResourceVersion(c Context, version ResourceVersion) {
+	if version.ETag != "" {
+		c.SetResponseHeader(HEADER_ETAG, version.ETag)
+	}
+	if !version.LastModified.IsZero() {
+		c.SetResponseHeader(HEADER_LAST_MODIFIED, version.LastModified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// CheckPreconditions evaluates If-Match and If-Unmodified-Since against the
+// current version (RFC 9110 13.2.2) and returns a 412 error when they fail.
+// Handlers that load the resource anyway can call it instead of using
+// MiddlewarePreconditions.
+func CheckPreconditions(c Context, current ResourceVersion) error {
+	if ifMatch := c.GetHeader(HEADER_IF_MATCH); ifMatch != "" {
+		if !current.exists() || !etagMatches(ifMatch, current.ETag) {
+			return preconditionFailed(c, current)
+		}
+		return nil // If-Unmodified-Since is ignored when If-Match is present
+	}
+	if since := c.GetHeader(HEADER_IF_UNMODIFIED_SINCE); since != "" && !current.LastModified.IsZero() {
+		t, err := http.ParseTime(since)
+		if err == nil && current.LastModified.Truncate(time.Second).After(t) {
+			return preconditionFailed(c, current)
+		}
+	}
+	return nil
+}
+
+// PreconditionsConfig configures MiddlewarePreconditions
+type PreconditionsConfig struct {
+	// Current loads the current version of the requested resource, return
+	// the zero ResourceVersion when it does not exist. Required.
+	Current func(c Context) (ResourceVersion, error)
+	// Methods the preconditions are checked for, default PUT, PATCH and DELETE
+	Methods []string
+	// Required answers 428 to requests without If-Match or
+	// If-Unmodified-Since, so clients can't skip the check by accident
+	Required bool
+	Skipper  Skipper
+}
+
+// MiddlewarePreconditions enforces optimistic concurrency on the routes it
+// wraps: an update based on a stale version fails with 412 instead of
+// overwriting a concurrent change.
+//
+//	server.PUT("/users/:id", updateUser, simplehttp.MiddlewarePreconditions(simplehttp.PreconditionsConfig{
+//		Current: func(c simplehttp.Context) (simplehttp.ResourceVersion, error) {
+//			user, err := store.User(userID(c))
+//			if err != nil {
+//				return simplehttp.ResourceVersion{}, err
+//			}
+//			return simplehttp.ResourceVersion{ETag: simplehttp.ETagOf(user), LastModified: user.UpdatedAt}, nil
+//		},
+//		Required: true,
+//	}))
+func MiddlewarePreconditions(config PreconditionsConfig) Middleware {
+	return Skip(WithName("preconditions", Preconditions(config)), config.Skipper)
+}
+
+// Preconditions checks If-Match and If-Unmodified-Since before the handler
+// runs, see CheckPreconditions
+func Preconditions(config PreconditionsConfig) MiddlewareFunc {
+	if config.Current == nil {
+		panic("simplehttp: PreconditionsConfig.Current is required")
+	}
+	if len(config.Methods) == 0 {
+		config.Methods = []string{http.MethodPut, http.MethodPatch, http.MethodDelete}
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			if !containsFold(config.Methods, c.GetMethod()) {
+				return next(c)
+			}
+			if c.GetHeader(HEADER_IF_MATCH) == "" && c.GetHeader(HEADER_IF_UNMODIFIED_SINCE) == "" {
+				if config.Required {
+					return NewError(http.StatusPreconditionRequired, "precondition required",
+						"send If-Match with the ETag of the resource")
+				}
+				return next(c)
+			}
+			current, err := config.Current(c)
+			if err != nil {
+				return err
+			}
+			if err := CheckPreconditions(c, current); err != nil {
+				return err
+			}
+			return next(c)
+		}
+	}
+}
+
+// preconditionFailed answers 412 with the current version, so the client can
+// refetch or merge
+func preconditionFailed(c Context, current ResourceVersion) error {
+	SetResourceVersion(c, current)
+	return NewError(http.StatusPreconditionFailed, "precondition failed", "the resource was modified")
+}
+
+// etagMatches compares If-Match against the current ETag with the strong
+// comparison, weak tags never match
+func etagMatches(ifMatch, etag string) bool {
+	if strings.TrimSpace(ifMatch) == "*" {
+		return true
+	}
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		if strings.TrimSpace(candidate) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
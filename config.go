@@ -18,6 +18,8 @@ const (
 	// This was used in fiber
 	DEFAULT_HTTP_CONCURRENCY = 512 * 1024
 
+	DEFAULT_SHUTDOWN_RETRY_AFTER = 5 * time.Second
+
 	// environment string
 	SIMPLEHTTP_FRAMEWORK                 = "SIMPLEHTTP_FRAMEWORK"
 	SIMPLEHTTP_PORT                      = "SIMPLEHTTP_PORT"
@@ -30,6 +32,19 @@ const (
 	SIMPLEHTTP_FRAMEWORK_STARTUP_MESSAGE = "SIMPLEHTTP_FRAMEWORK_STARTUP_MESSAGE"
 	SIMPLEHTTP_INTERNAL_API              = "SIMPLEHTTP_INTERNAL_API"
 	SIMPLEHTTP_INTERNAL_STATUS           = "SIMPLEHTTP_INTERNAL_STATUS"
+	SIMPLEHTTP_JSON_INDENT               = "SIMPLEHTTP_JSON_INDENT"
+	SIMPLEHTTP_TRUSTED_PROXIES           = "SIMPLEHTTP_TRUSTED_PROXIES" // comma separated CIDRs/IPs
+	SIMPLEHTTP_TRUSTED_IP_HEADER         = "SIMPLEHTTP_TRUSTED_IP_HEADER"
+	SIMPLEHTTP_SHUTDOWN_DRAIN_WINDOW     = "SIMPLEHTTP_SHUTDOWN_DRAIN_WINDOW"
+	SIMPLEHTTP_SHUTDOWN_RETRY_AFTER      = "SIMPLEHTTP_SHUTDOWN_RETRY_AFTER"
+	SIMPLEHTTP_ID_FORMAT                 = "SIMPLEHTTP_ID_FORMAT"
+	SIMPLEHTTP_SNOWFLAKE_NODE            = "SIMPLEHTTP_SNOWFLAKE_NODE" // 0-1023, unique per instance
+	SIMPLEHTTP_CONFIG_FILE               = "SIMPLEHTTP_CONFIG_FILE"    // ConfigFile with the profiles
+	SIMPLEHTTP_PROFILE                   = "SIMPLEHTTP_PROFILE"        // e.g. dev, staging or prod
+	SIMPLEHTTP_STRICT_STARTUP            = "SIMPLEHTTP_STRICT_STARTUP"
+	SIMPLEHTTP_STUB_DIR                  = "SIMPLEHTTP_STUB_DIR"
+	SIMPLEHTTP_STUB_ROUTES               = "SIMPLEHTTP_STUB_ROUTES" // comma separated SkipPaths patterns
+	SIMPLEHTTP_STUB_RECORD               = "SIMPLEHTTP_STUB_RECORD"
 
 	// internal API (if enabled)
 	DEFAULT_INTERNAL_API    = "/internal_d" // internal debug
@@ -42,6 +57,15 @@ type TimeOutConfig struct {
 	IdleTimeout  time.Duration
 }
 
+// ShutdownConfig controls the drain of Shutdown: requests arriving after it
+// was called get 503 (with Retry-After and Connection: close) instead of
+// having their connection dropped, in-flight requests finish normally.
+type ShutdownConfig struct {
+	DrainWindow    time.Duration // keep answering 503 this long before the listeners close, lets load balancers notice
+	RetryAfter     time.Duration // 0 omits Retry-After
+	KeepConnection bool          // don't send Connection: close
+}
+
 // Used to save all endpoints or routes that the server currently handling!
 type Routes struct {
 	EndPoint string
@@ -61,6 +85,7 @@ func (r *Routes) Sprint() string {
 
 // Configuration holds server settings
 type Config struct {
+	Profile   string // loaded from SIMPLEHTTP_CONFIG_FILE, see ConfigFile
 	Framework string
 	AppName   string
 	Hostname  string
@@ -72,10 +97,22 @@ type Config struct {
 	MaxRequestSize          int64
 	UploadDir               string
 	TempDir                 string
-	TrustedProxies          []string
+	TrustedProxies          []string    // CIDRs/IPs of proxies whose forwarded headers are honored
+	TrustedIPHeader         string      // client IP header always set by TrustedProxies, e.g. CF-Connecting-IP, see ExtractIPFromHeader
+	IPExtractor             IPExtractor // overrides TrustedProxies, see RequestHeader.IP()
 	Debug                   bool
-	FrameworkStartupMessage bool // true means display the default framework startup message, false: quite mode
-	Concurrency             int  // for fiber settings
+	FrameworkStartupMessage bool   // true means display the default framework startup message, false: quite mode
+	JSONIndent              string // in Debug mode c.JSON responses are indented with this, empty means compact
+	Concurrency             int    // for fiber settings
+	// StrictStartup fails Start on a startup diagnostic instead of logging
+	// a warning, IgnoreDiagnostics are the codes accepted, see Diagnose
+	StrictStartup     bool
+	IgnoreDiagnostics []string
+	// StubDir turns on the stub mode, the servers answer from the fixture
+	// files of the directory, see MiddlewareStub
+	StubDir    string
+	StubRoutes []string
+	StubRecord bool
 
 	// TLS Configuration
 	TLSCert   string
@@ -90,13 +127,33 @@ type Config struct {
 	// CORS Configuration
 	ConfigCORS    *CORSConfig
 	ConfigTimeOut *TimeOutConfig
+	// nil means no drain window and DEFAULT_SHUTDOWN_RETRY_AFTER
+	ConfigShutdown *ShutdownConfig
 	// TODO: Do we need to add other config like security, limiter, timeout, etc?
 
 	// Custom error handlers
 	ErrorHandler func(error, Context) error
 
 	// Additional components
-	Logger Logger // Interface defined in logger.go
+	Logger      Logger            // Interface defined in logger.go
+	Validator   Validator         // Used by BindAndValidate, nil means go-playground/validator
+	JSONCodec   JSONCodec         // Used by c.JSON and BindJSON, nil means encoding/json
+	JSONEncoder JSONMarshalFunc   // Overrides JSONCodec.Marshal, e.g. sonic.Marshal
+	JSONDecoder JSONUnmarshalFunc // Overrides JSONCodec.Unmarshal, e.g. sonic.Unmarshal
+	// Clock of the server, nil means DefaultClock. The middleware without a
+	// Clock of their own use it, pass it on to the other components, e.g.
+	// MemoryCacheConfig{Clock: config.Clock}, see ClockOf.
+	Clock Clock
+	// IDGenerator makes request IDs, nil means the generator of IDFormat.
+	// Pass it on like Clock, e.g. RequestIDConfig{Generator:
+	// IDGeneratorOf(config)}.
+	IDGenerator IDGenerator
+	// IDFormat picks a built-in generator by name when IDGenerator is nil:
+	// "token" (default), "ulid", "uuidv4", "uuidv7" or "snowflake"
+	IDFormat string
+	// Metrics is where MiddlewareMetrics records to, e.g. a StatsDRecorder
+	// or PrometheusRecorder, pass it as MetricsConfig.Recorder
+	Metrics MetricsRecorder
 	// Cache        Cache   // Interface defined in cache.go
 	// SessionStore Session // Interface defined in cache.go (session interface)
 }
@@ -123,7 +180,16 @@ var DefaultConfig = &Config{
 
 // LoadConfig loads configuration from environment variables
 func LoadConfig() *Config {
+	// the profile only sets what the environment doesn't
+	var profile string
+	if path := os.Getenv(SIMPLEHTTP_CONFIG_FILE); path != "" {
+		var err error
+		if profile, err = LoadProfile(path, ""); err != nil {
+			NewDefaultLogger().Errorf("config profile: %v", err)
+		}
+	}
 	config := &Config{
+		Profile:   profile,
 		Framework: utils.GetEnvString(SIMPLEHTTP_FRAMEWORK, DefaultConfig.Framework),
 		Port:      utils.GetEnvString(SIMPLEHTTP_PORT, DefaultConfig.Port),
 		AppName:   utils.GetEnvString(SIMPLEHTTP_APP_NAME, DefaultConfig.AppName),
@@ -133,8 +199,20 @@ func LoadConfig() *Config {
 			WriteTimeout: utils.GetEnvDuration(SIMPLEHTTP_WRITE_TIMEOUT, DefaultConfig.ConfigTimeOut.WriteTimeout),
 			IdleTimeout:  utils.GetEnvDuration(SIMPLEHTTP_IDLE_TIMEOUT, DefaultConfig.ConfigTimeOut.IdleTimeout),
 		},
+		ConfigShutdown: &ShutdownConfig{
+			DrainWindow: utils.GetEnvDuration(SIMPLEHTTP_SHUTDOWN_DRAIN_WINDOW, 0),
+			RetryAfter:  utils.GetEnvDuration(SIMPLEHTTP_SHUTDOWN_RETRY_AFTER, DEFAULT_SHUTDOWN_RETRY_AFTER),
+		},
 		Debug:                   utils.GetEnvBool(SIMPLEHTTP_DEBUG, DefaultConfig.Debug),
 		FrameworkStartupMessage: utils.GetEnvBool(SIMPLEHTTP_FRAMEWORK_STARTUP_MESSAGE, DefaultConfig.FrameworkStartupMessage),
+		JSONIndent:              utils.GetEnvString(SIMPLEHTTP_JSON_INDENT, DefaultConfig.JSONIndent),
+		TrustedProxies:          splitList(utils.GetEnvString(SIMPLEHTTP_TRUSTED_PROXIES, "")),
+		TrustedIPHeader:         utils.GetEnvString(SIMPLEHTTP_TRUSTED_IP_HEADER, ""),
+		IDFormat:                utils.GetEnvString(SIMPLEHTTP_ID_FORMAT, ""),
+		StrictStartup:           utils.GetEnvBool(SIMPLEHTTP_STRICT_STARTUP, false),
+		StubDir:                 utils.GetEnvString(SIMPLEHTTP_STUB_DIR, ""),
+		StubRoutes:              splitList(utils.GetEnvString(SIMPLEHTTP_STUB_ROUTES, "")),
+		StubRecord:              utils.GetEnvBool(SIMPLEHTTP_STUB_RECORD, false),
 		Logger:                  NewDefaultLogger(),
 	}
 	PathInternalAPI = utils.GetEnvString(SIMPLEHTTP_INTERNAL_API, DEFAULT_INTERNAL_API)
@@ -197,6 +275,10 @@ func ValidateConfig(config *Config) error {
 		}
 	}
 
+	if _, err := IDGeneratorByFormat(config.IDFormat); err != nil {
+		return err
+	}
+
 	// Validate TLS configuration
 	if config.AutoTLS && config.TLSDomain == "" {
 		return fmt.Errorf("TLS domain required when AutoTLS is enabled")
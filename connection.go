@@ -0,0 +1,37 @@
+package simplehttp
+
+import "crypto/tls"
+
+// ConnectionInfo describes the connection of a request in loggable strings,
+// see Connection
+type ConnectionInfo struct {
+	LocalAddr   string `json:"local_addr,omitempty"`
+	RemoteAddr  string `json:"remote_addr,omitempty"` // the peer, a proxy when there is one
+	TLSVersion  string `json:"tls_version,omitempty"` // e.g. "TLS 1.3", empty on plain connections
+	CipherSuite string `json:"cipher_suite,omitempty"`
+	ALPN        string `json:"alpn,omitempty"`        // protocol negotiated in the handshake, e.g. "h2"
+	ServerName  string `json:"server_name,omitempty"` // SNI sent by the client
+	Resumed     bool   `json:"resumed,omitempty"`     // TLS session resumption
+}
+
+// Connection returns the connection of the request as seen by this server,
+// for security logs and to debug TLS termination:
+//
+//	logger.Infof("login of %s over %+v", user, simplehttp.Connection(c))
+func Connection(c Context) ConnectionInfo {
+	var info ConnectionInfo
+	if addr := c.LocalAddr(); addr != nil {
+		info.LocalAddr = addr.String()
+	}
+	if addr := c.RemoteAddr(); addr != nil {
+		info.RemoteAddr = addr.String()
+	}
+	if state := c.TLSConnectionState(); state != nil {
+		info.TLSVersion = tls.VersionName(state.Version)
+		info.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+		info.ALPN = state.NegotiatedProtocol
+		info.ServerName = state.ServerName
+		info.Resumed = state.DidResume
+	}
+	return info
+}
@@ -0,0 +1,475 @@
+package simplehttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OpenAPIDocument is the part of an OpenAPI 3 document (JSON) read by
+// VerifyContract
+type OpenAPIDocument struct {
+	OpenAPI    string                     `json:"openapi"`
+	Paths      map[string]OpenAPIPathItem `json:"paths"`
+	Components OpenAPIComponents          `json:"components"`
+}
+
+type OpenAPIComponents struct {
+	Schemas map[string]*JSONSchema `json:"schemas"`
+}
+
+// OpenAPIPathItem holds the operations of a path by lower case method, and
+// the parameters shared by them
+type OpenAPIPathItem struct {
+	Parameters []OpenAPIParameter
+	Operations map[string]*OpenAPIOperation
+}
+
+func (p *OpenAPIPathItem) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	p.Operations = make(map[string]*OpenAPIOperation)
+	for key, value := range raw {
+		switch key {
+		case "parameters":
+			if err := json.Unmarshal(value, &p.Parameters); err != nil {
+				return err
+			}
+		case "get", "put", "post", "delete", "options", "head", "patch", "trace":
+			var op OpenAPIOperation
+			if err := json.Unmarshal(value, &op); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			p.Operations[key] = &op
+		}
+	}
+	return nil
+}
+
+type OpenAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Parameters  []OpenAPIParameter         `json:"parameters"`
+	RequestBody *OpenAPIRequestBody        `json:"requestBody"`
+	Responses   map[string]OpenAPIResponse `json:"responses"` // "200", "4XX" or "default"
+}
+
+type OpenAPIParameter struct {
+	Name     string      `json:"name"`
+	In       string      `json:"in"` // path, query or header
+	Required bool        `json:"required"`
+	Example  interface{} `json:"example"`
+	Schema   *JSONSchema `json:"schema"`
+}
+
+type OpenAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]OpenAPIMediaType `json:"content"`
+}
+
+type OpenAPIResponse struct {
+	Content map[string]OpenAPIMediaType `json:"content"`
+}
+
+type OpenAPIMediaType struct {
+	Schema  *JSONSchema `json:"schema"`
+	Example interface{} `json:"example"`
+}
+
+// JSONSchema is the subset of the OpenAPI schemas VerifyContract checks
+type JSONSchema struct {
+	Ref                  string                 `json:"$ref"`
+	Type                 string                 `json:"type"`
+	Properties           map[string]*JSONSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	Items                *JSONSchema            `json:"items"`
+	Enum                 []interface{}          `json:"enum"`
+	Nullable             bool                   `json:"nullable"`
+	AdditionalProperties interface{}            `json:"additionalProperties"` // false refuses unknown properties
+	AllOf                []*JSONSchema          `json:"allOf"`
+	AnyOf                []*JSONSchema          `json:"anyOf"`
+	OneOf                []*JSONSchema          `json:"oneOf"` // checked like anyOf
+	Example              interface{}            `json:"example"`
+}
+
+// ReadOpenAPI parses an OpenAPI 3 document in JSON, convert YAML documents
+// first
+func ReadOpenAPI(path string) (*OpenAPIDocument, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc OpenAPIDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &doc, nil
+}
+
+// ContractConfig configures VerifyContract
+type ContractConfig struct {
+	BaseURL string       // of the server under test, e.g. "http://127.0.0.1:8080", required
+	Client  *http.Client // defaults to a client with a 10s timeout
+	// Params are the values of the parameters by name, for the path, query
+	// and header parameters. Without one the example of the document is
+	// used, or a value made from the schema.
+	Params  map[string]string
+	Headers map[string]string // sent on every request, e.g. Authorization
+	// Skip lists the operations not checked, "METHOD /path" as documented,
+	// e.g. "DELETE /users/{id}"
+	Skip []string
+}
+
+// ContractResult is the check of one documented operation
+type ContractResult struct {
+	Method string   `json:"method"`
+	Path   string   `json:"path"` // as documented
+	URL    string   `json:"url"`
+	Status int      `json:"status"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+func (r ContractResult) OK() bool {
+	return len(r.Errors) == 0
+}
+
+func (r ContractResult) String() string {
+	if r.OK() {
+		return fmt.Sprintf("%s %s: %d ok", r.Method, r.Path, r.Status)
+	}
+	return fmt.Sprintf("%s %s: %s", r.Method, r.Path, strings.Join(r.Errors, "; "))
+}
+
+// VerifyContract calls every operation of the document on a running server
+// and checks that the status is documented and that JSON bodies match the
+// schema of their response, catching drift between the docs and the
+// handlers. The operations are called by path, reads first and deletes
+// last, and the results come in that order, ready for a table-driven test:
+//
+//	func TestContract(t *testing.T) {
+//		doc, err := simplehttp.ReadOpenAPI("openapi.json")
+//		if err != nil {
+//			t.Fatal(err)
+//		}
+//		go server.Start("127.0.0.1:18080")
+//		results := simplehttp.VerifyContract(doc, simplehttp.ContractConfig{
+//			BaseURL: "http://127.0.0.1:18080",
+//			Params:  map[string]string{"id": "42"},
+//		})
+//		for _, r := range results {
+//			t.Run(r.Method+" "+r.Path, func(t *testing.T) {
+//				for _, err := range r.Errors {
+//					t.Error(err)
+//				}
+//			})
+//		}
+//	}
+func VerifyContract(doc *OpenAPIDocument, config ContractConfig) []ContractResult {
+	if config.BaseURL == "" {
+		panic("simplehttp: ContractConfig.BaseURL is required")
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	paths := make([]string, 0, len(doc.Paths))
+	for path := range doc.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var results []ContractResult
+	for _, path := range paths {
+		item := doc.Paths[path]
+		for _, method := range contractMethods {
+			op, ok := item.Operations[method]
+			method = strings.ToUpper(method)
+			if !ok || slices.Contains(config.Skip, method+" "+path) {
+				continue
+			}
+			results = append(results, verifyOperation(doc, config, method, path, item.Parameters, op))
+		}
+	}
+	return results
+}
+
+// contractMethods is the order the operations of a path are called in,
+// reads first and deletes last
+var contractMethods = []string{"get", "head", "options", "trace", "post", "put", "patch", "delete"}
+
+func verifyOperation(doc *OpenAPIDocument, config ContractConfig, method, path string, shared []OpenAPIParameter, op *OpenAPIOperation) ContractResult {
+	result := ContractResult{Method: method, Path: path}
+	fail := func(format string, args ...interface{}) ContractResult {
+		result.Errors = append(result.Errors, fmt.Sprintf(format, args...))
+		return result
+	}
+
+	target := path
+	query := url.Values{}
+	header := http.Header{}
+	for _, param := range append(slices.Clone(shared), op.Parameters...) {
+		value, ok := config.Params[param.Name]
+		if !ok {
+			if !param.Required && param.In != "path" {
+				continue
+			}
+			value = contractParamValue(doc, param)
+		}
+		switch param.In {
+		case "path":
+			target = strings.ReplaceAll(target, "{"+param.Name+"}", url.PathEscape(value))
+		case "query":
+			query.Set(param.Name, value)
+		case "header":
+			header.Set(param.Name, value)
+		}
+	}
+	target = strings.TrimSuffix(config.BaseURL, "/") + target
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	result.URL = target
+
+	var body io.Reader
+	if op.RequestBody != nil {
+		if media, ok := op.RequestBody.Content[CONTENT_TYPE_JSON]; ok {
+			example := media.Example
+			if example == nil {
+				example = exampleOf(doc, media.Schema, 0)
+			}
+			data, err := json.Marshal(example)
+			if err != nil {
+				return fail("request body: %v", err)
+			}
+			body = bytes.NewReader(data)
+			header.Set(HEADER_CONTENT_TYPE, CONTENT_TYPE_JSON)
+		}
+	}
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return fail("request: %v", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	for key, value := range config.Headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := config.Client.Do(req)
+	if err != nil {
+		return fail("request: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fail("response: %v", err)
+	}
+	result.Status = resp.StatusCode
+
+	response, ok := documentedResponse(op.Responses, resp.StatusCode)
+	if !ok {
+		return fail("status %d not documented", resp.StatusCode)
+	}
+	contentType := normalizeContentType(resp.Header.Get(HEADER_CONTENT_TYPE))
+	media, ok := response.Content[contentType]
+	if !ok && len(response.Content) > 0 && len(data) > 0 {
+		return fail("content type %q not documented for %d", contentType, resp.StatusCode)
+	}
+	if media.Schema == nil || len(data) == 0 || !strings.Contains(contentType, "json") {
+		return result
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fail("body is not JSON: %v", err)
+	}
+	var errs []string
+	validateSchema(doc, media.Schema, value, "body", &errs)
+	result.Errors = append(result.Errors, errs...)
+	return result
+}
+
+// documentedResponse finds the response of status: exact, range ("4XX"),
+// then default
+func documentedResponse(responses map[string]OpenAPIResponse, status int) (OpenAPIResponse, bool) {
+	code := strconv.Itoa(status)
+	if r, ok := responses[code]; ok {
+		return r, true
+	}
+	if r, ok := responses[code[:1]+"XX"]; ok {
+		return r, true
+	}
+	if r, ok := responses[code[:1]+"xx"]; ok {
+		return r, true
+	}
+	r, ok := responses["default"]
+	return r, ok
+}
+
+func contractParamValue(doc *OpenAPIDocument, param OpenAPIParameter) string {
+	value := param.Example
+	if value == nil {
+		value = exampleOf(doc, param.Schema, 0)
+	}
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return fmt.Sprint(value)
+}
+
+// exampleOf makes a value matching schema, its example when it has one
+func exampleOf(doc *OpenAPIDocument, schema *JSONSchema, depth int) interface{} {
+	schema = resolveSchema(doc, schema)
+	if schema == nil || depth > 10 {
+		return nil
+	}
+	if schema.Example != nil {
+		return schema.Example
+	}
+	if len(schema.Enum) > 0 {
+		return schema.Enum[0]
+	}
+	if len(schema.AllOf) > 0 {
+		merged := make(map[string]interface{})
+		for _, part := range schema.AllOf {
+			if m, ok := exampleOf(doc, part, depth+1).(map[string]interface{}); ok {
+				for key, v := range m {
+					merged[key] = v
+				}
+			}
+		}
+		return merged
+	}
+	if len(schema.OneOf) > 0 {
+		return exampleOf(doc, schema.OneOf[0], depth+1)
+	}
+	if len(schema.AnyOf) > 0 {
+		return exampleOf(doc, schema.AnyOf[0], depth+1)
+	}
+	switch schema.Type {
+	case "object", "":
+		object := make(map[string]interface{})
+		for _, name := range schema.Required {
+			object[name] = exampleOf(doc, schema.Properties[name], depth+1)
+		}
+		return object
+	case "array":
+		return []interface{}{}
+	case "integer", "number":
+		return 1
+	case "boolean":
+		return true
+	}
+	return "string"
+}
+
+func resolveSchema(doc *OpenAPIDocument, schema *JSONSchema) *JSONSchema {
+	for i := 0; schema != nil && schema.Ref != "" && i < 32; i++ {
+		name, ok := strings.CutPrefix(schema.Ref, "#/components/schemas/")
+		if !ok {
+			return nil
+		}
+		schema = doc.Components.Schemas[name]
+	}
+	return schema
+}
+
+// validateSchema appends to errs where value doesn't match schema, at is
+// the location of value in the body, e.g. body.items[2].id
+func validateSchema(doc *OpenAPIDocument, schema *JSONSchema, value interface{}, at string, errs *[]string) {
+	if schema != nil && schema.Ref != "" {
+		ref := schema.Ref
+		if schema = resolveSchema(doc, schema); schema == nil {
+			*errs = append(*errs, at+": unknown schema "+ref)
+			return
+		}
+	}
+	if schema == nil {
+		return
+	}
+	if value == nil {
+		if !schema.Nullable && schema.Type != "" && schema.Type != "null" {
+			*errs = append(*errs, at+": null, expected "+schema.Type)
+		}
+		return
+	}
+	for _, part := range schema.AllOf {
+		validateSchema(doc, part, value, at, errs)
+	}
+	if alternatives := append(slices.Clone(schema.AnyOf), schema.OneOf...); len(alternatives) > 0 {
+		matched := false
+		for _, alternative := range alternatives {
+			var altErrs []string
+			validateSchema(doc, alternative, value, at, &altErrs)
+			if len(altErrs) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			*errs = append(*errs, at+": matches none of the alternatives")
+		}
+	}
+	if len(schema.Enum) > 0 && !slices.ContainsFunc(schema.Enum, func(e interface{}) bool { return fmt.Sprint(e) == fmt.Sprint(value) }) {
+		*errs = append(*errs, fmt.Sprintf("%s: %v is not one of %v", at, value, schema.Enum))
+	}
+
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			*errs = append(*errs, at+": expected object")
+			return
+		}
+		for _, name := range schema.Required {
+			if _, ok := object[name]; !ok {
+				*errs = append(*errs, at+"."+name+": required")
+			}
+		}
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if property, ok := schema.Properties[name]; ok {
+				validateSchema(doc, property, object[name], at+"."+name, errs)
+			} else if allowed, ok := schema.AdditionalProperties.(bool); ok && !allowed {
+				*errs = append(*errs, at+"."+name+": not documented")
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			*errs = append(*errs, at+": expected array")
+			return
+		}
+		for i, item := range items {
+			validateSchema(doc, schema.Items, item, fmt.Sprintf("%s[%d]", at, i), errs)
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			*errs = append(*errs, at+": expected string")
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != math.Trunc(n) {
+			*errs = append(*errs, at+": expected integer")
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			*errs = append(*errs, at+": expected number")
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			*errs = append(*errs, at+": expected boolean")
+		}
+	}
+}
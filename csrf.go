@@ -0,0 +1,81 @@
+package simplehttp
+
+import (
+	"crypto/subtle"
+	"html/template"
+	"net/http"
+)
+
+var (
+	SESSION_CSRF_KEY  = "_csrf"
+	HEADER_CSRF_TOKEN = "X-CSRF-Token"
+	FORM_CSRF_FIELD   = "csrf_token"
+
+	// CSRFTokenGenerator makes the CSRF tokens, 192 random bits by default.
+	// NewUUIDv4, NewULID or NewUUIDv7 fit too, they keep at least 74
+	// random bits. Never use snowflakes or SequentialIDs, tokens must be
+	// unguessable.
+	CSRFTokenGenerator IDGenerator = randomToken
+)
+
+// CSRFToken returns the CSRF token of the session, creating it on first use.
+// It is stable for the whole session (synchronizer token pattern).
+func CSRFToken(session Session) string {
+	if token, ok := session.Get(SESSION_CSRF_KEY).(string); ok && token != "" {
+		return token
+	}
+	token := CSRFTokenGenerator()
+	session.Set(SESSION_CSRF_KEY, token)
+	return token
+}
+
+// CSRFField is the hidden form input carrying the token
+func CSRFField(token string) template.HTML {
+	return template.HTML(`<input type="hidden" name="` + FORM_CSRF_FIELD + `" value="` + template.HTMLEscapeString(token) + `">`)
+}
+
+// VerifyCSRFToken compares token with the session token in constant time
+func VerifyCSRFToken(session Session, token string) bool {
+	expected, _ := session.Get(SESSION_CSRF_KEY).(string)
+	return expected != "" && subtle.ConstantTimeCompare([]byte(expected), []byte(token)) == 1
+}
+
+// CSRFConfig configures MiddlewareCSRF
+type CSRFConfig struct {
+	// Session returns the session of the request (required)
+	Session func(c Context) Session
+	Skipper Skipper
+}
+
+func MiddlewareCSRF(config CSRFConfig) Middleware {
+	return Skip(WithName("csrf", CSRF(config)), config.Skipper)
+}
+
+// CSRF checks the token of unsafe requests (POST, PUT, PATCH, DELETE) sent in
+// the X-CSRF-Token header or the csrf_token form field, 403 when it is
+// missing or wrong
+func CSRF(config CSRFConfig) MiddlewareFunc {
+	if config.Session == nil {
+		panic("simplehttp: CSRFConfig.Session is required")
+	}
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			switch c.GetMethod() {
+			case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+				return next(c)
+			}
+			session := config.Session(c)
+			if session == nil {
+				return NewError(http.StatusForbidden, "invalid CSRF token")
+			}
+			token := c.GetHeader(HEADER_CSRF_TOKEN)
+			if token == "" {
+				token = c.Request().FormValue(FORM_CSRF_FIELD)
+			}
+			if !VerifyCSRFToken(session, token) {
+				return NewError(http.StatusForbidden, "invalid CSRF token")
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package simplehttp
+
+import (
+	"time"
+)
+
+const DEFAULT_DEADLINE_WARNING_THRESHOLD = 0.8
+
+// DeadlineUsage reports a request that used most of its timeout budget
+type DeadlineUsage struct {
+	Time      time.Time     `json:"time"`
+	RequestID string        `json:"request_id,omitempty"`
+	Method    string        `json:"method"`
+	Path      string        `json:"path"`
+	Status    int           `json:"status"`
+	Elapsed   time.Duration `json:"elapsed"`
+	Budget    time.Duration `json:"budget"`
+	Used      float64       `json:"used"`     // Elapsed / Budget
+	Exceeded  bool          `json:"exceeded"` // the request ran out of time
+}
+
+// DeadlineWarningConfig configures MiddlewareDeadlineWarning
+type DeadlineWarningConfig struct {
+	// Threshold is the fraction of the budget that triggers a warning,
+	// default 0.8
+	Threshold float64
+	// Budget is used for requests without MiddlewareTimeout or WithTimeout,
+	// 0 means they are not checked
+	Budget time.Duration
+	// OnWarning receives every warning, e.g. to count them in metrics
+	OnWarning func(DeadlineUsage)
+	// Logger gets a warning line per request, defaults to the DefaultLogger
+	// when OnWarning is not set
+	Logger  Logger
+	Skipper Skipper
+}
+
+// MiddlewareDeadlineWarning reports requests that use more than Threshold of
+// their timeout budget without stopping them, so latency creeping towards the
+// 504s of MiddlewareTimeout shows up before clients see them. The budget is
+// the one of the timeout middleware, including WithTimeout overrides, so it
+// can be used before or after it:
+//
+//	server.Use(
+//		simplehttp.MiddlewareTimeout(timeoutConfig),
+//		simplehttp.MiddlewareDeadlineWarning(simplehttp.DeadlineWarningConfig{
+//			Threshold: 0.75,
+//			OnWarning: func(u simplehttp.DeadlineUsage) { nearTimeouts.WithLabelValues(u.Path).Inc() },
+//		}),
+//	)
+func MiddlewareDeadlineWarning(config DeadlineWarningConfig) Middleware {
+	return Skip(WithName("deadline warning", DeadlineWarning(config)), config.Skipper)
+}
+
+// DeadlineWarning measures the handler against the request deadline
+func DeadlineWarning(config DeadlineWarningConfig) MiddlewareFunc {
+	if config.Threshold <= 0 {
+		config.Threshold = DEFAULT_DEADLINE_WARNING_THRESHOLD
+	}
+	if config.Logger == nil && config.OnWarning == nil {
+		config.Logger = NewDefaultLogger()
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			start := time.Now()
+			err := next(c)
+			now := time.Now()
+
+			budget := config.Budget
+			if t, ok := c.Get(REQUEST_TIMEOUT_STRING).(*requestTimeout); ok {
+				// measured from the start of the timeout, the middleware
+				// may run inside it
+				deadline, _ := t.Deadline()
+				start, budget = t.start, deadline.Sub(t.start)
+			}
+			if budget <= 0 {
+				return err
+			}
+			elapsed := now.Sub(start)
+			used := float64(elapsed) / float64(budget)
+			if used < config.Threshold {
+				return err
+			}
+
+			warning := DeadlineUsage{
+				Time:      start,
+				RequestID: RequestIDOf(c),
+				Method:    c.GetMethod(),
+				Path:      c.GetPath(),
+				Status:    statusOf(c, err),
+				Elapsed:   elapsed,
+				Budget:    budget,
+				Used:      used,
+				Exceeded:  used >= 1,
+			}
+			if config.OnWarning != nil {
+				config.OnWarning(warning)
+			}
+			if config.Logger != nil {
+				config.Logger.Warnf("%s %s %s used %.0f%% of its %s budget (%s)",
+					warning.RequestID, warning.Method, warning.Path, used*100, budget, elapsed.Round(time.Millisecond))
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package simplehttp
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DEFAULT_DECOMPRESS_MAX_SIZE limits decompressed request bodies
+const DEFAULT_DECOMPRESS_MAX_SIZE = 10 << 20 // 10MB
+
+// DecompressionEncodings are the request content-codings MiddlewareDecompress
+// understands
+var DecompressionEncodings = []string{ENCODING_GZIP, ENCODING_DEFLATE}
+
+// DecompressConfig configures MiddlewareDecompress
+type DecompressConfig struct {
+	// MaxSize of the decompressed body, larger ones get 413 without being
+	// inflated further. Defaults to DEFAULT_DECOMPRESS_MAX_SIZE.
+	MaxSize int64
+	// MaxRatio of the decompressed to the compressed size, 0 means no limit.
+	// Catches small bombs that stay under MaxSize, JSON rarely goes over 20.
+	MaxRatio int64
+	Skipper  Skipper
+}
+
+func MiddlewareDecompress(config DecompressConfig) Middleware {
+	return Skip(WithName("decompress", Decompress(config)), config.Skipper)
+}
+
+// Decompress inflates request bodies sent with Content-Encoding gzip or
+// deflate (zlib, raw deflate is accepted too) before the handler and binding
+// see them, the header is removed afterwards. Several codings ("gzip, deflate")
+// are undone in reverse order. Unknown codings get 415, corrupt bodies 400
+// and bodies inflating past MaxSize or MaxRatio 413.
+//
+//	webhooks.Use(simplehttp.MiddlewareDecompress(simplehttp.DecompressConfig{MaxSize: 5 << 20}))
+func Decompress(config DecompressConfig) MiddlewareFunc {
+	if config.MaxSize == 0 {
+		config.MaxSize = DEFAULT_DECOMPRESS_MAX_SIZE
+	}
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			header := c.GetHeader(HEADER_CONTENT_ENCODING)
+			var encodings []string
+			for _, encoding := range strings.Split(header, ",") {
+				encoding = strings.ToLower(strings.TrimSpace(encoding))
+				if encoding != "" && encoding != ENCODING_IDENTITY {
+					encodings = append(encodings, encoding)
+				}
+			}
+			if len(encodings) == 0 {
+				return next(c)
+			}
+			for _, encoding := range encodings {
+				if !isDecompressible(encoding) {
+					c.SetResponseHeader(HEADER_ACCEPT_ENCODING, strings.Join(DecompressionEncodings, ", "))
+					return NewError(http.StatusUnsupportedMediaType, "unsupported content encoding "+header, DecompressionEncodings)
+				}
+			}
+
+			// cleared first, some frameworks (fiber) inflate the body
+			// themselves, without limit, when the header is set
+			c.SetRequestHeader(HEADER_CONTENT_ENCODING, "")
+			body := c.GetBody()
+			if len(body) == 0 {
+				return next(c)
+			}
+			limit := config.MaxSize
+			if config.MaxRatio > 0 && int64(len(body))*config.MaxRatio < limit {
+				limit = int64(len(body)) * config.MaxRatio
+			}
+			for i := len(encodings) - 1; i >= 0; i-- {
+				var err error
+				if body, err = DecompressBytes(encodings[i], body, limit); err != nil {
+					if errors.Is(err, ErrBodyTooLarge) {
+						return NewError(http.StatusRequestEntityTooLarge, "request body too large",
+							fmt.Sprintf("limit is %d bytes decompressed", limit))
+					}
+					return NewError(http.StatusBadRequest, "failed to decompress request body", err.Error())
+				}
+			}
+			c.SetRequestBody(body)
+			return next(c)
+		}
+	}
+}
+
+// ErrBodyTooLarge is returned by DecompressBytes when the data inflates past
+// its limit
+var ErrBodyTooLarge = errors.New("decompressed body too large")
+
+// DecompressBytes undoes one content-coding of data, reading at most limit
+// bytes of output
+func DecompressBytes(encoding string, data []byte, limit int64) ([]byte, error) {
+	var r io.Reader
+	switch encoding {
+	case ENCODING_GZIP, "x-gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	case ENCODING_DEFLATE:
+		// zlib wrapped as in RFC 9110, some clients send raw deflate
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if errors.Is(err, zlib.ErrHeader) {
+			zr = flate.NewReader(bytes.NewReader(data))
+		} else if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	out, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > limit {
+		return nil, ErrBodyTooLarge
+	}
+	return out, nil
+}
+
+func isDecompressible(encoding string) bool {
+	if encoding == "x-gzip" {
+		return true
+	}
+	for _, supported := range DecompressionEncodings {
+		if encoding == supported {
+			return true
+		}
+	}
+	return false
+}
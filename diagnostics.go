@@ -0,0 +1,184 @@
+package simplehttp
+
+import (
+	"net"
+	"slices"
+	"strings"
+)
+
+// Codes of the startup diagnostics, for Config.IgnoreDiagnostics
+const (
+	DIAGNOSTIC_CORS_WILDCARD_CREDENTIALS = "SH001"
+	DIAGNOSTIC_DEBUG_PUBLIC              = "SH002"
+	DIAGNOSTIC_STATIC_BROWSE             = "SH003"
+	DIAGNOSTIC_NO_RECOVER                = "SH004"
+	DIAGNOSTIC_RATE_LIMIT_SHARED_KEY     = "SH005"
+	DIAGNOSTIC_STUB_MODE                 = "SH006"
+)
+
+// Diagnostic is a suspicious setting found when a server starts
+type Diagnostic struct {
+	Code    string `json:"code"`
+	Field   string `json:"field"` // the setting involved, e.g. "CORSConfig.AllowCredentials"
+	Message string `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	return d.Code + " " + d.Field + ": " + d.Message
+}
+
+// DiagnosticsError fails Start in Config.StrictStartup
+type DiagnosticsError []Diagnostic
+
+func (e DiagnosticsError) Error() string {
+	messages := make([]string, len(e))
+	for i, d := range e {
+		messages[i] = d.String()
+	}
+	return "simplehttp: startup diagnostics: " + strings.Join(messages, "; ")
+}
+
+// Diagnoser is implemented by middleware checking their config against the
+// server they run in, see NamedMiddleware.Diagnose
+type Diagnoser interface {
+	Diagnose(info StartupInfo) []Diagnostic
+}
+
+// StartupInfo is what the framework adapters know of a server when it
+// starts, see CheckStartup
+type StartupInfo struct {
+	Config  *Config
+	Address string
+	// Middleware of the server and of its groups
+	Middleware []Middleware
+	Static     []StaticDir
+	// Recovers reports that the framework recovers panics itself
+	Recovers bool
+}
+
+// StaticDir is a directory served by Static
+type StaticDir struct {
+	Prefix string
+	Root   string
+	Browse bool // lists the directories without an index.html
+}
+
+// Diagnose returns the diagnostics of a server about to start, except the
+// codes of Config.IgnoreDiagnostics
+func Diagnose(info StartupInfo) []Diagnostic {
+	config := info.Config
+	if config == nil {
+		config = DefaultConfig
+	}
+	var found []Diagnostic
+	if config.Debug && publicAddress(info.Address) {
+		found = append(found, Diagnostic{
+			Code:    DIAGNOSTIC_DEBUG_PUBLIC,
+			Field:   "Config.Debug",
+			Message: "debug mode on " + info.Address + " is reachable from other hosts, turn it off or bind to localhost",
+		})
+	}
+	if config.StubDir != "" {
+		found = append(found, Diagnostic{
+			Code:    DIAGNOSTIC_STUB_MODE,
+			Field:   "Config.StubDir",
+			Message: "stub mode, responses come from the fixtures of " + config.StubDir + " instead of the handlers",
+		})
+	}
+	for _, dir := range info.Static {
+		if dir.Browse {
+			found = append(found, Diagnostic{
+				Code:    DIAGNOSTIC_STATIC_BROWSE,
+				Field:   "Static(" + dir.Prefix + ")",
+				Message: "directories of " + dir.Root + " without an index.html are listed, add one or serve the files with FileHandler",
+			})
+		}
+	}
+	recovers := info.Recovers
+	for _, m := range info.Middleware {
+		if m.Name() == "recover" {
+			recovers = true
+		}
+		if d, ok := m.(Diagnoser); ok {
+			found = append(found, d.Diagnose(info)...)
+		}
+	}
+	if !recovers {
+		found = append(found, Diagnostic{
+			Code:    DIAGNOSTIC_NO_RECOVER,
+			Field:   "Use",
+			Message: "no recover middleware, a panicking handler takes the server down, use MiddlewareRecover",
+		})
+	}
+	return slices.DeleteFunc(found, func(d Diagnostic) bool {
+		return slices.Contains(config.IgnoreDiagnostics, d.Code)
+	})
+}
+
+// CheckStartup logs the diagnostics as warnings, called by the framework
+// adapters at Start. In Config.StrictStartup it returns them as a
+// DiagnosticsError instead, and the server doesn't start.
+func CheckStartup(info StartupInfo) error {
+	found := Diagnose(info)
+	if len(found) == 0 {
+		return nil
+	}
+	if info.Config != nil && info.Config.StrictStartup {
+		return DiagnosticsError(found)
+	}
+	logger := NewDefaultLogger()
+	if info.Config != nil && info.Config.Logger != nil {
+		logger = info.Config.Logger
+	}
+	for _, d := range found {
+		logger.Warnf("startup %s", d)
+	}
+	return nil
+}
+
+// publicAddress reports whether a listen address accepts other hosts
+func publicAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	switch host {
+	case "":
+		return true
+	case "localhost":
+		return false
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip == nil || !ip.IsLoopback()
+}
+
+// corsDiagnostics flags a wildcard origin allowed with credentials, browsers
+// refuse it and reflecting the origin instead exposes users to every site
+func corsDiagnostics(config *CORSConfig) func(StartupInfo) []Diagnostic {
+	return func(StartupInfo) []Diagnostic {
+		if config == nil || !config.AllowCredentials || !slices.Contains(config.AllowOrigins, "*") {
+			return nil
+		}
+		return []Diagnostic{{
+			Code:    DIAGNOSTIC_CORS_WILDCARD_CREDENTIALS,
+			Field:   "CORSConfig.AllowCredentials",
+			Message: `AllowOrigins "*" with credentials, list the allowed origins instead`,
+		}}
+	}
+}
+
+// rateLimitDiagnostics flags a limiter keyed by IP without trusted proxies,
+// behind a load balancer all clients then share its limit
+func rateLimitDiagnostics(config RateLimitConfig) func(StartupInfo) []Diagnostic {
+	return func(info StartupInfo) []Diagnostic {
+		if config.KeyFunc != nil || info.Config == nil ||
+			len(info.Config.TrustedProxies) > 0 || info.Config.IPExtractor != nil {
+			return nil
+		}
+		return []Diagnostic{{
+			Code:    DIAGNOSTIC_RATE_LIMIT_SHARED_KEY,
+			Field:   "RateLimitConfig.KeyFunc",
+			Message: "keyed by the connection IP, behind a proxy all clients share one limit, set Config.TrustedProxies or a KeyFunc",
+		}}
+	}
+}
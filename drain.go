@@ -0,0 +1,67 @@
+package simplehttp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Drain is the shutdown state shared by a server's request handling and its
+// Shutdown, the adapters answer with DrainResponse once it started
+type Drain struct {
+	draining atomic.Bool
+	config   ShutdownConfig
+}
+
+func NewDrain(config *Config) *Drain {
+	d := &Drain{config: ShutdownConfig{RetryAfter: DEFAULT_SHUTDOWN_RETRY_AFTER}}
+	if config != nil && config.ConfigShutdown != nil {
+		d.config = *config.ConfigShutdown
+	}
+	return d
+}
+
+func (d *Drain) Draining() bool {
+	return d.draining.Load()
+}
+
+// Start marks the server as draining and waits for the drain window, or
+// until ctx is done, before the adapter closes its listeners
+func (d *Drain) Start(ctx context.Context) {
+	d.draining.Store(true)
+	if d.config.DrainWindow <= 0 {
+		return
+	}
+	timer := time.NewTimer(d.config.DrainWindow)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// Headers are set on every response while draining
+func (d *Drain) Headers() map[string]string {
+	headers := make(map[string]string, 2)
+	if d.config.RetryAfter > 0 {
+		headers["Retry-After"] = strconv.Itoa(int(d.config.RetryAfter.Round(time.Second).Seconds()))
+	}
+	if !d.config.KeepConnection {
+		headers["Connection"] = "close"
+	}
+	return headers
+}
+
+// CloseConnection reports whether the connection is closed after the 503
+func (d *Drain) CloseConnection() bool {
+	return !d.config.KeepConnection
+}
+
+// DrainResponse is the JSON body of the 503 sent while draining
+func DrainResponse() []byte {
+	body, _ := json.Marshal(NewError(http.StatusServiceUnavailable, ErrShuttingDown.Error()))
+	return body
+}
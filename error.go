@@ -10,6 +10,7 @@ var (
 	ErrUnauthorized      = fmt.Errorf("unauthorized")
 	ErrForbidden         = fmt.Errorf("forbidden")
 	ErrRateLimitExceeded = fmt.Errorf("limit exceeded")
+	ErrShuttingDown      = fmt.Errorf("server is shutting down")
 )
 
 // SimpleHttpError represents a standardized error response
@@ -1,9 +1,18 @@
 package simplehttp
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -13,26 +22,42 @@ type FileInfo struct {
 	Size         int64
 	ContentType  string
 	LastModified time.Time
-	Hash         string // MD5/SHA hash of file
+	Hash         string // SHA-256 of the content, hex encoded
 }
 
 // File handling utilities
 type FileHandler struct {
-	UploadDir    string
-	MaxFileSize  int64
+	UploadDir string // used when Storage is nil
+	// Storage receives the uploads, NewFileHandler sets a DiskStorage of
+	// UploadDir. Use S3Storage on containers with ephemeral disks.
+	Storage     Storage
+	MaxFileSize int64
+	// AllowedTypes are checked against the type sniffed from the content,
+	// not the one sent by the client, and accept wildcards like image/*.
+	// Empty allows every type.
 	AllowedTypes []string
+	// SignedURLExpiry makes HandleStorageDownload redirect to a signed URL of
+	// the storage, so the files don't go through the server. 0 streams them.
+	SignedURLExpiry time.Duration
+	// Uploads keeps the state of resumable uploads (MountResumableUploads),
+	// use a shared store when running several instances
+	Uploads      CacheStore
+	UploadExpiry time.Duration // of unfinished uploads, defaults to 24h
+
+	uploading sync.Map // ids of uploads with a request in progress
 }
 
 func NewFileHandler(uploadDir string) *FileHandler {
 	return &FileHandler{
 		UploadDir:    uploadDir,
+		Storage:      NewDiskStorage(uploadDir),
 		MaxFileSize:  10 << 20, // 10MB default
 		AllowedTypes: []string{"image/*", "application/pdf"},
 	}
 }
 
 // This is independent of implementation
-// Make sure the implementation context has .GetFile and .SaveFile
+// Make sure the implementation context has .GetFile
 func (h *FileHandler) HandleUpload() HandlerFunc {
 	return func(c Context) error {
 		file, err := c.GetFile("file")
@@ -45,26 +70,204 @@ func (h *FileHandler) HandleUpload() HandlerFunc {
 			return c.JSON(400, map[string]string{"error": "file too large"})
 		}
 
+		src, err := file.Open()
+		if err != nil {
+			return c.JSON(400, map[string]string{"error": "file unreadable"})
+		}
+		defer src.Close()
+
+		// Validate file type from its content
+		head := make([]byte, 512)
+		n, err := io.ReadFull(src, head)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return c.JSON(400, map[string]string{"error": "file unreadable"})
+		}
+		head = head[:n]
+		contentType := sniffContentType(head, file.Header.Get(HEADER_CONTENT_TYPE))
+		if len(h.AllowedTypes) > 0 && !matchContentType(h.AllowedTypes, contentType) {
+			return c.JSON(415, map[string]string{"error": "file type not allowed"})
+		}
+
 		// Generate safe filename
 		filename := generateSafeFilename(file.Filename)
 
-		// Save file
-		if err := c.SaveFile(file, filepath.Join(h.UploadDir, filename)); err != nil {
+		// Save file, hashing it on the way
+		hash := sha256.New()
+		content := io.TeeReader(io.MultiReader(bytes.NewReader(head), src), hash)
+		info := FileInfo{Filename: filename, Size: file.Size, ContentType: contentType}
+		if err := h.storage().Save(c.Context(), filename, content, info); err != nil {
 			return c.JSON(500, map[string]string{"error": "failed to save file"})
 		}
 
 		return c.JSON(200, FileInfo{
 			Filename:    filename,
 			Size:        file.Size,
-			ContentType: file.Header.Get("Content-Type"),
+			ContentType: contentType,
+			Hash:        hex.EncodeToString(hash.Sum(nil)),
 		})
 	}
 }
 
-func (h *FileHandler) HandleDownload(filepath string) HandlerFunc {
+// sniffContentType detects the type of the first 512 bytes of a file.
+// Content sniffed as plain text keeps the more precise text type declared
+// by the client, e.g. text/csv, which can't be told apart by sniffing.
+func sniffContentType(head []byte, declared string) string {
+	sniffed := normalizeContentType(http.DetectContentType(head))
+	declared = normalizeContentType(declared)
+	if sniffed == "text/plain" && (strings.HasPrefix(declared, "text/") || declared == CONTENT_TYPE_JSON) {
+		return declared
+	}
+	return sniffed
+}
+
+// HandleDownload sends the file of pathTemplate, where {{name}} is replaced
+// by the route parameter name:
+//
+//	server.GET("/files/:filename", fileHandler.HandleDownload("./uploads/{{filename}}"))
+//
+// The file must stay in the directory before the first placeholder, so a
+// parameter like ../../etc/passwd gets 404 like a missing file.
+func (h *FileHandler) HandleDownload(pathTemplate string) HandlerFunc {
+	start := strings.Index(pathTemplate, "{{")
+	if start < 0 {
+		return func(c Context) error {
+			return c.SendFile(pathTemplate, true)
+		}
+	}
+	root := filepath.Dir(pathTemplate[:start])
+
+	return func(c Context) error {
+		path, ok := expandPathTemplate(pathTemplate, c.GetParam)
+		if !ok || !withinDir(root, path) {
+			return c.JSON(404, map[string]string{"error": "file not found"})
+		}
+		if info, err := os.Stat(path); err != nil || info.IsDir() {
+			return c.JSON(404, map[string]string{"error": "file not found"})
+		}
+		return c.SendFile(path, true)
+	}
+}
+
+// HandleStorageDownload sends the file of the Storage named by nameTemplate,
+// where {{name}} is replaced by the route parameter name:
+//
+//	server.GET("/files/:filename", fileHandler.HandleStorageDownload("{{filename}}"))
+//
+// With SignedURLExpiry it redirects to a signed URL when the storage has them.
+func (h *FileHandler) HandleStorageDownload(nameTemplate string) HandlerFunc {
 	return func(c Context) error {
-		return c.SendFile(filepath, true)
+		name, ok := expandPathTemplate(nameTemplate, c.GetParam)
+		if !ok {
+			return c.JSON(404, map[string]string{"error": "file not found"})
+		}
+		name = filepath.ToSlash(name)
+		storage := h.storage()
+		info, err := storage.Stat(c.Context(), name)
+		if errors.Is(err, ErrFileNotFound) || errors.Is(err, ErrStorageName) {
+			return c.JSON(404, map[string]string{"error": "file not found"})
+		}
+		if err != nil {
+			return c.JSON(500, map[string]string{"error": "failed to read file"})
+		}
+
+		if h.SignedURLExpiry > 0 {
+			url, err := storage.SignedURL(c.Context(), name, h.SignedURLExpiry)
+			if err == nil {
+				c.SetResponseHeader("Location", url)
+				return c.String(http.StatusFound, "")
+			}
+			if !errors.Is(err, ErrSignedURLUnsupported) {
+				return c.JSON(500, map[string]string{"error": "failed to read file"})
+			}
+		}
+
+		file, err := storage.Open(c.Context(), name)
+		if err != nil {
+			return c.JSON(500, map[string]string{"error": "failed to read file"})
+		}
+		// seekable files (DiskStorage) get ranges and conditional requests
+		if seeker, ok := file.(io.ReadSeeker); ok {
+			version := ResourceVersion{
+				ETag:         fileETag(info.LastModified, info.Size),
+				LastModified: info.LastModified,
+			}
+			if info.Hash != "" {
+				version.ETag = `"` + info.Hash + `"`
+			}
+			return ServeContent(c, seeker, info.Size, version, ServeFileOptions{
+				Attachment:  true,
+				Filename:    path.Base(name),
+				ContentType: info.ContentType,
+			})
+		}
+		// fiber sends the stream after the handler returns, the file closes
+		// once read instead of with a defer
+		body := &closeOnEOF{ReadCloser: file}
+		c.SetResponseHeader(HEADER_CONTENT_DISPOSITION, ContentDisposition("attachment", path.Base(name)))
+		if err := c.Stream(http.StatusOK, info.ContentType, body); err != nil {
+			body.Close()
+			return err
+		}
+		return nil
+	}
+}
+
+// closeOnEOF closes the reader when it is used up, Close can be called again
+type closeOnEOF struct {
+	io.ReadCloser
+	once sync.Once
+	err  error
+}
+
+func (r *closeOnEOF) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil {
+		r.Close()
+	}
+	return n, err
+}
+
+func (r *closeOnEOF) Close() error {
+	r.once.Do(func() { r.err = r.ReadCloser.Close() })
+	return r.err
+}
+
+func (h *FileHandler) storage() Storage {
+	if h.Storage != nil {
+		return h.Storage
+	}
+	return NewDiskStorage(h.UploadDir)
+}
+
+// expandPathTemplate replaces the {{name}} placeholders with param(name),
+// values that are empty or hold a path separator are refused
+func expandPathTemplate(template string, param func(string) string) (string, bool) {
+	var b strings.Builder
+	for {
+		start := strings.Index(template, "{{")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(template[start:], "}}")
+		if end < 0 {
+			break
+		}
+		value := param(strings.TrimSpace(template[start+2 : start+end]))
+		if value == "" || strings.ContainsAny(value, `/\`+"\x00") {
+			return "", false
+		}
+		b.WriteString(template[:start])
+		b.WriteString(value)
+		template = template[start+end+2:]
 	}
+	b.WriteString(template)
+	return filepath.Clean(b.String()), true
+}
+
+// withinDir reports whether path is dir or inside it
+func withinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
 }
 
 func generateSafeFilename(filename string) string {
@@ -0,0 +1,349 @@
+package simplehttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_UPLOAD_EXPIRY = 24 * time.Hour
+
+	// tus protocol headers (tus.io)
+	HEADER_UPLOAD_LENGTH   = "Upload-Length"
+	HEADER_UPLOAD_OFFSET   = "Upload-Offset"
+	HEADER_UPLOAD_METADATA = "Upload-Metadata"
+	HEADER_TUS_RESUMABLE   = "Tus-Resumable"
+	TUS_VERSION            = "1.0.0"
+
+	// RESUMABLE_UPLOAD_DIR holds the chunks of unfinished uploads in the Storage
+	RESUMABLE_UPLOAD_DIR = ".resumable"
+)
+
+// UploadStatus is the state of a resumable upload
+type UploadStatus struct {
+	ID          string    `json:"id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type,omitempty"`
+	Size        int64     `json:"size"`
+	Offset      int64     `json:"offset"`
+	Expires     time.Time `json:"expires"`
+	Chunks      []int64   `json:"-"` // sizes of the stored chunks, in order
+}
+
+// MountResumableUploads registers the resumable upload routes under prefix,
+// tus style, so uploads over flaky networks continue where they broke off:
+//
+//	POST   prefix                 create, Upload-Length header, 201 with Location
+//	HEAD   prefix/:id             progress in Upload-Offset
+//	PATCH  prefix/:id             a chunk at Upload-Offset
+//	POST   prefix/:id/finalize    checks the type and stores the file, FileInfo
+//	DELETE prefix/:id             cancels
+//
+// Chunk state is kept in h.Uploads, the chunks in the Storage until finalize.
+func (h *FileHandler) MountResumableUploads(r Router, prefix string) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	r.POST(prefix, h.HandleUploadCreate())
+	r.HEAD(prefix+"/:id", h.HandleUploadStatus())
+	r.PATCH(prefix+"/:id", h.HandleUploadChunk())
+	r.POST(prefix+"/:id/finalize", h.HandleUploadFinalize())
+	r.DELETE(prefix+"/:id", h.HandleUploadCancel())
+}
+
+// HandleUploadCreate starts a resumable upload of Upload-Length bytes. The
+// file name and type come from Upload-Metadata, "filename" and "filetype"
+// base64 encoded as in tus.
+func (h *FileHandler) HandleUploadCreate() HandlerFunc {
+	h.uploadStore()
+	return func(c Context) error {
+		size, err := strconv.ParseInt(c.GetHeader(HEADER_UPLOAD_LENGTH), 10, 64)
+		if err != nil || size < 0 {
+			return c.JSON(400, map[string]string{"error": "Upload-Length required"})
+		}
+		if h.MaxFileSize > 0 && size > h.MaxFileSize {
+			return c.JSON(413, map[string]string{"error": "file too large"})
+		}
+		metadata := parseUploadMetadata(c.GetHeader(HEADER_UPLOAD_METADATA))
+		filename := metadata["filename"]
+		if filename == "" {
+			filename = "upload"
+		}
+
+		upload := &UploadStatus{
+			ID:          randomToken(),
+			Filename:    filename,
+			ContentType: metadata["filetype"],
+			Size:        size,
+			Expires:     time.Now().Add(h.uploadExpiry()),
+		}
+		if err := h.saveUploadStatus(upload); err != nil {
+			return c.JSON(500, map[string]string{"error": "failed to create upload"})
+		}
+		c.SetResponseHeader(HEADER_TUS_RESUMABLE, TUS_VERSION)
+		c.SetResponseHeader("Location", strings.TrimSuffix(c.Request().URL.Path, "/")+"/"+upload.ID)
+		return c.JSON(http.StatusCreated, upload)
+	}
+}
+
+// HandleUploadStatus answers HEAD with the bytes received in Upload-Offset
+func (h *FileHandler) HandleUploadStatus() HandlerFunc {
+	h.uploadStore()
+	return func(c Context) error {
+		upload, ok := h.uploadStatus(c.GetParam("id"))
+		if !ok {
+			return c.JSON(404, map[string]string{"error": "upload not found"})
+		}
+		setUploadHeaders(c, upload)
+		return c.JSON(http.StatusOK, upload)
+	}
+}
+
+// HandleUploadChunk appends the body at Upload-Offset, which must be the
+// bytes received so far (409 with the current offset otherwise, the client
+// asks HEAD and resumes from there)
+func (h *FileHandler) HandleUploadChunk() HandlerFunc {
+	h.uploadStore()
+	return func(c Context) error {
+		id := c.GetParam("id")
+		// one chunk at a time per upload
+		if _, busy := h.uploading.LoadOrStore(id, struct{}{}); busy {
+			return c.JSON(http.StatusLocked, map[string]string{"error": "upload busy"})
+		}
+		defer h.uploading.Delete(id)
+
+		upload, ok := h.uploadStatus(id)
+		if !ok {
+			return c.JSON(404, map[string]string{"error": "upload not found"})
+		}
+		setUploadHeaders(c, upload)
+		offset, err := strconv.ParseInt(c.GetHeader(HEADER_UPLOAD_OFFSET), 10, 64)
+		if err != nil || offset != upload.Offset {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "offset mismatch"})
+		}
+		chunk := c.GetBody()
+		if upload.Offset+int64(len(chunk)) > upload.Size {
+			return c.JSON(413, map[string]string{"error": "chunk beyond Upload-Length"})
+		}
+		if len(chunk) == 0 {
+			return c.String(http.StatusNoContent, "")
+		}
+
+		// fail fast on a disallowed type, finalize checks again
+		if upload.Offset == 0 && len(h.AllowedTypes) > 0 && (len(chunk) >= 512 || int64(len(chunk)) == upload.Size) {
+			contentType := sniffContentType(chunk[:min(len(chunk), 512)], upload.ContentType)
+			if !matchContentType(h.AllowedTypes, contentType) {
+				h.cancelUpload(c.Context(), upload)
+				return c.JSON(415, map[string]string{"error": "file type not allowed"})
+			}
+		}
+
+		name := uploadChunkName(upload.ID, len(upload.Chunks))
+		info := FileInfo{Filename: name, Size: int64(len(chunk)), ContentType: CONTENT_TYPE_OCTET_STREAM}
+		if err := h.storage().Save(c.Context(), name, bytes.NewReader(chunk), info); err != nil {
+			return c.JSON(500, map[string]string{"error": "failed to save chunk"})
+		}
+		upload.Chunks = append(upload.Chunks, int64(len(chunk)))
+		upload.Offset += int64(len(chunk))
+		upload.Expires = time.Now().Add(h.uploadExpiry())
+		if err := h.saveUploadStatus(upload); err != nil {
+			return c.JSON(500, map[string]string{"error": "failed to save chunk"})
+		}
+		setUploadHeaders(c, upload)
+		return c.String(http.StatusNoContent, "")
+	}
+}
+
+// HandleUploadFinalize joins the chunks of a complete upload into the file,
+// checking AllowedTypes like HandleUpload, and answers with its FileInfo
+func (h *FileHandler) HandleUploadFinalize() HandlerFunc {
+	h.uploadStore()
+	return func(c Context) error {
+		id := c.GetParam("id")
+		if _, busy := h.uploading.LoadOrStore(id, struct{}{}); busy {
+			return c.JSON(http.StatusLocked, map[string]string{"error": "upload busy"})
+		}
+		defer h.uploading.Delete(id)
+
+		upload, ok := h.uploadStatus(id)
+		if !ok {
+			return c.JSON(404, map[string]string{"error": "upload not found"})
+		}
+		if upload.Offset != upload.Size {
+			setUploadHeaders(c, upload)
+			return c.JSON(http.StatusConflict, map[string]string{"error": "upload incomplete"})
+		}
+
+		storage := h.storage()
+		chunks := &chunkReader{ctx: c.Context(), storage: storage, upload: upload}
+		defer chunks.Close()
+		head := make([]byte, 512)
+		n, err := io.ReadFull(chunks, head)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return c.JSON(500, map[string]string{"error": "failed to read upload"})
+		}
+		head = head[:n]
+		contentType := sniffContentType(head, upload.ContentType)
+		if len(h.AllowedTypes) > 0 && !matchContentType(h.AllowedTypes, contentType) {
+			h.cancelUpload(c.Context(), upload)
+			return c.JSON(415, map[string]string{"error": "file type not allowed"})
+		}
+
+		filename := generateSafeFilename(upload.Filename)
+		hash := sha256.New()
+		content := io.TeeReader(io.MultiReader(bytes.NewReader(head), chunks), hash)
+		info := FileInfo{Filename: filename, Size: upload.Size, ContentType: contentType}
+		if err := storage.Save(c.Context(), filename, content, info); err != nil {
+			return c.JSON(500, map[string]string{"error": "failed to save file"})
+		}
+		h.cancelUpload(c.Context(), upload)
+
+		info.Hash = hex.EncodeToString(hash.Sum(nil))
+		return c.JSON(200, info)
+	}
+}
+
+// HandleUploadCancel drops an upload and its chunks
+func (h *FileHandler) HandleUploadCancel() HandlerFunc {
+	h.uploadStore()
+	return func(c Context) error {
+		id := c.GetParam("id")
+		if _, busy := h.uploading.LoadOrStore(id, struct{}{}); busy {
+			return c.JSON(http.StatusLocked, map[string]string{"error": "upload busy"})
+		}
+		defer h.uploading.Delete(id)
+
+		upload, ok := h.uploadStatus(id)
+		if !ok {
+			return c.JSON(404, map[string]string{"error": "upload not found"})
+		}
+		h.cancelUpload(c.Context(), upload)
+		return c.String(http.StatusNoContent, "")
+	}
+}
+
+func (h *FileHandler) uploadStore() CacheStore {
+	if h.Uploads == nil {
+		panic("simplehttp: FileHandler.Uploads is required for resumable uploads")
+	}
+	return h.Uploads
+}
+
+func (h *FileHandler) uploadExpiry() time.Duration {
+	if h.UploadExpiry > 0 {
+		return h.UploadExpiry
+	}
+	return DEFAULT_UPLOAD_EXPIRY
+}
+
+func (h *FileHandler) uploadStatus(id string) (*UploadStatus, bool) {
+	if id == "" {
+		return nil, false
+	}
+	cached, found := h.uploadStore().Get(uploadKey(id))
+	upload, _ := cached.(*UploadStatus)
+	if !found || upload == nil || time.Now().After(upload.Expires) {
+		return nil, false
+	}
+	// a copy, the store may hand out the same pointer to other requests
+	copied := *upload
+	copied.Chunks = append([]int64(nil), upload.Chunks...)
+	return &copied, true
+}
+
+func (h *FileHandler) saveUploadStatus(upload *UploadStatus) error {
+	return h.uploadStore().Set(uploadKey(upload.ID), upload, time.Until(upload.Expires))
+}
+
+// cancelUpload deletes the chunks and the state of upload
+func (h *FileHandler) cancelUpload(ctx context.Context, upload *UploadStatus) {
+	storage := h.storage()
+	for i := range upload.Chunks {
+		storage.Delete(ctx, uploadChunkName(upload.ID, i))
+	}
+	h.uploadStore().Delete(uploadKey(upload.ID))
+}
+
+func setUploadHeaders(c Context, upload *UploadStatus) {
+	c.SetResponseHeader(HEADER_TUS_RESUMABLE, TUS_VERSION)
+	c.SetResponseHeader(HEADER_UPLOAD_OFFSET, strconv.FormatInt(upload.Offset, 10))
+	c.SetResponseHeader(HEADER_UPLOAD_LENGTH, strconv.FormatInt(upload.Size, 10))
+	c.SetResponseHeader(HEADER_CACHE_CONTROL, "no-store")
+}
+
+func uploadKey(id string) string {
+	return "upload:" + id
+}
+
+func uploadChunkName(id string, index int) string {
+	return fmt.Sprintf("%s/%s/%06d", RESUMABLE_UPLOAD_DIR, id, index)
+}
+
+// parseUploadMetadata reads "key base64value,key base64value", values that
+// don't decode are dropped
+func parseUploadMetadata(header string) map[string]string {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		metadata[key] = string(decoded)
+	}
+	return metadata
+}
+
+// chunkReader reads the chunks of an upload in order, opening one at a time
+type chunkReader struct {
+	ctx     context.Context
+	storage Storage
+	upload  *UploadStatus
+	next    int
+	current io.ReadCloser
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if r.next == len(r.upload.Chunks) {
+				return 0, io.EOF
+			}
+			file, err := r.storage.Open(r.ctx, uploadChunkName(r.upload.ID, r.next))
+			if err != nil {
+				return 0, err
+			}
+			r.current = file
+			r.next++
+		}
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *chunkReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+	err := r.current.Close()
+	r.current = nil
+	return err
+}
@@ -0,0 +1,191 @@
+package simplehttp
+
+import "net/http"
+
+const (
+	SESSION_FLASH_KEY = "_flash"
+
+	TEMPLATE_CSRF_TOKEN = "csrf_token"
+	TEMPLATE_CSRF_FIELD = "csrf_field"
+	TEMPLATE_FLASHES    = "flashes"
+)
+
+// FlashSession is an optional Session interface for sessions reading their
+// flashes until they are saved, so a layout and a page can both show them.
+// MemorySession implements it, and so do the sessions of MiddlewareSession.
+type FlashSession interface {
+	Session
+	Flash(key, message string) error
+	Flashes(key string) []string
+	AllFlashes() map[string][]string
+}
+
+// Flash queues a one-time message under key (e.g. "success", "error") to be
+// shown on the next page, the classic set message, redirect, render flow.
+// Call session.Save() afterwards like for any other change.
+func Flash(session Session, key, message string) error {
+	if fs, ok := session.(FlashSession); ok {
+		return fs.Flash(key, message)
+	}
+	flashes := sessionFlashes(session)
+	flashes[key] = append(flashes[key], message)
+	return session.Set(SESSION_FLASH_KEY, flashes)
+}
+
+// Flashes returns the messages queued under key. A FlashSession drops them
+// when it is saved, other sessions right away.
+func Flashes(session Session, key string) []string {
+	if fs, ok := session.(FlashSession); ok {
+		return fs.Flashes(key)
+	}
+	flashes := sessionFlashes(session)
+	messages := flashes[key]
+	if len(messages) == 0 {
+		return nil
+	}
+	delete(flashes, key)
+	if len(flashes) == 0 {
+		session.Delete(SESSION_FLASH_KEY)
+	} else {
+		session.Set(SESSION_FLASH_KEY, flashes)
+	}
+	return messages
+}
+
+// AllFlashes returns every queued message grouped by key, dropped like by
+// Flashes
+func AllFlashes(session Session) map[string][]string {
+	if fs, ok := session.(FlashSession); ok {
+		return fs.AllFlashes()
+	}
+	flashes := sessionFlashes(session)
+	if len(flashes) > 0 {
+		session.Delete(SESSION_FLASH_KEY)
+	}
+	return flashes
+}
+
+// FlashRedirect queues message on the session of MiddlewareSession and
+// redirects to location with 303 See Other, to end a form POST:
+//
+//	return simplehttp.FlashRedirect(c, "success", "Profile saved", "/profile")
+func FlashRedirect(c Context, key, message, location string) error {
+	session := SessionFromContext(c)
+	if session == nil {
+		return NewError(http.StatusInternalServerError, "no session, use MiddlewareSession")
+	}
+	if err := Flash(session, key, message); err != nil {
+		return err
+	}
+	c.SetResponseHeader("Location", location)
+	return c.String(http.StatusSeeOther, "")
+}
+
+// TemplateData adds the CSRF token (plain and as a hidden input) and the
+// flashes (consumed) to data for server-rendered pages
+//
+//	data := simplehttp.TemplateData(session, map[string]interface{}{"user": user})
+//	tmpl.Execute(&buf, data) // {{ .csrf_field }}, {{ range .flashes.error }}...
+func TemplateData(session Session, data map[string]interface{}) map[string]interface{} {
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	token := CSRFToken(session)
+	data[TEMPLATE_CSRF_TOKEN] = token
+	data[TEMPLATE_CSRF_FIELD] = CSRFField(token)
+	data[TEMPLATE_FLASHES] = AllFlashes(session)
+	return data
+}
+
+// sessionFlashes reads a copy of the flash map
+func sessionFlashes(session Session) map[string][]string {
+	return flashMap(session.Get(SESSION_FLASH_KEY))
+}
+
+// flashMap copies the flash map, stores that serialize sessions (e.g. as
+// JSON) give it back as map[string]interface{}
+func flashMap(value interface{}) map[string][]string {
+	switch v := value.(type) {
+	case map[string][]string:
+		// stores keeping the session in memory may share the map
+		flashes := make(map[string][]string, len(v))
+		for key, list := range v {
+			flashes[key] = append([]string(nil), list...)
+		}
+		return flashes
+	case map[string]interface{}:
+		flashes := make(map[string][]string, len(v))
+		for key, list := range v {
+			items, _ := list.([]interface{})
+			for _, item := range items {
+				if s, ok := item.(string); ok {
+					flashes[key] = append(flashes[key], s)
+				}
+			}
+		}
+		return flashes
+	}
+	return make(map[string][]string)
+}
+
+// Flash queues a message like the Flash function
+func (s *MemorySession) Flash(key, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flashes := flashMap(s.data[SESSION_FLASH_KEY])
+	flashes[key] = append(flashes[key], message)
+	s.data[SESSION_FLASH_KEY] = flashes
+	return nil
+}
+
+// Flashes returns the messages queued under key, again on every call until
+// the session is saved. Messages queued after the call are kept.
+func (s *MemorySession) Flashes(key string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	messages := flashMap(s.data[SESSION_FLASH_KEY])[key]
+	if len(messages) > 0 {
+		if s.flashesRead == nil {
+			s.flashesRead = make(map[string]int)
+		}
+		s.flashesRead[key] = len(messages)
+	}
+	return messages
+}
+
+// AllFlashes returns every queued message grouped by key, like Flashes
+func (s *MemorySession) AllFlashes() map[string][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flashes := flashMap(s.data[SESSION_FLASH_KEY])
+	for key, messages := range flashes {
+		if s.flashesRead == nil {
+			s.flashesRead = make(map[string]int)
+		}
+		s.flashesRead[key] = len(messages)
+	}
+	return flashes
+}
+
+// sweepFlashes drops the messages read, called when the session is saved
+func (s *MemorySession) sweepFlashes() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.flashesRead) == 0 {
+		return
+	}
+	flashes := flashMap(s.data[SESSION_FLASH_KEY])
+	for key, read := range s.flashesRead {
+		if read >= len(flashes[key]) {
+			delete(flashes, key)
+		} else {
+			flashes[key] = flashes[key][read:]
+		}
+	}
+	s.flashesRead = nil
+	if len(flashes) == 0 {
+		delete(s.data, SESSION_FLASH_KEY)
+	} else {
+		s.data[SESSION_FLASH_KEY] = flashes
+	}
+}
@@ -0,0 +1,115 @@
+package simplehttp
+
+import (
+	"net"
+	"strings"
+	"sync"
+)
+
+const (
+	HEADER_FORWARDED         = "Forwarded" // RFC 7239
+	HEADER_X_FORWARDED_PROTO = "X-Forwarded-Proto"
+	HEADER_X_FORWARDED_HOST  = "X-Forwarded-Host"
+	HEADER_X_FORWARDED_SSL   = "X-Forwarded-Ssl"
+	HEADER_FRONT_END_HTTPS   = "Front-End-Https"
+
+	SCHEME_HTTP  = "http"
+	SCHEME_HTTPS = "https"
+)
+
+var trustedProxyNets sync.Map // *Config -> []*net.IPNet
+
+// IsTrustedProxy reports whether remoteAddr ("ip" or "ip:port") is one of
+// Config.TrustedProxies, whose forwarded headers are honored
+func IsTrustedProxy(config *Config, remoteAddr string) bool {
+	if config == nil || len(config.TrustedProxies) == 0 {
+		return false
+	}
+	nets, ok := trustedProxyNets.Load(config)
+	if !ok {
+		parsed, err := ParseCIDRs(config.TrustedProxies)
+		if err != nil {
+			panic("simplehttp: invalid trusted proxy: " + err.Error())
+		}
+		nets, _ = trustedProxyNets.LoadOrStore(config, parsed)
+	}
+	return IPInNets(net.ParseIP(StripPort(remoteAddr)), nets.([]*net.IPNet))
+}
+
+// ForwardedScheme returns the scheme the client used: the one forwarded by a
+// trusted proxy (Forwarded proto=, X-Forwarded-Proto, X-Forwarded-Ssl: on,
+// Front-End-Https: on), otherwise "https" when the connection is TLS. The
+// adapters implement Context.Scheme with it.
+func ForwardedScheme(config *Config, remoteAddr string, tls bool, header func(string) string) string {
+	if IsTrustedProxy(config, remoteAddr) {
+		proto := forwardedParam(header(HEADER_FORWARDED), "proto")
+		if proto == "" {
+			proto = firstValue(header(HEADER_X_FORWARDED_PROTO))
+		}
+		switch proto = strings.ToLower(proto); {
+		case proto == SCHEME_HTTP || proto == SCHEME_HTTPS:
+			return proto
+		case strings.EqualFold(header(HEADER_X_FORWARDED_SSL), "on"),
+			strings.EqualFold(header(HEADER_FRONT_END_HTTPS), "on"):
+			return SCHEME_HTTPS
+		}
+	}
+	if tls {
+		return SCHEME_HTTPS
+	}
+	return SCHEME_HTTP
+}
+
+// ForwardedHost returns the host the client asked for: the one forwarded by
+// a trusted proxy (Forwarded host=, X-Forwarded-Host), otherwise host, the
+// Host header of the request. Forwarded values that are not a valid host are
+// ignored.
+func ForwardedHost(config *Config, remoteAddr, host string, header func(string) string) string {
+	if IsTrustedProxy(config, remoteAddr) {
+		forwarded := forwardedParam(header(HEADER_FORWARDED), "host")
+		if forwarded == "" {
+			forwarded = firstValue(header(HEADER_X_FORWARDED_HOST))
+		}
+		if validHost(forwarded) {
+			return forwarded
+		}
+	}
+	return host
+}
+
+// forwardedParam returns the parameter of the first (client side) element
+// of a Forwarded header, e.g. proto from `for=1.2.3.4;proto=https;host=a.com`
+func forwardedParam(forwarded, name string) string {
+	first, _, _ := strings.Cut(forwarded, ",")
+	for _, pair := range strings.Split(first, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && strings.EqualFold(key, name) {
+			return strings.Trim(value, `"`)
+		}
+	}
+	return ""
+}
+
+// firstValue returns the first entry of a comma separated header, the one
+// added by the proxy closest to the client
+func firstValue(v string) string {
+	first, _, _ := strings.Cut(v, ",")
+	return strings.TrimSpace(first)
+}
+
+// validHost accepts a hostname, IPv4 or [IPv6] with an optional port, so a
+// forwarded header can't inject paths or credentials into built URLs
+func validHost(host string) bool {
+	if host == "" || len(host) > 255 {
+		return false
+	}
+	for i := 0; i < len(host); i++ {
+		switch ch := host[i]; {
+		case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9':
+		case ch == '.' || ch == '-' || ch == '_' || ch == ':' || ch == '[' || ch == ']':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -2,25 +2,74 @@
 package echo
 
 import (
+	"errors"
+	"io"
+	"net/http"
+
 	"github.com/labstack/echo/v5"
 	"github.com/medatechnology/simplehttp"
 )
 
 // Adapter converts SimpleHttp HandlerFunc to echo.HandlerFunc
-func Adapter(handler simplehttp.HandlerFunc) echo.HandlerFunc {
+func Adapter(handler simplehttp.HandlerFunc, cfgs ...*simplehttp.Config) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		return handler(NewEchoContext(c))
+		return handler(NewEchoContext(c, cfgs...))
 	}
 }
 
 // MiddlewareAdapter converts SimpleHttp Middleware to echo.MiddlewareFunc
-func MiddlewareAdapter(middleware simplehttp.MiddlewareFunc) echo.MiddlewareFunc {
+func MiddlewareAdapter(middleware simplehttp.MiddlewareFunc, cfgs ...*simplehttp.Config) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			medaNext := func(mc simplehttp.Context) error {
 				return next(c)
 			}
-			return middleware(medaNext)(NewEchoContext(c))
+			return middleware(medaNext)(NewEchoContext(c, cfgs...))
+		}
+	}
+}
+
+// errorHandler renders *simplehttp.SimpleHttpError like the other adapters,
+// anything else goes to echo's default handler
+func errorHandler(fallback echo.HTTPErrorHandler) echo.HTTPErrorHandler {
+	return func(c echo.Context, err error) {
+		var medaErr *simplehttp.SimpleHttpError
+		if errors.As(err, &medaErr) && !c.Response().Committed {
+			if c.Request().Method == http.MethodHead {
+				c.NoContent(medaErr.Code)
+				return
+			}
+			c.JSON(medaErr.Code, medaErr)
+			return
+		}
+		fallback(c, err)
+	}
+}
+
+// jsonSerializer plugs simplehttp.JSONCodec into echo, used by c.JSON and Bind
+type jsonSerializer struct {
+	codec simplehttp.JSONCodec
+}
+
+func (s *jsonSerializer) Serialize(c echo.Context, i interface{}, indent string) error {
+	if indent != "" {
+		b, err := simplehttp.MarshalJSONIndent(s.codec, i, indent)
+		if err != nil {
+			return err
 		}
+		_, err = c.Response().Write(append(b, '\n'))
+		return err
+	}
+	return s.codec.NewEncoder(c.Response()).Encode(i)
+}
+
+func (s *jsonSerializer) Deserialize(c echo.Context, i interface{}) error {
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return err
+	}
+	if err := s.codec.Unmarshal(body, i); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	return nil
 }
@@ -2,11 +2,19 @@
 package echo
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/tls"
 	"io"
 	"mime/multipart"
+	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v5"
@@ -31,6 +39,11 @@ func (c *EchoContext) GetPath() string {
 	return c.ctx.Path()
 }
 
+// Route is the pattern of the matched route, see simplehttp.RouteOf
+func (c *EchoContext) Route() string {
+	return c.ctx.Path()
+}
+
 func (c *EchoContext) GetMethod() string {
 	return c.ctx.Request().Method
 }
@@ -42,9 +55,54 @@ func (c *EchoContext) GetHeader(key string) string {
 func (c *EchoContext) GetHeaders() *simplehttp.RequestHeader {
 	headers := &simplehttp.RequestHeader{}
 	headers.FromHttpRequest(c.ctx.Request())
+	headers.SetIPExtractor(simplehttp.GetIPExtractor(c.config))
 	return headers
 }
 
+// Connection accessors, see simplehttp.ForwardedScheme and ForwardedHost
+func (c *EchoContext) Scheme() string {
+	r := c.ctx.Request()
+	return simplehttp.ForwardedScheme(c.config, r.RemoteAddr, r.TLS != nil, r.Header.Get)
+}
+
+func (c *EchoContext) Host() string {
+	r := c.ctx.Request()
+	return simplehttp.ForwardedHost(c.config, r.RemoteAddr, r.Host, r.Header.Get)
+}
+
+func (c *EchoContext) IsTLS() bool {
+	return c.Scheme() == simplehttp.SCHEME_HTTPS
+}
+
+func (c *EchoContext) Protocol() string {
+	return c.ctx.Request().Proto
+}
+
+func (c *EchoContext) LocalAddr() net.Addr {
+	addr, _ := c.ctx.Request().Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return addr
+}
+
+func (c *EchoContext) RemoteAddr() net.Addr {
+	addr, err := netip.ParseAddrPort(c.ctx.Request().RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	return net.TCPAddrFromAddrPort(addr)
+}
+
+func (c *EchoContext) TLSConnectionState() *tls.ConnectionState {
+	return c.ctx.Request().TLS
+}
+
+func (c *EchoContext) FullURL() string {
+	return simplehttp.AbsoluteURL(c, c.ctx.Request().URL.RequestURI())
+}
+
+func (c *EchoContext) BuildURL(path string, params map[string]string, query url.Values) string {
+	return simplehttp.BuildURL(c, path, params, query)
+}
+
 func (c *EchoContext) SetRequestHeader(key, value string) {
 	c.ctx.Request().Header.Set(key, value)
 }
@@ -58,6 +116,25 @@ func (c *EchoContext) SetHeader(key, value string) {
 	c.ctx.Response().Header().Set(key, value)
 }
 
+func (c *EchoContext) Cookie(name string) (*http.Cookie, error) {
+	return c.ctx.Cookie(name)
+}
+
+func (c *EchoContext) SetCookie(cookie *http.Cookie) {
+	c.ctx.SetCookie(cookie)
+}
+
+func (c *EchoContext) GetParam(name string) string {
+	value := c.ctx.PathParam(name)
+	// echo matches the escaped path when it has one, e.g. with %2F
+	if c.ctx.Request().URL.RawPath != "" {
+		if unescaped, err := url.PathUnescape(value); err == nil {
+			return unescaped
+		}
+	}
+	return value
+}
+
 func (c *EchoContext) GetQueryParam(key string) string {
 	return c.ctx.QueryParam(key)
 }
@@ -71,6 +148,13 @@ func (c *EchoContext) GetBody() []byte {
 	return body
 }
 
+func (c *EchoContext) SetRequestBody(body []byte) {
+	req := c.ctx.Request()
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+}
+
 func (c *EchoContext) Request() *http.Request {
 	return c.ctx.Request()
 }
@@ -80,9 +164,38 @@ func (c *EchoContext) Response() http.ResponseWriter {
 }
 
 func (c *EchoContext) JSON(code int, data interface{}) error {
+	if indent := simplehttp.DebugJSONIndent(c.config); indent != "" {
+		return c.ctx.JSONPretty(code, data, indent)
+	}
 	return c.ctx.JSON(code, data)
 }
 
+func (c *EchoContext) JSONBlob(code int, data []byte) error {
+	return c.ctx.JSONBlob(code, data)
+}
+
+func (c *EchoContext) RawJSON(code int, data string) error {
+	return c.ctx.JSONBlob(code, []byte(data))
+}
+
+func (c *EchoContext) PrettyJSON(code int, data interface{}) error {
+	return c.ctx.JSONPretty(code, data, simplehttp.DEFAULT_JSON_INDENT)
+}
+
+// JSONPretty goes through the serializer of the server, which indents the
+// output of the configured codec
+func (c *EchoContext) JSONPretty(code int, data interface{}, indent string) error {
+	return c.ctx.JSONPretty(code, data, indent)
+}
+
+func (c *EchoContext) Encode(code int, contentType string, data interface{}) error {
+	b, err := simplehttp.MarshalCodec(contentType, data)
+	if err != nil {
+		return err
+	}
+	return c.ctx.Blob(code, contentType, b)
+}
+
 func (c *EchoContext) String(code int, data string) error {
 	return c.ctx.String(code, data)
 }
@@ -91,6 +204,305 @@ func (c *EchoContext) Stream(code int, contentType string, reader io.Reader) err
 	return c.ctx.Stream(code, contentType, reader)
 }
 
+func (c *EchoContext) Blob(code int, contentType string, data []byte) error {
+	return c.ctx.Blob(code, contentType, data)
+}
+
+func (c *EchoContext) Attachment(reader io.Reader, filename string) error {
+	return c.sendDisposition("attachment", reader, filename)
+}
+
+func (c *EchoContext) Inline(reader io.Reader, filename string) error {
+	return c.sendDisposition("inline", reader, filename)
+}
+
+func (c *EchoContext) Accepted(jobID string) error {
+	return simplehttp.Accepted(c, jobID)
+}
+
+func (c *EchoContext) sendDisposition(dispositionType string, reader io.Reader, filename string) error {
+	c.ctx.Response().Header().Set(simplehttp.HEADER_CONTENT_DISPOSITION, simplehttp.ContentDisposition(dispositionType, filename))
+	return c.ctx.Stream(http.StatusOK, simplehttp.ContentTypeByFilename(filename), reader)
+}
+
+// deferredLimit is the body held back by deferredWriter before it commits
+const deferredLimit = 64 << 10
+
+// deferredWriter holds the status and the start of the body until the
+// handlers return, so headers set after next still apply as on fiber and
+// fasthttp. It commits early on Flush, Hijack and once the body passes
+// deferredLimit, streams can't wait.
+type deferredWriter struct {
+	original  http.ResponseWriter
+	status    int
+	body      bytes.Buffer
+	committed bool
+}
+
+func (w *deferredWriter) Header() http.Header {
+	return w.original.Header()
+}
+
+func (w *deferredWriter) WriteHeader(code int) {
+	if w.committed {
+		w.original.WriteHeader(code)
+		return
+	}
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *deferredWriter) Write(b []byte) (int, error) {
+	if w.committed {
+		return w.original.Write(b)
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.body.Len()+len(b) <= deferredLimit {
+		return w.body.Write(b)
+	}
+	if err := w.commit(); err != nil {
+		return 0, err
+	}
+	return w.original.Write(b)
+}
+
+// commit sends the status and the body held back, once
+func (w *deferredWriter) commit() error {
+	if w.committed {
+		return nil
+	}
+	w.committed = true
+	if w.status == 0 {
+		return nil
+	}
+	w.original.WriteHeader(w.status)
+	if w.body.Len() == 0 {
+		return nil
+	}
+	_, err := w.original.Write(w.body.Bytes())
+	w.body.Reset()
+	return err
+}
+
+func (w *deferredWriter) Flush() {
+	w.commit()
+	if flusher, ok := w.original.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *deferredWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.original.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	w.committed = true
+	return hijacker.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the connection
+func (w *deferredWriter) Unwrap() http.ResponseWriter {
+	return w.original
+}
+
+// deferResponse commits the response once the handlers and the error
+// handler are done, see deferredWriter
+func deferResponse(e *echo.Echo) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			resp := c.Response()
+			w := &deferredWriter{original: resp.Writer}
+			resp.Writer = w
+			defer func() {
+				resp.Writer = w.original
+				w.commit()
+			}()
+			if err := next(c); err != nil {
+				e.HTTPErrorHandler(c, err)
+			}
+			return nil
+		}
+	}
+}
+
+// Response buffering. The state lives in the echo context because an
+// EchoContext wrapper is created for every middleware.
+const responseBufferKey = "simplehttp.response_buffer"
+
+type bufferedWriter struct {
+	original http.ResponseWriter
+	header   http.Header // headers when buffering started, restored by ResetResponse
+	status   int
+	body     bytes.Buffer
+	depth    int  // nested BufferResponse calls, only the outermost flushes
+	streamed bool // Flush or Hijack, the rest goes straight to original
+}
+
+func (w *bufferedWriter) Header() http.Header {
+	return w.original.Header()
+}
+
+func (w *bufferedWriter) WriteHeader(code int) {
+	if w.streamed {
+		w.original.WriteHeader(code)
+		return
+	}
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	if w.streamed {
+		return w.original.Write(b)
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+// unbuffer sends what is held back and stops buffering, the middleware
+// can't rewrite a streamed response anymore
+func (w *bufferedWriter) unbuffer() error {
+	if w.streamed {
+		return nil
+	}
+	w.streamed = true
+	if w.status == 0 {
+		return nil
+	}
+	w.original.WriteHeader(w.status)
+	if w.body.Len() == 0 {
+		return nil
+	}
+	_, err := w.original.Write(w.body.Bytes())
+	w.body.Reset()
+	return err
+}
+
+func (w *bufferedWriter) Flush() {
+	w.unbuffer()
+	if flusher, ok := w.original.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *bufferedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.original.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	w.streamed = true
+	return hijacker.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the connection
+func (w *bufferedWriter) Unwrap() http.ResponseWriter {
+	return w.original
+}
+
+func (c *EchoContext) buffer() *bufferedWriter {
+	bw, _ := c.ctx.Get(responseBufferKey).(*bufferedWriter)
+	return bw
+}
+
+// BufferResponse doesn't buffer upgrade requests, and stops buffering on
+// Flush and Hijack, so websockets and SSE work under buffering middleware
+func (c *EchoContext) BufferResponse() {
+	if bw := c.buffer(); bw != nil {
+		bw.depth++
+		return
+	}
+	if simplehttp.IsUpgradeRequest(c) {
+		return
+	}
+	resp := c.ctx.Response()
+	bw := &bufferedWriter{original: resp.Writer, header: resp.Header().Clone(), depth: 1}
+	resp.Writer = bw
+	c.ctx.Set(responseBufferKey, bw)
+}
+
+func (c *EchoContext) FlushResponse() error {
+	bw := c.buffer()
+	if bw == nil {
+		return nil
+	}
+	bw.depth--
+	if bw.depth > 0 {
+		return nil
+	}
+	c.ctx.Response().Writer = bw.original
+	c.ctx.Set(responseBufferKey, nil)
+
+	// already sent, or nothing written and left to the error handler
+	if bw.streamed || bw.status == 0 && bw.body.Len() == 0 {
+		return nil
+	}
+	if bw.status == 0 {
+		bw.status = http.StatusOK
+	}
+	bw.original.WriteHeader(bw.status)
+	_, err := bw.original.Write(bw.body.Bytes())
+	c.ctx.Response().Size = int64(bw.body.Len()) // a middleware may have rewritten the body
+	return err
+}
+
+func (c *EchoContext) ResetResponse() {
+	bw := c.buffer()
+	if bw == nil || bw.streamed {
+		return
+	}
+	bw.status = 0
+	bw.body.Reset()
+	header := bw.original.Header()
+	for key := range header {
+		delete(header, key)
+	}
+	for key, values := range bw.header {
+		header[key] = values
+	}
+	resp := c.ctx.Response()
+	resp.Status = http.StatusOK
+	resp.Size = 0
+	resp.Committed = false
+}
+
+func (c *EchoContext) GetResponseStatus() int {
+	return c.ctx.Response().Status
+}
+
+func (c *EchoContext) GetResponseHeader(key string) string {
+	return c.ctx.Response().Header().Get(key)
+}
+
+func (c *EchoContext) GetResponseBody() []byte {
+	if bw := c.buffer(); bw != nil {
+		return bw.body.Bytes()
+	}
+	return nil
+}
+
+func (c *EchoContext) GetResponseSize() int64 {
+	if bw := c.buffer(); bw != nil {
+		return int64(bw.body.Len())
+	}
+	return c.ctx.Response().Size
+}
+
+func (c *EchoContext) SetResponseBody(body []byte) {
+	bw := c.buffer()
+	if bw == nil {
+		return
+	}
+	bw.body.Reset()
+	bw.body.Write(body)
+	c.ctx.Response().Header().Del("Content-Length")
+}
+
 func (c *EchoContext) GetFile(fieldName string) (*multipart.FileHeader, error) {
 	return c.ctx.FormFile(fieldName)
 }
@@ -112,11 +524,8 @@ func (c *EchoContext) SaveFile(file *multipart.FileHeader, dst string) error {
 	return err
 }
 
-func (c *EchoContext) SendFile(filepath string, attachment bool) error {
-	if attachment {
-		return c.ctx.Attachment(filepath, filepath)
-	}
-	return c.ctx.File(filepath)
+func (c *EchoContext) SendFile(file string, attachment bool) error {
+	return simplehttp.ServeFile(c, file, simplehttp.ServeFileOptions{Attachment: attachment})
 }
 
 func (c *EchoContext) Upgrade() (simplehttp.Websocket, error) {
@@ -163,6 +572,20 @@ func (c *EchoContext) SetContext(ctx context.Context) {
 	c.ctx.SetRequest(c.ctx.Request().WithContext(ctx))
 }
 
+func (c *EchoContext) SetCacheTTL(ttl time.Duration) {
+	simplehttp.CacheDirectiveOf(c).TTL = ttl
+}
+
+func (c *EchoContext) NoStore() {
+	simplehttp.CacheDirectiveOf(c).NoStore = true
+}
+
+// Deadline of the request context, pass c.Context() (or client.FromContext(c))
+// to outbound calls so they never outlive this request
+func (c *EchoContext) Deadline() (time.Time, bool) {
+	return c.ctx.Request().Context().Deadline()
+}
+
 func (c *EchoContext) Set(key string, value interface{}) {
 	c.ctx.Set(key, value)
 }
@@ -183,6 +606,22 @@ func (c *EchoContext) BindForm(i interface{}) error {
 	return c.ctx.Bind(i)
 }
 
+func (c *EchoContext) BindCodec(i interface{}) error {
+	return simplehttp.UnmarshalCodec(c.ctx.Request().Header.Get("Content-Type"), c.GetBody(), i)
+}
+
+func (c *EchoContext) BindAndValidate(i interface{}) error {
+	if err := c.ctx.Bind(i); err != nil {
+		return simplehttp.NewError(http.StatusBadRequest, "invalid request payload", err.Error())
+	}
+	return simplehttp.ValidateStruct(c.config, i)
+}
+
+// Clock returns the Clock of the server config, see simplehttp.ClockContext
+func (c *EchoContext) Clock() simplehttp.Clock {
+	return simplehttp.ClockOf(c.config)
+}
+
 // EchoWebSocket implements MedaWebsocket interface using gorilla
 type EchoWebSocket struct {
 	conn *websocket.Conn
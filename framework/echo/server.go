@@ -3,7 +3,12 @@ package echo
 
 import (
 	"context"
-	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
 
 	"github.com/labstack/echo/v5"
 	"github.com/labstack/echo/v5/middleware"
@@ -15,10 +20,18 @@ type EchoServer struct {
 	config *simplehttp.Config
 	// router *EchoGroup
 	middleware []simplehttp.Middleware
-	// mu         sync.RWMutex
+	// used by the groups, for the startup diagnostics
+	groupMiddleware []simplehttp.Middleware
+	static          []simplehttp.StaticDir
+	redirects       []*simplehttp.HTTPRedirector
+	drain           *simplehttp.Drain
+	server          *http.Server // set once Start listens
+	mu              sync.Mutex
 }
 
-func NewServer(config *simplehttp.Config) simplehttp.Server {
+func NewServer(config *simplehttp.Config, options ...simplehttp.ServerOption) simplehttp.Server {
+	opts := simplehttp.ApplyServerOptions(config, options...)
+	config = opts.Config
 	e := echo.New()
 
 	// Basic middleware setup
@@ -27,45 +40,73 @@ func NewServer(config *simplehttp.Config) simplehttp.Server {
 		e.Use(middleware.Logger())
 	}
 
+	e.HTTPErrorHandler = errorHandler(e.HTTPErrorHandler)
+	// headers set after next apply as on the other adapters
+	e.Pre(deferResponse(e))
+
+	// once Shutdown started draining every request gets 503, see ShutdownConfig
+	drain := simplehttp.NewDrain(config)
+	e.Pre(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !drain.Draining() {
+				return next(c)
+			}
+			for key, value := range drain.Headers() {
+				c.Response().Header().Set(key, value)
+			}
+			return c.JSONBlob(http.StatusServiceUnavailable, simplehttp.DrainResponse())
+		}
+	})
+
 	// Set max request size
 	e.IPExtractor = echo.ExtractIPFromXFFHeader()
-	e.JSONSerializer = echo.DefaultJSONSerializer{}
+	if simplehttp.HasCustomJSON(config) {
+		e.JSONSerializer = &jsonSerializer{codec: simplehttp.GetJSONCodec(config)}
+	} else {
+		e.JSONSerializer = echo.DefaultJSONSerializer{}
+	}
 
-	return &EchoServer{
+	s := &EchoServer{
 		e:      e,
 		config: config,
+		drain:  drain,
 	}
+	opts.Setup(s)
+	return s
 }
 
-func (s *EchoServer) GET(path string, handler simplehttp.HandlerFunc) {
-	s.e.GET(path, Adapter(handler))
+func (s *EchoServer) GET(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	s.e.GET(path, Adapter(simplehttp.Chain(handler, middleware...), s.config))
 }
 
-func (s *EchoServer) POST(path string, handler simplehttp.HandlerFunc) {
-	s.e.POST(path, Adapter(handler))
+func (s *EchoServer) POST(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	s.e.POST(path, Adapter(simplehttp.Chain(handler, middleware...), s.config))
 }
 
-func (s *EchoServer) PUT(path string, handler simplehttp.HandlerFunc) {
-	s.e.PUT(path, Adapter(handler))
+func (s *EchoServer) PUT(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	s.e.PUT(path, Adapter(simplehttp.Chain(handler, middleware...), s.config))
 }
 
-func (s *EchoServer) DELETE(path string, handler simplehttp.HandlerFunc) {
-	s.e.DELETE(path, Adapter(handler))
+func (s *EchoServer) DELETE(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	s.e.DELETE(path, Adapter(simplehttp.Chain(handler, middleware...), s.config))
 }
 
-func (s *EchoServer) PATCH(path string, handler simplehttp.HandlerFunc) {
-	s.e.PATCH(path, Adapter(handler))
+func (s *EchoServer) PATCH(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	s.e.PATCH(path, Adapter(simplehttp.Chain(handler, middleware...), s.config))
 }
 
-func (s *EchoServer) OPTIONS(path string, handler simplehttp.HandlerFunc) {
-	s.e.OPTIONS(path, Adapter(handler))
+func (s *EchoServer) OPTIONS(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	s.e.OPTIONS(path, Adapter(simplehttp.Chain(handler, middleware...), s.config))
 }
 
-func (s *EchoServer) HEAD(path string, handler simplehttp.HandlerFunc) {
-	s.e.HEAD(path, Adapter(handler))
+func (s *EchoServer) HEAD(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	s.e.HEAD(path, Adapter(simplehttp.Chain(handler, middleware...), s.config))
 }
 
 func (s *EchoServer) Static(prefix, root string) {
+	s.mu.Lock()
+	s.static = append(s.static, simplehttp.StaticDir{Prefix: prefix, Root: root})
+	s.mu.Unlock()
 	s.e.Static(prefix, root)
 }
 
@@ -74,6 +115,7 @@ func (s *EchoServer) StaticFile(path, filepath string) {
 }
 
 func (s *EchoServer) WebSocket(path string, handler func(simplehttp.Websocket) error) {
+	handler = simplehttp.DefaultWebSocketStats.Track(path, handler)
 	s.e.GET(path, func(c echo.Context) error {
 		echoCtx := NewEchoContext(c, s.config)
 		ws, err := echoCtx.Upgrade()
@@ -86,57 +128,148 @@ func (s *EchoServer) WebSocket(path string, handler func(simplehttp.Websocket) e
 
 func (s *EchoServer) Group(prefix string) simplehttp.Router {
 	group := s.e.Group(prefix)
-	return &EchoGroup{group: group, config: s.config}
+	return &EchoGroup{group: group, prefix: prefix, config: s.config, server: s}
 }
 
 func (s *EchoServer) Use(middleware ...simplehttp.Middleware) {
+	s.middleware = append(s.middleware, middleware...)
 	for _, m := range middleware {
-		s.e.Use(MiddlewareAdapter(m.Handle))
+		s.e.Use(MiddlewareAdapter(m.Handle, s.config))
 	}
 }
 
+// Routes lists the registered routes, sorted by path and method
+func (s *EchoServer) Routes() []simplehttp.RouteInfo {
+	var routes []simplehttp.RouteInfo
+	for _, route := range s.e.Router().Routes() {
+		routes = append(routes, simplehttp.RouteInfo{Method: route.Method(), Path: route.Path()})
+	}
+	simplehttp.SortRoutes(routes)
+	return routes
+}
+
+func (s *EchoServer) MiddlewareNames() []string {
+	names := make([]string, 0, len(s.middleware))
+	for _, m := range s.middleware {
+		names = append(names, m.Name())
+	}
+	return names
+}
+
 func (s *EchoServer) Start(address string) error {
-	return s.e.Start(fmt.Sprintf(":%s", s.config.Port))
+	if address == "" {
+		address = ":" + s.config.Port
+	} else if !strings.Contains(address, ":") {
+		// address passed is the port number
+		address = ":" + address
+	}
+	if err := simplehttp.CheckStartup(s.startupInfo(address)); err != nil {
+		return err
+	}
+	simplehttp.StartHTTPRedirectors(s.redirects, address, s.config.Logger)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	sc := echo.StartConfig{
+		Address:         address,
+		GracefulContext: ctx,
+		// kept for Shutdown, echo only shuts down on the graceful context
+		BeforeServeFunc: func(server *http.Server) error {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			// Shutdown came first, e.g. a Runner stopping after another
+			// server failed
+			if s.drain.Draining() {
+				return http.ErrServerClosed
+			}
+			s.server = server
+			return nil
+		},
+	}
+	if s.config.TLSCert != "" && s.config.TLSKey != "" {
+		// read here because echo resolves paths relative to the working
+		// directory only
+		cert, err := os.ReadFile(s.config.TLSCert)
+		if err != nil {
+			return err
+		}
+		key, err := os.ReadFile(s.config.TLSKey)
+		if err != nil {
+			return err
+		}
+		return sc.StartTLS(s.e, cert, key)
+	}
+	return sc.Start(s.e)
+}
+
+// startupInfo is checked by simplehttp.CheckStartup at Start, echo
+// recovers panics itself
+func (s *EchoServer) startupInfo(address string) simplehttp.StartupInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return simplehttp.StartupInfo{
+		Config:     s.config,
+		Address:    address,
+		Middleware: append(append([]simplehttp.Middleware{}, s.middleware...), s.groupMiddleware...),
+		Static:     s.static,
+		Recovers:   true,
+	}
 }
 
-// Shutdown is a no-op in Echo v5 as it's handled internally
+// Shutdown drains, stops the HTTP redirectors and gracefully shuts the
+// server down, Start returns http.ErrServerClosed
 func (s *EchoServer) Shutdown(ctx context.Context) error {
-	// Echo v5 handles graceful shutdown internally
-	return nil
+	s.drain.Start(ctx)
+	err := simplehttp.ShutdownHTTPRedirectors(ctx, s.redirects)
+	s.mu.Lock()
+	server := s.server
+	s.mu.Unlock()
+	if server != nil {
+		if serr := server.Shutdown(ctx); serr != nil {
+			return serr
+		}
+	}
+	return err
+}
+
+func (s *EchoServer) RedirectHTTP(address string) {
+	s.redirects = append(s.redirects, simplehttp.NewHTTPRedirector(address))
 }
 
 // EchoGroup implements MedaRouter interface for route groups
 type EchoGroup struct {
 	group  *echo.Group
+	prefix string
 	config *simplehttp.Config
+	server *EchoServer
 }
 
-func (g *EchoGroup) GET(path string, handler simplehttp.HandlerFunc) {
-	g.group.GET(path, Adapter(handler))
+func (g *EchoGroup) GET(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	g.group.GET(path, Adapter(simplehttp.Chain(handler, middleware...), g.config))
 }
 
-func (g *EchoGroup) POST(path string, handler simplehttp.HandlerFunc) {
-	g.group.POST(path, Adapter(handler))
+func (g *EchoGroup) POST(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	g.group.POST(path, Adapter(simplehttp.Chain(handler, middleware...), g.config))
 }
 
-func (g *EchoGroup) PUT(path string, handler simplehttp.HandlerFunc) {
-	g.group.PUT(path, Adapter(handler))
+func (g *EchoGroup) PUT(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	g.group.PUT(path, Adapter(simplehttp.Chain(handler, middleware...), g.config))
 }
 
-func (g *EchoGroup) DELETE(path string, handler simplehttp.HandlerFunc) {
-	g.group.DELETE(path, Adapter(handler))
+func (g *EchoGroup) DELETE(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	g.group.DELETE(path, Adapter(simplehttp.Chain(handler, middleware...), g.config))
 }
 
-func (g *EchoGroup) PATCH(path string, handler simplehttp.HandlerFunc) {
-	g.group.PATCH(path, Adapter(handler))
+func (g *EchoGroup) PATCH(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	g.group.PATCH(path, Adapter(simplehttp.Chain(handler, middleware...), g.config))
 }
 
-func (g *EchoGroup) OPTIONS(path string, handler simplehttp.HandlerFunc) {
-	g.group.OPTIONS(path, Adapter(handler))
+func (g *EchoGroup) OPTIONS(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	g.group.OPTIONS(path, Adapter(simplehttp.Chain(handler, middleware...), g.config))
 }
 
-func (g *EchoGroup) HEAD(path string, handler simplehttp.HandlerFunc) {
-	g.group.HEAD(path, Adapter(handler))
+func (g *EchoGroup) HEAD(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	g.group.HEAD(path, Adapter(simplehttp.Chain(handler, middleware...), g.config))
 }
 
 func (g *EchoGroup) Static(prefix, root string) {
@@ -148,6 +281,7 @@ func (g *EchoGroup) StaticFile(path, filepath string) {
 }
 
 func (g *EchoGroup) WebSocket(path string, handler func(simplehttp.Websocket) error) {
+	handler = simplehttp.DefaultWebSocketStats.Track(g.prefix+path, handler)
 	g.group.GET(path, func(c echo.Context) error {
 		medaCtx := NewEchoContext(c, g.config)
 		ws, err := medaCtx.Upgrade()
@@ -160,11 +294,22 @@ func (g *EchoGroup) WebSocket(path string, handler func(simplehttp.Websocket) er
 
 func (g *EchoGroup) Group(prefix string) simplehttp.Router {
 	subgroup := g.group.Group(prefix)
-	return &EchoGroup{group: subgroup, config: g.config}
+	return &EchoGroup{group: subgroup, prefix: g.prefix + prefix, config: g.config, server: g.server}
 }
 
 func (g *EchoGroup) Use(middleware ...simplehttp.Middleware) {
+	g.server.mu.Lock()
+	g.server.groupMiddleware = append(g.server.groupMiddleware, middleware...)
+	g.server.mu.Unlock()
 	for _, m := range middleware {
-		g.group.Use(MiddlewareAdapter(m.Handle))
+		g.group.Use(MiddlewareAdapter(m.Handle, g.config))
 	}
 }
+
+// Dispatch runs req through the routes and middleware in memory, e.g. for
+// the sub requests of simplehttp.BatchHandler
+func (s *EchoServer) Dispatch(req *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	s.e.ServeHTTP(rec, req)
+	return rec.Result(), nil
+}
@@ -18,10 +18,12 @@ const (
 )
 
 // Adapter converts SimpleHttp HandlerFunc to fasthttp.RequestHandler
-func Adapter(handler simplehttp.HandlerFunc) fasthttp.RequestHandler {
+func Adapter(handler simplehttp.HandlerFunc, cfgs ...*simplehttp.Config) fasthttp.RequestHandler {
 	return func(ctx *fasthttp.RequestCtx) {
-		c := NewContext(ctx)
-		if err := handler(c); err != nil {
+		c := NewContext(ctx, cfgs...)
+		err := handler(c)
+		c.syncResponseHeader()
+		if err != nil {
 			handleError(c, err)
 		}
 		// return handler(NewContext(ctx))
@@ -29,13 +31,13 @@ func Adapter(handler simplehttp.HandlerFunc) fasthttp.RequestHandler {
 }
 
 // MiddlewareAdapter converts SimpleHttp Middleware to fasthttp middleware
-func MiddlewareAdapter(middleware simplehttp.MiddlewareFunc) func(fasthttp.RequestHandler) fasthttp.RequestHandler {
+func MiddlewareAdapter(middleware simplehttp.MiddlewareFunc, cfgs ...*simplehttp.Config) func(fasthttp.RequestHandler) fasthttp.RequestHandler {
 	return func(next fasthttp.RequestHandler) fasthttp.RequestHandler {
 		return Adapter(middleware(func(c simplehttp.Context) error {
 			ctx := c.(*FHContext).ctx
 			next(ctx)
 			return nil
-		}))
+		}), cfgs...)
 	}
 }
 
@@ -4,16 +4,21 @@ package fasthttp
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"mime/multipart"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"reflect"
+	"slices"
+	"strconv"
+	"time"
 
-	"github.com/medatechnology/goutil/filesystem"
-	"github.com/medatechnology/goutil/object"
+	"github.com/fasthttp/router"
 	"github.com/medatechnology/simplehttp"
 	"github.com/valyala/fasthttp"
 	"github.com/valyala/fasthttp/fasthttpadaptor"
@@ -23,31 +28,49 @@ type FHContext struct {
 	ctx         *fasthttp.RequestCtx
 	userContext context.Context
 	store       map[string]interface{}
+	config      *simplehttp.Config
+	response    *responseWriter // created by the first Response() call
 }
 
-func NewContext(ctx *fasthttp.RequestCtx) *FHContext {
-	return &FHContext{
+func NewContext(ctx *fasthttp.RequestCtx, cfgs ...*simplehttp.Config) *FHContext {
+	c := &FHContext{
 		ctx:         ctx,
 		userContext: context.Background(),
 		store:       make(map[string]interface{}),
 	}
+	if dispatched, ok := ctx.UserValue(simplehttp.ContextKeyDispatch).(context.Context); ok {
+		c.userContext = dispatched
+	}
+	// optional config, same as echo NewEchoContext
+	if len(cfgs) > 0 && cfgs[0] != nil {
+		c.config = cfgs[0]
+	}
+	return c
 }
 
 func (c *FHContext) GetPath() string {
 	return string(c.ctx.Path())
 }
 
+// Route is the pattern of the matched route, see simplehttp.RouteOf, with
+// ":name" segments as registered
+func (c *FHContext) Route() string {
+	route, _ := c.ctx.UserValue(router.MatchedRoutePathParam).(string)
+	return patternPath(route)
+}
+
 func (c *FHContext) GetMethod() string {
 	return string(c.ctx.Method())
 }
 
 // check for both request and response header
 func (c *FHContext) GetHeader(key string) string {
-	// First check response headers (for headers set by middleware)
-	if val := c.ctx.Response.Header.Peek(key); len(val) > 0 {
+	if val := c.ctx.Request.Header.Peek(key); len(val) > 0 {
 		return string(val)
 	}
-	return string(c.ctx.Request.Header.Peek(key))
+	// Fall back to response headers (for headers set by middleware), checking
+	// them first would return the default response Content-Type for requests
+	return string(c.ctx.Response.Header.Peek(key))
 }
 
 func (c *FHContext) GetHeaders() *simplehttp.RequestHeader {
@@ -58,7 +81,8 @@ func (c *FHContext) GetHeaders() *simplehttp.RequestHeader {
 	// if c.Get(simplehttp.HEADER_PARSED_STRING) == nil {
 	// Convert fasthttp request to http.Request for header parsing
 	r := &http.Request{
-		Header: make(http.Header),
+		Header:     make(http.Header),
+		RemoteAddr: c.ctx.RemoteAddr().String(),
 	}
 
 	c.ctx.Request.Header.VisitAll(func(key, value []byte) {
@@ -78,23 +102,83 @@ func (c *FHContext) GetHeaders() *simplehttp.RequestHeader {
 	// 	}
 	// }
 	headers.FromHttpRequest(r)
+	headers.SetIPExtractor(simplehttp.GetIPExtractor(c.config))
 	// } else {
 	// 	headers = c.Get(simplehttp.HEADER_PARSED_STRING).(simplehttp.RequestHeader)
 	// }
 	return &headers
 }
 
+// Connection accessors, see simplehttp.ForwardedScheme and ForwardedHost.
+// fasthttp only speaks HTTP/1.x.
+func (c *FHContext) Scheme() string {
+	return simplehttp.ForwardedScheme(c.config, c.ctx.RemoteAddr().String(), c.ctx.IsTLS(), c.GetHeader)
+}
+
+func (c *FHContext) Host() string {
+	return simplehttp.ForwardedHost(c.config, c.ctx.RemoteAddr().String(), string(c.ctx.Host()), c.GetHeader)
+}
+
+func (c *FHContext) IsTLS() bool {
+	return c.Scheme() == simplehttp.SCHEME_HTTPS
+}
+
+func (c *FHContext) Protocol() string {
+	return string(c.ctx.Request.Header.Protocol())
+}
+
+func (c *FHContext) LocalAddr() net.Addr {
+	return c.ctx.LocalAddr()
+}
+
+func (c *FHContext) RemoteAddr() net.Addr {
+	return c.ctx.RemoteAddr()
+}
+
+func (c *FHContext) TLSConnectionState() *tls.ConnectionState {
+	return c.ctx.TLSConnectionState()
+}
+
+func (c *FHContext) FullURL() string {
+	return simplehttp.AbsoluteURL(c, string(c.ctx.RequestURI()))
+}
+
+func (c *FHContext) BuildURL(path string, params map[string]string, query url.Values) string {
+	return simplehttp.BuildURL(c, path, params, query)
+}
+
 func (c *FHContext) SetRequestHeader(key, value string) {
 	c.ctx.Request.Header.Set(key, value)
 }
 
 func (c *FHContext) SetResponseHeader(key, value string) {
+	c.syncResponseHeader()
 	c.ctx.Response.Header.Set(key, value)
 }
 
 func (c *FHContext) SetHeader(key, value string) {
 	c.ctx.Request.Header.Set(key, value)
-	c.ctx.Response.Header.Set(key, value)
+	c.SetResponseHeader(key, value)
+}
+
+func (c *FHContext) Cookie(name string) (*http.Cookie, error) {
+	value := c.ctx.Request.Header.Cookie(name)
+	if len(value) == 0 {
+		return nil, http.ErrNoCookie
+	}
+	return &http.Cookie{Name: name, Value: string(value)}, nil
+}
+
+func (c *FHContext) SetCookie(cookie *http.Cookie) {
+	c.ctx.Response.Header.Add(fasthttp.HeaderSetCookie, cookie.String())
+}
+
+func (c *FHContext) GetParam(name string) string {
+	value, _ := c.ctx.UserValue(name).(string)
+	if unescaped, err := url.PathUnescape(value); err == nil {
+		return unescaped
+	}
+	return value
 }
 
 func (c *FHContext) GetQueryParam(key string) string {
@@ -114,6 +198,10 @@ func (c *FHContext) GetBody() []byte {
 	return c.ctx.Request.Body()
 }
 
+func (c *FHContext) SetRequestBody(body []byte) {
+	c.ctx.Request.SetBody(body)
+}
+
 func (c *FHContext) Request() *http.Request {
 	// Convert fasthttp request to http.Request
 	var r http.Request
@@ -139,13 +227,64 @@ func (c *FHContext) Response() http.ResponseWriter {
 	// 	ctx: ctx,
 	// }
 	// return w
-	return &responseWriter{ctx: c.ctx}
+	// the same writer every time, its header map is the one that syncs
+	if c.response == nil {
+		c.response = &responseWriter{ctx: c.ctx}
+	}
+	return c.response
+}
+
+// syncResponseHeader applies the pending c.Response().Header() changes
+func (c *FHContext) syncResponseHeader() {
+	if c.response != nil {
+		c.response.syncHeader()
+	}
 }
 
 func (c *FHContext) JSON(code int, data interface{}) error {
+	if indent := simplehttp.DebugJSONIndent(c.config); indent != "" {
+		return c.JSONPretty(code, data, indent)
+	}
 	c.ctx.Response.Header.SetContentType("application/json")
 	c.ctx.Response.SetStatusCode(code)
-	return json.NewEncoder(c.ctx).Encode(data)
+	return simplehttp.GetJSONCodec(c.config).NewEncoder(c.ctx).Encode(data)
+}
+
+func (c *FHContext) JSONBlob(code int, data []byte) error {
+	c.ctx.Response.Header.SetContentType(simplehttp.CONTENT_TYPE_JSON)
+	c.ctx.Response.SetStatusCode(code)
+	_, err := c.ctx.Write(data)
+	return err
+}
+
+func (c *FHContext) RawJSON(code int, data string) error {
+	c.ctx.Response.Header.SetContentType(simplehttp.CONTENT_TYPE_JSON)
+	c.ctx.Response.SetStatusCode(code)
+	_, err := c.ctx.WriteString(data)
+	return err
+}
+
+func (c *FHContext) PrettyJSON(code int, data interface{}) error {
+	return c.JSONPretty(code, data, simplehttp.DEFAULT_JSON_INDENT)
+}
+
+func (c *FHContext) JSONPretty(code int, data interface{}, indent string) error {
+	b, err := simplehttp.MarshalJSONIndent(simplehttp.GetJSONCodec(c.config), data, indent)
+	if err != nil {
+		return err
+	}
+	return c.JSONBlob(code, b)
+}
+
+func (c *FHContext) Encode(code int, contentType string, data interface{}) error {
+	b, err := simplehttp.MarshalCodec(contentType, data)
+	if err != nil {
+		return err
+	}
+	c.ctx.Response.Header.SetContentType(contentType)
+	c.ctx.Response.SetStatusCode(code)
+	_, err = c.ctx.Write(b)
+	return err
 }
 
 func (c *FHContext) String(code int, data string) error {
@@ -158,10 +297,105 @@ func (c *FHContext) String(code int, data string) error {
 func (c *FHContext) Stream(code int, contentType string, reader io.Reader) error {
 	c.ctx.Response.Header.SetContentType(contentType)
 	c.ctx.Response.SetStatusCode(code)
+	// a Content-Length set before (ServeFile) streams the body as it is
+	// sent, instead of reading it all in memory first
+	if size, err := strconv.Atoi(string(c.ctx.Response.Header.Peek(simplehttp.HEADER_CONTENT_LENGTH))); err == nil {
+		c.ctx.SetBodyStream(reader, size)
+		return nil
+	}
 	_, err := io.Copy(c.ctx, reader)
 	return err
 }
 
+func (c *FHContext) Blob(code int, contentType string, data []byte) error {
+	c.ctx.Response.Header.SetContentType(contentType)
+	c.ctx.Response.SetStatusCode(code)
+	_, err := c.ctx.Write(data)
+	return err
+}
+
+func (c *FHContext) Attachment(reader io.Reader, filename string) error {
+	return c.sendDisposition("attachment", reader, filename)
+}
+
+func (c *FHContext) Inline(reader io.Reader, filename string) error {
+	return c.sendDisposition("inline", reader, filename)
+}
+
+func (c *FHContext) Accepted(jobID string) error {
+	return simplehttp.Accepted(c, jobID)
+}
+
+func (c *FHContext) sendDisposition(dispositionType string, reader io.Reader, filename string) error {
+	c.ctx.Response.Header.Set(simplehttp.HEADER_CONTENT_DISPOSITION, simplehttp.ContentDisposition(dispositionType, filename))
+	return c.Stream(http.StatusOK, simplehttp.ContentTypeByFilename(filename), reader)
+}
+
+// Response buffering, fasthttp keeps the whole response in memory anyway.
+// BufferResponse only remembers the headers for ResetResponse.
+const responseSnapshotKey = "simplehttp.response_snapshot"
+
+type responseSnapshot struct {
+	header *fasthttp.ResponseHeader
+	depth  int
+}
+
+func (c *FHContext) BufferResponse() {
+	if snapshot, ok := c.Get(responseSnapshotKey).(*responseSnapshot); ok {
+		snapshot.depth++
+		return
+	}
+	c.syncResponseHeader()
+	snapshot := &responseSnapshot{header: &fasthttp.ResponseHeader{}, depth: 1}
+	c.ctx.Response.Header.CopyTo(snapshot.header)
+	c.Set(responseSnapshotKey, snapshot)
+}
+
+func (c *FHContext) FlushResponse() error {
+	if snapshot, ok := c.Get(responseSnapshotKey).(*responseSnapshot); ok {
+		if snapshot.depth--; snapshot.depth == 0 {
+			c.Set(responseSnapshotKey, nil)
+		}
+	}
+	return nil
+}
+
+func (c *FHContext) ResetResponse() {
+	snapshot, ok := c.Get(responseSnapshotKey).(*responseSnapshot)
+	if !ok {
+		return
+	}
+	// pending map changes are dropped with the rest, the map is reloaded
+	c.syncResponseHeader()
+	snapshot.header.CopyTo(&c.ctx.Response.Header)
+	c.ctx.Response.ResetBody()
+	c.syncResponseHeader()
+}
+
+func (c *FHContext) GetResponseStatus() int {
+	return c.ctx.Response.StatusCode()
+}
+
+func (c *FHContext) GetResponseHeader(key string) string {
+	c.syncResponseHeader()
+	return string(c.ctx.Response.Header.Peek(key))
+}
+
+func (c *FHContext) GetResponseBody() []byte {
+	return c.ctx.Response.Body()
+}
+
+func (c *FHContext) GetResponseSize() int64 {
+	if c.ctx.Response.IsBodyStream() {
+		return int64(max(c.ctx.Response.Header.ContentLength(), 0))
+	}
+	return int64(len(c.ctx.Response.Body()))
+}
+
+func (c *FHContext) SetResponseBody(body []byte) {
+	c.ctx.Response.SetBody(body)
+}
+
 func (c *FHContext) GetFile(fieldName string) (*multipart.FileHeader, error) {
 	form, err := c.ctx.MultipartForm()
 	if err != nil {
@@ -194,14 +428,7 @@ func (c *FHContext) SaveFile(file *multipart.FileHeader, dst string) error {
 }
 
 func (c *FHContext) SendFile(filepath string, attachment bool) error {
-	if attachment {
-		c.ctx.Response.Header.Set("Content-Disposition", "attachment; filename="+filesystem.FileName(filepath))
-	}
-	c.ctx.SendFile(filepath)
-	if c.ctx.Response.Header.StatusCode() != fasthttp.StatusOK {
-		return fmt.Errorf("error in SendFile %s", filepath)
-	}
-	return nil
+	return simplehttp.ServeFile(c, filepath, simplehttp.ServeFileOptions{Attachment: attachment})
 }
 
 func (c *FHContext) Upgrade() (simplehttp.Websocket, error) {
@@ -217,6 +444,20 @@ func (c *FHContext) SetContext(ctx context.Context) {
 	c.userContext = ctx
 }
 
+func (c *FHContext) SetCacheTTL(ttl time.Duration) {
+	simplehttp.CacheDirectiveOf(c).TTL = ttl
+}
+
+func (c *FHContext) NoStore() {
+	simplehttp.CacheDirectiveOf(c).NoStore = true
+}
+
+// Deadline of the request context, pass c.Context() (or client.FromContext(c))
+// to outbound calls so they never outlive this request
+func (c *FHContext) Deadline() (time.Time, bool) {
+	return c.userContext.Deadline()
+}
+
 func (c *FHContext) Set(key string, value interface{}) {
 	c.store[key] = value
 }
@@ -262,12 +503,8 @@ func (c *FHContext) Bind(v interface{}) error {
 		}
 	}
 
-	// Get concrete type and convert
-	result := object.MapToStruct[any](params)
-
-	// Set the result back
-	reflect.ValueOf(v).Elem().Set(reflect.ValueOf(result))
-	return nil
+	// Convert into the concrete type behind v
+	return simplehttp.BindMap(params, v)
 }
 
 // func (c *FHContext) BindJSON(v interface{}) error {
@@ -281,6 +518,11 @@ func (c *FHContext) BindJSON(v interface{}) error {
 		return fmt.Errorf("empty request body")
 	}
 
+	// custom codec from config takes over, otherwise keep UseNumber behaviour
+	if simplehttp.HasCustomJSON(c.config) {
+		return simplehttp.GetJSONCodec(c.config).Unmarshal(body, v)
+	}
+
 	decoder := json.NewDecoder(bytes.NewReader(body))
 	decoder.UseNumber() // For better number handling
 	return decoder.Decode(v)
@@ -297,28 +539,88 @@ func (c *FHContext) BindForm(v interface{}) error {
 		return err
 	}
 
-	result := object.MapToStruct[any](formData)
-	reflect.ValueOf(v).Elem().Set(reflect.ValueOf(result))
-	return nil
+	return simplehttp.BindMap(formData, v)
 }
 
-// responseWriter implements http.ResponseWriter for fasthttp
+func (c *FHContext) BindCodec(v interface{}) error {
+	return simplehttp.UnmarshalCodec(string(c.ctx.Request.Header.ContentType()), c.ctx.Request.Body(), v)
+}
+
+func (c *FHContext) BindAndValidate(v interface{}) error {
+	if err := c.Bind(v); err != nil {
+		return simplehttp.NewError(http.StatusBadRequest, "invalid request payload", err.Error())
+	}
+	return simplehttp.ValidateStruct(c.config, v)
+}
+
+// Clock returns the Clock of the server config, see simplehttp.ClockContext
+func (c *FHContext) Clock() simplehttp.Clock {
+	return simplehttp.ClockOf(c.config)
+}
+
+// responseWriter implements http.ResponseWriter for fasthttp. Header()
+// returns a map as in net/http, its changes are copied to the fasthttp
+// response on the next Header, Write or WriteHeader call, before the context
+// reads or resets response headers and when the handler returns, so
+// c.Response().Header().Set() persists like on echo.
 type responseWriter struct {
-	ctx *fasthttp.RequestCtx
+	ctx      *fasthttp.RequestCtx
+	header   http.Header
+	snapshot http.Header // response headers as of the last sync
 }
 
 func (w *responseWriter) Header() http.Header {
-	h := make(http.Header)
-	w.ctx.Response.Header.VisitAll(func(key, value []byte) {
-		h.Add(string(key), string(value))
-	})
-	return h
+	w.syncHeader()
+	return w.header
 }
 
 func (w *responseWriter) Write(b []byte) (int, error) {
+	w.syncHeader()
 	return w.ctx.Write(b)
 }
 
 func (w *responseWriter) WriteHeader(statusCode int) {
+	w.syncHeader()
 	w.ctx.Response.SetStatusCode(statusCode)
 }
+
+// syncHeader applies the keys changed in the map since the last sync, then
+// reloads the map. Only changed keys are applied, so headers set directly on
+// the response in between (SetResponseHeader, c.JSON's Content-Type) are
+// kept.
+func (w *responseWriter) syncHeader() {
+	syncResponseHeader(&w.ctx.Response.Header, &w.header, &w.snapshot)
+}
+
+// syncResponseHeader copies the keys of header that differ from snapshot to
+// h, then reloads both maps from h. The maps are created on the first call.
+func syncResponseHeader(h *fasthttp.ResponseHeader, header, snapshot *http.Header) {
+	if *header == nil {
+		*header, *snapshot = make(http.Header), make(http.Header)
+	}
+	// deletes first, fasthttp's Del moves the last header into the gap
+	// which would reorder values added before it
+	var changed []string
+	for key, values := range *header {
+		if !slices.Equal(values, (*snapshot)[key]) {
+			changed = append(changed, key)
+			h.Del(key)
+		}
+	}
+	for key := range *snapshot {
+		if _, ok := (*header)[key]; !ok {
+			h.Del(key)
+		}
+	}
+	for _, key := range changed {
+		for _, value := range (*header)[key] {
+			h.Add(key, value)
+		}
+	}
+	clear(*header)
+	clear(*snapshot)
+	h.VisitAll(func(key, value []byte) {
+		header.Add(string(key), string(value))
+		snapshot.Add(string(key), string(value))
+	})
+}
@@ -2,8 +2,12 @@
 package fasthttp
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"strings"
 	"sync"
 
@@ -18,19 +22,26 @@ type Server struct {
 	config     *simplehttp.Config
 	router     *router.Router
 	middleware []simplehttp.Middleware
-	mu         sync.RWMutex
+	// used by the groups, for the startup diagnostics
+	groupMiddleware []simplehttp.Middleware
+	static          []simplehttp.StaticDir
+	redirects       []*simplehttp.HTTPRedirector
+	drain           *simplehttp.Drain
+	mu              sync.RWMutex
 }
 
-func NewServer(config *simplehttp.Config) *Server {
+func NewServer(config *simplehttp.Config, options ...simplehttp.ServerOption) *Server {
 	r := router.New()
-	if config == nil {
-		config = simplehttp.DefaultConfig
-	}
+	r.SaveMatchedRoutePath = true // for FHContext.Route
+	opts := simplehttp.ApplyServerOptions(config, options...)
+	config = opts.Config
+	drain := simplehttp.NewDrain(config)
 	s := &Server{
 		config: config,
 		router: r,
+		drain:  drain,
 		server: &fasthttp.Server{
-			Handler:            r.Handler,
+			Handler:            drainHandler(drain, r.Handler),
 			ReadTimeout:        config.ConfigTimeOut.ReadTimeout,
 			WriteTimeout:       config.ConfigTimeOut.WriteTimeout,
 			IdleTimeout:        config.ConfigTimeOut.IdleTimeout,
@@ -38,9 +49,30 @@ func NewServer(config *simplehttp.Config) *Server {
 			Name:               "MedaHTTP/FastHTTP",
 		},
 	}
+	opts.Setup(s)
 	return s
 }
 
+// drainHandler answers 503 once Shutdown started draining, so requests on
+// kept-alive connections get a response instead of a dropped connection
+func drainHandler(drain *simplehttp.Drain, next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if !drain.Draining() {
+			next(ctx)
+			return
+		}
+		for key, value := range drain.Headers() {
+			ctx.Response.Header.Set(key, value)
+		}
+		if drain.CloseConnection() {
+			ctx.SetConnectionClose()
+		}
+		ctx.SetContentType("application/json")
+		ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+		ctx.SetBody(simplehttp.DrainResponse())
+	}
+}
+
 func (s *Server) applyMiddleware(handler simplehttp.HandlerFunc) simplehttp.HandlerFunc {
 	for i := len(s.middleware) - 1; i >= 0; i-- {
 		handler = s.middleware[i].Handle(handler)
@@ -48,35 +80,61 @@ func (s *Server) applyMiddleware(handler simplehttp.HandlerFunc) simplehttp.Hand
 	return handler
 }
 
-func (s *Server) GET(path string, handler simplehttp.HandlerFunc) {
-	s.router.GET(path, Adapter(s.applyMiddleware(handler)))
+func (s *Server) GET(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	s.router.GET(routePath(path), Adapter(s.applyMiddleware(simplehttp.Chain(handler, middleware...)), s.config))
+}
+
+func (s *Server) POST(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	s.router.POST(routePath(path), Adapter(s.applyMiddleware(simplehttp.Chain(handler, middleware...)), s.config))
 }
 
-func (s *Server) POST(path string, handler simplehttp.HandlerFunc) {
-	s.router.POST(path, Adapter(s.applyMiddleware(handler)))
+func (s *Server) PUT(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	s.router.PUT(routePath(path), Adapter(s.applyMiddleware(simplehttp.Chain(handler, middleware...)), s.config))
 }
 
-func (s *Server) PUT(path string, handler simplehttp.HandlerFunc) {
-	s.router.PUT(path, Adapter(s.applyMiddleware(handler)))
+func (s *Server) DELETE(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	s.router.DELETE(routePath(path), Adapter(s.applyMiddleware(simplehttp.Chain(handler, middleware...)), s.config))
 }
 
-func (s *Server) DELETE(path string, handler simplehttp.HandlerFunc) {
-	s.router.DELETE(path, Adapter(s.applyMiddleware(handler)))
+func (s *Server) PATCH(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	s.router.PATCH(routePath(path), Adapter(s.applyMiddleware(simplehttp.Chain(handler, middleware...)), s.config))
 }
 
-func (s *Server) PATCH(path string, handler simplehttp.HandlerFunc) {
-	s.router.PATCH(path, Adapter(s.applyMiddleware(handler)))
+func (s *Server) OPTIONS(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	s.router.OPTIONS(routePath(path), Adapter(s.applyMiddleware(simplehttp.Chain(handler, middleware...)), s.config))
 }
 
-func (s *Server) OPTIONS(path string, handler simplehttp.HandlerFunc) {
-	s.router.OPTIONS(path, Adapter(s.applyMiddleware(handler)))
+func (s *Server) HEAD(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	s.router.HEAD(routePath(path), Adapter(s.applyMiddleware(simplehttp.Chain(handler, middleware...)), s.config))
 }
 
-func (s *Server) HEAD(path string, handler simplehttp.HandlerFunc) {
-	s.router.HEAD(path, Adapter(s.applyMiddleware(handler)))
+// routePath converts ":name" segments, as used by fiber and echo, to the
+// "{name}" syntax of fasthttp/router so routes are portable across adapters
+func routePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if len(segment) > 1 && segment[0] == ':' {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// patternPath converts back the "{name}" segments of routePath
+func patternPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if name, ok := strings.CutPrefix(segment, "{"); ok && strings.HasSuffix(name, "}") {
+			segments[i] = ":" + strings.TrimSuffix(name, "}")
+		}
+	}
+	return strings.Join(segments, "/")
 }
 
 func (s *Server) Static(prefix, root string) {
+	s.mu.Lock()
+	s.static = append(s.static, simplehttp.StaticDir{Prefix: prefix, Root: root, Browse: true})
+	s.mu.Unlock()
 	fs := &fasthttp.FS{
 		Root:               root,
 		IndexNames:         []string{"index.html"},
@@ -128,6 +186,7 @@ func (w *wsConn) ReadJSON(v interface{}) error {
 }
 
 func (s *Server) WebSocket(path string, handler func(simplehttp.Websocket) error) {
+	handler = simplehttp.DefaultWebSocketStats.Track(path, handler)
 	s.router.GET(path, func(ctx *fasthttp.RequestCtx) {
 		err := upgrader.Upgrade(ctx, func(ws *websocket.Conn) {
 			wsWrapper := &wsConn{Conn: ws}
@@ -154,7 +213,33 @@ func (s *Server) Use(middleware ...simplehttp.Middleware) {
 	s.middleware = append(s.middleware, middleware...)
 }
 
+// Routes lists the registered routes, sorted by path and method
+func (s *Server) Routes() []simplehttp.RouteInfo {
+	var routes []simplehttp.RouteInfo
+	for method, paths := range s.router.List() {
+		for _, path := range paths {
+			routes = append(routes, simplehttp.RouteInfo{Method: method, Path: path})
+		}
+	}
+	simplehttp.SortRoutes(routes)
+	return routes
+}
+
+func (s *Server) MiddlewareNames() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.middleware))
+	for _, m := range s.middleware {
+		names = append(names, m.Name())
+	}
+	return names
+}
+
 func (s *Server) Start(address string) error {
+	// Shutdown came first, e.g. a Runner stopping after another server failed
+	if s.drain.Draining() {
+		return http.ErrServerClosed
+	}
 	if address == "" {
 		if s.config != nil {
 			address = s.config.Hostname + ":" + s.config.Port
@@ -168,6 +253,9 @@ func (s *Server) Start(address string) error {
 			address = ":" + address
 		}
 	}
+	if err := simplehttp.CheckStartup(s.startupInfo(address)); err != nil {
+		return err
+	}
 
 	// Get All Routes
 	var allroutes []string
@@ -209,6 +297,8 @@ func (s *Server) Start(address string) error {
 		fmt.Printf("Registered Middleware (%d)\n", len(s.middleware))
 		fmt.Printf("Registered routes/endpoints (%d)\n", totalroutes)
 	}
+	simplehttp.StartHTTPRedirectors(s.redirects, address, s.config.Logger)
+
 	// Apply TLS if configured
 	if s.config.TLSCert != "" && s.config.TLSKey != "" {
 		return s.server.ListenAndServeTLS(address, s.config.TLSCert, s.config.TLSKey)
@@ -221,42 +311,73 @@ func (s *Server) Start(address string) error {
 	return s.server.ListenAndServe(address)
 }
 
+// startupInfo is checked by simplehttp.CheckStartup at Start
+func (s *Server) startupInfo(address string) simplehttp.StartupInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return simplehttp.StartupInfo{
+		Config:     s.config,
+		Address:    address,
+		Middleware: append(append([]simplehttp.Middleware{}, s.middleware...), s.groupMiddleware...),
+		Static:     s.static,
+	}
+}
+
 func (s *Server) Shutdown(ctx context.Context) error {
-	return s.server.ShutdownWithContext(ctx)
+	s.drain.Start(ctx)
+	err := simplehttp.ShutdownHTTPRedirectors(ctx, s.redirects)
+	if serr := s.server.ShutdownWithContext(ctx); serr != nil {
+		return serr
+	}
+	return err
+}
+
+func (s *Server) RedirectHTTP(address string) {
+	s.redirects = append(s.redirects, simplehttp.NewHTTPRedirector(address))
 }
 
 // RouterGroup implements group routing
 type RouterGroup struct {
-	prefix string
-	server *Server
+	prefix     string
+	server     *Server
+	middleware []simplehttp.Middleware
 }
 
-func (g *RouterGroup) GET(path string, handler simplehttp.HandlerFunc) {
-	g.server.GET(g.prefix+path, handler)
+// applyMiddleware wraps the handler with the group middleware, the server
+// middleware is applied afterwards when registering on the server.
+func (g *RouterGroup) applyMiddleware(handler simplehttp.HandlerFunc) simplehttp.HandlerFunc {
+	for i := len(g.middleware) - 1; i >= 0; i-- {
+		handler = g.middleware[i].Handle(handler)
+	}
+	return handler
 }
 
-func (g *RouterGroup) POST(path string, handler simplehttp.HandlerFunc) {
-	g.server.POST(g.prefix+path, handler)
+func (g *RouterGroup) GET(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	g.server.GET(g.prefix+path, g.applyMiddleware(simplehttp.Chain(handler, middleware...)))
 }
 
-func (g *RouterGroup) PUT(path string, handler simplehttp.HandlerFunc) {
-	g.server.PUT(g.prefix+path, handler)
+func (g *RouterGroup) POST(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	g.server.POST(g.prefix+path, g.applyMiddleware(simplehttp.Chain(handler, middleware...)))
 }
 
-func (g *RouterGroup) DELETE(path string, handler simplehttp.HandlerFunc) {
-	g.server.DELETE(g.prefix+path, handler)
+func (g *RouterGroup) PUT(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	g.server.PUT(g.prefix+path, g.applyMiddleware(simplehttp.Chain(handler, middleware...)))
 }
 
-func (g *RouterGroup) PATCH(path string, handler simplehttp.HandlerFunc) {
-	g.server.PATCH(g.prefix+path, handler)
+func (g *RouterGroup) DELETE(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	g.server.DELETE(g.prefix+path, g.applyMiddleware(simplehttp.Chain(handler, middleware...)))
 }
 
-func (g *RouterGroup) OPTIONS(path string, handler simplehttp.HandlerFunc) {
-	g.server.OPTIONS(g.prefix+path, handler)
+func (g *RouterGroup) PATCH(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	g.server.PATCH(g.prefix+path, g.applyMiddleware(simplehttp.Chain(handler, middleware...)))
 }
 
-func (g *RouterGroup) HEAD(path string, handler simplehttp.HandlerFunc) {
-	g.server.HEAD(g.prefix+path, handler)
+func (g *RouterGroup) OPTIONS(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	g.server.OPTIONS(g.prefix+path, g.applyMiddleware(simplehttp.Chain(handler, middleware...)))
+}
+
+func (g *RouterGroup) HEAD(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	g.server.HEAD(g.prefix+path, g.applyMiddleware(simplehttp.Chain(handler, middleware...)))
 }
 
 func (g *RouterGroup) Static(prefix, root string) {
@@ -272,12 +393,71 @@ func (g *RouterGroup) WebSocket(path string, handler func(simplehttp.Websocket)
 }
 
 func (g *RouterGroup) Group(prefix string) simplehttp.Router {
+	// sub group inherits the middleware registered so far
 	return &RouterGroup{
-		prefix: g.prefix + prefix,
-		server: g.server,
+		prefix:     g.prefix + prefix,
+		server:     g.server,
+		middleware: append([]simplehttp.Middleware{}, g.middleware...),
 	}
 }
 
+// Use only applies to this group, not the whole server
 func (g *RouterGroup) Use(middleware ...simplehttp.Middleware) {
-	g.server.Use(middleware...)
+	g.middleware = append(g.middleware, middleware...)
+	g.server.mu.Lock()
+	g.server.groupMiddleware = append(g.server.groupMiddleware, middleware...)
+	g.server.mu.Unlock()
+}
+
+// Dispatch runs req through the routes and middleware in memory, e.g. for
+// the sub requests of simplehttp.BatchHandler
+func (s *Server) Dispatch(req *http.Request) (*http.Response, error) {
+	return dispatch(s.server.Handler, req)
+}
+
+// dispatch serves req with a fasthttp handler and converts the response back
+func dispatch(handler fasthttp.RequestHandler, req *http.Request) (*http.Response, error) {
+	var freq fasthttp.Request
+	freq.Header.SetMethod(req.Method)
+	freq.SetRequestURI(req.URL.RequestURI())
+	freq.Header.SetHost(req.Host)
+	for key, values := range req.Header {
+		for _, value := range values {
+			freq.Header.Add(key, value)
+		}
+	}
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		freq.SetBody(body)
+	}
+	remote, err := net.ResolveTCPAddr("tcp", req.RemoteAddr)
+	if err != nil {
+		remote = &net.TCPAddr{IP: net.IPv4zero}
+	}
+
+	var ctx fasthttp.RequestCtx
+	ctx.Init(&freq, remote, nil)
+	ctx.SetUserValue(simplehttp.ContextKeyDispatch, req.Context())
+	handler(&ctx)
+
+	resp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", ctx.Response.StatusCode(), http.StatusText(ctx.Response.StatusCode())),
+		StatusCode:    ctx.Response.StatusCode(),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		Request:       req,
+		ContentLength: -1,
+	}
+	ctx.Response.Header.VisitAll(func(key, value []byte) {
+		resp.Header.Add(string(key), string(value))
+	})
+	body := append([]byte(nil), ctx.Response.Body()...)
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	return resp, nil
 }
@@ -15,10 +15,12 @@ import (
 // )
 
 // Adapter converts SimpleHttpHandlerFunc to fiber.Handler
-func Adapter(handler simplehttp.HandlerFunc) fiber.Handler {
+func Adapter(handler simplehttp.HandlerFunc, cfgs ...*simplehttp.Config) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		ctx := NewContext(c)
-		if err := handler(ctx); err != nil {
+		ctx := NewContext(c, cfgs...)
+		err := handler(ctx)
+		ctx.syncResponseHeader()
+		if err != nil {
 			return handleError(ctx, err)
 		}
 		return nil
@@ -26,9 +28,9 @@ func Adapter(handler simplehttp.HandlerFunc) fiber.Handler {
 }
 
 // MiddlewareAdapter converts SimpleHttpMiddleware to fiber middleware
-func MiddlewareAdapter(middleware simplehttp.MiddlewareFunc) fiber.Handler {
+func MiddlewareAdapter(middleware simplehttp.MiddlewareFunc, cfgs ...*simplehttp.Config) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		ctx := NewContext(c)
+		ctx := NewContext(c, cfgs...)
 		err := middleware(func(medaCtx simplehttp.Context) error {
 			return c.Next()
 		})(ctx)
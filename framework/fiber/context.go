@@ -4,18 +4,23 @@ package fiber
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/url"
 	"reflect"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
-	"github.com/medatechnology/goutil/object"
 	"github.com/medatechnology/simplehttp"
+	"github.com/valyala/fasthttp"
 	"github.com/valyala/fasthttp/fasthttpadaptor"
 )
 
@@ -35,13 +40,23 @@ const (
 type FiberContext struct {
 	ctx         *fiber.Ctx
 	userContext context.Context
+	config      *simplehttp.Config
+	response    *fiberResponseWriter // created by the first Response() call
 }
 
-func NewContext(c *fiber.Ctx) *FiberContext {
-	return &FiberContext{
+func NewContext(c *fiber.Ctx, cfgs ...*simplehttp.Config) *FiberContext {
+	fc := &FiberContext{
 		ctx:         c,
 		userContext: context.Background(),
 	}
+	if dispatched, ok := c.Context().UserValue(simplehttp.ContextKeyDispatch).(context.Context); ok {
+		fc.userContext = dispatched
+	}
+	// optional config, same as echo NewEchoContext
+	if len(cfgs) > 0 && cfgs[0] != nil {
+		fc.config = cfgs[0]
+	}
+	return fc
 }
 
 // Header manipulation methods
@@ -50,6 +65,7 @@ func (c *FiberContext) SetRequestHeader(key, value string) {
 }
 
 func (c *FiberContext) SetResponseHeader(key, value string) {
+	c.syncResponseHeader()
 	c.ctx.Response().Header.Set(key, value)
 }
 
@@ -106,9 +122,50 @@ func (c *FiberContext) GetHeaders() *simplehttp.RequestHeader {
 	if headers.TrueIP == "" {
 		headers.TrueIP = c.ctx.Get(simplehttp.HEADER_TRUE_CLIENT_IP)
 	}
+	headers.SetIPExtractor(simplehttp.GetIPExtractor(c.config))
 	return &headers
 }
 
+// Connection accessors, see simplehttp.ForwardedScheme and ForwardedHost.
+// fasthttp only speaks HTTP/1.x.
+func (c *FiberContext) Scheme() string {
+	ctx := c.ctx.Context()
+	return simplehttp.ForwardedScheme(c.config, ctx.RemoteAddr().String(), ctx.IsTLS(), c.GetHeader)
+}
+
+func (c *FiberContext) Host() string {
+	ctx := c.ctx.Context()
+	return simplehttp.ForwardedHost(c.config, ctx.RemoteAddr().String(), string(ctx.Host()), c.GetHeader)
+}
+
+func (c *FiberContext) IsTLS() bool {
+	return c.Scheme() == simplehttp.SCHEME_HTTPS
+}
+
+func (c *FiberContext) Protocol() string {
+	return string(c.ctx.Request().Header.Protocol())
+}
+
+func (c *FiberContext) LocalAddr() net.Addr {
+	return c.ctx.Context().LocalAddr()
+}
+
+func (c *FiberContext) RemoteAddr() net.Addr {
+	return c.ctx.Context().RemoteAddr()
+}
+
+func (c *FiberContext) TLSConnectionState() *tls.ConnectionState {
+	return c.ctx.Context().TLSConnectionState()
+}
+
+func (c *FiberContext) FullURL() string {
+	return simplehttp.AbsoluteURL(c, c.ctx.OriginalURL())
+}
+
+func (c *FiberContext) BuildURL(path string, params map[string]string, query url.Values) string {
+	return simplehttp.BuildURL(c, path, params, query)
+}
+
 // Standard http.Request and http.ResponseWriter implementation
 func (c *FiberContext) Request() *http.Request {
 	req := &http.Request{
@@ -119,8 +176,9 @@ func (c *FiberContext) Request() *http.Request {
 			Path:     string(c.ctx.Request().URI().Path()),
 			RawQuery: string(c.ctx.Request().URI().QueryString()),
 		},
-		Body:   io.NopCloser(bytes.NewReader(c.ctx.Body())),
-		Header: make(http.Header),
+		Body:          io.NopCloser(bytes.NewReader(c.ctx.Body())),
+		ContentLength: int64(len(c.ctx.Body())),
+		Header:        make(http.Header),
 	}
 
 	// Copy current headers
@@ -131,28 +189,86 @@ func (c *FiberContext) Request() *http.Request {
 	return req
 }
 
+// fiberResponseWriter implements http.ResponseWriter for fiber. Header()
+// returns a map as in net/http, its changes are copied to the fasthttp
+// response on the next Header, Write or WriteHeader call, before the context
+// reads or resets response headers and when the handler returns, so
+// c.Response().Header().Set() persists like on echo.
 type fiberResponseWriter struct {
-	ctx *fiber.Ctx
+	ctx      *fiber.Ctx
+	header   http.Header
+	snapshot http.Header // response headers as of the last sync
 }
 
 func (w *fiberResponseWriter) Header() http.Header {
-	h := make(http.Header)
-	w.ctx.Response().Header.VisitAll(func(key, value []byte) {
-		h.Add(string(key), string(value))
-	})
-	return h
+	w.syncHeader()
+	return w.header
 }
 
 func (w *fiberResponseWriter) Write(b []byte) (int, error) {
+	w.syncHeader()
 	return w.ctx.Write(b)
 }
 
 func (w *fiberResponseWriter) WriteHeader(statusCode int) {
+	w.syncHeader()
 	w.ctx.Status(statusCode)
 }
 
+// syncHeader applies the keys changed in the map since the last sync, then
+// reloads the map. Only changed keys are applied, so headers set directly on
+// the response in between (SetResponseHeader, c.JSON's Content-Type) are
+// kept.
+func (w *fiberResponseWriter) syncHeader() {
+	syncResponseHeader(&w.ctx.Response().Header, &w.header, &w.snapshot)
+}
+
+// syncResponseHeader copies the keys of header that differ from snapshot to
+// h, then reloads both maps from h. The maps are created on the first call.
+func syncResponseHeader(h *fasthttp.ResponseHeader, header, snapshot *http.Header) {
+	if *header == nil {
+		*header, *snapshot = make(http.Header), make(http.Header)
+	}
+	// deletes first, fasthttp's Del moves the last header into the gap
+	// which would reorder values added before it
+	var changed []string
+	for key, values := range *header {
+		if !slices.Equal(values, (*snapshot)[key]) {
+			changed = append(changed, key)
+			h.Del(key)
+		}
+	}
+	for key := range *snapshot {
+		if _, ok := (*header)[key]; !ok {
+			h.Del(key)
+		}
+	}
+	for _, key := range changed {
+		for _, value := range (*header)[key] {
+			h.Add(key, value)
+		}
+	}
+	clear(*header)
+	clear(*snapshot)
+	h.VisitAll(func(key, value []byte) {
+		header.Add(string(key), string(value))
+		snapshot.Add(string(key), string(value))
+	})
+}
+
 func (c *FiberContext) Response() http.ResponseWriter {
-	return &fiberResponseWriter{ctx: c.ctx}
+	// the same writer every time, its header map is the one that syncs
+	if c.response == nil {
+		c.response = &fiberResponseWriter{ctx: c.ctx}
+	}
+	return c.response
+}
+
+// syncResponseHeader applies the pending c.Response().Header() changes
+func (c *FiberContext) syncResponseHeader() {
+	if c.response != nil {
+		c.response.syncHeader()
+	}
 }
 
 // Path and method accessors
@@ -160,11 +276,38 @@ func (c *FiberContext) GetPath() string {
 	return c.ctx.Path()
 }
 
+// Route is the pattern of the matched route, see simplehttp.RouteOf. The
+// middleware only run on matched routes.
+func (c *FiberContext) Route() string {
+	return c.ctx.Route().Path
+}
+
 func (c *FiberContext) GetMethod() string {
 	return c.ctx.Method()
 }
 
 // Query parameter handling
+func (c *FiberContext) Cookie(name string) (*http.Cookie, error) {
+	value := c.ctx.Cookies(name)
+	if value == "" {
+		return nil, http.ErrNoCookie
+	}
+	return &http.Cookie{Name: name, Value: value}, nil
+}
+
+func (c *FiberContext) SetCookie(cookie *http.Cookie) {
+	c.ctx.Response().Header.Add(fiber.HeaderSetCookie, cookie.String())
+}
+
+func (c *FiberContext) GetParam(name string) string {
+	// cloned, fiber reuses the buffer after the request
+	value := strings.Clone(c.ctx.Params(name))
+	if unescaped, err := url.PathUnescape(value); err == nil {
+		return unescaped
+	}
+	return value
+}
+
 func (c *FiberContext) GetQueryParam(key string) string {
 	return c.ctx.Query(key)
 }
@@ -182,20 +325,150 @@ func (c *FiberContext) GetBody() []byte {
 	return c.ctx.Body()
 }
 
+func (c *FiberContext) SetRequestBody(body []byte) {
+	c.ctx.Request().SetBody(body)
+}
+
 // Response methods
 func (c *FiberContext) JSON(code int, data interface{}) error {
+	if indent := simplehttp.DebugJSONIndent(c.config); indent != "" {
+		return c.JSONPretty(code, data, indent)
+	}
 	return c.ctx.Status(code).JSON(data)
 }
 
+func (c *FiberContext) JSONBlob(code int, data []byte) error {
+	c.ctx.Set(fiber.HeaderContentType, simplehttp.CONTENT_TYPE_JSON)
+	return c.ctx.Status(code).Send(data)
+}
+
+func (c *FiberContext) RawJSON(code int, data string) error {
+	c.ctx.Set(fiber.HeaderContentType, simplehttp.CONTENT_TYPE_JSON)
+	return c.ctx.Status(code).SendString(data)
+}
+
+func (c *FiberContext) PrettyJSON(code int, data interface{}) error {
+	return c.JSONPretty(code, data, simplehttp.DEFAULT_JSON_INDENT)
+}
+
+func (c *FiberContext) JSONPretty(code int, data interface{}, indent string) error {
+	b, err := simplehttp.MarshalJSONIndent(simplehttp.GetJSONCodec(c.config), data, indent)
+	if err != nil {
+		return err
+	}
+	return c.JSONBlob(code, b)
+}
+
+func (c *FiberContext) Encode(code int, contentType string, data interface{}) error {
+	b, err := simplehttp.MarshalCodec(contentType, data)
+	if err != nil {
+		return err
+	}
+	c.ctx.Set(fiber.HeaderContentType, contentType)
+	return c.ctx.Status(code).Send(b)
+}
+
 func (c *FiberContext) String(code int, data string) error {
 	return c.ctx.Status(code).SendString(data)
 }
 
 func (c *FiberContext) Stream(code int, contentType string, reader io.Reader) error {
 	c.ctx.Set("Content-Type", contentType)
+	// a Content-Length set before (ServeFile) avoids a chunked response
+	if size, err := strconv.Atoi(c.ctx.GetRespHeader(fiber.HeaderContentLength)); err == nil {
+		return c.ctx.Status(code).SendStream(reader, size)
+	}
 	return c.ctx.Status(code).SendStream(reader)
 }
 
+func (c *FiberContext) Blob(code int, contentType string, data []byte) error {
+	c.ctx.Set(fiber.HeaderContentType, contentType)
+	return c.ctx.Status(code).Send(data)
+}
+
+func (c *FiberContext) Attachment(reader io.Reader, filename string) error {
+	return c.sendDisposition("attachment", reader, filename)
+}
+
+func (c *FiberContext) Inline(reader io.Reader, filename string) error {
+	return c.sendDisposition("inline", reader, filename)
+}
+
+func (c *FiberContext) Accepted(jobID string) error {
+	return simplehttp.Accepted(c, jobID)
+}
+
+func (c *FiberContext) sendDisposition(dispositionType string, reader io.Reader, filename string) error {
+	c.ctx.Set(simplehttp.HEADER_CONTENT_DISPOSITION, simplehttp.ContentDisposition(dispositionType, filename))
+	return c.Stream(http.StatusOK, simplehttp.ContentTypeByFilename(filename), reader)
+}
+
+// Response buffering, fasthttp keeps the whole response in memory anyway.
+// BufferResponse only remembers the headers for ResetResponse.
+const responseSnapshotKey = "simplehttp.response_snapshot"
+
+type responseSnapshot struct {
+	header *fasthttp.ResponseHeader
+	depth  int
+}
+
+func (c *FiberContext) BufferResponse() {
+	if snapshot, ok := c.Get(responseSnapshotKey).(*responseSnapshot); ok {
+		snapshot.depth++
+		return
+	}
+	c.syncResponseHeader()
+	snapshot := &responseSnapshot{header: &fasthttp.ResponseHeader{}, depth: 1}
+	c.ctx.Response().Header.CopyTo(snapshot.header)
+	c.Set(responseSnapshotKey, snapshot)
+}
+
+func (c *FiberContext) FlushResponse() error {
+	if snapshot, ok := c.Get(responseSnapshotKey).(*responseSnapshot); ok {
+		if snapshot.depth--; snapshot.depth == 0 {
+			c.Set(responseSnapshotKey, nil)
+		}
+	}
+	return nil
+}
+
+func (c *FiberContext) ResetResponse() {
+	snapshot, ok := c.Get(responseSnapshotKey).(*responseSnapshot)
+	if !ok {
+		return
+	}
+	// pending map changes are dropped with the rest, the map is reloaded
+	c.syncResponseHeader()
+	snapshot.header.CopyTo(&c.ctx.Response().Header)
+	c.ctx.Response().ResetBody()
+	c.syncResponseHeader()
+}
+
+func (c *FiberContext) GetResponseStatus() int {
+	return c.ctx.Response().StatusCode()
+}
+
+func (c *FiberContext) GetResponseHeader(key string) string {
+	c.syncResponseHeader()
+	return string(c.ctx.Response().Header.Peek(key))
+}
+
+func (c *FiberContext) GetResponseBody() []byte {
+	return c.ctx.Response().Body()
+}
+
+func (c *FiberContext) GetResponseSize() int64 {
+	resp := c.ctx.Response()
+	if resp.IsBodyStream() {
+		return int64(max(resp.Header.ContentLength(), 0))
+	}
+	return int64(len(resp.Body()))
+}
+
+func (c *FiberContext) SetResponseBody(body []byte) {
+	c.ctx.Response().SetBody(body)
+}
+
 // File handling
 func (c *FiberContext) GetFile(fieldName string) (*multipart.FileHeader, error) {
 	return c.ctx.FormFile(fieldName)
@@ -206,10 +479,7 @@ func (c *FiberContext) SaveFile(file *multipart.FileHeader, dst string) error {
 }
 
 func (c *FiberContext) SendFile(filepath string, attachment bool) error {
-	if attachment {
-		return c.ctx.Download(filepath)
-	}
-	return c.ctx.SendFile(filepath)
+	return simplehttp.ServeFile(c, filepath, simplehttp.ServeFileOptions{Attachment: attachment})
 }
 
 // WebSocket handling
@@ -229,6 +499,20 @@ func (c *FiberContext) SetContext(ctx context.Context) {
 	c.userContext = ctx
 }
 
+func (c *FiberContext) SetCacheTTL(ttl time.Duration) {
+	simplehttp.CacheDirectiveOf(c).TTL = ttl
+}
+
+func (c *FiberContext) NoStore() {
+	simplehttp.CacheDirectiveOf(c).NoStore = true
+}
+
+// Deadline of the request context, pass c.Context() (or client.FromContext(c))
+// to outbound calls so they never outlive this request
+func (c *FiberContext) Deadline() (time.Time, bool) {
+	return c.userContext.Deadline()
+}
+
 func (c *FiberContext) Set(key string, value interface{}) {
 	c.ctx.Locals(key, value)
 }
@@ -299,15 +583,29 @@ func (c *FiberContext) Bind(v interface{}) error {
 		}
 	}
 
-	result := object.MapToStruct[any](params)
-	reflect.ValueOf(v).Elem().Set(reflect.ValueOf(result))
-	return nil
+	return simplehttp.BindMap(params, v)
 }
 
 func (c *FiberContext) BindJSON(v interface{}) error {
 	return c.ctx.BodyParser(v)
 }
 
+func (c *FiberContext) BindCodec(v interface{}) error {
+	return simplehttp.UnmarshalCodec(string(c.ctx.Request().Header.ContentType()), c.ctx.Body(), v)
+}
+
+func (c *FiberContext) BindAndValidate(v interface{}) error {
+	if err := c.Bind(v); err != nil {
+		return simplehttp.NewError(http.StatusBadRequest, "invalid request payload", err.Error())
+	}
+	return simplehttp.ValidateStruct(c.config, v)
+}
+
+// Clock returns the Clock of the server config, see simplehttp.ClockContext
+func (c *FiberContext) Clock() simplehttp.Clock {
+	return simplehttp.ClockOf(c.config)
+}
+
 func (c *FiberContext) BindForm(v interface{}) error {
 	if reflect.TypeOf(v).Kind() != reflect.Ptr {
 		return fmt.Errorf("binding element must be a pointer")
@@ -318,9 +616,7 @@ func (c *FiberContext) BindForm(v interface{}) error {
 		return err
 	}
 
-	result := object.MapToStruct[any](formData)
-	reflect.ValueOf(v).Elem().Set(reflect.ValueOf(result))
-	return nil
+	return simplehttp.BindMap(formData, v)
 }
 
 // WebSocket implementation
@@ -8,7 +8,6 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/basicauth"
 	"github.com/gofiber/fiber/v2/middleware/cache"
-	"github.com/gofiber/fiber/v2/middleware/compress"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/csrf"
 	"github.com/gofiber/fiber/v2/middleware/etag"
@@ -17,7 +16,6 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/monitor"
 	"github.com/gofiber/fiber/v2/middleware/recover"
-	"github.com/gofiber/fiber/v2/middleware/requestid"
 	"github.com/medatechnology/simplehttp"
 )
 
@@ -33,7 +31,6 @@ const (
 	xssProtectionEnabled    = "1; mode=block"
 	xssProtectionDisabled   = "0"
 	contentTypeNosniffValue = "nosniff"
-	defaultReferrerPolicy   = "strict-origin-when-cross-origin"
 	defaultHSTSMaxAge       = 31536000
 	defaultHSTSPreload      = true
 
@@ -51,9 +48,6 @@ const (
 	csrfCookieSameSite = "Strict"
 	csrfExpiration     = 1 * time.Hour
 
-	// Context keys
-	requestIDContextKey = "requestid"
-
 	// Cache constants
 	// defaultCacheEnabled = true
 
@@ -77,10 +71,6 @@ const (
 	// simpleHTTPNextHandlerKey = "meda_next_handler"
 )
 
-var (
-	requestIDHeaderKey = simplehttp.HEADER_REQUEST_ID
-)
-
 // namedMiddleware wraps a Fiber middleware with a name
 type namedMiddleware struct {
 	name       string
@@ -93,11 +83,15 @@ func (m namedMiddleware) Name() string {
 
 func (m namedMiddleware) Handle(next simplehttp.HandlerFunc) simplehttp.HandlerFunc {
 	return func(c simplehttp.Context) error {
-		fiberCtx := c.(*FiberContext).ctx
+		if !simplehttp.DefaultMiddlewareToggles.Enabled(m.name) {
+			return next(c)
+		}
+		simpleCtx := c.(*FiberContext)
+		fiberCtx := simpleCtx.ctx
 
 		// Create a wrapper handler that will be called after middleware
 		wrappedNext := func(c *fiber.Ctx) error {
-			return next(&FiberContext{ctx: c})
+			return next(&FiberContext{ctx: c, userContext: simpleCtx.userContext, config: simpleCtx.config})
 		}
 
 		// Store the handler
@@ -107,27 +101,20 @@ func (m namedMiddleware) Handle(next simplehttp.HandlerFunc) simplehttp.HandlerF
 	}
 }
 
-// RequestID middleware as an example., TODO: Check and test this, last time it wasn't working!
-func MiddlewareRequestID() simplehttp.Middleware {
-	return namedMiddleware{
-		name: "request ID",
-		middleware: func(c *fiber.Ctx) error {
-			// Run the actual requestid middleware
-			if err := requestid.New(requestid.Config{
-				Header:     requestIDHeaderKey,
-				Generator:  simplehttp.GenerateRequestID,
-				ContextKey: requestIDContextKey,
-			})(c); err != nil {
-				return err
-			}
-
-			// Get and call the next handler
-			if next, ok := c.Locals("nextHandler").(func(*fiber.Ctx) error); ok {
-				return next(c)
-			}
-			return c.Next()
-		},
+// callNext runs the next simplehttp handler stored by namedMiddleware.Handle,
+// for middleware that need to act after the handler ran.
+func callNext(c *fiber.Ctx) error {
+	if next, ok := c.Locals("nextHandler").(func(*fiber.Ctx) error); ok {
+		return next(c)
 	}
+	return c.Next()
+}
+
+// MiddlewareRequestID is simplehttp.MiddlewareRequestID. Fiber's requestid
+// middleware calls c.Next() itself, which skips the rest of the simplehttp
+// chain, and does not store the ID where simplehttp.RequestIDOf finds it.
+func MiddlewareRequestID(config ...simplehttp.RequestIDConfig) simplehttp.Middleware {
+	return simplehttp.MiddlewareRequestID(config...)
 }
 
 // Example of another middleware following the same pattern
@@ -144,7 +131,9 @@ func MiddlewareCORS(config *simplehttp.CORSConfig) simplehttp.Middleware {
 		},
 	}
 
+	var skipper simplehttp.Skipper
 	if config != nil {
+		skipper = config.Skipper
 		if config.AllowCredentials {
 			if len(config.AllowOrigins) > 0 {
 				fiberConfig.AllowOrigins = strings.Join(config.AllowOrigins, ",")
@@ -165,10 +154,10 @@ func MiddlewareCORS(config *simplehttp.CORSConfig) simplehttp.Middleware {
 		fiberConfig.MaxAge = int(config.MaxAge.Seconds())
 	}
 
-	return namedMiddleware{
+	return simplehttp.Skip(namedMiddleware{
 		name:       "CORS",
 		middleware: cors.New(fiberConfig),
-	}
+	}, skipper)
 }
 
 // MiddlewareLogger returns Fiber's logger middleware
@@ -183,19 +172,46 @@ func MiddlewareLogger(log simplehttp.Logger) simplehttp.Middleware {
 	}
 }
 
-// MiddlewareCompress returns Fiber's compression middleware
+// MiddlewareCompress compresses the response after the handler ran, honoring
+// Level, MinSize and Types from the config. Already compressed content
+// (images, archives, ...) is skipped, see CompressionConfig.ShouldCompress.
 func MiddlewareCompress(config simplehttp.CompressionConfig) simplehttp.Middleware {
 	level := defaultCompressLevel
 	if config.Level != 0 {
 		level = config.Level
 	}
 
-	return namedMiddleware{
+	return simplehttp.Skip(namedMiddleware{
 		name: "compress",
-		middleware: compress.New(compress.Config{
-			Level: compress.Level(level),
-		}),
-	}
+		middleware: func(c *fiber.Ctx) error {
+			if err := callNext(c); err != nil {
+				return err
+			}
+
+			resp := c.Response()
+			if len(resp.Header.Peek(fiber.HeaderContentEncoding)) > 0 {
+				return nil
+			}
+			body := resp.Body()
+			if !config.ShouldCompress(string(resp.Header.ContentType()), int64(len(body))) {
+				return nil
+			}
+
+			encoding := simplehttp.NegotiateEncoding(c.Get(fiber.HeaderAcceptEncoding), simplehttp.SupportedEncodings)
+			if encoding == "" {
+				return nil
+			}
+			compressed, err := simplehttp.CompressBytes(encoding, body, level)
+			if err != nil {
+				return err
+			}
+
+			resp.SetBodyRaw(compressed)
+			resp.Header.Set(fiber.HeaderContentEncoding, encoding)
+			resp.Header.Add(fiber.HeaderVary, fiber.HeaderAcceptEncoding)
+			return nil
+		},
+	}, config.Skipper)
 }
 
 // MiddlewareBasicAuth returns Fiber's basic auth middleware
@@ -211,23 +227,49 @@ func MiddlewareBasicAuth(username, password string) simplehttp.Middleware {
 	}
 }
 
-// MiddlewareRateLimiter returns Fiber's rate limiter middleware
+// MiddlewareRateLimiter returns Fiber's rate limiter middleware. Fiber has
+// no token bucket, RATE_LIMIT_SLIDING_WINDOW maps to its sliding window and
+// everything else to its fixed window of Limit (or RequestsPerSecond)
+// requests per Window (or ClientTimeout). Fiber always sends the
+// X-RateLimit-* headers.
 func MiddlewareRateLimiter(config simplehttp.RateLimitConfig) simplehttp.Middleware {
-	return namedMiddleware{
+	max, expiration := config.Limit, config.Window
+	if max <= 0 {
+		max = config.RequestsPerSecond
+	}
+	if expiration <= 0 {
+		expiration = config.ClientTimeout
+	}
+	var algorithm limiter.LimiterHandler = limiter.FixedWindow{}
+	if config.Algorithm == simplehttp.RATE_LIMIT_SLIDING_WINDOW {
+		algorithm = limiter.SlidingWindow{}
+	}
+	return simplehttp.Skip(namedMiddleware{
 		name: "rate limiter",
 		middleware: limiter.New(limiter.Config{
-			Max:                    config.RequestsPerSecond,
-			Expiration:             config.ClientTimeout,
+			Max:                    max,
+			Expiration:             expiration,
+			LimiterMiddleware:      algorithm,
 			KeyGenerator:           func(c *fiber.Ctx) string { return c.IP() },
 			LimitReached:           func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusTooManyRequests) },
 			SkipFailedRequests:     defaultLimitSkipFailed,
 			SkipSuccessfulRequests: defaultLimitSkipSucceeded,
 		}),
-	}
+	}, config.Skipper)
 }
 
 // MiddlewareSecurity returns Fiber's security middleware (Helmet)
 func MiddlewareSecurity(config simplehttp.SecurityConfig) simplehttp.Middleware {
+	config = config.WithDefaults()
+	// helmet fills empty values with its own defaults, so "off" can't be
+	// honored there, use simplehttp.MiddlewareSecurity for that
+	policy := func(value string) string {
+		if value == simplehttp.SECURITY_HEADER_OFF {
+			return ""
+		}
+		return value
+	}
+
 	xFrameOptions := xFrameOptionsSameOrigin
 	if config.FrameDeny {
 		xFrameOptions = xFrameOptionsDeny
@@ -243,24 +285,28 @@ func MiddlewareSecurity(config simplehttp.SecurityConfig) simplehttp.Middleware
 		nosniff = contentTypeNosniffValue
 	}
 
-	return namedMiddleware{
+	return simplehttp.Skip(namedMiddleware{
 		name: "security",
 		middleware: helmet.New(helmet.Config{
-			ContentSecurityPolicy: config.ContentSecurityPolicy,
-			XSSProtection:         xssProtection,
-			ContentTypeNosniff:    nosniff,
-			XFrameOptions:         xFrameOptions,
-			ReferrerPolicy:        defaultReferrerPolicy,
-			HSTSMaxAge:            defaultHSTSMaxAge,
-			HSTSExcludeSubdomains: !config.STSIncludeSubdomains,
-			HSTSPreloadEnabled:    defaultHSTSPreload,
+			ContentSecurityPolicy:     config.ContentSecurityPolicy,
+			XSSProtection:             xssProtection,
+			ContentTypeNosniff:        nosniff,
+			XFrameOptions:             xFrameOptions,
+			ReferrerPolicy:            policy(config.ReferrerPolicy),
+			PermissionPolicy:          policy(config.PermissionsPolicy),
+			CrossOriginOpenerPolicy:   policy(config.CrossOriginOpenerPolicy),
+			CrossOriginEmbedderPolicy: policy(config.CrossOriginEmbedderPolicy),
+			CrossOriginResourcePolicy: policy(config.CrossOriginResourcePolicy),
+			HSTSMaxAge:                defaultHSTSMaxAge,
+			HSTSExcludeSubdomains:     !config.STSIncludeSubdomains,
+			HSTSPreloadEnabled:        defaultHSTSPreload,
 		}),
-	}
+	}, config.Skipper)
 }
 
 // MiddlewareCache returns Fiber's cache middleware
 func MiddlewareCache(config simplehttp.CacheConfig) simplehttp.Middleware {
-	return namedMiddleware{
+	return simplehttp.Skip(namedMiddleware{
 		name: "cache",
 		middleware: cache.New(cache.Config{
 			Expiration: config.TTL,
@@ -277,7 +323,7 @@ func MiddlewareCache(config simplehttp.CacheConfig) simplehttp.Middleware {
 				return false
 			},
 		}),
-	}
+	}, config.Skipper)
 }
 
 // MiddlewareRecover returns Fiber's recover middleware
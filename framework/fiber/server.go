@@ -2,14 +2,19 @@
 package fiber
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"strings"
 	"sync"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
 	"github.com/medatechnology/simplehttp"
+	"github.com/valyala/fasthttp"
 )
 
 const (
@@ -20,14 +25,19 @@ type Server struct {
 	app        *fiber.App
 	config     *simplehttp.Config
 	middleware []simplehttp.Middleware
-	mu         sync.RWMutex
+	// used by the groups, for the startup diagnostics
+	groupMiddleware []simplehttp.Middleware
+	static          []simplehttp.StaticDir
+	redirects       []*simplehttp.HTTPRedirector
+	drain           *simplehttp.Drain
+	mu              sync.RWMutex
 }
 
-func NewServer(config *simplehttp.Config) *Server {
-	if config == nil {
-		config = simplehttp.DefaultConfig
-	}
+func NewServer(config *simplehttp.Config, options ...simplehttp.ServerOption) *Server {
+	opts := simplehttp.ApplyServerOptions(config, options...)
+	config = opts.Config
 
+	jsonCodec := simplehttp.GetJSONCodec(config)
 	app := fiber.New(fiber.Config{
 		ReadTimeout:           config.ConfigTimeOut.ReadTimeout,
 		WriteTimeout:          config.ConfigTimeOut.WriteTimeout,
@@ -36,13 +46,41 @@ func NewServer(config *simplehttp.Config) *Server {
 		DisableStartupMessage: !config.FrameworkStartupMessage,
 		AppName:               "MedaHTTP/Fiber",
 		Concurrency:           config.Concurrency, // Increase concurrency limit
+		JSONEncoder:           jsonCodec.Marshal,
+		JSONDecoder:           jsonCodec.Unmarshal,
 		// Add explicit H2C configuration if needed
 		// EnableH2C:             true,
 	})
 
-	return &Server{
+	drain := simplehttp.NewDrain(config)
+	app.Server().Handler = drainHandler(drain, app.Server().Handler)
+
+	s := &Server{
 		app:    app,
 		config: config,
+		drain:  drain,
+	}
+	opts.Setup(s)
+	return s
+}
+
+// drainHandler answers 503 once Shutdown started draining, so requests on
+// kept-alive connections get a response instead of a dropped connection
+func drainHandler(drain *simplehttp.Drain, next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if !drain.Draining() {
+			next(ctx)
+			return
+		}
+		for key, value := range drain.Headers() {
+			ctx.Response.Header.Set(key, value)
+		}
+		if drain.CloseConnection() {
+			ctx.SetConnectionClose()
+		}
+		ctx.SetContentType("application/json")
+		ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+		ctx.SetBody(simplehttp.DrainResponse())
 	}
 }
 
@@ -55,6 +93,26 @@ func (s *Server) PrintMiddleware(verbose bool) {
 	}
 }
 
+// Routes lists the registered routes, sorted by path and method
+func (s *Server) Routes() []simplehttp.RouteInfo {
+	var routes []simplehttp.RouteInfo
+	for _, route := range s.app.GetRoutes(true) {
+		routes = append(routes, simplehttp.RouteInfo{Method: route.Method, Path: route.Path})
+	}
+	simplehttp.SortRoutes(routes)
+	return routes
+}
+
+func (s *Server) MiddlewareNames() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.middleware))
+	for _, m := range s.middleware {
+		names = append(names, m.Name())
+	}
+	return names
+}
+
 func (s *Server) applyMiddleware(handler simplehttp.HandlerFunc) simplehttp.HandlerFunc {
 	for i := len(s.middleware) - 1; i >= 0; i-- {
 		handler = s.middleware[i].Handle(handler)
@@ -62,35 +120,38 @@ func (s *Server) applyMiddleware(handler simplehttp.HandlerFunc) simplehttp.Hand
 	return handler
 }
 
-func (s *Server) GET(path string, handler simplehttp.HandlerFunc) {
-	s.app.Get(path, Adapter(s.applyMiddleware(handler)))
+func (s *Server) GET(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	s.app.Get(path, Adapter(s.applyMiddleware(simplehttp.Chain(handler, middleware...)), s.config))
 }
 
-func (s *Server) POST(path string, handler simplehttp.HandlerFunc) {
-	s.app.Post(path, Adapter(s.applyMiddleware(handler)))
+func (s *Server) POST(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	s.app.Post(path, Adapter(s.applyMiddleware(simplehttp.Chain(handler, middleware...)), s.config))
 }
 
-func (s *Server) PUT(path string, handler simplehttp.HandlerFunc) {
-	s.app.Put(path, Adapter(s.applyMiddleware(handler)))
+func (s *Server) PUT(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	s.app.Put(path, Adapter(s.applyMiddleware(simplehttp.Chain(handler, middleware...)), s.config))
 }
 
-func (s *Server) DELETE(path string, handler simplehttp.HandlerFunc) {
-	s.app.Delete(path, Adapter(s.applyMiddleware(handler)))
+func (s *Server) DELETE(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	s.app.Delete(path, Adapter(s.applyMiddleware(simplehttp.Chain(handler, middleware...)), s.config))
 }
 
-func (s *Server) PATCH(path string, handler simplehttp.HandlerFunc) {
-	s.app.Patch(path, Adapter(s.applyMiddleware(handler)))
+func (s *Server) PATCH(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	s.app.Patch(path, Adapter(s.applyMiddleware(simplehttp.Chain(handler, middleware...)), s.config))
 }
 
-func (s *Server) OPTIONS(path string, handler simplehttp.HandlerFunc) {
-	s.app.Options(path, Adapter(s.applyMiddleware(handler)))
+func (s *Server) OPTIONS(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	s.app.Options(path, Adapter(s.applyMiddleware(simplehttp.Chain(handler, middleware...)), s.config))
 }
 
-func (s *Server) HEAD(path string, handler simplehttp.HandlerFunc) {
-	s.app.Head(path, Adapter(s.applyMiddleware(handler)))
+func (s *Server) HEAD(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	s.app.Head(path, Adapter(s.applyMiddleware(simplehttp.Chain(handler, middleware...)), s.config))
 }
 
 func (s *Server) Static(prefix, root string) {
+	s.mu.Lock()
+	s.static = append(s.static, simplehttp.StaticDir{Prefix: prefix, Root: root, Browse: true})
+	s.mu.Unlock()
 	s.app.Static(prefix, root, fiber.Static{
 		Compress:      true,
 		ByteRange:     true,
@@ -105,6 +166,7 @@ func (s *Server) StaticFile(path, filepath string) {
 }
 
 func (s *Server) WebSocket(path string, handler func(simplehttp.Websocket) error) {
+	handler = simplehttp.DefaultWebSocketStats.Track(path, handler)
 	// Configure WebSocket route
 	s.app.Use(path, func(c *fiber.Ctx) error {
 		if websocket.IsWebSocketUpgrade(c) {
@@ -138,6 +200,10 @@ func (s *Server) Use(middleware ...simplehttp.Middleware) {
 // Usually this is framework.Listen() function
 // TODO: use config.Debug to print out or if not silence / minimal
 func (s *Server) Start(address string) error {
+	// Shutdown came first, e.g. a Runner stopping after another server failed
+	if s.drain.Draining() {
+		return http.ErrServerClosed
+	}
 	if address == "" {
 		if s.config != nil {
 			address = s.config.Hostname + ":" + s.config.Port
@@ -150,6 +216,9 @@ func (s *Server) Start(address string) error {
 			address = ":" + address
 		}
 	}
+	if err := simplehttp.CheckStartup(s.startupInfo(address)); err != nil {
+		return err
+	}
 
 	// Get all routes for logging
 	allRoutes := make(map[string]simplehttp.Routes)
@@ -206,6 +275,8 @@ func (s *Server) Start(address string) error {
 		fmt.Printf("Registered routes/endpoints (%d)\n", totalRoutes)
 	}
 
+	simplehttp.StartHTTPRedirectors(s.redirects, address, s.config.Logger)
+
 	// Apply TLS if configured
 	if s.config.TLSCert != "" && s.config.TLSKey != "" {
 		return s.app.ListenTLS(address, s.config.TLSCert, s.config.TLSKey)
@@ -214,8 +285,29 @@ func (s *Server) Start(address string) error {
 	return s.app.Listen(address)
 }
 
+// startupInfo is checked by simplehttp.CheckStartup at Start
+func (s *Server) startupInfo(address string) simplehttp.StartupInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return simplehttp.StartupInfo{
+		Config:     s.config,
+		Address:    address,
+		Middleware: append(append([]simplehttp.Middleware{}, s.middleware...), s.groupMiddleware...),
+		Static:     s.static,
+	}
+}
+
 func (s *Server) Shutdown(ctx context.Context) error {
-	return s.app.ShutdownWithContext(ctx)
+	s.drain.Start(ctx)
+	err := simplehttp.ShutdownHTTPRedirectors(ctx, s.redirects)
+	if serr := s.app.ShutdownWithContext(ctx); serr != nil {
+		return serr
+	}
+	return err
+}
+
+func (s *Server) RedirectHTTP(address string) {
+	s.redirects = append(s.redirects, simplehttp.NewHTTPRedirector(address))
 }
 
 // RouterGroup implements group routing
@@ -239,32 +331,32 @@ func (g *RouterGroup) applyMiddleware(handler simplehttp.HandlerFunc) simplehttp
 	return handler
 }
 
-func (g *RouterGroup) GET(path string, handler simplehttp.HandlerFunc) {
-	g.server.app.Get(g.prefix+path, Adapter(g.applyMiddleware(handler)))
+func (g *RouterGroup) GET(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	g.server.app.Get(g.prefix+path, Adapter(g.applyMiddleware(simplehttp.Chain(handler, middleware...)), g.server.config))
 }
 
-func (g *RouterGroup) POST(path string, handler simplehttp.HandlerFunc) {
-	g.server.app.Post(g.prefix+path, Adapter(g.applyMiddleware(handler)))
+func (g *RouterGroup) POST(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	g.server.app.Post(g.prefix+path, Adapter(g.applyMiddleware(simplehttp.Chain(handler, middleware...)), g.server.config))
 }
 
-func (g *RouterGroup) PUT(path string, handler simplehttp.HandlerFunc) {
-	g.server.app.Put(g.prefix+path, Adapter(g.applyMiddleware(handler)))
+func (g *RouterGroup) PUT(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	g.server.app.Put(g.prefix+path, Adapter(g.applyMiddleware(simplehttp.Chain(handler, middleware...)), g.server.config))
 }
 
-func (g *RouterGroup) DELETE(path string, handler simplehttp.HandlerFunc) {
-	g.server.app.Delete(g.prefix+path, Adapter(g.applyMiddleware(handler)))
+func (g *RouterGroup) DELETE(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	g.server.app.Delete(g.prefix+path, Adapter(g.applyMiddleware(simplehttp.Chain(handler, middleware...)), g.server.config))
 }
 
-func (g *RouterGroup) PATCH(path string, handler simplehttp.HandlerFunc) {
-	g.server.app.Patch(g.prefix+path, Adapter(g.applyMiddleware(handler)))
+func (g *RouterGroup) PATCH(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	g.server.app.Patch(g.prefix+path, Adapter(g.applyMiddleware(simplehttp.Chain(handler, middleware...)), g.server.config))
 }
 
-func (g *RouterGroup) OPTIONS(path string, handler simplehttp.HandlerFunc) {
-	g.server.app.Options(g.prefix+path, Adapter(g.applyMiddleware(handler)))
+func (g *RouterGroup) OPTIONS(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	g.server.app.Options(g.prefix+path, Adapter(g.applyMiddleware(simplehttp.Chain(handler, middleware...)), g.server.config))
 }
 
-func (g *RouterGroup) HEAD(path string, handler simplehttp.HandlerFunc) {
-	g.server.app.Head(g.prefix+path, Adapter(g.applyMiddleware(handler)))
+func (g *RouterGroup) HEAD(path string, handler simplehttp.HandlerFunc, middleware ...simplehttp.Middleware) {
+	g.server.app.Head(g.prefix+path, Adapter(g.applyMiddleware(simplehttp.Chain(handler, middleware...)), g.server.config))
 }
 
 func (g *RouterGroup) Static(prefix, root string) {
@@ -285,13 +377,71 @@ func (g *RouterGroup) WebSocket(path string, handler func(simplehttp.Websocket)
 }
 
 func (g *RouterGroup) Group(prefix string) simplehttp.Router {
+	// sub group inherits the middleware registered so far, same as echo
 	return &RouterGroup{
 		prefix:     g.prefix + prefix,
 		server:     g.server,
-		middleware: make([]simplehttp.Middleware, 0),
+		middleware: append([]simplehttp.Middleware{}, g.middleware...),
 	}
 }
 
 func (g *RouterGroup) Use(middleware ...simplehttp.Middleware) {
 	g.middleware = append(g.middleware, middleware...)
+	g.server.mu.Lock()
+	g.server.groupMiddleware = append(g.server.groupMiddleware, middleware...)
+	g.server.mu.Unlock()
+}
+
+// Dispatch runs req through the routes and middleware in memory, e.g. for
+// the sub requests of simplehttp.BatchHandler
+func (s *Server) Dispatch(req *http.Request) (*http.Response, error) {
+	// Handler builds the routes registered since, also before Start
+	return dispatch(s.app.Handler(), req)
+}
+
+// dispatch serves req with a fasthttp handler and converts the response back
+func dispatch(handler fasthttp.RequestHandler, req *http.Request) (*http.Response, error) {
+	var freq fasthttp.Request
+	freq.Header.SetMethod(req.Method)
+	freq.SetRequestURI(req.URL.RequestURI())
+	freq.Header.SetHost(req.Host)
+	for key, values := range req.Header {
+		for _, value := range values {
+			freq.Header.Add(key, value)
+		}
+	}
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		freq.SetBody(body)
+	}
+	remote, err := net.ResolveTCPAddr("tcp", req.RemoteAddr)
+	if err != nil {
+		remote = &net.TCPAddr{IP: net.IPv4zero}
+	}
+
+	var ctx fasthttp.RequestCtx
+	ctx.Init(&freq, remote, nil)
+	ctx.SetUserValue(simplehttp.ContextKeyDispatch, req.Context())
+	handler(&ctx)
+
+	resp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", ctx.Response.StatusCode(), http.StatusText(ctx.Response.StatusCode())),
+		StatusCode:    ctx.Response.StatusCode(),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		Request:       req,
+		ContentLength: -1,
+	}
+	ctx.Response.Header.VisitAll(func(key, value []byte) {
+		resp.Header.Add(string(key), string(value))
+	})
+	body := append([]byte(nil), ctx.Response.Body()...)
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	return resp, nil
 }
@@ -0,0 +1,176 @@
+package simplehttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Health states, from best to worst
+const (
+	HEALTH_UP       = "up"
+	HEALTH_DEGRADED = "degraded"
+	HEALTH_DOWN     = "down"
+
+	DEFAULT_HEALTH_TIMEOUT = 5 * time.Second
+)
+
+// DegradedError is returned by a health check whose dependency works
+// partially, see Degraded
+type DegradedError struct {
+	Err error
+}
+
+func (e *DegradedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DegradedError) Unwrap() error {
+	return e.Err
+}
+
+// Degraded makes a health check report degraded instead of down, e.g. a
+// replica set with one node left:
+//
+//	if healthy < len(nodes) {
+//		return simplehttp.Degraded(fmt.Errorf("%d of %d nodes up", healthy, len(nodes)))
+//	}
+func Degraded(err error) error {
+	return &DegradedError{Err: err}
+}
+
+// HealthCheck is a dependency of the service
+type HealthCheck struct {
+	Check func(context.Context) error
+	// Soft dependencies degrade the service when they fail instead of taking
+	// it down, e.g. a cache in front of the database
+	Soft bool
+	// Features impaired when the check isn't up, e.g. "search", listed by
+	// the report so clients and dashboards know what doesn't work
+	Features []string
+}
+
+// HealthConfig configures HealthHandler
+type HealthConfig struct {
+	Checks  map[string]HealthCheck
+	Timeout time.Duration // per check, defaults to 5s
+	// ReadyWhenDegraded answers 200 when degraded, so load balancers keep
+	// sending traffic to a service that still works without its soft
+	// dependencies. Otherwise only up is 200.
+	ReadyWhenDegraded bool
+}
+
+// HealthCheckResult is the outcome of one check
+type HealthCheckResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HealthReport is the body of HealthHandler, Status is the worst of the
+// checks
+type HealthReport struct {
+	Status   string                       `json:"status"`
+	Checks   map[string]HealthCheckResult `json:"checks"`
+	Impaired []string                     `json:"impaired,omitempty"` // features of the checks not up
+}
+
+// CheckHealth runs the checks in parallel, each with its own timeout
+func CheckHealth(ctx context.Context, config HealthConfig) HealthReport {
+	checks := make(map[string]func(context.Context) error, len(config.Checks))
+	for name, check := range config.Checks {
+		checks[name] = check.Check
+	}
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = DEFAULT_HEALTH_TIMEOUT
+	}
+
+	report := HealthReport{Status: HEALTH_UP, Checks: make(map[string]HealthCheckResult, len(checks))}
+	impaired := make(map[string]bool)
+	for name, err := range runChecks(ctx, checks, timeout) {
+		result := HealthCheckResult{Status: healthStatus(err, config.Checks[name].Soft)}
+		if err != nil {
+			result.Error = err.Error()
+			for _, feature := range config.Checks[name].Features {
+				impaired[feature] = true
+			}
+		}
+		report.Checks[name] = result
+		if healthRank(result.Status) > healthRank(report.Status) {
+			report.Status = result.Status
+		}
+	}
+	for feature := range impaired {
+		report.Impaired = append(report.Impaired, feature)
+	}
+	sort.Strings(report.Impaired)
+	return report
+}
+
+// HealthHandler serves the HealthReport, 200 when up (or degraded with
+// ReadyWhenDegraded) and 503 otherwise, for readiness probes:
+//
+//	server.GET("/readyz", simplehttp.HealthHandler(simplehttp.HealthConfig{
+//		Checks: map[string]simplehttp.HealthCheck{
+//			"db":    {Check: db.PingContext},
+//			"redis": {Check: redisPing, Soft: true, Features: []string{"sessions", "rate limits"}},
+//		},
+//		ReadyWhenDegraded: true,
+//	}))
+func HealthHandler(config HealthConfig) HandlerFunc {
+	return func(c Context) error {
+		report := CheckHealth(c.Context(), config)
+		code := http.StatusOK
+		if report.Status == HEALTH_DOWN || (report.Status == HEALTH_DEGRADED && !config.ReadyWhenDegraded) {
+			code = http.StatusServiceUnavailable
+		}
+		c.SetResponseHeader("Cache-Control", "no-store")
+		return c.JSON(code, report)
+	}
+}
+
+func healthStatus(err error, soft bool) string {
+	var degraded *DegradedError
+	switch {
+	case err == nil:
+		return HEALTH_UP
+	case soft || errors.As(err, &degraded):
+		return HEALTH_DEGRADED
+	}
+	return HEALTH_DOWN
+}
+
+func healthRank(status string) int {
+	switch status {
+	case HEALTH_DEGRADED:
+		return 1
+	case HEALTH_DOWN:
+		return 2
+	}
+	return 0
+}
+
+// runChecks runs the checks in parallel, each with its own timeout, and
+// returns their errors by name
+func runChecks(ctx context.Context, checks map[string]func(context.Context) error, timeout time.Duration) map[string]error {
+	results := make(map[string]error, len(checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) error) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			err := check(checkCtx)
+			mu.Lock()
+			results[name] = err
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+	return results
+}
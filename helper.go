@@ -1,13 +1,70 @@
 package simplehttp
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
 	"github.com/medatechnology/goutil/encryption"
 )
 
+// ContentDisposition builds the Content-Disposition header value, dispositionType
+// is "attachment" or "inline". Non ASCII filenames are encoded as per RFC 2231.
+func ContentDisposition(dispositionType, filename string) string {
+	filename = filepath.Base(filename)
+	if v := mime.FormatMediaType(dispositionType, map[string]string{"filename": filename}); v != "" {
+		return v
+	}
+	return dispositionType
+}
+
+// ContentTypeByFilename guesses the content type from the file extension
+func ContentTypeByFilename(filename string) string {
+	if ct := mime.TypeByExtension(filepath.Ext(filename)); ct != "" {
+		return ct
+	}
+	return CONTENT_TYPE_OCTET_STREAM
+}
+
+// IDGenerator returns a new unique ID on every call
+type IDGenerator func() string
+
+// GenerateRequestID is the default IDGenerator, a random token
 func GenerateRequestID() string {
 	return encryption.NewRandomToken()
 }
 
+// IDGeneratorOf returns the ID generator of the config, the one of its
+// IDFormat when it has none, GenerateRequestID for an unknown format
+func IDGeneratorOf(config *Config) IDGenerator {
+	if config == nil {
+		return GenerateRequestID
+	}
+	if config.IDGenerator != nil {
+		return config.IDGenerator
+	}
+	if generate, err := IDGeneratorByFormat(config.IDFormat); err == nil {
+		return generate
+	}
+	return GenerateRequestID
+}
+
+// SequentialIDs returns predictable IDs "<prefix>-1", "<prefix>-2", ... for
+// tests that assert on request IDs, never use it in production
+func SequentialIDs(prefix string) IDGenerator {
+	var n atomic.Int64
+	return func() string {
+		return prefix + "-" + strconv.FormatInt(n.Add(1), 10)
+	}
+}
+
 // NOTE: already moved to validateBasicAuth using encryption package
 // func parseBasicAuth(auth string) (username, password string, ok bool) {
 // 	if auth == "" {
@@ -32,3 +89,157 @@ func GenerateRequestID() string {
 
 // 	return cs[:s], cs[s+1:], true
 // }
+
+// BindMap converts the collected params (query, form or json) into v, which
+// must be a pointer. Used by the adapters Bind and BindForm. Query and form
+// values are strings, they are converted to the number or bool fields they
+// bind to, "42" to an int and "true" to a bool, an empty one leaves the
+// field alone.
+func BindMap(params map[string]interface{}, v interface{}) error {
+	if v == nil || reflect.TypeOf(v).Kind() != reflect.Ptr {
+		return fmt.Errorf("binding element must be a pointer")
+	}
+	data, err := json.Marshal(convertParams(params, reflect.TypeOf(v).Elem()))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// convertParams returns params with the strings bound to scalar fields of t
+// converted to their kind, JSON wouldn't put "42" in an int
+func convertParams(params map[string]interface{}, t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return params
+	}
+	kinds := make(map[string]reflect.Kind)
+	scalarFields(t, kinds)
+	converted := make(map[string]interface{}, len(params))
+	for key, value := range params {
+		s, ok := value.(string)
+		kind, scalar := kinds[strings.ToLower(key)]
+		if !ok || !scalar {
+			converted[key] = value
+			continue
+		}
+		switch {
+		case s == "":
+			// nothing to convert, the field keeps its value
+		case kind == reflect.Bool:
+			if b, err := strconv.ParseBool(s); err == nil {
+				converted[key] = b
+			} else {
+				converted[key] = s // the decoding error tells the field
+			}
+		default:
+			if _, err := strconv.ParseFloat(s, 64); err == nil {
+				converted[key] = json.Number(s)
+			} else {
+				converted[key] = s
+			}
+		}
+	}
+	return converted
+}
+
+// scalarFields collects the number and bool fields of the struct t by
+// lower-cased JSON name, json.Unmarshal matches names case-insensitively too
+func scalarFields(t reflect.Type, kinds map[string]reflect.Kind) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" && options == "" {
+			continue
+		}
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			scalarFields(fieldType, kinds)
+			continue
+		}
+		if !field.IsExported() || strings.Contains(","+options+",", ",string,") {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		switch fieldType.Kind() {
+		case reflect.Bool,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			kinds[strings.ToLower(name)] = fieldType.Kind()
+		}
+	}
+}
+
+// ROUTE_UNMATCHED is the route of requests that matched none, see RouteOf
+const ROUTE_UNMATCHED = "unmatched"
+
+// RouteContext is implemented by the contexts of the framework packages,
+// Route returns the pattern of the matched route, "/users/:id", empty when
+// none matched
+type RouteContext interface {
+	Route() string
+}
+
+// RouteOf returns the pattern of the route of the request, ROUTE_UNMATCHED
+// when there is none. Unlike the request path it is bounded by the routes,
+// so it can be used as a metric label.
+func RouteOf(c Context) string {
+	if rc, ok := c.(RouteContext); ok {
+		if route := rc.Route(); route != "" {
+			return route
+		}
+	}
+	return ROUTE_UNMATCHED
+}
+
+// IsUpgradeRequest reports whether the request asks to switch protocols,
+// e.g. to a websocket. Its response can't be buffered.
+func IsUpgradeRequest(c Context) bool {
+	if c.GetHeader("Upgrade") == "" {
+		return false
+	}
+	for _, token := range splitList(c.GetHeader("Connection")) {
+		if strings.EqualFold(token, "upgrade") {
+			return true
+		}
+	}
+	return false
+}
+
+// IsStreamingRequest reports whether the response is long-lived, an upgrade
+// or a Server-Sent Events stream (Accept: text/event-stream)
+func IsStreamingRequest(c Context) bool {
+	return IsUpgradeRequest(c) || strings.Contains(c.GetHeader("Accept"), "text/event-stream")
+}
+
+// splitList splits a comma separated list, dropping empty items
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// statusOf returns the status a request ended with, an error returned by the
+// handler wins over the response status as it is rendered later
+func statusOf(c Context, err error) int {
+	if err == nil {
+		return c.GetResponseStatus()
+	}
+	var httpErr *SimpleHttpError
+	if errors.As(err, &httpErr) {
+		return httpErr.Code
+	}
+	return http.StatusInternalServerError
+}
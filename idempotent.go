@@ -0,0 +1,45 @@
+package simplehttp
+
+import "net/http"
+
+var REQUEST_IDEMPOTENT_STRING string = "idempotent"
+
+// WithIdempotent declares that the routes it is applied to are safe to execute
+// more than once, so proxying, hedging and failover may retry them. Methods
+// that are idempotent by definition (GET, PUT, DELETE, ...) do not need it.
+//
+//	server.POST("/orders/:id/confirm", simplehttp.WithIdempotent().Handle(confirmOrder))
+func WithIdempotent() Middleware {
+	return WithName("idempotent", Idempotent())
+}
+
+// Idempotent flags the request as retry-safe, see IsIdempotent
+func Idempotent() MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			c.Set(REQUEST_IDEMPOTENT_STRING, true)
+			return next(c)
+		}
+	}
+}
+
+// IsIdempotentMethod reports whether the method is idempotent per RFC 9110 9.2.2
+func IsIdempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// IsIdempotent reports whether the current request can be retried without
+// side effects, either because of its method or because the route was
+// declared with WithIdempotent. POST and PATCH are never retried otherwise.
+func IsIdempotent(c Context) bool {
+	if IsIdempotentMethod(c.GetMethod()) {
+		return true
+	}
+	flag, _ := c.Get(REQUEST_IDEMPOTENT_STRING).(bool)
+	return flag
+}
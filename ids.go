@@ -0,0 +1,166 @@
+package simplehttp
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ID formats of Config.IDFormat and SIMPLEHTTP_ID_FORMAT
+const (
+	ID_FORMAT_TOKEN     = "token" // GenerateRequestID, the default
+	ID_FORMAT_ULID      = "ulid"
+	ID_FORMAT_UUIDV4    = "uuidv4"
+	ID_FORMAT_UUIDV7    = "uuidv7"
+	ID_FORMAT_SNOWFLAKE = "snowflake"
+
+	SNOWFLAKE_MAX_NODE = 1<<10 - 1
+)
+
+// SnowflakeEpoch is the start of the snowflake timestamps, the 41 bits of
+// milliseconds last until 2089
+var SnowflakeEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// IDGeneratorByFormat returns the generator of one of the ID_FORMAT_ names.
+// ULIDs, UUIDv7s and snowflakes sort by creation time, which log systems and
+// database indexes handle better than random tokens.
+func IDGeneratorByFormat(format string) (IDGenerator, error) {
+	switch format {
+	case "", ID_FORMAT_TOKEN:
+		return GenerateRequestID, nil
+	case ID_FORMAT_ULID:
+		return NewULID, nil
+	case ID_FORMAT_UUIDV4:
+		return NewUUIDv4, nil
+	case ID_FORMAT_UUIDV7:
+		return NewUUIDv7, nil
+	case ID_FORMAT_SNOWFLAKE:
+		return defaultSnowflake(), nil
+	}
+	return nil, fmt.Errorf("unknown ID format %q", format)
+}
+
+// NewUUIDv4 returns a random UUID (RFC 9562 version 4)
+func NewUUIDv4() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return formatUUID(b)
+}
+
+// NewUUIDv7 returns a UUID starting with the Unix time in milliseconds (RFC
+// 9562 version 7), the other 74 bits are random
+func NewUUIDv7() string {
+	var b [16]byte
+	rand.Read(b[6:])
+	putMillis(b[:6], DefaultClock.Now())
+	b[6] = b[6]&0x0f | 0x70
+	b[8] = b[8]&0x3f | 0x80
+	return formatUUID(b)
+}
+
+func formatUUID(b [16]byte) string {
+	var s [36]byte
+	hex.Encode(s[0:8], b[0:4])
+	s[8] = '-'
+	hex.Encode(s[9:13], b[4:6])
+	s[13] = '-'
+	hex.Encode(s[14:18], b[6:8])
+	s[18] = '-'
+	hex.Encode(s[19:23], b[8:10])
+	s[23] = '-'
+	hex.Encode(s[24:], b[10:])
+	return string(s[:])
+}
+
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewULID returns a ULID, 26 characters of Crockford base32 holding the Unix
+// time in milliseconds and 80 random bits
+func NewULID() string {
+	var b [16]byte
+	rand.Read(b[6:])
+	putMillis(b[:6], DefaultClock.Now())
+
+	// 128 bits in 26 characters of 5 bits, the first one only has 3
+	hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+	var s [26]byte
+	for i := 25; i >= 0; i-- {
+		s[i] = crockfordBase32[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(s[:])
+}
+
+// putMillis writes the 48 bits of Unix milliseconds of t big endian
+func putMillis(b []byte, t time.Time) {
+	ms := uint64(t.UnixMilli())
+	for i := 5; i >= 0; i-- {
+		b[i] = byte(ms)
+		ms >>= 8
+	}
+}
+
+// NewSnowflake returns a generator of snowflake IDs: 41 bits of milliseconds
+// since SnowflakeEpoch, 10 bits of node and a 12 bit sequence, as decimal
+// strings. Every instance of the service needs its own node (0 to
+// SNOWFLAKE_MAX_NODE), otherwise IDs collide. It panics on a node out of
+// range.
+//
+// Snowflakes are predictable, never use them as secrets like CSRF tokens.
+func NewSnowflake(node int64) IDGenerator {
+	if node < 0 || node > SNOWFLAKE_MAX_NODE {
+		panic(fmt.Sprintf("simplehttp: snowflake node %d out of range 0-%d", node, SNOWFLAKE_MAX_NODE))
+	}
+	var (
+		mu       sync.Mutex
+		last     int64
+		sequence int64
+	)
+	return func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		now := DefaultClock.Now().Sub(SnowflakeEpoch).Milliseconds()
+		if now < last {
+			// the clock went back, keep counting on the last millisecond
+			// so IDs stay increasing
+			now = last
+		}
+		if now == last {
+			sequence = (sequence + 1) & 0xfff
+			if sequence == 0 {
+				// 4096 IDs in this millisecond, borrow the next one
+				now++
+			}
+		} else {
+			sequence = 0
+		}
+		last = now
+		return strconv.FormatInt(now<<22|node<<12|sequence, 10)
+	}
+}
+
+// defaultSnowflake is the snowflake of ID_FORMAT_SNOWFLAKE, shared so the
+// sequence is not reset. Its node is SIMPLEHTTP_SNOWFLAKE_NODE, or derived
+// from the hostname when that is not set.
+var defaultSnowflake = sync.OnceValue(func() IDGenerator {
+	if s := os.Getenv(SIMPLEHTTP_SNOWFLAKE_NODE); s != "" {
+		node, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			panic(fmt.Sprintf("simplehttp: %s: %v", SIMPLEHTTP_SNOWFLAKE_NODE, err))
+		}
+		return NewSnowflake(node)
+	}
+	hostname, _ := os.Hostname()
+	h := fnv.New32a()
+	h.Write([]byte(hostname))
+	return NewSnowflake(int64(h.Sum32() % (SNOWFLAKE_MAX_NODE + 1)))
+})
@@ -10,12 +10,42 @@ var (
 	PathInternalStatus string = DEFAULT_INTERNAL_STATUS
 )
 
-func CreateInternalAPI(s Server) Router {
+// InternalAPIConfig configures MountInternalAPI
+type InternalAPIConfig struct {
+	// TrustedCIDRs may reach the internal API, besides loopback and private
+	// networks
+	TrustedCIDRs []string
+	// Auth guards switching middleware with a POST to PathInternalMiddleware,
+	// refused without it
+	Auth   Middleware
+	Logger Logger      // logs the switched middleware, defaults to NewDefaultLogger
+	SLO    *SLOTracker // served at PathInternalSLO, defaults to DefaultSLOTracker
+}
+
+// CreateInternalAPI mounts the internal debug endpoints under PathInternalAPI.
+// They are only reachable from loopback/private networks, plus trustedCIDRs.
+// Middleware can't be switched without the Auth of MountInternalAPI.
+func CreateInternalAPI(s Server, trustedCIDRs ...string) Router {
+	return MountInternalAPI(s, InternalAPIConfig{TrustedCIDRs: trustedCIDRs})
+}
+
+// MountInternalAPI mounts the internal debug endpoints under PathInternalAPI,
+// see CreateInternalAPI
+func MountInternalAPI(s Server, config InternalAPIConfig) Router {
+	if config.Logger == nil {
+		config.Logger = NewDefaultLogger()
+	}
+	if config.SLO == nil {
+		config.SLO = DefaultSLOTracker
+	}
+
 	// API routes
 	internalAPI := s.Group(PathInternalAPI)
 	{
-		s.Use(
+		internalAPI.Use(
+			MiddlewareInternalOnly(config.TrustedCIDRs...),
 			MiddlewareHeaderParser(),
+			MiddlewareProfiler(DefaultProfiler),
 		)
 
 		internalAPI.GET(PathInternalStatus, func(c Context) error {
@@ -30,6 +60,26 @@ func CreateInternalAPI(s Server) Router {
 				},
 			})
 		})
+
+		// switch middleware off and on, see MiddlewareToggles
+		toggles := middlewareTogglesHandler(s, DefaultMiddlewareToggles, config.Logger)
+		internalAPI.GET(PathInternalMiddleware, toggles)
+		if config.Auth != nil {
+			internalAPI.POST(PathInternalMiddleware, toggles, config.Auth)
+		} else {
+			internalAPI.POST(PathInternalMiddleware, func(c Context) error {
+				return NewError(http.StatusForbidden, "switching middleware needs InternalAPIConfig.Auth")
+			})
+		}
+
+		// on-demand CPU and heap profiles, see Profiler
+		profiler := profilerHandler(DefaultProfiler)
+		internalAPI.GET(PathInternalProfile, profiler)
+		internalAPI.POST(PathInternalProfile, profiler)
+		internalAPI.GET(PathInternalProfile+"/download", profileDownloadHandler(DefaultProfiler))
+
+		// burn rates of the SLO objectives, see SLOTracker
+		internalAPI.GET(PathInternalSLO, config.SLO.Handler())
 	}
 	return internalAPI
 }
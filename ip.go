@@ -0,0 +1,341 @@
+package simplehttp
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ParseCIDRs parses a list of CIDRs or plain IPs (treated as /32 or /128)
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: c}
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// StripPort returns the host part of an "ip:port" address, or the address
+// itself when it has no port
+func StripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.Trim(addr, "[]")
+}
+
+// IPInNets reports whether ip is in any of the networks
+func IPInNets(ip net.IP, nets []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsInternalIP reports whether ip is loopback, private (RFC1918 / RFC4193)
+// or link-local
+func IsInternalIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast()
+}
+
+// splitForwardedFor returns the X-Forwarded-For entries, left (client) to right
+func splitForwardedFor(xff string) []string {
+	if xff == "" {
+		return nil
+	}
+	parts := strings.Split(xff, ",")
+	ips := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			ips = append(ips, StripPort(p))
+		}
+	}
+	return ips
+}
+
+// IPExtractor finds the client IP of a request from its headers
+type IPExtractor func(h *RequestHeader) string
+
+// ExtractDirectIP uses the address of the direct peer, ignoring any forwarded
+// header. Right when the server is exposed directly.
+func ExtractDirectIP() IPExtractor {
+	return func(h *RequestHeader) string {
+		return StripPort(h.RemoteIP)
+	}
+}
+
+// ExtractIPFromProxies honors forwarded headers only when the direct peer is
+// one of the trusted proxies. X-Forwarded-For is walked from the right
+// skipping trusted hops, the first untrusted one is the client. Single value
+// headers like CF-Connecting-IP are ignored, a client could send its own
+// through proxies that don't overwrite them, see ExtractIPFromHeader.
+func ExtractIPFromProxies(trustedProxies ...string) IPExtractor {
+	trusted := parseTrustedProxies(trustedProxies)
+	return func(h *RequestHeader) string {
+		remote := StripPort(h.RemoteIP)
+		if !IPInNets(net.ParseIP(remote), trusted) {
+			return remote
+		}
+		return forwardedClientIP(h, trusted, remote)
+	}
+}
+
+// ExtractIPFromHeader uses header, X-Real-IP, CF-Connecting-IP or
+// True-Client-IP, when the direct peer is one of the trusted proxies, and
+// walks X-Forwarded-For as ExtractIPFromProxies without it. Only for proxies
+// that always set the header, e.g. CF-Connecting-IP behind Cloudflare.
+func ExtractIPFromHeader(header string, trustedProxies ...string) IPExtractor {
+	value := ipHeaderValue(header)
+	if value == nil {
+		panic("simplehttp: " + errTrustedIPHeader(header))
+	}
+	trusted := parseTrustedProxies(trustedProxies)
+	return func(h *RequestHeader) string {
+		remote := StripPort(h.RemoteIP)
+		if !IPInNets(net.ParseIP(remote), trusted) {
+			return remote
+		}
+		if ip := net.ParseIP(StripPort(strings.TrimSpace(value(h)))); ip != nil {
+			return ip.String()
+		}
+		return forwardedClientIP(h, trusted, remote)
+	}
+}
+
+// ipHeaderValue returns the field of RequestHeader holding header, nil for
+// the headers that are not a client IP
+func ipHeaderValue(header string) func(h *RequestHeader) string {
+	switch http.CanonicalHeaderKey(header) {
+	case http.CanonicalHeaderKey(HEADER_REAL_IP):
+		return func(h *RequestHeader) string { return h.RealIP }
+	case http.CanonicalHeaderKey(HEADER_CONNECTING_IP):
+		return func(h *RequestHeader) string { return h.ConnectingIP }
+	case http.CanonicalHeaderKey(HEADER_TRUE_CLIENT_IP):
+		return func(h *RequestHeader) string { return h.TrueIP }
+	}
+	return nil
+}
+
+func errTrustedIPHeader(header string) string {
+	return "trusted IP header must be X-Real-IP, CF-Connecting-IP or True-Client-IP, not " + header
+}
+
+func parseTrustedProxies(trustedProxies []string) []*net.IPNet {
+	trusted, err := ParseCIDRs(trustedProxies)
+	if err != nil {
+		panic("simplehttp: invalid trusted proxy: " + err.Error())
+	}
+	return trusted
+}
+
+// forwardedClientIP walks X-Forwarded-For from the right, the first hop not
+// in trusted is the client, remote when there is none
+func forwardedClientIP(h *RequestHeader, trusted []*net.IPNet, remote string) string {
+	hops := splitForwardedFor(h.ForwardedFor)
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(hops[i])
+		if ip == nil {
+			// garbage in the chain, don't trust anything left of it
+			break
+		}
+		if !IPInNets(ip, trusted) || i == 0 {
+			return ip.String()
+		}
+	}
+	return remote
+}
+
+var ipExtractors sync.Map // *Config -> IPExtractor
+
+// GetIPExtractor returns Config.IPExtractor, or one built from
+// Config.TrustedProxies and Config.TrustedIPHeader, or ExtractDirectIP
+func GetIPExtractor(config *Config) IPExtractor {
+	if config == nil {
+		return ExtractDirectIP()
+	}
+	if config.IPExtractor != nil {
+		return config.IPExtractor
+	}
+	if cached, ok := ipExtractors.Load(config); ok {
+		return cached.(IPExtractor)
+	}
+	extractor := ExtractDirectIP()
+	switch {
+	case len(config.TrustedProxies) > 0 && config.TrustedIPHeader != "" && ipHeaderValue(config.TrustedIPHeader) != nil:
+		extractor = ExtractIPFromHeader(config.TrustedIPHeader, config.TrustedProxies...)
+	case len(config.TrustedProxies) > 0:
+		if config.TrustedIPHeader != "" {
+			// from the environment, walk X-Forwarded-For rather than fail requests
+			logger := config.Logger
+			if logger == nil {
+				logger = NewDefaultLogger()
+			}
+			logger.Errorf("config: %s, using X-Forwarded-For", errTrustedIPHeader(config.TrustedIPHeader))
+		}
+		extractor = ExtractIPFromProxies(config.TrustedProxies...)
+	}
+	ipExtractors.Store(config, extractor)
+	return extractor
+}
+
+func MiddlewareInternalOnly(trustedCIDRs ...string) Middleware {
+	return WithName("internal only", InternalOnly(trustedCIDRs...))
+}
+
+// InternalOnly only lets through requests coming from loopback, private
+// networks or one of the trusted CIDRs. It is proxy aware: forwarded headers
+// are only looked at when the direct peer is itself internal/trusted, and
+// every hop in X-Forwarded-For must be internal/trusted as well.
+func InternalOnly(trustedCIDRs ...string) MiddlewareFunc {
+	trusted, err := ParseCIDRs(trustedCIDRs)
+	if err != nil {
+		panic("simplehttp: invalid trusted CIDR for InternalOnly: " + err.Error())
+	}
+	allowed := func(addr string) bool {
+		ip := net.ParseIP(StripPort(addr))
+		return IsInternalIP(ip) || IPInNets(ip, trusted)
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			headers := c.GetHeaders()
+			if !allowed(headers.RemoteIP) {
+				return NewError(http.StatusForbidden, ErrForbidden.Error())
+			}
+			// the peer is a proxy we trust, check who it is forwarding for
+			for _, hop := range splitForwardedFor(headers.ForwardedFor) {
+				if !allowed(hop) {
+					return NewError(http.StatusForbidden, ErrForbidden.Error())
+				}
+			}
+			for _, h := range []string{headers.RealIP, headers.ConnectingIP, headers.TrueIP} {
+				if h != "" && !allowed(h) {
+					return NewError(http.StatusForbidden, ErrForbidden.Error())
+				}
+			}
+			return next(c)
+		}
+	}
+}
+
+// IPFilterConfig holds the allow and deny lists of MiddlewareIPFilter, both
+// take CIDRs or plain IPs
+type IPFilterConfig struct {
+	Allow   []string // when not empty only these are let through
+	Deny    []string // always blocked, checked before Allow
+	Bans    *IPBans  // temporary bans added at runtime, e.g. by the anomaly detector
+	Skipper Skipper
+}
+
+func MiddlewareIPFilter(config IPFilterConfig) Middleware {
+	return Skip(WithName("ip filter", IPFilter(config)), config.Skipper)
+}
+
+// IPFilter blocks clients by IP with 403. The client IP is RequestHeader.IP().
+func IPFilter(config IPFilterConfig) MiddlewareFunc {
+	allow, err := ParseCIDRs(config.Allow)
+	if err != nil {
+		panic("simplehttp: invalid allow CIDR for IPFilter: " + err.Error())
+	}
+	deny, err := ParseCIDRs(config.Deny)
+	if err != nil {
+		panic("simplehttp: invalid deny CIDR for IPFilter: " + err.Error())
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			ip := net.ParseIP(StripPort(c.GetHeaders().IP()))
+			if ip == nil || IPInNets(ip, deny) || (len(allow) > 0 && !IPInNets(ip, allow)) || config.Bans.Banned(ip.String()) {
+				return NewError(http.StatusForbidden, ErrForbidden.Error())
+			}
+			return next(c)
+		}
+	}
+}
+
+// IPBans holds temporary IP bans, safe for concurrent use
+type IPBans struct {
+	mu   sync.Mutex
+	bans map[string]time.Time
+}
+
+func NewIPBans() *IPBans {
+	return &IPBans{bans: make(map[string]time.Time)}
+}
+
+// Ban blocks ip until d passed, a longer existing ban is kept
+func (b *IPBans) Ban(ip string, d time.Duration) {
+	until := time.Now().Add(d)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if current, ok := b.bans[ip]; !ok || current.Before(until) {
+		b.bans[ip] = until
+	}
+}
+
+func (b *IPBans) Unban(ip string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.bans, ip)
+}
+
+// Banned is false for a nil *IPBans so IPFilterConfig.Bans is optional
+func (b *IPBans) Banned(ip string) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	until, ok := b.bans[ip]
+	if ok && time.Now().After(until) {
+		delete(b.bans, ip)
+		return false
+	}
+	return ok
+}
+
+// List returns the active bans with their expiry
+func (b *IPBans) List() map[string]time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	out := make(map[string]time.Time, len(b.bans))
+	for ip, until := range b.bans {
+		if now.After(until) {
+			delete(b.bans, ip)
+			continue
+		}
+		out[ip] = until
+	}
+	return out
+}
@@ -0,0 +1,317 @@
+package simplehttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_JOBS_PATH         = "/jobs"
+	DEFAULT_JOB_TTL           = 24 * time.Hour
+	DEFAULT_JOB_POLL_INTERVAL = time.Second
+	HEADER_LOCATION           = "Location"
+
+	JOB_PENDING   = "pending"
+	JOB_RUNNING   = "running"
+	JOB_SUCCEEDED = "succeeded"
+	JOB_FAILED    = "failed"
+)
+
+var REQUEST_JOBS_STRING string = "jobs"
+
+// ErrJobNotFound is returned by a JobStore for ids it doesn't have
+var ErrJobNotFound = errors.New("job not found")
+
+// Job is the state of a long-running operation, what the status endpoint
+// reports
+type Job struct {
+	ID       string      `json:"id"`
+	Status   string      `json:"status"`
+	Progress float64     `json:"progress"` // 0 to 1
+	Message  string      `json:"message,omitempty"`
+	Result   interface{} `json:"result,omitempty"`
+	Error    string      `json:"error,omitempty"`
+	Created  time.Time   `json:"created"`
+	Updated  time.Time   `json:"updated"`
+}
+
+// Done reports whether the job succeeded or failed
+func (j *Job) Done() bool {
+	return j.Status == JOB_SUCCEEDED || j.Status == JOB_FAILED
+}
+
+// JobStore keeps the jobs of a JobRegistry
+type JobStore interface {
+	Load(id string) (*Job, error) // ErrJobNotFound when unknown or expired
+	Save(job *Job, ttl time.Duration) error
+}
+
+// JobConfig configures NewJobRegistry
+type JobConfig struct {
+	// Store defaults to a CacheJobStore on a MemoryCache, use a shared store
+	// when running several instances so any of them answers the polls
+	Store JobStore
+	Path  string        // of the status endpoint, default "/jobs"
+	TTL   time.Duration // jobs are kept that long after their last update, default 24h
+	// Timeout cancels the context of a job running longer, 0 means no limit
+	Timeout time.Duration
+	// PollInterval is sent as Retry-After while a job isn't done, default 1s
+	PollInterval time.Duration
+	// GenerateID makes the job ids, 192 random bits by default so the ids
+	// of other clients can't be guessed
+	GenerateID IDGenerator
+	Logger     Logger // reports failed saves and panics, defaults to NewDefaultLogger
+}
+
+// JobFunc is the work of a job, its result is reported as the job result.
+// ctx is canceled after JobConfig.Timeout.
+type JobFunc func(ctx context.Context, run *JobRun) (interface{}, error)
+
+// JobRegistry runs long operations in the background and tracks them, so
+// handlers answer 202 Accepted at once and clients poll GET /jobs/:id for the
+// status and result:
+//
+//	jobs := simplehttp.NewJobRegistry(simplehttp.JobConfig{})
+//	server := fiber.NewServer(config, simplehttp.WithJobs(jobs))
+//	server.POST("/reports", func(c simplehttp.Context) error {
+//		job, err := jobs.Start(func(ctx context.Context, run *simplehttp.JobRun) (interface{}, error) {
+//			run.Progress(0.5, "collecting")
+//			return buildReport(ctx)
+//		})
+//		if err != nil {
+//			return err
+//		}
+//		return c.Accepted(job.ID)
+//	})
+type JobRegistry struct {
+	config  JobConfig
+	running sync.WaitGroup
+}
+
+func NewJobRegistry(config JobConfig) *JobRegistry {
+	if config.Store == nil {
+		config.Store = NewCacheJobStore(NewMemoryCache())
+	}
+	if config.Path == "" {
+		config.Path = DEFAULT_JOBS_PATH
+	}
+	config.Path = "/" + strings.Trim(config.Path, "/")
+	if config.TTL <= 0 {
+		config.TTL = DEFAULT_JOB_TTL
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = DEFAULT_JOB_POLL_INTERVAL
+	}
+	if config.GenerateID == nil {
+		config.GenerateID = randomToken
+	}
+	if config.Logger == nil {
+		config.Logger = NewDefaultLogger()
+	}
+	return &JobRegistry{config: config}
+}
+
+// Start saves a pending job and runs fn in the background. A panic of fn
+// fails the job.
+func (r *JobRegistry) Start(fn JobFunc) (*Job, error) {
+	now := time.Now()
+	job := &Job{ID: r.config.GenerateID(), Status: JOB_PENDING, Created: now, Updated: now}
+	if err := r.config.Store.Save(job, r.config.TTL); err != nil {
+		return nil, err
+	}
+	run := &JobRun{registry: r, job: *job}
+	r.running.Add(1)
+	go run.execute(fn)
+	return job, nil
+}
+
+// Get returns the job of id, ErrJobNotFound when unknown or expired
+func (r *JobRegistry) Get(id string) (*Job, error) {
+	return r.config.Store.Load(id)
+}
+
+// Wait blocks until the jobs started by this registry are done or ctx ends,
+// call it on shutdown so jobs aren't cut off
+func (r *JobRegistry) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		r.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Mount registers GET Path/:id, WithJobs does it at server creation
+func (r *JobRegistry) Mount(router Router) {
+	router.GET(r.config.Path+"/:id", r.HandleStatus())
+}
+
+// Middleware lets c.Accepted use the Path of this registry, WithJobs uses it
+// on every route
+func (r *JobRegistry) Middleware() Middleware {
+	return WithName("jobs", func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			c.Set(REQUEST_JOBS_STRING, r)
+			return next(c)
+		}
+	})
+}
+
+// HandleStatus reports the job of the route parameter id, with Retry-After
+// until it is done
+func (r *JobRegistry) HandleStatus() HandlerFunc {
+	return func(c Context) error {
+		job, err := r.Get(c.GetParam("id"))
+		if errors.Is(err, ErrJobNotFound) {
+			return NewError(http.StatusNotFound, ErrJobNotFound.Error())
+		}
+		if err != nil {
+			return NewError(http.StatusInternalServerError, "failed to read job")
+		}
+		if !job.Done() {
+			c.SetResponseHeader(HEADER_RETRY_AFTER, retryAfterSeconds(r.config.PollInterval))
+		}
+		return c.JSON(http.StatusOK, job)
+	}
+}
+
+// Accepted answers 202 with the status URL of the job in Location, what
+// c.Accepted does with the registry of the request
+func (r *JobRegistry) Accepted(c Context, jobID string) error {
+	return accepted(c, r.config.Path, jobID, r.config.PollInterval)
+}
+
+// Accepted answers 202 for the job, with the registry set by WithJobs or
+// JobRegistry.Middleware, under /jobs without one. The adapters implement
+// Context.Accepted with it.
+func Accepted(c Context, jobID string) error {
+	if registry, ok := c.Get(REQUEST_JOBS_STRING).(*JobRegistry); ok {
+		return registry.Accepted(c, jobID)
+	}
+	return accepted(c, DEFAULT_JOBS_PATH, jobID, DEFAULT_JOB_POLL_INTERVAL)
+}
+
+func accepted(c Context, path, jobID string, pollInterval time.Duration) error {
+	location := c.BuildURL(path+"/"+jobID, nil, nil)
+	c.SetResponseHeader(HEADER_LOCATION, location)
+	c.SetResponseHeader(HEADER_RETRY_AFTER, retryAfterSeconds(pollInterval))
+	return c.JSON(http.StatusAccepted, map[string]string{
+		"id":         jobID,
+		"status":     JOB_PENDING,
+		"status_url": location,
+	})
+}
+
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// JobRun reports the progress of a running job
+type JobRun struct {
+	registry *JobRegistry
+	mu       sync.Mutex
+	job      Job
+}
+
+// ID of the job
+func (r *JobRun) ID() string {
+	return r.job.ID
+}
+
+// Progress saves how far the job is, from 0 to 1, and what it is doing
+func (r *JobRun) Progress(progress float64, message string) error {
+	return r.update(func(job *Job) {
+		job.Progress = math.Max(0, math.Min(1, progress))
+		job.Message = message
+	})
+}
+
+func (r *JobRun) update(change func(*Job)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	change(&r.job)
+	r.job.Updated = time.Now()
+	job := r.job // a copy, in memory stores would share it with the run
+	return r.registry.config.Store.Save(&job, r.registry.config.TTL)
+}
+
+func (r *JobRun) execute(fn JobFunc) {
+	defer r.registry.running.Done()
+	ctx := context.Background()
+	if r.registry.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.registry.config.Timeout)
+		defer cancel()
+	}
+
+	if err := r.update(func(job *Job) { job.Status = JOB_RUNNING }); err != nil {
+		r.registry.config.Logger.Errorf("job %s: %v", r.job.ID, err)
+	}
+	result, err := r.call(ctx, fn)
+	saveErr := r.update(func(job *Job) {
+		if err != nil {
+			job.Status, job.Error = JOB_FAILED, err.Error()
+			return
+		}
+		job.Status, job.Result, job.Progress = JOB_SUCCEEDED, result, 1
+	})
+	if saveErr != nil {
+		r.registry.config.Logger.Errorf("job %s: %v", r.job.ID, saveErr)
+	}
+}
+
+func (r *JobRun) call(ctx context.Context, fn JobFunc) (result interface{}, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			r.registry.config.Logger.Errorf("job %s panicked: %v", r.job.ID, recovered)
+			result, err = nil, errors.New("job panicked")
+		}
+	}()
+	return fn(ctx, r)
+}
+
+// CacheJobStore keeps jobs in a CacheStore
+type CacheJobStore struct {
+	store CacheStore
+}
+
+func NewCacheJobStore(store CacheStore) *CacheJobStore {
+	if store == nil {
+		panic("simplehttp: CacheJobStore store is required")
+	}
+	return &CacheJobStore{store: store}
+}
+
+func (s *CacheJobStore) Load(id string) (*Job, error) {
+	value, found := s.store.Get(s.key(id))
+	if !found {
+		return nil, ErrJobNotFound
+	}
+	job, ok := value.(*Job)
+	if !ok {
+		return nil, fmt.Errorf("job %s: unexpected %T in the store", id, value)
+	}
+	copied := *job
+	return &copied, nil
+}
+
+func (s *CacheJobStore) Save(job *Job, ttl time.Duration) error {
+	copied := *job
+	return s.store.Set(s.key(job.ID), &copied, ttl)
+}
+
+func (s *CacheJobStore) key(id string) string {
+	return "job:" + id
+}
@@ -0,0 +1,131 @@
+package simplehttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// JSONCodec is used by c.JSON and c.BindJSON on every adapter. Defaults to
+// encoding/json, set Config.JSONCodec to swap in jsoniter, go-json, sonic, ...
+//
+//	var jsoniterCodec = jsoniter.ConfigCompatibleWithStandardLibrary
+//	config.JSONCodec = simplehttp.JSONCodecFuncs{
+//		MarshalFunc:   jsoniterCodec.Marshal,
+//		UnmarshalFunc: jsoniterCodec.Unmarshal,
+//	}
+type JSONCodec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+	NewEncoder(w io.Writer) JSONEncoder
+}
+
+// JSONEncoder is the streaming part of JSONCodec, same as *json.Encoder
+type JSONEncoder interface {
+	Encode(v interface{}) error
+}
+
+// StdJSONCodec is the default JSONCodec, backed by encoding/json
+type StdJSONCodec struct{}
+
+func (StdJSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (StdJSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (StdJSONCodec) NewEncoder(w io.Writer) JSONEncoder {
+	return json.NewEncoder(w)
+}
+
+// JSONCodecFuncs builds a JSONCodec out of plain functions, which is what most
+// of the json libraries expose. NewEncoder falls back to MarshalFunc.
+type JSONCodecFuncs struct {
+	MarshalFunc   func(v interface{}) ([]byte, error)
+	UnmarshalFunc func(data []byte, v interface{}) error
+}
+
+func (f JSONCodecFuncs) Marshal(v interface{}) ([]byte, error) {
+	return f.MarshalFunc(v)
+}
+
+func (f JSONCodecFuncs) Unmarshal(data []byte, v interface{}) error {
+	return f.UnmarshalFunc(data, v)
+}
+
+func (f JSONCodecFuncs) NewEncoder(w io.Writer) JSONEncoder {
+	return funcEncoder{w: w, marshal: f.MarshalFunc}
+}
+
+type funcEncoder struct {
+	w       io.Writer
+	marshal func(v interface{}) ([]byte, error)
+}
+
+func (e funcEncoder) Encode(v interface{}) error {
+	b, err := e.marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(append(b, '\n'))
+	return err
+}
+
+// JSONMarshalFunc and JSONUnmarshalFunc match the signatures exported by
+// encoding/json, jsoniter, go-json and sonic, e.g. config.JSONEncoder = sonic.Marshal
+type JSONMarshalFunc func(v interface{}) ([]byte, error)
+type JSONUnmarshalFunc func(data []byte, v interface{}) error
+
+// GetJSONCodec returns the codec configured in config or StdJSONCodec.
+// Config.JSONEncoder / Config.JSONDecoder override the matching half of it.
+func GetJSONCodec(config *Config) JSONCodec {
+	var codec JSONCodec = StdJSONCodec{}
+	if config == nil {
+		return codec
+	}
+	if config.JSONCodec != nil {
+		codec = config.JSONCodec
+	}
+	if config.JSONEncoder == nil && config.JSONDecoder == nil {
+		return codec
+	}
+
+	funcs := JSONCodecFuncs{MarshalFunc: codec.Marshal, UnmarshalFunc: codec.Unmarshal}
+	if config.JSONEncoder != nil {
+		funcs.MarshalFunc = config.JSONEncoder
+	}
+	if config.JSONDecoder != nil {
+		funcs.UnmarshalFunc = config.JSONDecoder
+	}
+	return funcs
+}
+
+// HasCustomJSON reports whether config replaces encoding/json in any way
+func HasCustomJSON(config *Config) bool {
+	return config != nil && (config.JSONCodec != nil || config.JSONEncoder != nil || config.JSONDecoder != nil)
+}
+
+// DebugJSONIndent returns the indent c.JSON should use, only set when the
+// server runs in Debug mode with Config.JSONIndent configured.
+func DebugJSONIndent(config *Config) string {
+	if config != nil && config.Debug {
+		return config.JSONIndent
+	}
+	return ""
+}
+
+// MarshalJSONIndent marshals data with codec and indents the result, for
+// c.PrettyJSON, c.JSONPretty and the Debug mode of c.JSON
+func MarshalJSONIndent(codec JSONCodec, data interface{}, indent string) ([]byte, error) {
+	b, err := codec.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, b, "", indent); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
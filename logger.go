@@ -55,15 +55,9 @@ func SimpleLog(log Logger) MiddlewareFunc {
 		return func(c Context) error {
 			start := time.Now()
 
-			// Get request ID from headers or generate new one
-			requestID := c.GetHeader(HEADER_REQUEST_ID)
+			requestID := ""
 			if log.IsPrintRequestID() {
-				if requestID == "" {
-					// requestID = GenerateRequestID()
-					requestID = "no-ID"
-				}
-			} else {
-				requestID = ""
+				requestID = logRequestID(c)
 			}
 
 			// Log request
@@ -74,6 +68,9 @@ func SimpleLog(log Logger) MiddlewareFunc {
 
 			// Log response
 			if log.IsAfterHandler() {
+				if log.IsPrintRequestID() {
+					requestID = logRequestID(c) // RequestID may run after the logger
+				}
 				duration := time.Since(start)
 				if err != nil {
 					log.Errorf("%s Failed %s %s - %v (%s)",
@@ -89,6 +86,13 @@ func SimpleLog(log Logger) MiddlewareFunc {
 	}
 }
 
+func logRequestID(c Context) string {
+	if rid := RequestIDOf(c); rid != "" {
+		return rid
+	}
+	return "no-ID"
+}
+
 // DefaultLogger holds configuration for DefaultLogger
 type DefaultLogger struct {
 	level  LogLevel
@@ -104,6 +108,7 @@ type DefaultLoggerConfig struct {
 	Prefix         string
 	Output         io.Writer
 	PrintRequestID bool
+	Clock          Clock // for the timestamps, nil means DefaultClock
 }
 
 // NewDefaultLogger creates a new DefaultLogger with optional configuration
@@ -130,13 +135,13 @@ func NewDefaultLogger(config ...*DefaultLoggerConfig) Logger {
 }
 
 func (l *DefaultLogger) formatMessage(v ...interface{}) string {
-	timestamp := time.Now().Format(l.config.TimeFormat)
+	timestamp := clockOr(l.config.Clock).Now().Format(l.config.TimeFormat)
 	// return fmt.Sprintf(" %s [%s] %s", timestamp, l.config.Prefix, fmt.Sprint(v...))
 	return fmt.Sprintf(" %s %s", timestamp, fmt.Sprint(v...))
 }
 
 func (l *DefaultLogger) formatMessagef(format string, v ...interface{}) string {
-	timestamp := time.Now().Format(l.config.TimeFormat)
+	timestamp := clockOr(l.config.Clock).Now().Format(l.config.TimeFormat)
 	message := fmt.Sprintf(format, v...)
 	// return fmt.Sprintf(" %s [%s] %s", timestamp, l.config.Prefix, message)
 	return fmt.Sprintf(" %s %s", timestamp, message)
@@ -0,0 +1,467 @@
+package simplehttp
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DEFAULT_METRICS_PREFIX         = "http"
+	DEFAULT_STATSD_ADDRESS         = "127.0.0.1:8125"
+	DEFAULT_STATSD_FLUSH_INTERVAL  = time.Second
+	DEFAULT_STATSD_MAX_PACKET_SIZE = 1432 // fits an ethernet frame
+	DEFAULT_PROMETHEUS_MAX_SERIES  = 10000
+)
+
+// DefaultHistogramBuckets are the upper bounds in seconds of the Prometheus
+// histograms of timings
+var DefaultHistogramBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// MetricsRecorder receives the metrics of MiddlewareMetrics, implement it to
+// send them to another backend. Names are dotted ("http.requests"), the
+// recorder converts them to its conventions.
+type MetricsRecorder interface {
+	Count(name string, value int64, tags map[string]string)
+	Gauge(name string, value float64, tags map[string]string)
+	Timing(name string, d time.Duration, tags map[string]string)
+}
+
+// MetricsConfig configures MiddlewareMetrics
+type MetricsConfig struct {
+	Recorder MetricsRecorder // required, usually Config.Metrics
+	Prefix   string          // of the metric names, default "http"
+	// RouteFunc names the route in the "route" tag, default RouteOf: the
+	// route pattern, "unmatched" for requests matching none, so the number
+	// of series stays bounded. Don't return the request path.
+	RouteFunc func(c Context) string
+	Skipper   Skipper
+}
+
+// MiddlewareMetrics records per request:
+//
+//	<prefix>.requests           count, tags method, route, status
+//	<prefix>.request.duration   timing, same tags
+//	<prefix>.requests.in_flight gauge
+//
+// The tags of MiddlewareRequestTags are added, so register that one first.
+//
+//	statsd, err := simplehttp.NewStatsDRecorder(simplehttp.StatsDConfig{Address: "127.0.0.1:8125"})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	config.Metrics = statsd
+//	server.Use(simplehttp.MiddlewareMetrics(simplehttp.MetricsConfig{Recorder: config.Metrics}))
+func MiddlewareMetrics(config MetricsConfig) Middleware {
+	return Skip(WithName("metrics", Metrics(config)), config.Skipper)
+}
+
+// Metrics records request counts, durations and requests in flight
+func Metrics(config MetricsConfig) MiddlewareFunc {
+	if config.Recorder == nil {
+		panic("simplehttp: MetricsConfig.Recorder is required")
+	}
+	if config.Prefix == "" {
+		config.Prefix = DEFAULT_METRICS_PREFIX
+	}
+	if config.RouteFunc == nil {
+		config.RouteFunc = RouteOf
+	}
+	requests := config.Prefix + ".requests"
+	duration := config.Prefix + ".request.duration"
+	inFlightName := config.Prefix + ".requests.in_flight"
+	var inFlight atomic.Int64
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			config.Recorder.Gauge(inFlightName, float64(inFlight.Add(1)), nil)
+			start := time.Now()
+			defer func() {
+				config.Recorder.Gauge(inFlightName, float64(inFlight.Add(-1)), nil)
+			}()
+
+			err := next(c)
+			tags := map[string]string{
+				"method": c.GetMethod(),
+				"route":  config.RouteFunc(c),
+				"status": strconv.Itoa(statusOf(c, err)),
+			}
+			for k, v := range GetRequestTags(c) {
+				if _, taken := tags[k]; !taken {
+					tags[k] = v
+				}
+			}
+			config.Recorder.Count(requests, 1, tags)
+			config.Recorder.Timing(duration, time.Since(start), tags)
+			return err
+		}
+	}
+}
+
+// MultiRecorder sends the metrics to every recorder, e.g. Prometheus and
+// StatsD during a migration
+func MultiRecorder(recorders ...MetricsRecorder) MetricsRecorder {
+	return multiRecorder(recorders)
+}
+
+type multiRecorder []MetricsRecorder
+
+func (m multiRecorder) Count(name string, value int64, tags map[string]string) {
+	for _, r := range m {
+		r.Count(name, value, tags)
+	}
+}
+
+func (m multiRecorder) Gauge(name string, value float64, tags map[string]string) {
+	for _, r := range m {
+		r.Gauge(name, value, tags)
+	}
+}
+
+func (m multiRecorder) Timing(name string, d time.Duration, tags map[string]string) {
+	for _, r := range m {
+		r.Timing(name, d, tags)
+	}
+}
+
+// StatsDTagFormat is how tags are written in StatsD lines, plain StatsD has
+// no tags
+type StatsDTagFormat int
+
+const (
+	StatsDTagsDogStatsD StatsDTagFormat = iota // name:1|c|#k:v,k2:v2 (Datadog)
+	StatsDTagsInflux                           // name,k=v,k2=v2:1|c (Telegraf)
+	StatsDTagsNone                             // name:1|c
+)
+
+// StatsDConfig configures NewStatsDRecorder
+type StatsDConfig struct {
+	Address       string            // UDP host:port of the agent, default 127.0.0.1:8125
+	Prefix        string            // prepended to every name, e.g. "orders."
+	Tags          map[string]string // added to every metric, e.g. {"env": "prod"}
+	TagFormat     StatsDTagFormat   // default DogStatsD
+	FlushInterval time.Duration     // default 1s
+	MaxPacketSize int               // default 1432 bytes
+}
+
+// StatsDRecorder sends metrics to a StatsD or DogStatsD agent over UDP. Lines
+// are batched into packets, sent when full and every FlushInterval. Sending
+// never blocks requests, metrics are dropped when the agent is unreachable.
+type StatsDRecorder struct {
+	config StatsDConfig
+	conn   net.Conn
+	mu     sync.Mutex
+	buf    []byte
+	done   chan struct{}
+	closed sync.Once
+}
+
+func NewStatsDRecorder(config StatsDConfig) (*StatsDRecorder, error) {
+	if config.Address == "" {
+		config.Address = DEFAULT_STATSD_ADDRESS
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = DEFAULT_STATSD_FLUSH_INTERVAL
+	}
+	if config.MaxPacketSize <= 0 {
+		config.MaxPacketSize = DEFAULT_STATSD_MAX_PACKET_SIZE
+	}
+	conn, err := net.Dial("udp", config.Address)
+	if err != nil {
+		return nil, err
+	}
+	s := &StatsDRecorder{config: config, conn: conn, done: make(chan struct{})}
+	go s.flushLoop()
+	return s, nil
+}
+
+func (s *StatsDRecorder) Count(name string, value int64, tags map[string]string) {
+	s.write(name, strconv.FormatInt(value, 10), "c", tags)
+}
+
+func (s *StatsDRecorder) Gauge(name string, value float64, tags map[string]string) {
+	s.write(name, strconv.FormatFloat(value, 'f', -1, 64), "g", tags)
+}
+
+func (s *StatsDRecorder) Timing(name string, d time.Duration, tags map[string]string) {
+	s.write(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64), "ms", tags)
+}
+
+// Flush sends the buffered lines now
+func (s *StatsDRecorder) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushLocked()
+}
+
+// Close flushes and stops the recorder
+func (s *StatsDRecorder) Close() error {
+	s.closed.Do(func() { close(s.done) })
+	s.Flush()
+	return s.conn.Close()
+}
+
+func (s *StatsDRecorder) write(name, value, kind string, tags map[string]string) {
+	line := s.line(name, value, kind, tags)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.buf) > 0 && len(s.buf)+1+len(line) > s.config.MaxPacketSize {
+		s.flushLocked()
+	}
+	if len(s.buf) > 0 {
+		s.buf = append(s.buf, '\n')
+	}
+	s.buf = append(s.buf, line...)
+}
+
+func (s *StatsDRecorder) line(name, value, kind string, tags map[string]string) string {
+	name = s.config.Prefix + name
+	merged := mergeTags(s.config.Tags, tags)
+	var b strings.Builder
+	switch s.config.TagFormat {
+	case StatsDTagsInflux:
+		b.WriteString(name)
+		for _, k := range sortedKeys(merged) {
+			b.WriteString("," + k + "=" + merged[k])
+		}
+		b.WriteString(":" + value + "|" + kind)
+	case StatsDTagsNone:
+		b.WriteString(name + ":" + value + "|" + kind)
+	default:
+		b.WriteString(name + ":" + value + "|" + kind)
+		for i, k := range sortedKeys(merged) {
+			if i == 0 {
+				b.WriteString("|#")
+			} else {
+				b.WriteByte(',')
+			}
+			b.WriteString(k + ":" + merged[k])
+		}
+	}
+	return b.String()
+}
+
+func (s *StatsDRecorder) flushLocked() {
+	if len(s.buf) == 0 {
+		return
+	}
+	s.conn.Write(s.buf) // UDP, a lost packet is a lost sample
+	s.buf = s.buf[:0]
+}
+
+func (s *StatsDRecorder) flushLoop() {
+	ticker := time.NewTicker(s.config.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.Flush()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// PrometheusRecorder keeps the metrics in memory and serves them in the
+// Prometheus text format: counts become counters ("_total"), timings
+// histograms in seconds. Dots in names become underscores. Beyond MaxSeries
+// the samples of new series are dropped and counted in
+// simplehttp_metrics_dropped_total, the memory stays bounded whatever the
+// tags.
+//
+//	prom := simplehttp.NewPrometheusRecorder()
+//	internal := simplehttp.CreateInternalAPI(server)
+//	internal.GET("/metrics", prom.Handler())
+type PrometheusRecorder struct {
+	// MaxSeries defaults to DEFAULT_PROMETHEUS_MAX_SERIES, set it before
+	// recording
+	MaxSeries int
+
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*promSeries
+	dropped uint64
+}
+
+type promSeries struct {
+	name   string
+	kind   string // counter, gauge or histogram
+	labels string
+	value  float64
+	counts []uint64 // per bucket, histograms only
+	count  uint64
+	sum    float64
+}
+
+// NewPrometheusRecorder uses DefaultHistogramBuckets without buckets
+func NewPrometheusRecorder(buckets ...float64) *PrometheusRecorder {
+	if len(buckets) == 0 {
+		buckets = DefaultHistogramBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &PrometheusRecorder{MaxSeries: DEFAULT_PROMETHEUS_MAX_SERIES, buckets: buckets, series: make(map[string]*promSeries)}
+}
+
+func (p *PrometheusRecorder) Count(name string, value int64, tags map[string]string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s := p.get(promName(name)+"_total", "counter", tags); s != nil {
+		s.value += float64(value)
+	}
+}
+
+func (p *PrometheusRecorder) Gauge(name string, value float64, tags map[string]string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s := p.get(promName(name), "gauge", tags); s != nil {
+		s.value = value
+	}
+}
+
+func (p *PrometheusRecorder) Timing(name string, d time.Duration, tags map[string]string) {
+	seconds := d.Seconds()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.get(promName(name)+"_seconds", "histogram", tags)
+	if s == nil {
+		return
+	}
+	for i, bound := range p.buckets {
+		if seconds <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += seconds
+}
+
+// get returns the series, nil when it is new and MaxSeries is reached
+func (p *PrometheusRecorder) get(name, kind string, tags map[string]string) *promSeries {
+	labels := promLabels(tags)
+	key := name + "{" + labels + "}"
+	s, ok := p.series[key]
+	if !ok {
+		if p.MaxSeries > 0 && len(p.series) >= p.MaxSeries {
+			p.dropped++
+			return nil
+		}
+		s = &promSeries{name: name, kind: kind, labels: labels}
+		if kind == "histogram" {
+			s.counts = make([]uint64, len(p.buckets))
+		}
+		p.series[key] = s
+	}
+	return s
+}
+
+// Handler serves the metrics, mount it on the internal API or behind auth
+func (p *PrometheusRecorder) Handler() HandlerFunc {
+	return func(c Context) error {
+		c.SetResponseHeader(HEADER_CONTENT_TYPE, "text/plain; version=0.0.4")
+		return c.String(http.StatusOK, p.Text())
+	}
+}
+
+// Text returns the metrics in the Prometheus text format
+func (p *PrometheusRecorder) Text() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	keys := make([]string, 0, len(p.series))
+	for key := range p.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	typed := make(map[string]bool)
+	for _, key := range keys {
+		s := p.series[key]
+		if !typed[s.name] {
+			fmt.Fprintf(&b, "# TYPE %s %s\n", s.name, s.kind)
+			typed[s.name] = true
+		}
+		if s.kind != "histogram" {
+			fmt.Fprintf(&b, "%s%s %s\n", s.name, braces(s.labels), promFloat(s.value))
+			continue
+		}
+		for i, bound := range p.buckets {
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", s.name, braces(joinLabels(s.labels, `le="`+promFloat(bound)+`"`)), s.counts[i])
+		}
+		fmt.Fprintf(&b, "%s_bucket%s %d\n", s.name, braces(joinLabels(s.labels, `le="+Inf"`)), s.count)
+		fmt.Fprintf(&b, "%s_sum%s %s\n", s.name, braces(s.labels), promFloat(s.sum))
+		fmt.Fprintf(&b, "%s_count%s %d\n", s.name, braces(s.labels), s.count)
+	}
+	if p.dropped > 0 {
+		fmt.Fprintf(&b, "# TYPE simplehttp_metrics_dropped_total counter\nsimplehttp_metrics_dropped_total %d\n", p.dropped)
+	}
+	return b.String()
+}
+
+func promName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == ':' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func promLabels(tags map[string]string) string {
+	parts := make([]string, 0, len(tags))
+	for _, k := range sortedKeys(tags) {
+		parts = append(parts, promName(k)+"="+strconv.Quote(tags[k]))
+	}
+	return strings.Join(parts, ",")
+}
+
+func promFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func joinLabels(labels, extra string) string {
+	if labels == "" {
+		return extra
+	}
+	return labels + "," + extra
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func mergeTags(base, tags map[string]string) map[string]string {
+	if len(base) == 0 {
+		return tags
+	}
+	merged := make(map[string]string, len(base)+len(tags))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	return merged
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,346 @@
+package simplehttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/medatechnology/simplehttp/client"
+)
+
+const (
+	DEFAULT_METRICS_PUSH_INTERVAL = 10 * time.Second
+	DEFAULT_OTLP_ENDPOINT         = "http://localhost:4318"
+	OTLP_METRICS_PATH             = "/v1/metrics"
+
+	// OTLP aggregation temporality, MetricsPusher sends the changes of each
+	// interval
+	otlpTemporalityDelta = 1
+)
+
+// MetricPoint is a metric aggregated over one push interval. Counters and
+// histograms hold what happened during the interval, gauges their last value.
+type MetricPoint struct {
+	Name  string
+	Kind  string // "counter", "gauge" or "histogram"
+	Tags  map[string]string
+	Value float64 // counters and gauges
+	// histograms, of timings in seconds. BucketCounts has one count per
+	// bound and one for above the last, not cumulative.
+	Count        uint64
+	Sum          float64
+	Min, Max     float64
+	Bounds       []float64
+	BucketCounts []uint64
+	Start, Time  time.Time // of the interval
+}
+
+// MetricsExporter sends the points of an interval to a backend
+type MetricsExporter interface {
+	Export(ctx context.Context, points []MetricPoint) error
+}
+
+// MetricsPushConfig configures NewMetricsPusher
+type MetricsPushConfig struct {
+	Exporter      MetricsExporter   // required, a StatsDExporter or OTLPExporter
+	FlushInterval time.Duration     // default 10s
+	Prefix        string            // prepended to every name, e.g. "orders."
+	Tags          map[string]string // added to every metric, e.g. {"env": "prod"}
+	Buckets       []float64         // of the timing histograms, default DefaultHistogramBuckets
+	Timeout       time.Duration     // of an export, default FlushInterval
+}
+
+// MetricsPusher is a MetricsRecorder that aggregates in memory and pushes
+// every FlushInterval, for environments where nothing scrapes /metrics.
+// Unlike StatsDRecorder, which sends every sample, one interval is one
+// export whatever the traffic. A failed export is logged and its points
+// dropped.
+//
+//	pusher := simplehttp.NewMetricsPusher(simplehttp.MetricsPushConfig{
+//		Exporter: simplehttp.NewOTLPExporter(simplehttp.OTLPConfig{Endpoint: "http://collector:4318"}),
+//	})
+//	defer pusher.Close()
+//	config.Metrics = pusher
+type MetricsPusher struct {
+	config MetricsPushConfig
+	mu     sync.Mutex
+	series map[string]*MetricPoint
+	start  time.Time
+	done   chan struct{}
+	closed sync.Once
+}
+
+func NewMetricsPusher(config MetricsPushConfig) *MetricsPusher {
+	if config.Exporter == nil {
+		panic("simplehttp: MetricsPushConfig.Exporter is required")
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = DEFAULT_METRICS_PUSH_INTERVAL
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = config.FlushInterval
+	}
+	if len(config.Buckets) == 0 {
+		config.Buckets = DefaultHistogramBuckets
+	}
+	config.Buckets = append([]float64(nil), config.Buckets...)
+	sort.Float64s(config.Buckets)
+	p := &MetricsPusher{config: config, series: make(map[string]*MetricPoint), start: time.Now(), done: make(chan struct{})}
+	go p.flushLoop()
+	return p
+}
+
+func (p *MetricsPusher) Count(name string, value int64, tags map[string]string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.get(name, "counter", tags).Value += float64(value)
+}
+
+func (p *MetricsPusher) Gauge(name string, value float64, tags map[string]string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.get(name, "gauge", tags).Value = value
+}
+
+func (p *MetricsPusher) Timing(name string, d time.Duration, tags map[string]string) {
+	seconds := d.Seconds()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	point := p.get(name, "histogram", tags)
+	i := sort.SearchFloat64s(point.Bounds, seconds)
+	point.BucketCounts[i]++
+	if point.Count == 0 || seconds < point.Min {
+		point.Min = seconds
+	}
+	if point.Count == 0 || seconds > point.Max {
+		point.Max = seconds
+	}
+	point.Count++
+	point.Sum += seconds
+}
+
+func (p *MetricsPusher) get(name, kind string, tags map[string]string) *MetricPoint {
+	name = p.config.Prefix + name
+	key := name + "{" + promLabels(tags) + "}"
+	point, ok := p.series[key]
+	if !ok {
+		// a copy, the caller may reuse its map
+		merged := make(map[string]string, len(p.config.Tags)+len(tags))
+		for k, v := range mergeTags(p.config.Tags, tags) {
+			merged[k] = v
+		}
+		point = &MetricPoint{Name: name, Kind: kind, Tags: merged}
+		if kind == "histogram" {
+			point.Bounds = p.config.Buckets
+			point.BucketCounts = make([]uint64, len(p.config.Buckets)+1)
+		}
+		p.series[key] = point
+	}
+	return point
+}
+
+// Flush exports the current interval now
+func (p *MetricsPusher) Flush() error {
+	now := time.Now()
+	p.mu.Lock()
+	points := make([]MetricPoint, 0, len(p.series))
+	for key, point := range p.series {
+		exported := *point
+		exported.Start, exported.Time = p.start, now
+		if point.Kind != "gauge" {
+			// counters and histograms start over, gauges keep their value
+			delete(p.series, key)
+		}
+		points = append(points, exported)
+	}
+	p.start = now
+	p.mu.Unlock()
+	if len(points) == 0 {
+		return nil
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].Name < points[j].Name })
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.config.Timeout)
+	defer cancel()
+	return p.config.Exporter.Export(ctx, points)
+}
+
+// Close pushes what is left and stops the pusher
+func (p *MetricsPusher) Close() error {
+	p.closed.Do(func() { close(p.done) })
+	return p.Flush()
+}
+
+func (p *MetricsPusher) flushLoop() {
+	ticker := time.NewTicker(p.config.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.Flush(); err != nil {
+				NewDefaultLogger().Errorf("metrics push: %v", err)
+			}
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// StatsDExporter pushes the aggregated points to a StatsD agent: counters
+// and gauges as such, histograms as <name>.count (counter) and <name>.avg,
+// <name>.max (gauges, milliseconds)
+type StatsDExporter struct {
+	recorder *StatsDRecorder
+}
+
+func NewStatsDExporter(config StatsDConfig) (*StatsDExporter, error) {
+	recorder, err := NewStatsDRecorder(config)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsDExporter{recorder: recorder}, nil
+}
+
+func (e *StatsDExporter) Export(ctx context.Context, points []MetricPoint) error {
+	for _, point := range points {
+		switch point.Kind {
+		case "counter":
+			e.recorder.Count(point.Name, int64(point.Value), point.Tags)
+		case "gauge":
+			e.recorder.Gauge(point.Name, point.Value, point.Tags)
+		case "histogram":
+			e.recorder.Count(point.Name+".count", int64(point.Count), point.Tags)
+			e.recorder.Gauge(point.Name+".avg", point.Sum/float64(point.Count)*1000, point.Tags)
+			e.recorder.Gauge(point.Name+".max", point.Max*1000, point.Tags)
+		}
+	}
+	e.recorder.Flush()
+	return nil
+}
+
+func (e *StatsDExporter) Close() error {
+	return e.recorder.Close()
+}
+
+// OTLPConfig configures NewOTLPExporter
+type OTLPConfig struct {
+	// Endpoint of the collector, default http://localhost:4318. /v1/metrics
+	// is added when it has no path.
+	Endpoint    string
+	Headers     map[string]string // e.g. an API key of the vendor
+	ServiceName string            // the service.name resource attribute
+	// Resource attributes besides service.name, e.g. {"deployment.environment": "prod"}
+	Resource map[string]string
+	Client   *client.Client // defaults to a client with a 10s timeout
+}
+
+// OTLPExporter pushes the points to an OpenTelemetry collector with
+// OTLP/HTTP in JSON. Points use delta temporality, use the
+// deltatocumulative processor of the collector for Prometheus backends.
+type OTLPExporter struct {
+	config   OTLPConfig
+	endpoint string
+}
+
+func NewOTLPExporter(config OTLPConfig) *OTLPExporter {
+	if config.Endpoint == "" {
+		config.Endpoint = DEFAULT_OTLP_ENDPOINT
+	}
+	if config.Client == nil {
+		config.Client = client.NewClient(client.WithTimeout(10*time.Second), client.WithMaxRetries(1))
+	}
+	endpoint := strings.TrimSuffix(config.Endpoint, "/")
+	if scheme := strings.Index(endpoint, "://"); !strings.Contains(endpoint[scheme+3:], "/") {
+		endpoint += OTLP_METRICS_PATH
+	}
+	return &OTLPExporter{config: config, endpoint: endpoint}
+}
+
+func (e *OTLPExporter) Export(ctx context.Context, points []MetricPoint) error {
+	options := []client.ClientOption{client.WithContext(ctx), client.WithJSONContentType()}
+	for key, value := range e.config.Headers {
+		options = append(options, client.WithHeader(key, value))
+	}
+	resp, err := e.config.Client.Request(http.MethodPost, e.endpoint, e.request(points), options...)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("otlp export: %s", resp.Status)
+	}
+	return nil
+}
+
+// request is an ExportMetricsServiceRequest in the JSON mapping of OTLP,
+// 64 bit integers are strings there
+func (e *OTLPExporter) request(points []MetricPoint) map[string]interface{} {
+	var metrics []interface{}
+	for _, point := range points {
+		dataPoint := map[string]interface{}{
+			"attributes":        otlpAttributes(point.Tags),
+			"startTimeUnixNano": strconv.FormatInt(point.Start.UnixNano(), 10),
+			"timeUnixNano":      strconv.FormatInt(point.Time.UnixNano(), 10),
+		}
+		metric := map[string]interface{}{"name": point.Name}
+		switch point.Kind {
+		case "counter":
+			dataPoint["asDouble"] = point.Value
+			metric["sum"] = map[string]interface{}{
+				"dataPoints":             []interface{}{dataPoint},
+				"aggregationTemporality": otlpTemporalityDelta,
+				"isMonotonic":            true,
+			}
+		case "gauge":
+			dataPoint["asDouble"] = point.Value
+			metric["gauge"] = map[string]interface{}{"dataPoints": []interface{}{dataPoint}}
+		case "histogram":
+			counts := make([]string, len(point.BucketCounts))
+			for i, count := range point.BucketCounts {
+				counts[i] = strconv.FormatUint(count, 10)
+			}
+			dataPoint["count"] = strconv.FormatUint(point.Count, 10)
+			dataPoint["sum"] = point.Sum
+			dataPoint["min"] = point.Min
+			dataPoint["max"] = point.Max
+			dataPoint["bucketCounts"] = counts
+			dataPoint["explicitBounds"] = point.Bounds
+			metric["unit"] = "s"
+			metric["histogram"] = map[string]interface{}{
+				"dataPoints":             []interface{}{dataPoint},
+				"aggregationTemporality": otlpTemporalityDelta,
+			}
+		}
+		metrics = append(metrics, metric)
+	}
+
+	resource := e.config.Resource
+	if e.config.ServiceName != "" {
+		resource = mergeTags(resource, map[string]string{"service.name": e.config.ServiceName})
+	}
+	return map[string]interface{}{
+		"resourceMetrics": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": otlpAttributes(resource)},
+			"scopeMetrics": []interface{}{map[string]interface{}{
+				"scope":   map[string]interface{}{"name": "simplehttp"},
+				"metrics": metrics,
+			}},
+		}},
+	}
+}
+
+func otlpAttributes(tags map[string]string) []interface{} {
+	attributes := make([]interface{}, 0, len(tags))
+	for _, key := range sortedKeys(tags) {
+		attributes = append(attributes, map[string]interface{}{
+			"key":   key,
+			"value": map[string]interface{}{"stringValue": tags[key]},
+		})
+	}
+	return attributes
+}
@@ -1,7 +1,6 @@
 package simplehttp
 
 import (
-	"context"
 	"fmt"
 	"net/http"
 	"runtime/debug"
@@ -32,10 +31,18 @@ var (
 	HEADER_ORIGIN         string = "Origin"
 )
 
+const (
+	// ContextKeyRequestID holds the request ID in the context, see RequestIDOf
+	ContextKeyRequestID   = "simplehttp.request_id"
+	MAX_REQUEST_ID_LENGTH = 128
+)
+
 // NamedMiddleware wraps a middleware with a name for debugging
 type NamedMiddleware struct {
 	name       string
 	middleware MiddlewareFunc
+	skipper    Skipper
+	diagnose   func(StartupInfo) []Diagnostic
 }
 
 // GetMiddlewareName returns the name of the middleware if it's a NamedMiddleware,
@@ -59,9 +66,132 @@ func WithName(name string, m MiddlewareFunc) NamedMiddleware {
 	}
 }
 
-// Implement the SimpleHttpMiddleware interface
+// Diagnose checks the config of the middleware at startup, see CheckStartup
+func (m NamedMiddleware) Diagnose(info StartupInfo) []Diagnostic {
+	if m.diagnose == nil {
+		return nil
+	}
+	return m.diagnose(info)
+}
+
+// withDiagnose adds the startup checks of a middleware
+func withDiagnose(m NamedMiddleware, diagnose func(StartupInfo) []Diagnostic) NamedMiddleware {
+	m.diagnose = diagnose
+	return m
+}
+
+// Implement the SimpleHttpMiddleware interface. Middleware switched off in
+// DefaultMiddlewareToggles are skipped, and so are those exempted for the
+// requests of a Scheduler.
 func (n NamedMiddleware) Handle(next HandlerFunc) HandlerFunc {
-	return n.middleware(next)
+	handler := n.middleware(next)
+	return func(c Context) error {
+		if !DefaultMiddlewareToggles.Enabled(n.name) || n.skipper != nil && n.skipper(c) || scheduledExempt(c, n.name) {
+			return next(c)
+		}
+		return handler(c)
+	}
+}
+
+// Chain wraps handler with middleware, the first one is outermost
+func Chain(handler HandlerFunc, middleware ...Middleware) HandlerFunc {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i].Handle(handler)
+	}
+	return handler
+}
+
+// Skipper returns true for requests that should bypass a middleware
+type Skipper func(Context) bool
+
+// Skip wraps any middleware so requests matching one of the skippers go
+// straight to the next handler, nil skippers are ignored.
+//
+//	server.Use(simplehttp.Skip(simplehttp.MiddlewareLogger(log), simplehttp.SkipPaths("/healthz", "/metrics")))
+func Skip(m Middleware, skippers ...Skipper) Middleware {
+	var active []Skipper
+	for _, s := range skippers {
+		if s != nil {
+			active = append(active, s)
+		}
+	}
+	if len(active) == 0 {
+		return m
+	}
+
+	named, ok := m.(NamedMiddleware)
+	if !ok {
+		named = NamedMiddleware{name: m.Name(), middleware: m.Handle}
+	}
+	if named.skipper != nil {
+		active = append(active, named.skipper)
+	}
+	named.skipper = func(c Context) bool {
+		for _, s := range active {
+			if s(c) {
+				return true
+			}
+		}
+		return false
+	}
+	return named
+}
+
+// SkipPaths matches exact paths, or path prefixes ending with "*" such as
+// "/static/*". It compares the path of the request URL, decoded and without
+// the query, the same on every adapter: "/users/42", never the route
+// pattern "/users/:id".
+func SkipPaths(paths ...string) Skipper {
+	match := matchPaths(paths...)
+	return func(c Context) bool {
+		return match(c.Request().URL.Path)
+	}
+}
+
+// matchPaths matches exact paths, or path prefixes ending with "*"
+func matchPaths(paths ...string) func(path string) bool {
+	exact := make(map[string]bool)
+	var prefixes []string
+	for _, p := range paths {
+		if strings.HasSuffix(p, "*") {
+			prefixes = append(prefixes, strings.TrimSuffix(p, "*"))
+		} else {
+			exact[p] = true
+		}
+	}
+	return func(path string) bool {
+		if exact[path] {
+			return true
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// If runs then when cond is true for the request, otherwise the else
+// middlewares (applied in order, the first one is outermost). Without else
+// middleware the request goes straight to the handler.
+//
+//	// anonymous requests are served from the cache, authenticated ones are not
+//	server.Use(simplehttp.If(isAnonymous, simplehttp.MiddlewareCache(cacheConfig)))
+func If(cond func(Context) bool, then Middleware, otherwise ...Middleware) Middleware {
+	return WithName("if "+then.Name(), func(next HandlerFunc) HandlerFunc {
+		thenHandler := then.Handle(next)
+		elseHandler := next
+		for i := len(otherwise) - 1; i >= 0; i-- {
+			elseHandler = otherwise[i].Handle(elseHandler)
+		}
+		return func(c Context) error {
+			if cond(c) {
+				return thenHandler(c)
+			}
+			return elseHandler(c)
+		}
+	})
 }
 
 type HeaderAuthorization struct {
@@ -95,6 +225,8 @@ type RequestHeader struct {
 	PlatformOSVersion string `db:"platform_os_version" json:"platform_os_version,omitempty"`
 	Platform          string `db:"platform"            json:"platform,omitempty"` // mobile, desktop, unknown
 	Device            string `db:"device"              json:"device,omitempty"`   // usually if mobile, this one has value
+
+	ipExtractor IPExtractor // set by the adapters from Config, see IP()
 }
 
 func (mh *RequestHeader) FromHttpRequest(stdRequest *http.Request) {
@@ -150,17 +282,19 @@ func (mh *RequestHeader) FromHttpRequest(stdRequest *http.Request) {
 	}
 }
 
+// IP returns the client IP using the extractor from Config.IPExtractor (or
+// Config.TrustedProxies). Without one, forwarded headers are spoofable so
+// only the address of the direct peer is used.
 func (mh *RequestHeader) IP() string {
-	// Are these IP variables more accurate? if not just return remoteIP which usually
-	// are not empty.
-	if mh.ConnectingIP != "" {
-		return mh.ConnectingIP
-	} else if mh.RealIP != "" {
-		return mh.RealIP
-	} else if mh.TrueIP != "" {
-		return mh.TrueIP
+	if mh.ipExtractor != nil {
+		return mh.ipExtractor(mh)
 	}
-	return mh.RemoteIP
+	return StripPort(mh.RemoteIP)
+}
+
+// SetIPExtractor sets how IP() finds the client IP
+func (mh *RequestHeader) SetIPExtractor(extractor IPExtractor) {
+	mh.ipExtractor = extractor
 }
 
 func MiddlewareHeaderParser() Middleware {
@@ -200,10 +334,15 @@ type CORSConfig struct {
 	ExposeHeaders    []string
 	AllowCredentials bool
 	MaxAge           time.Duration
+	Skipper          Skipper
 }
 
 func MiddlewareCORS(config *CORSConfig) Middleware {
-	return WithName("CORS bypass", CORS(config))
+	var skipper Skipper
+	if config != nil {
+		skipper = config.Skipper
+	}
+	return Skip(withDiagnose(WithName("CORS bypass", CORS(config)), corsDiagnostics(config)), skipper)
 }
 
 // CORS middleware returns a Middleware that adds CORS headers to the response
@@ -228,8 +367,6 @@ func CORS(config *CORSConfig) MiddlewareFunc {
 		config.AllowHeaders[i] = http.CanonicalHeaderKey(h)
 	}
 
-	// IMPORTANT: Remember to use context SetResponseHeader or SetRequestHeader!!!
-	//            doing c.Response().Header().Set() -- doesn't work, it will set a copy of header and won't persist
 	return func(next HandlerFunc) HandlerFunc {
 		return func(c Context) error {
 			// fmt.Println("--- cors middleware")
@@ -285,27 +422,6 @@ func getAllowedOrigin(allowedOrigins []string, origin string) string {
 	return allowedOrigins[0]
 }
 
-// Compression middleware configuration
-type CompressionConfig struct {
-	Level   int      // Compression level (1-9)
-	MinSize int64    // Minimum size to compress
-	Types   []string // Content types to compress
-}
-
-func MiddlewareCompress(config CompressionConfig) Middleware {
-	return WithName("compression", Compress(config))
-}
-
-// Compress returns a compression middleware
-func Compress(config CompressionConfig) MiddlewareFunc {
-	return func(next HandlerFunc) HandlerFunc {
-		return func(c Context) error {
-			// Implementation details for compression
-			return next(c)
-		}
-	}
-}
-
 func MiddlewareBasicAuth(username, password string) Middleware {
 	return WithName("basic auth", BasicAuth(username, password))
 }
@@ -344,62 +460,63 @@ func validateBasicAuth(auth, username, password string) bool {
 	}
 }
 
-func MiddlewareRequestID() Middleware {
-	return WithName("request ID", RequestID())
+// RequestIDConfig configures MiddlewareRequestID
+type RequestIDConfig struct {
+	// Generator makes the IDs of requests without a valid X-Request-ID,
+	// nil means GenerateRequestID. Pass Config.IDGenerator to use the one
+	// of the server, see IDGeneratorOf.
+	Generator IDGenerator
 }
 
-// RequestID middleware adds a unique ID to each request
-func RequestID() MiddlewareFunc {
+func MiddlewareRequestID(config ...RequestIDConfig) Middleware {
+	return WithName("request ID", RequestID(config...))
+}
+
+// RequestID middleware gives each request an ID: the X-Request-ID sent by the
+// client or proxy, or a new one. The ID is stored in the context under
+// ContextKeyRequestID, set on the request header for handlers and upstream
+// calls, and always echoed on the response.
+func RequestID(config ...RequestIDConfig) MiddlewareFunc {
+	generate := IDGenerator(GenerateRequestID)
+	if len(config) > 0 && config[0].Generator != nil {
+		generate = config[0].Generator
+	}
 	return func(next HandlerFunc) HandlerFunc {
 		return func(c Context) error {
-			// fmt.Println("--- requestID middleware")
-
 			rid := c.GetHeader(HEADER_REQUEST_ID)
-			if rid == "" {
-				rid = GenerateRequestID()
-				// fmt.Println("request id generated = ", rid)
-				c.SetHeader(HEADER_REQUEST_ID, rid)
-				// c.Response().Header().Set(HEADER_REQUEST_ID, rid)
-				// c.Request().Header.Set(HEADER_REQUEST_ID, rid)
-
+			if !validRequestID(rid) {
+				rid = generate()
 			}
-			// Test if requestID is actually there.
-			// testRID := c.GetHeader(HEADER_REQUEST_ID)
-			// testGet := c.Request().Header.Get(HEADER_REQUEST_ID)
-			// fmt.Println("======== TEST ID = [", testRID, "] ========== ", testGet)
+			c.Set(ContextKeyRequestID, rid)
+			c.SetHeader(HEADER_REQUEST_ID, rid)
 			return next(c)
 		}
 	}
 }
 
-func MiddlewareTimeout(config TimeOutConfig) Middleware {
-	return WithName("timeout middleware", Timeout(config))
+// RequestIDOf returns the ID set by the RequestID middleware, or the
+// X-Request-ID header of the request when the middleware did not run (yet)
+func RequestIDOf(c Context) string {
+	if rid, ok := c.Get(ContextKeyRequestID).(string); ok {
+		return rid
+	}
+	if rid := c.GetHeader(HEADER_REQUEST_ID); validRequestID(rid) {
+		return rid
+	}
+	return ""
 }
 
-// Timeout middleware adds a timeout to the request context
-func Timeout(config TimeOutConfig) MiddlewareFunc {
-	return func(next HandlerFunc) HandlerFunc {
-		return func(c Context) error {
-			// fmt.Println("--- timeout middleware")
-
-			ctx, cancel := context.WithTimeout(c.Context(), config.ReadTimeout)
-			defer cancel()
-
-			c.SetContext(ctx)
-
-			done := make(chan error, 1)
-			go func() {
-				done <- next(c)
-			}()
-
-			select {
-			case err := <-done:
-				return err
-			case <-ctx.Done():
-				return NewError(http.StatusGatewayTimeout, "request timeout")
-			}
+// validRequestID rejects IDs that would bloat or forge log lines
+func validRequestID(rid string) bool {
+	if rid == "" || len(rid) > MAX_REQUEST_ID_LENGTH {
+		return false
+	}
+	for i := 0; i < len(rid); i++ {
+		if rid[i] < '!' || rid[i] > '~' {
+			return false
 		}
 	}
+	return true
 }
 
 // RecoverConfig holds configuration for the Recover middleware
@@ -412,8 +529,21 @@ type RecoverConfig struct {
 	ErrorHandler func(c Context, err interface{}, stack []byte) error
 	// Logger for recording panic information
 	Logger Logger
+	// Reporter receives the panics, with their stack, and the errors of the
+	// handlers that end in a 5xx, with a nil stack, e.g. a SentryReporter
+	Reporter Reporter
+}
+
+// Reporter sends errors to an error tracker, see RecoverConfig.Reporter
+type Reporter interface {
+	Report(c Context, err interface{}, stack []byte)
 }
 
+// ReporterFunc adapts a function to a Reporter
+type ReporterFunc func(c Context, err interface{}, stack []byte)
+
+func (f ReporterFunc) Report(c Context, err interface{}, stack []byte) { f(c, err, stack) }
+
 func MiddlewareRecover(config ...RecoverConfig) Middleware {
 	return WithName("recover", Recover(config...))
 }
@@ -445,6 +575,9 @@ func Recover(config ...RecoverConfig) MiddlewareFunc {
 
 					// Log the panic
 					cfg.Logger.Errorf("[PANIC RECOVERED] %v\n%s", r, string(stack))
+					if cfg.Reporter != nil {
+						cfg.Reporter.Report(c, r, stack)
+					}
 
 					// Use custom error handler if provided
 					if cfg.ErrorHandler != nil {
@@ -471,7 +604,11 @@ func Recover(config ...RecoverConfig) MiddlewareFunc {
 				}
 			}()
 
-			return next(c)
+			err := next(c)
+			if err != nil && cfg.Reporter != nil && statusOf(c, err) >= http.StatusInternalServerError {
+				cfg.Reporter.Report(c, err, nil)
+			}
+			return err
 		}
 	}
 }
@@ -0,0 +1,52 @@
+package simplehttp_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/medatechnology/simplehttp"
+)
+
+// TestSkipPaths matches the request path, not the route pattern, on every
+// adapter
+func TestSkipPaths(t *testing.T) {
+	tests := []struct {
+		path string
+		skip bool
+	}{
+		{"/users/42", true},
+		{"/users/7", false},
+		{"/static/app.js", true},
+		{"/static", false},
+		{"/users/42?tab=1", true},
+	}
+	skipper := simplehttp.SkipPaths("/users/42", "/static/*")
+
+	for name, newServer := range adapters {
+		for _, tt := range tests {
+			t.Run(name+tt.path, func(t *testing.T) {
+				var skipped bool
+				server := newServer()
+				handler := func(c simplehttp.Context) error {
+					skipped = skipper(c)
+					return c.String(http.StatusOK, "ok")
+				}
+				server.GET("/users/:id", handler)
+				server.GET("/static/:file", handler)
+				server.GET("/static", handler)
+				resp, err := server.(simplehttp.Dispatcher).Dispatch(httptest.NewRequest(http.MethodGet, tt.path, nil))
+				if err != nil {
+					t.Fatalf("dispatch: %v", err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					t.Fatalf("status = %d, want 200", resp.StatusCode)
+				}
+				if skipped != tt.skip {
+					t.Errorf("skipped = %v, want %v", skipped, tt.skip)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package simplehttp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_MQTT_KEEP_ALIVE   = time.Minute
+	DEFAULT_MQTT_DIAL_TIMEOUT = 10 * time.Second
+	DEFAULT_MQTT_MAX_MESSAGE  = 256 << 10 // 256KB
+
+	// MQTT_IDENTITY in MQTTTopic.Broker is replaced by the identity of the
+	// request
+	MQTT_IDENTITY = "{identity}"
+)
+
+// MQTTBridgeConfig configures MQTTBridge
+type MQTTBridgeConfig struct {
+	// Broker is "host:port", or "tls://host:port" with TLSConfig, required
+	Broker    string
+	TLSConfig *tls.Config
+	// Topics the clients can use, the first mapping matching a topic wins,
+	// topics matching none are refused
+	Topics []MQTTTopic
+	// Identity of the request, defaults to the "sub" claim (MiddlewareOIDC)
+	// or a string principal (MiddlewareAPIKey). Requests without one get 401.
+	Identity func(c Context) string
+	// Credentials of the broker connection, defaults to the identity as user
+	// name without password, for brokers trusting the bridge
+	Credentials func(c Context, identity string) (username, password string, err error)
+	// ClientID of the broker connection, defaults to the identity and a
+	// random suffix so a user can have several tabs open
+	ClientID       func(identity string) string
+	KeepAlive      time.Duration // defaults to 1m
+	DialTimeout    time.Duration // connect and CONNACK, defaults to 10s
+	MaxMessageSize int           // payloads both ways, defaults to 256KB
+	// AllowedOrigins may connect from a browser besides the origin of the
+	// server, "*" allows any. Other origins get 403, so a page of another
+	// site can't ride the session of the victim, see TunnelConfig.
+	AllowedOrigins []string
+	// Dial replaces net.Dialer, e.g. for tests or a proxy
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// MQTTTopic maps topics of the WebSocket clients to topics of the broker by
+// prefix. With Client "sensors/" and Broker "tenants/{identity}/sensors/",
+// user acme subscribing to "sensors/#" gets "tenants/acme/sensors/#" and
+// sees its messages as "sensors/...", other tenants are out of reach.
+type MQTTTopic struct {
+	Client    string
+	Broker    string
+	Subscribe bool
+	Publish   bool
+}
+
+// MQTTMessage is the JSON exchanged with the WebSocket clients. Clients send
+// "subscribe", "unsubscribe" and "publish", the bridge sends "message",
+// "subscribed", "unsubscribed" and "error".
+//
+//	{"type": "subscribe", "topic": "sensors/#"}
+//	{"type": "publish", "topic": "sensors/42/cmd", "payload": "reboot"}
+//	{"type": "message", "topic": "sensors/42/temp", "payload": "21.5"}
+type MQTTMessage struct {
+	Type    string `json:"type"`
+	Topic   string `json:"topic,omitempty"`
+	Payload string `json:"payload,omitempty"`
+	Retain  bool   `json:"retain,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// MQTTBridge connects WebSocket clients to an MQTT broker, so dashboards use
+// the auth and sessions of the server instead of reaching the broker
+// directly. It is a normal route, the middleware runs before the upgrade,
+// and each client gets its own broker connection made with its identity.
+//
+//	api.GET("/mqtt", simplehttp.MQTTBridge(simplehttp.MQTTBridgeConfig{
+//		Broker: "localhost:1883",
+//		Topics: []simplehttp.MQTTTopic{
+//			{Client: "sensors/", Broker: "tenants/{identity}/sensors/", Subscribe: true},
+//			{Client: "commands/", Broker: "tenants/{identity}/commands/", Publish: true},
+//		},
+//	}), simplehttp.MiddlewareOIDC(oidcConfig))
+//
+// Browsers from other origins than the server and AllowedOrigins get 403, a
+// broker refusing the credentials gives 403, an unreachable one 502. It is
+// echo only, it needs Context.Upgrade.
+func MQTTBridge(config MQTTBridgeConfig) HandlerFunc {
+	if config.Broker == "" {
+		panic("simplehttp: MQTTBridgeConfig.Broker is required")
+	}
+	if config.Identity == nil {
+		config.Identity = requestSubject
+	}
+	if config.ClientID == nil {
+		config.ClientID = func(identity string) string { return identity + "-" + randomToken()[:8] }
+	}
+	if config.KeepAlive <= 0 {
+		config.KeepAlive = DEFAULT_MQTT_KEEP_ALIVE
+	}
+	if config.DialTimeout <= 0 {
+		config.DialTimeout = DEFAULT_MQTT_DIAL_TIMEOUT
+	}
+	if config.MaxMessageSize <= 0 {
+		config.MaxMessageSize = DEFAULT_MQTT_MAX_MESSAGE
+	}
+	if config.Dial == nil {
+		config.Dial = (&net.Dialer{}).DialContext
+	}
+
+	return func(c Context) error {
+		if err := checkWebSocketOrigin(c, config.AllowedOrigins); err != nil {
+			return err
+		}
+		identity := config.Identity(c)
+		if identity == "" {
+			return NewError(http.StatusUnauthorized, ErrUnauthorized.Error())
+		}
+		// the identity goes into topics, it can't widen them
+		if strings.ContainsAny(identity, "/+#\x00") {
+			return NewError(http.StatusForbidden, "identity can't be used in MQTT topics")
+		}
+		username, password := identity, ""
+		if config.Credentials != nil {
+			var err error
+			if username, password, err = config.Credentials(c, identity); err != nil {
+				return err
+			}
+		}
+
+		client, err := dialMQTT(c.Context(), config, config.ClientID(identity), username, password)
+		if err != nil {
+			var refused *mqttConnectError
+			if errors.As(err, &refused) && refused.unauthorized() {
+				return NewError(http.StatusForbidden, err.Error())
+			}
+			return NewError(http.StatusBadGateway, "mqtt broker unavailable", err.Error())
+		}
+		ws, err := c.Upgrade()
+		if err != nil {
+			client.disconnect()
+			return err
+		}
+		bridge := &mqttBridge{
+			ws:      ws,
+			client:  client,
+			topics:  expandMQTTTopics(config.Topics, identity),
+			maxSize: config.MaxMessageSize,
+			pending: make(map[uint16]MQTTMessage),
+		}
+		bridge.run()
+		return nil
+	}
+}
+
+func dialMQTT(ctx context.Context, config MQTTBridgeConfig, clientID, username, password string) (*mqttClient, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.DialTimeout)
+	defer cancel()
+	address, secure := strings.CutPrefix(config.Broker, "tls://")
+	address = strings.TrimPrefix(address, "tcp://")
+	conn, err := config.Dial(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if secure {
+		tlsConfig := config.TLSConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName, _, _ = net.SplitHostPort(address)
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	client := newMQTTClient(conn, config.KeepAlive, config.MaxMessageSize+len(config.Broker)+1024)
+	if err := client.connect(clientID, username, password, config.DialTimeout); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+func expandMQTTTopics(topics []MQTTTopic, identity string) []MQTTTopic {
+	expanded := make([]MQTTTopic, len(topics))
+	for i, topic := range topics {
+		topic.Client = strings.ReplaceAll(topic.Client, MQTT_IDENTITY, identity)
+		topic.Broker = strings.ReplaceAll(topic.Broker, MQTT_IDENTITY, identity)
+		expanded[i] = topic
+	}
+	return expanded
+}
+
+type mqttBridge struct {
+	ws      Websocket
+	client  *mqttClient
+	topics  []MQTTTopic
+	maxSize int
+
+	mu      sync.Mutex // serializes the websocket writes and guards pending
+	pending map[uint16]MQTTMessage
+}
+
+// run relays until the client or the broker goes away
+func (b *mqttBridge) run() {
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		b.fromBroker()
+		// unblocks the websocket reader below
+		b.ws.Close()
+	}()
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(b.client.keepAlive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				b.client.ping()
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	b.fromClient()
+	close(stop)
+	b.client.disconnect()
+	wg.Wait()
+	b.ws.Close()
+}
+
+func (b *mqttBridge) fromClient() {
+	for {
+		var msg MQTTMessage
+		if err := b.ws.ReadJSON(&msg); err != nil {
+			return
+		}
+		switch msg.Type {
+		case "subscribe", "unsubscribe":
+			filter, ok := b.toBroker(msg.Topic, true)
+			if !ok {
+				b.send(MQTTMessage{Type: "error", Topic: msg.Topic, Error: "topic not allowed"})
+				continue
+			}
+			// reserved first, the ack can be read before the write returns
+			id := b.client.packetID()
+			b.mu.Lock()
+			b.pending[id] = MQTTMessage{Type: msg.Type + "d", Topic: msg.Topic}
+			b.mu.Unlock()
+			var err error
+			if msg.Type == "subscribe" {
+				err = b.client.subscribe(id, filter)
+			} else {
+				err = b.client.unsubscribe(id, filter)
+			}
+			if err != nil {
+				return
+			}
+		case "publish":
+			topic, ok := b.toBroker(msg.Topic, false)
+			if !ok || strings.ContainsAny(topic, "+#") {
+				b.send(MQTTMessage{Type: "error", Topic: msg.Topic, Error: "topic not allowed"})
+				continue
+			}
+			if len(msg.Payload) > b.maxSize {
+				b.send(MQTTMessage{Type: "error", Topic: msg.Topic, Error: "payload too large"})
+				continue
+			}
+			if err := b.client.publish(topic, []byte(msg.Payload), msg.Retain); err != nil {
+				return
+			}
+		default:
+			b.send(MQTTMessage{Type: "error", Error: "unknown message type " + msg.Type})
+		}
+	}
+}
+
+func (b *mqttBridge) fromBroker() {
+	for {
+		packet, err := b.client.read()
+		if err != nil {
+			return
+		}
+		switch packet.kind {
+		case mqttPublish:
+			topic, payload, err := b.client.parsePublish(packet)
+			if err != nil {
+				return
+			}
+			if clientTopic, ok := b.toClient(topic); ok {
+				b.send(MQTTMessage{Type: "message", Topic: clientTopic, Payload: string(payload), Retain: packet.flags&0x01 != 0})
+			}
+		case mqttSuback, mqttUnsuback:
+			if len(packet.body) < 2 {
+				return
+			}
+			id := uint16(packet.body[0])<<8 | uint16(packet.body[1])
+			b.mu.Lock()
+			reply, ok := b.pending[id]
+			delete(b.pending, id)
+			b.mu.Unlock()
+			if !ok {
+				continue
+			}
+			// 0x80 is a refused subscription
+			if packet.kind == mqttSuback && len(packet.body) > 2 && packet.body[2] == 0x80 {
+				reply = MQTTMessage{Type: "error", Topic: reply.Topic, Error: "subscription refused by the broker"}
+			}
+			b.send(reply)
+		}
+	}
+}
+
+// toBroker maps a client topic (or filter) through the first mapping
+// allowing it
+func (b *mqttBridge) toBroker(topic string, subscribe bool) (string, bool) {
+	if topic == "" {
+		return "", false
+	}
+	for _, mapping := range b.topics {
+		if (subscribe && !mapping.Subscribe) || (!subscribe && !mapping.Publish) {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(topic, mapping.Client); ok {
+			return mapping.Broker + rest, true
+		}
+	}
+	return "", false
+}
+
+func (b *mqttBridge) toClient(topic string) (string, bool) {
+	for _, mapping := range b.topics {
+		if !mapping.Subscribe {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(topic, mapping.Broker); ok {
+			return mapping.Client + rest, true
+		}
+	}
+	return "", false
+}
+
+func (b *mqttBridge) send(msg MQTTMessage) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.ws.WriteJSON(msg)
+}
@@ -0,0 +1,255 @@
+package simplehttp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// A minimal MQTT 3.1.1 client for MQTTBridge: clean sessions, QoS 0
+// publish and subscribe, keep alive
+
+// MQTT control packet types
+const (
+	mqttConnect     = 1
+	mqttConnack     = 2
+	mqttPublish     = 3
+	mqttPuback      = 4
+	mqttSubscribe   = 8
+	mqttSuback      = 9
+	mqttUnsubscribe = 10
+	mqttUnsuback    = 11
+	mqttPingreq     = 12
+	mqttDisconnect  = 14
+)
+
+// mqttConnectError is a CONNACK refusing the connection
+type mqttConnectError struct {
+	code byte
+}
+
+func (e *mqttConnectError) Error() string {
+	reasons := map[byte]string{
+		1: "unacceptable protocol version",
+		2: "client identifier rejected",
+		3: "server unavailable",
+		4: "bad user name or password",
+		5: "not authorized",
+	}
+	if reason, ok := reasons[e.code]; ok {
+		return "mqtt: connection refused, " + reason
+	}
+	return fmt.Sprintf("mqtt: connection refused, code %d", e.code)
+}
+
+// unauthorized reports a refusal caused by the credentials
+func (e *mqttConnectError) unauthorized() bool {
+	return e.code == 4 || e.code == 5
+}
+
+type mqttPacket struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+type mqttClient struct {
+	conn      net.Conn
+	r         *bufio.Reader
+	keepAlive time.Duration
+	maxSize   int
+
+	mu     sync.Mutex // serializes writes
+	nextID uint16
+}
+
+func newMQTTClient(conn net.Conn, keepAlive time.Duration, maxSize int) *mqttClient {
+	return &mqttClient{conn: conn, r: bufio.NewReader(conn), keepAlive: keepAlive, maxSize: maxSize}
+}
+
+// connect sends CONNECT with a clean session and waits for the CONNACK
+func (m *mqttClient) connect(clientID, username, password string, timeout time.Duration) error {
+	flags := byte(0x02) // clean session
+	if username != "" {
+		flags |= 0x80
+		if password != "" {
+			flags |= 0x40
+		}
+	}
+	body := appendMQTTString(nil, "MQTT")
+	body = append(body, 4, flags) // protocol level 4 is 3.1.1
+	body = binary.BigEndian.AppendUint16(body, uint16(m.keepAlive/time.Second))
+	body = appendMQTTString(body, clientID)
+	if flags&0x80 != 0 {
+		body = appendMQTTString(body, username)
+	}
+	if flags&0x40 != 0 {
+		body = appendMQTTString(body, password)
+	}
+	if err := m.write(mqttConnect, 0, body); err != nil {
+		return err
+	}
+
+	m.conn.SetReadDeadline(time.Now().Add(timeout))
+	packet, err := m.read()
+	m.conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return err
+	}
+	if packet.kind != mqttConnack || len(packet.body) < 2 {
+		return errors.New("mqtt: expected CONNACK")
+	}
+	if code := packet.body[1]; code != 0 {
+		return &mqttConnectError{code: code}
+	}
+	return nil
+}
+
+// subscribe asks for filter at QoS 0, the SUBACK comes through read with
+// id, from packetID. Expect it before calling, it can arrive before
+// subscribe returns.
+func (m *mqttClient) subscribe(id uint16, filter string) error {
+	body := binary.BigEndian.AppendUint16(nil, id)
+	body = appendMQTTString(body, filter)
+	body = append(body, 0)
+	return m.write(mqttSubscribe, 0x02, body)
+}
+
+func (m *mqttClient) unsubscribe(id uint16, filter string) error {
+	body := binary.BigEndian.AppendUint16(nil, id)
+	body = appendMQTTString(body, filter)
+	return m.write(mqttUnsubscribe, 0x02, body)
+}
+
+// publish sends a QoS 0 message
+func (m *mqttClient) publish(topic string, payload []byte, retain bool) error {
+	var flags byte
+	if retain {
+		flags = 0x01
+	}
+	body := appendMQTTString(nil, topic)
+	return m.write(mqttPublish, flags, append(body, payload...))
+}
+
+func (m *mqttClient) ping() error {
+	return m.write(mqttPingreq, 0, nil)
+}
+
+// disconnect says goodbye to the broker and closes the connection
+func (m *mqttClient) disconnect() {
+	m.write(mqttDisconnect, 0, nil)
+	m.conn.Close()
+}
+
+// read returns the next packet, packets over maxSize are skipped. A broker
+// silent for 1.5 keep alive periods, PINGRESP included, is gone.
+func (m *mqttClient) read() (mqttPacket, error) {
+	for {
+		if m.keepAlive > 0 {
+			m.conn.SetReadDeadline(time.Now().Add(m.keepAlive * 3 / 2))
+		}
+		first, err := m.r.ReadByte()
+		if err != nil {
+			return mqttPacket{}, err
+		}
+		length, multiplier := 0, 1
+		for i := 0; ; i++ {
+			b, err := m.r.ReadByte()
+			if err != nil {
+				return mqttPacket{}, err
+			}
+			length += int(b&0x7f) * multiplier
+			if b&0x80 == 0 {
+				break
+			}
+			if i == 3 {
+				return mqttPacket{}, errors.New("mqtt: malformed remaining length")
+			}
+			multiplier *= 128
+		}
+		if m.maxSize > 0 && length > m.maxSize {
+			if _, err := io.CopyN(io.Discard, m.r, int64(length)); err != nil {
+				return mqttPacket{}, err
+			}
+			continue
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(m.r, body); err != nil {
+			return mqttPacket{}, err
+		}
+		return mqttPacket{kind: first >> 4, flags: first & 0x0f, body: body}, nil
+	}
+}
+
+// parsePublish splits a PUBLISH into its topic and payload, acknowledging
+// QoS 1 messages
+func (m *mqttClient) parsePublish(packet mqttPacket) (string, []byte, error) {
+	topic, rest, err := readMQTTString(packet.body)
+	if err != nil {
+		return "", nil, err
+	}
+	if qos := (packet.flags >> 1) & 0x03; qos > 0 {
+		if len(rest) < 2 {
+			return "", nil, errors.New("mqtt: malformed PUBLISH")
+		}
+		if qos == 1 {
+			m.write(mqttPuback, 0, rest[:2])
+		}
+		rest = rest[2:]
+	}
+	return topic, rest, nil
+}
+
+func (m *mqttClient) write(kind, flags byte, body []byte) error {
+	packet := appendMQTTLength([]byte{kind<<4 | flags}, len(body))
+	packet = append(packet, body...)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := m.conn.Write(packet)
+	return err
+}
+
+func (m *mqttClient) packetID() uint16 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	if m.nextID == 0 {
+		m.nextID = 1
+	}
+	return m.nextID
+}
+
+func appendMQTTLength(b []byte, length int) []byte {
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if length == 0 {
+			return b
+		}
+	}
+}
+
+func appendMQTTString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func readMQTTString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, errors.New("mqtt: malformed string")
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, errors.New("mqtt: malformed string")
+	}
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
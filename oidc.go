@@ -0,0 +1,513 @@
+package simplehttp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	REQUEST_OIDC_CLAIMS_STRING string = "oidc_claims"
+
+	// Session keys used by the authorization-code flow
+	SESSION_OIDC_STATE    = "oidc_state"
+	SESSION_OIDC_NONCE    = "oidc_nonce"
+	SESSION_OIDC_RETURN   = "oidc_return"
+	SESSION_OIDC_IDENTITY = "oidc_identity"
+	SESSION_OIDC_EXPIRES  = "oidc_expires" // unix seconds, when the identity must log in again
+)
+
+const (
+	DEFAULT_OIDC_JWKS_REFRESH = 5 * time.Minute
+	OIDC_DISCOVERY_PATH       = "/.well-known/openid-configuration"
+)
+
+// OIDCConfig configures MiddlewareOIDC and the login/callback handlers
+type OIDCConfig struct {
+	// Issuer is matched exactly against the iss of tokens and the issuer of
+	// the discovery document, trailing slash included, e.g.
+	// https://accounts.example.com
+	Issuer   string
+	Audience string // expected aud of access tokens, defaults to ClientID
+	JWKSURL  string // optional, discovered from the issuer when empty
+
+	// Authorization-code flow, only needed for LoginHandler/CallbackHandler
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string   // the public URL of the callback route
+	Scopes       []string // defaults to openid, profile, email
+
+	// Session returns the session of the request. When set, the middleware also
+	// accepts requests whose session holds an identity from the callback.
+	Session func(c Context) Session
+	// RegenerateSession gives the session a new id after the callback, so a
+	// session id planted before the login is worthless after it. Defaults to
+	// RegenerateSession, for the sessions of MiddlewareSession.
+	RegenerateSession func(c Context) (Session, error)
+	// IdentityMaxAge is how long the session identity stays logged in,
+	// defaults to the expiry of the ID token
+	IdentityMaxAge time.Duration
+
+	HTTPClient  *http.Client
+	JWKSRefresh time.Duration // minimum time between JWKS refreshes on unknown kid
+	Skipper     Skipper
+}
+
+// OIDCProvider validates tokens of one issuer, keys are fetched lazily and
+// refreshed when a token is signed with an unknown key id.
+type OIDCProvider struct {
+	config OIDCConfig
+
+	mu          sync.RWMutex
+	discovery   *oidcDiscovery
+	keys        map[string]interface{}
+	lastRefresh time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+func NewOIDCProvider(config OIDCConfig) *OIDCProvider {
+	if config.Audience == "" {
+		config.Audience = config.ClientID
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "profile", "email"}
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if config.JWKSRefresh == 0 {
+		config.JWKSRefresh = DEFAULT_OIDC_JWKS_REFRESH
+	}
+	return &OIDCProvider{config: config, keys: make(map[string]interface{})}
+}
+
+func MiddlewareOIDC(config OIDCConfig) Middleware {
+	return Skip(NewOIDCProvider(config).Middleware(), config.Skipper)
+}
+
+// Middleware requires a valid bearer access token (or, with Session set, a
+// logged in session). Claims are available with GetOIDCClaims and the subject
+// with GetPrincipal.
+func (p *OIDCProvider) Middleware() Middleware {
+	return WithName("oidc", func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			authType, token, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+			if strings.EqualFold(authType, "Bearer") && token != "" {
+				claims, err := p.VerifyToken(c.Context(), strings.TrimSpace(token), p.config.Audience)
+				if err != nil {
+					return NewError(http.StatusUnauthorized, ErrUnauthorized.Error(), err.Error())
+				}
+				setOIDCClaims(c, claims)
+				return next(c)
+			}
+
+			if p.config.Session != nil {
+				if session := p.config.Session(c); session != nil {
+					if claims, ok := session.Get(SESSION_OIDC_IDENTITY).(map[string]interface{}); ok {
+						expires, ok := unixSeconds(session.Get(SESSION_OIDC_EXPIRES))
+						if ok && clockFor(c, nil).Now().Unix() < expires {
+							setOIDCClaims(c, jwt.MapClaims(claims))
+							return next(c)
+						}
+						// expired, the user logs in again
+						session.Delete(SESSION_OIDC_IDENTITY)
+						session.Delete(SESSION_OIDC_EXPIRES)
+					}
+				}
+			}
+			return NewError(http.StatusUnauthorized, ErrUnauthorized.Error())
+		}
+	})
+}
+
+// GetOIDCClaims returns the verified claims of the request, nil when the
+// request did not go through the OIDC middleware
+func GetOIDCClaims(c Context) jwt.MapClaims {
+	claims, _ := c.Get(REQUEST_OIDC_CLAIMS_STRING).(jwt.MapClaims)
+	return claims
+}
+
+func setOIDCClaims(c Context, claims jwt.MapClaims) {
+	c.Set(REQUEST_OIDC_CLAIMS_STRING, claims)
+	c.Set(REQUEST_PRINCIPAL_STRING, claims["sub"])
+}
+
+// VerifyToken checks signature, expiry, issuer and audience of a JWT
+func (p *OIDCProvider) VerifyToken(ctx context.Context, raw, audience string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "PS256", "PS384", "PS512"}))
+	_, err := parser.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !claims.VerifyIssuer(p.config.Issuer, true) {
+		return nil, errors.New("invalid issuer")
+	}
+	if audience != "" && !claims.VerifyAudience(audience, true) {
+		return nil, errors.New("invalid audience")
+	}
+	return claims, nil
+}
+
+// LoginHandler redirects to the issuer to start the authorization-code flow.
+// ?return=/path is where the callback sends the user afterwards.
+func (p *OIDCProvider) LoginHandler() HandlerFunc {
+	return func(c Context) error {
+		session, err := p.session(c)
+		if err != nil {
+			return err
+		}
+		disc, err := p.getDiscovery(c.Context())
+		if err != nil {
+			return NewError(http.StatusServiceUnavailable, "identity provider unavailable", err.Error())
+		}
+
+		state, nonce := randomToken(), randomToken()
+		session.Set(SESSION_OIDC_STATE, state)
+		session.Set(SESSION_OIDC_NONCE, nonce)
+		if ret := c.GetQueryParam("return"); strings.HasPrefix(ret, "/") && !strings.HasPrefix(ret, "//") {
+			session.Set(SESSION_OIDC_RETURN, ret)
+		}
+		if err := session.Save(); err != nil {
+			return err
+		}
+
+		q := url.Values{
+			"response_type": {"code"},
+			"client_id":     {p.config.ClientID},
+			"redirect_uri":  {p.config.RedirectURL},
+			"scope":         {strings.Join(p.config.Scopes, " ")},
+			"state":         {state},
+			"nonce":         {nonce},
+		}
+		return redirect(c, disc.AuthorizationEndpoint+"?"+q.Encode())
+	}
+}
+
+// CallbackHandler exchanges the code for tokens, verifies the ID token and
+// stores its claims in the session
+func (p *OIDCProvider) CallbackHandler() HandlerFunc {
+	return func(c Context) error {
+		session, err := p.session(c)
+		if err != nil {
+			return err
+		}
+		if e := c.GetQueryParam("error"); e != "" {
+			return NewError(http.StatusUnauthorized, "login failed", e)
+		}
+		state, _ := session.Get(SESSION_OIDC_STATE).(string)
+		if state == "" || c.GetQueryParam("state") != state {
+			return NewError(http.StatusBadRequest, "invalid state")
+		}
+		session.Delete(SESSION_OIDC_STATE)
+
+		idToken, err := p.exchangeCode(c.Context(), c.GetQueryParam("code"))
+		if err != nil {
+			return NewError(http.StatusUnauthorized, "login failed", err.Error())
+		}
+		claims, err := p.VerifyToken(c.Context(), idToken, p.config.ClientID)
+		if err != nil {
+			return NewError(http.StatusUnauthorized, "login failed", err.Error())
+		}
+		if nonce, _ := session.Get(SESSION_OIDC_NONCE).(string); nonce == "" || claims["nonce"] != nonce {
+			return NewError(http.StatusUnauthorized, "login failed", "invalid nonce")
+		}
+		session.Delete(SESSION_OIDC_NONCE)
+		expires, ok := unixSeconds(claims["exp"])
+		if p.config.IdentityMaxAge > 0 {
+			expires, ok = clockFor(c, nil).Now().Add(p.config.IdentityMaxAge).Unix(), true
+		}
+		if !ok {
+			return NewError(http.StatusUnauthorized, "login failed", "id token without exp")
+		}
+		if session, err = p.renewSession(c, session); err != nil {
+			return err
+		}
+
+		session.Set(SESSION_OIDC_IDENTITY, map[string]interface{}(claims))
+		session.Set(SESSION_OIDC_EXPIRES, expires)
+		target, _ := session.Get(SESSION_OIDC_RETURN).(string)
+		session.Delete(SESSION_OIDC_RETURN)
+		if err := session.Save(); err != nil {
+			return err
+		}
+		if target == "" {
+			target = "/"
+		}
+		return redirect(c, target)
+	}
+}
+
+func (p *OIDCProvider) session(c Context) (Session, error) {
+	if p.config.Session == nil {
+		return nil, NewError(http.StatusInternalServerError, "oidc: OIDCConfig.Session is required for the login flow")
+	}
+	session := p.config.Session(c)
+	if session == nil {
+		return nil, NewError(http.StatusInternalServerError, "oidc: no session for request")
+	}
+	return session, nil
+}
+
+// renewSession regenerates the session id on login, session when the
+// session can't be regenerated (not one of MiddlewareSession)
+func (p *OIDCProvider) renewSession(c Context, session Session) (Session, error) {
+	regenerate := p.config.RegenerateSession
+	if regenerate == nil {
+		regenerate = RegenerateSession
+	}
+	renewed, err := regenerate(c)
+	if err != nil {
+		return nil, err
+	}
+	if renewed == nil {
+		return session, nil
+	}
+	return renewed, nil
+}
+
+func (p *OIDCProvider) exchangeCode(ctx context.Context, code string) (string, error) {
+	if code == "" {
+		return "", errors.New("missing code")
+	}
+	disc, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {p.config.RedirectURL},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, disc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := p.fetchJSON(req, &token); err != nil {
+		return "", err
+	}
+	if token.IDToken == "" {
+		return "", errors.New("no id_token in token response")
+	}
+	return token.IDToken, nil
+}
+
+func (p *OIDCProvider) getDiscovery(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.RLock()
+	disc := p.discovery
+	p.mu.RUnlock()
+	if disc != nil {
+		return disc, nil
+	}
+
+	// only the URL ignores a trailing slash of the issuer
+	discoveryURL := strings.TrimSuffix(p.config.Issuer, "/") + OIDC_DISCOVERY_PATH
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	disc = &oidcDiscovery{}
+	if err := p.fetchJSON(req, disc); err != nil {
+		return nil, err
+	}
+	// OpenID Connect Discovery 4.3, a document naming another issuer is
+	// from the wrong provider or an impostor
+	if disc.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("discovery issuer %q doesn't match %q", disc.Issuer, p.config.Issuer)
+	}
+	if p.config.JWKSURL != "" {
+		disc.JWKSURI = p.config.JWKSURL
+	}
+
+	p.mu.Lock()
+	p.discovery = disc
+	p.mu.Unlock()
+	return disc, nil
+}
+
+// key returns the verification key for kid, refreshing the JWKS when the key
+// is unknown (key rotation) at most once per JWKSRefresh
+func (p *OIDCProvider) key(ctx context.Context, kid string) (interface{}, error) {
+	p.mu.RLock()
+	key, ok := p.keys[kid]
+	stale := time.Since(p.lastRefresh) >= p.config.JWKSRefresh
+	p.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+	if !stale {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	if err := p.refreshKeys(ctx); err != nil {
+		return nil, err
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+func (p *OIDCProvider) refreshKeys(ctx context.Context) error {
+	jwksURL := p.config.JWKSURL
+	if jwksURL == "" {
+		disc, err := p.getDiscovery(ctx)
+		if err != nil {
+			return err
+		}
+		jwksURL = disc.JWKSURI
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURL, nil)
+	if err != nil {
+		return err
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.fetchJSON(req, &set); err != nil {
+		return err
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.lastRefresh = time.Now()
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *OIDCProvider) fetchJSON(req *http.Request, v interface{}) error {
+	resp, err := p.config.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s", req.Method, req.URL, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// unixSeconds reads a time stored as unix seconds, as the session stores
+// and JSON decoding give it back
+func unixSeconds(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	case float64:
+		return int64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return int64(f), err == nil
+	}
+	return 0, false
+}
+
+func randomToken() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func redirect(c Context, location string) error {
+	c.SetResponseHeader("Location", location)
+	return c.String(http.StatusFound, "")
+}
@@ -0,0 +1,231 @@
+package simplehttp_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/medatechnology/simplehttp"
+)
+
+// testIssuer is an identity provider serving discovery, the JWKS and a token
+// endpoint answering with the ID token of idToken
+type testIssuer struct {
+	*httptest.Server
+	key     *rsa.PrivateKey
+	issuer  string // in the discovery document
+	idToken func() string
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &testIssuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc(simplehttp.OIDC_DISCOVERY_PATH, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.issuer,
+			"authorization_endpoint": issuer.URL + "/authorize",
+			"token_endpoint":         issuer.URL + "/token",
+			"jwks_uri":               issuer.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		encode := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kid": "k1",
+			"kty": "RSA",
+			"use": "sig",
+			"n":   encode(key.N.Bytes()),
+			"e":   encode(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"id_token": issuer.idToken()})
+	})
+	issuer.Server = httptest.NewServer(mux)
+	issuer.issuer = issuer.URL
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+func (i *testIssuer) sign(t *testing.T, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	raw, err := token.SignedString(i.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestOIDCVerifyToken(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		issuer    func(url string) string // configured
+		discovery func(url string) string // in the discovery document
+		kid       string
+		claims    func(url string) jwt.MapClaims
+		wantErr   bool
+	}{
+		{
+			name: "valid",
+			claims: func(u string) jwt.MapClaims {
+				return jwt.MapClaims{"iss": u, "aud": "api", "sub": "ann", "exp": now.Add(time.Hour).Unix()}
+			},
+		},
+		{
+			name:      "issuer with trailing slash",
+			issuer:    func(u string) string { return u + "/" },
+			discovery: func(u string) string { return u + "/" },
+			claims: func(u string) jwt.MapClaims {
+				return jwt.MapClaims{"iss": u + "/", "aud": "api", "exp": now.Add(time.Hour).Unix()}
+			},
+		},
+		{
+			name:   "iss without the trailing slash of the issuer",
+			issuer: func(u string) string { return u + "/" },
+			claims: func(u string) jwt.MapClaims {
+				return jwt.MapClaims{"iss": u, "aud": "api", "exp": now.Add(time.Hour).Unix()}
+			},
+			discovery: func(u string) string { return u + "/" },
+			wantErr:   true,
+		},
+		{
+			name:      "discovery of another issuer",
+			discovery: func(u string) string { return "https://evil.example.com" },
+			claims: func(u string) jwt.MapClaims {
+				return jwt.MapClaims{"iss": u, "aud": "api", "exp": now.Add(time.Hour).Unix()}
+			},
+			wantErr: true,
+		},
+		{
+			name: "expired",
+			claims: func(u string) jwt.MapClaims {
+				return jwt.MapClaims{"iss": u, "aud": "api", "exp": now.Add(-time.Minute).Unix()}
+			},
+			wantErr: true,
+		},
+		{
+			name: "wrong issuer",
+			claims: func(u string) jwt.MapClaims {
+				return jwt.MapClaims{"iss": "https://other.example.com", "aud": "api", "exp": now.Add(time.Hour).Unix()}
+			},
+			wantErr: true,
+		},
+		{
+			name: "wrong audience",
+			claims: func(u string) jwt.MapClaims {
+				return jwt.MapClaims{"iss": u, "aud": "other", "exp": now.Add(time.Hour).Unix()}
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown key id",
+			kid:  "k2",
+			claims: func(u string) jwt.MapClaims {
+				return jwt.MapClaims{"iss": u, "aud": "api", "exp": now.Add(time.Hour).Unix()}
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newTestIssuer(t)
+			configured := issuer.URL
+			if tt.issuer != nil {
+				configured = tt.issuer(issuer.URL)
+			}
+			if tt.discovery != nil {
+				issuer.issuer = tt.discovery(issuer.URL)
+			}
+			kid := tt.kid
+			if kid == "" {
+				kid = "k1"
+			}
+			provider := simplehttp.NewOIDCProvider(simplehttp.OIDCConfig{Issuer: configured, Audience: "api"})
+			_, err := provider.VerifyToken(context.Background(), issuer.sign(t, kid, tt.claims(issuer.URL)), "api")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestOIDCSessionExpiry logs in with the authorization-code flow, the
+// session identity works until the ID token expires
+func TestOIDCSessionExpiry(t *testing.T) {
+	for name, newServer := range adapters {
+		t.Run(name, func(t *testing.T) {
+			clock := simplehttp.NewFakeClock(time.Now())
+			issuer := newTestIssuer(t)
+			session := simplehttp.NewMemorySession("s1")
+			issuer.idToken = func() string {
+				nonce, _ := session.Get(simplehttp.SESSION_OIDC_NONCE).(string)
+				return issuer.sign(t, "k1", jwt.MapClaims{
+					"iss": issuer.URL, "aud": "dashboard", "sub": "ann", "nonce": nonce,
+					"exp": time.Now().Add(time.Hour).Unix(),
+				})
+			}
+			provider := simplehttp.NewOIDCProvider(simplehttp.OIDCConfig{
+				Issuer:      issuer.URL,
+				ClientID:    "dashboard",
+				RedirectURL: "http://app/callback",
+				Session:     func(c simplehttp.Context) simplehttp.Session { return session },
+			})
+
+			server := newServer(simplehttp.WithConfig(func(c *simplehttp.Config) { c.Clock = clock }))
+			server.GET("/login", provider.LoginHandler())
+			server.GET("/callback", provider.CallbackHandler())
+			server.GET("/private", func(c simplehttp.Context) error {
+				return c.String(http.StatusOK, simplehttp.GetOIDCClaims(c)["sub"].(string))
+			}, provider.Middleware())
+
+			get := func(target string) int {
+				t.Helper()
+				resp, err := server.(simplehttp.Dispatcher).Dispatch(httptest.NewRequest(http.MethodGet, target, nil))
+				if err != nil {
+					t.Fatalf("dispatch: %v", err)
+				}
+				resp.Body.Close()
+				return resp.StatusCode
+			}
+
+			if status := get("/private"); status != http.StatusUnauthorized {
+				t.Fatalf("before login: status = %d, want 401", status)
+			}
+			if status := get("/login"); status != http.StatusFound {
+				t.Fatalf("login: status = %d, want 302", status)
+			}
+			state, _ := session.Get(simplehttp.SESSION_OIDC_STATE).(string)
+			if status := get("/callback?" + url.Values{"state": {state}, "code": {"c"}}.Encode()); status != http.StatusFound {
+				t.Fatalf("callback: status = %d, want 302", status)
+			}
+			if status := get("/private"); status != http.StatusOK {
+				t.Fatalf("after login: status = %d, want 200", status)
+			}
+			clock.Advance(2 * time.Hour)
+			if status := get("/private"); status != http.StatusUnauthorized {
+				t.Errorf("after expiry: status = %d, want 401", status)
+			}
+			if session.Get(simplehttp.SESSION_OIDC_IDENTITY) != nil {
+				t.Error("expired identity is still in the session")
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package simplehttp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	DEFAULT_PAGE_LIMIT = 20
+	MAX_PAGE_LIMIT     = 100
+
+	QUERY_CURSOR = "cursor"
+	QUERY_LIMIT  = "limit"
+)
+
+// CursorCodec encodes pagination state (e.g. the sort key of the last row)
+// into opaque, HMAC signed cursors so clients can't forge or tamper with them
+// and offsets are never exposed.
+//
+//	type userCursor struct{ CreatedAt time.Time; ID int64 }
+//	cursors := simplehttp.NewCursorCodec([]byte(os.Getenv("CURSOR_SECRET")))
+type CursorCodec struct {
+	secret []byte
+}
+
+func NewCursorCodec(secret []byte) *CursorCodec {
+	return &CursorCodec{secret: secret}
+}
+
+// Encode returns the cursor for v, v is JSON encoded
+func (cc *CursorCodec) Encode(v interface{}) (string, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(cc.sign(payload)), nil
+}
+
+// Decode verifies the cursor and decodes it into v, a bad cursor is a 400
+func (cc *CursorCodec) Decode(cursor string, v interface{}) error {
+	invalid := NewError(http.StatusBadRequest, "invalid cursor")
+	data, sig, ok := strings.Cut(cursor, ".")
+	if !ok {
+		return invalid
+	}
+	enc := base64.RawURLEncoding
+	payload, err := enc.DecodeString(data)
+	if err != nil {
+		return invalid
+	}
+	mac, err := enc.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, cc.sign(payload)) {
+		return invalid
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		return invalid
+	}
+	return nil
+}
+
+func (cc *CursorCodec) sign(payload []byte) []byte {
+	h := hmac.New(sha256.New, cc.secret)
+	h.Write(payload)
+	return h.Sum(nil)
+}
+
+// PageRequest is the pagination part of a list query: ?cursor=...&limit=...
+type PageRequest struct {
+	Cursor string
+	Limit  int
+}
+
+// ParsePageRequest reads cursor and limit from the query string. Limit falls
+// back to defaultLimit (DEFAULT_PAGE_LIMIT when 0) and is capped at maxLimit
+// (MAX_PAGE_LIMIT when 0).
+func ParsePageRequest(c Context, defaultLimit, maxLimit int) (PageRequest, error) {
+	if defaultLimit <= 0 {
+		defaultLimit = DEFAULT_PAGE_LIMIT
+	}
+	if maxLimit <= 0 {
+		maxLimit = MAX_PAGE_LIMIT
+	}
+	page := PageRequest{Cursor: c.GetQueryParam(QUERY_CURSOR), Limit: defaultLimit}
+	if raw := c.GetQueryParam(QUERY_LIMIT); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			return page, NewError(http.StatusBadRequest, "invalid limit", raw)
+		}
+		page.Limit = min(limit, maxLimit)
+	}
+	return page, nil
+}
+
+// DecodeCursor decodes the request cursor into v, false when the request had
+// none (first page)
+func (p PageRequest) DecodeCursor(cc *CursorCodec, v interface{}) (bool, error) {
+	if p.Cursor == "" {
+		return false, nil
+	}
+	return true, cc.Decode(p.Cursor, v)
+}
+
+// PageInfo is the pagination block of the response envelope
+type PageInfo struct {
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+	Limit      int    `json:"limit"`
+}
+
+// Page is the response envelope of a cursor paginated list
+type Page struct {
+	Data       interface{} `json:"data"`
+	Pagination PageInfo    `json:"pagination"`
+}
+
+// NewPage builds the envelope. Fetch limit+1 rows to know whether there is
+// a next page, pass the first limit rows as data and, when hasMore, the
+// state of the last returned row as next.
+//
+//	rows := store.ListUsers(after, page.Limit+1)
+//	hasMore := len(rows) > page.Limit
+//	if hasMore { rows = rows[:page.Limit] }
+//	last := rows[len(rows)-1]
+//	resp, err := simplehttp.NewPage(cursors, rows, page.Limit, hasMore, userCursor{last.CreatedAt, last.ID})
+func NewPage(cc *CursorCodec, data interface{}, limit int, hasMore bool, next interface{}) (Page, error) {
+	page := Page{Data: data, Pagination: PageInfo{HasMore: hasMore, Limit: limit}}
+	if hasMore && next != nil {
+		cursor, err := cc.Encode(next)
+		if err != nil {
+			return page, err
+		}
+		page.Pagination.NextCursor = cursor
+	}
+	return page, nil
+}
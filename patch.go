@@ -0,0 +1,462 @@
+package simplehttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	CONTENT_TYPE_JSON_PATCH  = "application/json-patch+json"
+	CONTENT_TYPE_MERGE_PATCH = "application/merge-patch+json"
+)
+
+var (
+	ErrPatchInvalid = errors.New("invalid patch")
+	// ErrPatchTestFailed means a "test" operation did not match, the resource
+	// changed since the client read it
+	ErrPatchTestFailed = errors.New("patch test failed")
+	// ErrPatchPath means an operation points to a location that does not exist
+	ErrPatchPath = errors.New("patch path not found")
+)
+
+// PatchOperation is one operation of a JSON Patch (RFC 6902)
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// BindJSONPatch applies the JSON Patch (RFC 6902) in the request body to
+// target, a pointer to the current struct or map. The patch is all or
+// nothing: target is only changed when every operation succeeds.
+//
+//	server.PATCH("/users/:id", func(c simplehttp.Context) error {
+//		user, _ := store.User(userID(c))
+//		if err := simplehttp.BindJSONPatch(c, &user); err != nil {
+//			return err // 400, 409 for a failed "test", 415 or 422
+//		}
+//		...
+//	})
+//
+// Run validation on target afterwards, the patch may produce any value the
+// JSON decoding of target accepts.
+func BindJSONPatch(c Context, target interface{}) error {
+	if err := patchContentType(c, CONTENT_TYPE_JSON_PATCH, CONTENT_TYPE_JSON); err != nil {
+		return err
+	}
+	return bindPatch(target, c.GetBody(), ApplyJSONPatch)
+}
+
+// BindMergePatch applies the JSON Merge Patch (RFC 7386) in the request body
+// to target: fields of the patch replace those of target, null removes them
+// and nested objects are merged.
+func BindMergePatch(c Context, target interface{}) error {
+	if err := patchContentType(c, CONTENT_TYPE_MERGE_PATCH, CONTENT_TYPE_JSON); err != nil {
+		return err
+	}
+	return bindPatch(target, c.GetBody(), ApplyMergePatch)
+}
+
+// ApplyJSONPatch applies a JSON Patch to a JSON document
+func ApplyJSONPatch(doc, patch []byte) ([]byte, error) {
+	var ops []PatchOperation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPatchInvalid, err)
+	}
+	node, err := decodeJSONNumber(doc)
+	if err != nil {
+		return nil, err
+	}
+	for i, op := range ops {
+		if node, err = applyPatchOperation(node, op); err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	return json.Marshal(node)
+}
+
+// ApplyMergePatch applies a JSON Merge Patch to a JSON document
+func ApplyMergePatch(doc, patch []byte) ([]byte, error) {
+	node, err := decodeJSONNumber(doc)
+	if err != nil {
+		return nil, err
+	}
+	patchNode, err := decodeJSONNumber(patch)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPatchInvalid, err)
+	}
+	return json.Marshal(mergePatch(node, patchNode))
+}
+
+func patchContentType(c Context, accepted ...string) error {
+	contentType := c.GetHeader(HEADER_CONTENT_TYPE)
+	mediaType := normalizeContentType(contentType)
+	for _, a := range accepted {
+		if mediaType == a {
+			return nil
+		}
+	}
+	return NewError(http.StatusUnsupportedMediaType, "unsupported content type "+contentType, accepted)
+}
+
+// bindPatch runs apply on the JSON of target and decodes the result back
+func bindPatch(target interface{}, patch []byte, apply func(doc, patch []byte) ([]byte, error)) error {
+	value := reflect.ValueOf(target)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return fmt.Errorf("simplehttp: patch target must be a non-nil pointer, got %T", target)
+	}
+	doc, err := json.Marshal(target)
+	if err != nil {
+		return err
+	}
+	patched, err := apply(doc, patch)
+	switch {
+	case errors.Is(err, ErrPatchInvalid):
+		return NewError(http.StatusBadRequest, "invalid patch", err.Error())
+	case errors.Is(err, ErrPatchTestFailed):
+		return NewError(http.StatusConflict, "patch test failed", err.Error())
+	case errors.Is(err, ErrPatchPath):
+		return NewError(http.StatusUnprocessableEntity, "patch can't be applied", err.Error())
+	case err != nil:
+		return err
+	}
+
+	// decode onto a copy of target, so fields JSON doesn't see (unexported
+	// or tagged "-") keep their value, with the JSON fields cleared first so
+	// removed ones don't keep theirs
+	patchedValue := reflect.New(value.Elem().Type())
+	patchedValue.Elem().Set(value.Elem())
+	clearJSONFields(patchedValue.Elem())
+	if err := json.Unmarshal(patched, patchedValue.Interface()); err != nil {
+		return NewError(http.StatusUnprocessableEntity, "patch can't be applied", err.Error())
+	}
+	value.Elem().Set(patchedValue.Elem())
+	return nil
+}
+
+// clearJSONFields zeroes what encoding/json reads into v, nested structs
+// field by field
+func clearJSONFields(v reflect.Value) {
+	if v.Kind() != reflect.Struct {
+		v.Set(reflect.Zero(v.Type()))
+		return
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Tag.Get("json") == "-" {
+			continue
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			// embedded structs promote their fields, exported or not
+			clearJSONFields(v.Field(i))
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		clearJSONFields(v.Field(i))
+	}
+}
+
+func decodeJSONNumber(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var node interface{}
+	if err := decoder.Decode(&node); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+func applyPatchOperation(doc interface{}, op PatchOperation) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("%w: value is required", ErrPatchInvalid)
+		}
+		if value, err = decodeJSONNumber(op.Value); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrPatchInvalid, err)
+		}
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		if value, err = pointerGet(doc, from); err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if strings.HasPrefix(op.Path+"/", op.From+"/") && op.Path != op.From {
+				return nil, fmt.Errorf("%w: can't move a value into itself", ErrPatchInvalid)
+			}
+			if doc, err = pointerRemove(doc, from); err != nil {
+				return nil, err
+			}
+		} else {
+			value = copyJSON(value)
+		}
+	}
+
+	switch op.Op {
+	case "add", "move", "copy":
+		return pointerAdd(doc, path, value)
+	case "remove":
+		return pointerRemove(doc, path)
+	case "replace":
+		if len(path) == 0 {
+			return value, nil
+		}
+		return withParent(doc, path, func(parent interface{}, key string) (interface{}, error) {
+			switch node := parent.(type) {
+			case map[string]interface{}:
+				if _, ok := node[key]; !ok {
+					return nil, ErrPatchPath
+				}
+				node[key] = value
+			case []interface{}:
+				i, err := arrayIndex(key, len(node)-1)
+				if err != nil {
+					return nil, err
+				}
+				node[i] = value
+			default:
+				return nil, ErrPatchPath
+			}
+			return parent, nil
+		})
+	case "test":
+		current, err := pointerGet(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(current, value) {
+			return nil, ErrPatchTestFailed
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown op %q", ErrPatchInvalid, op.Op)
+	}
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901), "" is the whole document
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: path %q must start with /", ErrPatchInvalid, pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func pointerGet(doc interface{}, path []string) (interface{}, error) {
+	for _, key := range path {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			child, ok := node[key]
+			if !ok {
+				return nil, ErrPatchPath
+			}
+			doc = child
+		case []interface{}:
+			i, err := arrayIndex(key, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, ErrPatchPath
+		}
+	}
+	return doc, nil
+}
+
+func pointerAdd(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return withParent(doc, path, func(parent interface{}, key string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			node[key] = value
+			return node, nil
+		case []interface{}:
+			i := len(node)
+			if key != "-" {
+				var err error
+				if i, err = arrayIndex(key, len(node)); err != nil {
+					return nil, err
+				}
+			}
+			node = append(node, nil)
+			copy(node[i+1:], node[i:])
+			node[i] = value
+			return node, nil
+		}
+		return nil, ErrPatchPath
+	})
+}
+
+func pointerRemove(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("%w: can't remove the whole document", ErrPatchInvalid)
+	}
+	return withParent(doc, path, func(parent interface{}, key string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := node[key]; !ok {
+				return nil, ErrPatchPath
+			}
+			delete(node, key)
+			return node, nil
+		case []interface{}:
+			i, err := arrayIndex(key, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			return append(node[:i], node[i+1:]...), nil
+		}
+		return nil, ErrPatchPath
+	})
+}
+
+// withParent calls fn with the container of the last path element and stores
+// what fn returns in its place, arrays change when they grow or shrink
+func withParent(doc interface{}, path []string, fn func(parent interface{}, key string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		child, ok := node[path[0]]
+		if !ok {
+			return nil, ErrPatchPath
+		}
+		child, err := withParent(child, path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		node[path[0]] = child
+		return node, nil
+	case []interface{}:
+		i, err := arrayIndex(path[0], len(node)-1)
+		if err != nil {
+			return nil, err
+		}
+		child, err := withParent(node[i], path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = child
+		return node, nil
+	}
+	return nil, ErrPatchPath
+}
+
+// arrayIndex parses an array index between 0 and max, leading zeros are not
+// allowed by RFC 6901
+func arrayIndex(key string, max int) (int, error) {
+	i, err := strconv.Atoi(key)
+	if err != nil || i < 0 || (len(key) > 1 && key[0] == '0') {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrPatchInvalid, key)
+	}
+	if i > max {
+		return 0, ErrPatchPath
+	}
+	return i, nil
+}
+
+func copyJSON(v interface{}) interface{} {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(node))
+		for k, child := range node {
+			out[k] = copyJSON(child)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(node))
+		for i, child := range node {
+			out[i] = copyJSON(child)
+		}
+		return out
+	}
+	return v
+}
+
+// jsonEqual compares two decoded documents, numbers by value so 1 equals 1.0
+func jsonEqual(a, b interface{}) bool {
+	switch x := a.(type) {
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		if x == y {
+			return true
+		}
+		fx, errX := x.Float64()
+		fy, errY := y.Float64()
+		return errX == nil && errY == nil && fx == fy
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			if w, ok := y[k]; !ok || !jsonEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !jsonEqual(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// mergePatch implements RFC 7386 section 2
+func mergePatch(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = make(map[string]interface{})
+	}
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+		} else {
+			targetObj[key] = mergePatch(targetObj[key], value)
+		}
+	}
+	return targetObj
+}
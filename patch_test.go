@@ -0,0 +1,148 @@
+package simplehttp_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/medatechnology/simplehttp"
+)
+
+type patchAddress struct {
+	City   string `json:"city"`
+	secret string
+}
+
+type patchUser struct {
+	Name     string       `json:"name"`
+	Email    string       `json:"email,omitempty"`
+	Tags     []string     `json:"tags"`
+	Address  patchAddress `json:"address"`
+	Password string       `json:"-"`
+	version  int
+}
+
+func TestBindPatch(t *testing.T) {
+	original := func() patchUser {
+		return patchUser{
+			Name:     "ann",
+			Email:    "ann@example.com",
+			Tags:     []string{"a"},
+			Address:  patchAddress{City: "Oslo", secret: "s"},
+			Password: "hash",
+			version:  3,
+		}
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		patch       string
+		status      int
+		want        func(u *patchUser)
+	}{
+		{
+			name:        "merge replaces a field",
+			contentType: simplehttp.CONTENT_TYPE_MERGE_PATCH,
+			patch:       `{"name":"bob"}`,
+			status:      http.StatusOK,
+			want:        func(u *patchUser) { u.Name = "bob" },
+		},
+		{
+			name:        "merge null removes a field",
+			contentType: simplehttp.CONTENT_TYPE_MERGE_PATCH,
+			patch:       `{"email":null,"tags":null}`,
+			status:      http.StatusOK,
+			want:        func(u *patchUser) { u.Email = ""; u.Tags = nil },
+		},
+		{
+			name:        "merge nested object",
+			contentType: simplehttp.CONTENT_TYPE_MERGE_PATCH,
+			patch:       `{"address":{"city":"Bergen"}}`,
+			status:      http.StatusOK,
+			want:        func(u *patchUser) { u.Address.City = "Bergen" },
+		},
+		{
+			name:        "merge can't set a hidden field",
+			contentType: simplehttp.CONTENT_TYPE_MERGE_PATCH,
+			patch:       `{"Password":"x","version":9}`,
+			status:      http.StatusOK,
+			want:        func(u *patchUser) {},
+		},
+		{
+			name:        "json patch add and remove",
+			contentType: simplehttp.CONTENT_TYPE_JSON_PATCH,
+			patch:       `[{"op":"add","path":"/tags/-","value":"b"},{"op":"remove","path":"/email"}]`,
+			status:      http.StatusOK,
+			want:        func(u *patchUser) { u.Tags = []string{"a", "b"}; u.Email = "" },
+		},
+		{
+			name:        "json patch failed test",
+			contentType: simplehttp.CONTENT_TYPE_JSON_PATCH,
+			patch:       `[{"op":"test","path":"/name","value":"bob"},{"op":"replace","path":"/name","value":"eve"}]`,
+			status:      http.StatusConflict,
+		},
+		{
+			name:        "json patch missing path",
+			contentType: simplehttp.CONTENT_TYPE_JSON_PATCH,
+			patch:       `[{"op":"replace","path":"/nope","value":1}]`,
+			status:      http.StatusUnprocessableEntity,
+		},
+		{
+			name:        "json patch invalid",
+			contentType: simplehttp.CONTENT_TYPE_JSON_PATCH,
+			patch:       `{"op":"add"}`,
+			status:      http.StatusBadRequest,
+		},
+		{
+			name:        "wrong content type",
+			contentType: "text/plain",
+			patch:       `{"name":"bob"}`,
+			status:      http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for name, newServer := range adapters {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				user := original()
+				server := newServer()
+				server.PATCH("/user", func(c simplehttp.Context) error {
+					var err error
+					if strings.HasPrefix(c.GetHeader(simplehttp.HEADER_CONTENT_TYPE), simplehttp.CONTENT_TYPE_JSON_PATCH) {
+						err = simplehttp.BindJSONPatch(c, &user)
+					} else {
+						err = simplehttp.BindMergePatch(c, &user)
+					}
+					if err != nil {
+						return err
+					}
+					return c.String(http.StatusOK, "ok")
+				})
+				req := httptest.NewRequest(http.MethodPatch, "/user", strings.NewReader(tt.patch))
+				req.Header.Set(simplehttp.HEADER_CONTENT_TYPE, tt.contentType)
+				resp, err := server.(simplehttp.Dispatcher).Dispatch(req)
+				if err != nil {
+					t.Fatalf("dispatch: %v", err)
+				}
+				defer resp.Body.Close()
+				if resp.StatusCode != tt.status {
+					t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+				}
+
+				// failed patches leave the user as it was
+				want := original()
+				if tt.want != nil {
+					tt.want(&want)
+				}
+				if !reflect.DeepEqual(user, want) {
+					got, _ := json.Marshal(user)
+					t.Errorf("user = %s %+v, want %+v", got, user, want)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package simplehttp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	PROFILE_CPU  = "cpu"
+	PROFILE_HEAP = "heap"
+
+	DEFAULT_PROFILE_DURATION = 30 * time.Second
+	MAX_PROFILE_DURATION     = 5 * time.Minute
+
+	// PROFILE_LABEL_ROUTE labels the CPU samples of the profiled requests,
+	// go tool pprof -tagfocus route=/api/search keeps only them
+	PROFILE_LABEL_ROUTE = "route"
+)
+
+// PathInternalProfile starts and downloads profiles under PathInternalAPI,
+// see CreateInternalAPI
+var PathInternalProfile string = "/profile"
+
+var (
+	ErrProfileRunning = errors.New("a profile is already running")
+	ErrProfileKind    = errors.New("unknown profile kind, use cpu or heap")
+)
+
+// ProfileStatus describes the last profile of a Profiler
+type ProfileStatus struct {
+	Kind    string    `json:"kind"`
+	Route   string    `json:"route,omitempty"`
+	Started time.Time `json:"started"`
+	Ends    time.Time `json:"ends"`
+	Running bool      `json:"running"`
+	Size    int       `json:"size"` // bytes of the finished profile
+	Error   string    `json:"error,omitempty"`
+}
+
+// Profiler takes one CPU or heap profile at a time over a window, on demand
+// in production. The CPU samples of the requests of the window are labelled
+// with their route by MiddlewareProfiler, a route given to Start limits
+// the labels to the requests of that route (SkipPaths patterns). A heap
+// profile is a snapshot at the end of the window, the one of its start is
+// kept as base to see what the window allocated.
+type Profiler struct {
+	mu      sync.Mutex
+	status  ProfileStatus
+	profile []byte
+	base    []byte
+	// the requests to label, nil outside of a CPU profile
+	labels atomic.Pointer[Skipper]
+}
+
+// DefaultProfiler is the one of the internal API
+var DefaultProfiler = &Profiler{}
+
+// Start begins a profile of kind for duration, DEFAULT_PROFILE_DURATION when
+// 0 and at most MAX_PROFILE_DURATION. The previous profile is dropped.
+func (p *Profiler) Start(kind string, duration time.Duration, route string) (ProfileStatus, error) {
+	if kind != PROFILE_CPU && kind != PROFILE_HEAP {
+		return ProfileStatus{}, ErrProfileKind
+	}
+	if duration <= 0 {
+		duration = DEFAULT_PROFILE_DURATION
+	}
+	if duration > MAX_PROFILE_DURATION {
+		duration = MAX_PROFILE_DURATION
+	}
+	// kept past the request, the fiber and fasthttp strings aren't
+	kind, route = strings.Clone(kind), strings.Clone(route)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.status.Running {
+		return p.status, ErrProfileRunning
+	}
+	var buf bytes.Buffer
+	p.base = nil
+	switch kind {
+	case PROFILE_CPU:
+		// fails when net/http/pprof or another profiler runs one
+		if err := pprof.StartCPUProfile(&buf); err != nil {
+			return p.status, err
+		}
+		var match Skipper = func(Context) bool { return true }
+		if route != "" {
+			match = SkipPaths(route)
+		}
+		p.labels.Store(&match)
+	case PROFILE_HEAP:
+		base, err := heapProfile()
+		if err != nil {
+			return p.status, err
+		}
+		p.base = base
+	}
+
+	now := time.Now()
+	p.status = ProfileStatus{Kind: kind, Route: route, Started: now, Ends: now.Add(duration), Running: true}
+	p.profile = nil
+	time.AfterFunc(duration, func() { p.finish(&buf) })
+	return p.status, nil
+}
+
+func (p *Profiler) finish(buf *bytes.Buffer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch p.status.Kind {
+	case PROFILE_CPU:
+		pprof.StopCPUProfile()
+		p.labels.Store(nil)
+		p.profile = buf.Bytes()
+	case PROFILE_HEAP:
+		profile, err := heapProfile()
+		if err != nil {
+			p.status.Error = err.Error()
+		}
+		p.profile = profile
+	}
+	p.status.Running = false
+	p.status.Size = len(p.profile)
+	NewDefaultLogger().Infof("%s profile done, %d bytes", p.status.Kind, p.status.Size)
+}
+
+// Status returns the running or last profile, false when there was none
+func (p *Profiler) Status() (ProfileStatus, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status, p.status.Kind != ""
+}
+
+// Profile returns the finished profile in the pprof format, or the heap at
+// the start of the window when base is true. It is nil while running.
+func (p *Profiler) Profile(base bool) []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.status.Running {
+		return nil
+	}
+	if base {
+		return p.base
+	}
+	return p.profile
+}
+
+func heapProfile() ([]byte, error) {
+	// up to date statistics, like ?gc=1 of net/http/pprof
+	runtime.GC()
+	var buf bytes.Buffer
+	if err := pprof.Lookup("heap").WriteTo(&buf, 0); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MiddlewareProfiler labels the requests profiled by profiler, nothing but
+// an atomic load outside of a CPU profile. CreateInternalAPI adds it to its
+// own routes only, add it for DefaultProfiler to the routes to label:
+//
+//	server.Use(simplehttp.MiddlewareProfiler(simplehttp.DefaultProfiler))
+func MiddlewareProfiler(profiler *Profiler) Middleware {
+	return WithName("profiler", Profile(profiler))
+}
+
+func Profile(profiler *Profiler) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			match := profiler.labels.Load()
+			if match == nil || !(*match)(c) {
+				return next(c)
+			}
+			var err error
+			// the profile keeps the label, the fiber path is reused
+			route := strings.Clone(c.GetPath())
+			pprof.Do(c.Context(), pprof.Labels(PROFILE_LABEL_ROUTE, route), func(ctx context.Context) {
+				c.SetContext(ctx)
+				err = next(c)
+			})
+			return err
+		}
+	}
+}
+
+// profilerHandler starts a profile on POST ?kind=cpu&seconds=30&route=/api/search
+// and returns the status on GET
+func profilerHandler(profiler *Profiler) HandlerFunc {
+	return func(c Context) error {
+		if c.GetMethod() == http.MethodPost {
+			kind := c.GetQueryParam("kind")
+			if kind == "" {
+				kind = PROFILE_CPU
+			}
+			var duration time.Duration
+			if seconds := c.GetQueryParam("seconds"); seconds != "" {
+				n, err := strconv.Atoi(seconds)
+				if err != nil || n <= 0 || n > int(MAX_PROFILE_DURATION/time.Second) {
+					return NewError(http.StatusBadRequest, "seconds must be between 1 and "+strconv.Itoa(int(MAX_PROFILE_DURATION/time.Second)))
+				}
+				duration = time.Duration(n) * time.Second
+			}
+			status, err := profiler.Start(kind, duration, c.GetQueryParam("route"))
+			switch {
+			case errors.Is(err, ErrProfileRunning):
+				return NewError(http.StatusConflict, err.Error())
+			case errors.Is(err, ErrProfileKind):
+				return NewError(http.StatusBadRequest, err.Error())
+			case err != nil:
+				return NewError(http.StatusConflict, err.Error())
+			}
+			NewDefaultLogger().Infof("%s profile started by %s until %s", kind, c.GetHeaders().IP(), status.Ends.Format(time.RFC3339))
+			return c.JSON(http.StatusAccepted, status)
+		}
+		status, ok := profiler.Status()
+		if !ok {
+			return NewError(http.StatusNotFound, "no profile taken yet")
+		}
+		return c.JSON(http.StatusOK, status)
+	}
+}
+
+// profileDownloadHandler sends the finished profile, ?base=1 the heap at the
+// start of the window:
+//
+//	go tool pprof -base heap-base.pprof heap.pprof
+func profileDownloadHandler(profiler *Profiler) HandlerFunc {
+	return func(c Context) error {
+		status, _ := profiler.Status()
+		if status.Running {
+			return NewError(http.StatusConflict, "profile still running until "+status.Ends.Format(time.RFC3339))
+		}
+		base := c.GetQueryParam("base") != ""
+		profile := profiler.Profile(base)
+		if profile == nil {
+			return NewError(http.StatusNotFound, "no profile taken yet")
+		}
+		name := status.Kind + ".pprof"
+		if base {
+			name = status.Kind + "-base.pprof"
+		}
+		c.SetResponseHeader(HEADER_CONTENT_DISPOSITION, `attachment; filename="`+name+`"`)
+		return c.Blob(http.StatusOK, CONTENT_TYPE_OCTET_STREAM, profile)
+	}
+}
@@ -0,0 +1,131 @@
+package simplehttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConfigFile holds the settings of every environment in one file, read by
+// LoadConfig from SIMPLEHTTP_CONFIG_FILE. The values are environment
+// variables, so every SIMPLEHTTP_* setting and those of the application
+// can be set per profile:
+//
+//	{
+//	  "profile": "dev",
+//	  "profiles": {
+//	    "base":    {"env": {"SIMPLEHTTP_APP_NAME": "orders", "SIMPLEHTTP_READ_TIMEOUT": "30s"}},
+//	    "dev":     {"extends": "base", "env": {"SIMPLEHTTP_DEBUG": true}},
+//	    "staging": {"extends": "base", "env": {"SIMPLEHTTP_PORT": 8080}},
+//	    "prod":    {"extends": "staging", "env": {"SIMPLEHTTP_SHUTDOWN_DRAIN_WINDOW": "10s"}}
+//	  }
+//	}
+type ConfigFile struct {
+	Profile  string                   `json:"profile"` // used when SIMPLEHTTP_PROFILE is not set
+	Profiles map[string]ConfigProfile `json:"profiles"`
+}
+
+// ConfigProfile is one environment of a ConfigFile. Its env overrides the
+// one of the profile it extends.
+type ConfigProfile struct {
+	Extends string                 `json:"extends"`
+	Env     map[string]interface{} `json:"env"` // strings, numbers or booleans
+}
+
+// ReadConfigFile parses a ConfigFile
+func ReadConfigFile(path string) (*ConfigFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// numbers stay as written, 10485760 and not 1.048576e+07
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var file ConfigFile
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &file, nil
+}
+
+// Resolve returns the environment of a profile merged with the profiles it
+// extends, empty name means the default Profile
+func (f *ConfigFile) Resolve(name string) (map[string]string, error) {
+	if name == "" {
+		name = f.Profile
+	}
+	if name == "" {
+		return nil, fmt.Errorf("no profile selected, set %s", SIMPLEHTTP_PROFILE)
+	}
+
+	// from the profile up to its root, then applied root first
+	var chain []ConfigProfile
+	seen := make(map[string]bool)
+	for current := name; current != ""; {
+		if seen[current] {
+			return nil, fmt.Errorf("profile %q is part of an extends cycle", current)
+		}
+		seen[current] = true
+		profile, ok := f.Profiles[current]
+		if !ok {
+			return nil, fmt.Errorf("unknown profile %q, have %s", current, strings.Join(f.names(), ", "))
+		}
+		chain = append(chain, profile)
+		current = profile.Extends
+	}
+	env := make(map[string]string)
+	for i := len(chain) - 1; i >= 0; i-- {
+		for key, value := range chain[i].Env {
+			switch v := value.(type) {
+			case string:
+				env[key] = v
+			case float64:
+				// a ConfigFile built in code or decoded without UseNumber
+				env[key] = strconv.FormatFloat(v, 'f', -1, 64)
+			default:
+				env[key] = fmt.Sprint(value)
+			}
+		}
+	}
+	return env, nil
+}
+
+func (f *ConfigFile) names() []string {
+	names := make([]string, 0, len(f.Profiles))
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadProfile sets the environment of a profile of the ConfigFile at path,
+// empty name means SIMPLEHTTP_PROFILE or else the default of the file.
+// Variables already set win, so the environment overrides the file. It
+// returns the name of the profile loaded.
+func LoadProfile(path, name string) (string, error) {
+	file, err := ReadConfigFile(path)
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		name = os.Getenv(SIMPLEHTTP_PROFILE)
+	}
+	if name == "" {
+		name = file.Profile
+	}
+	env, err := file.Resolve(name)
+	if err != nil {
+		return "", err
+	}
+	for key, value := range env {
+		if _, set := os.LookupEnv(key); !set {
+			os.Setenv(key, value)
+		}
+	}
+	return name, nil
+}
@@ -0,0 +1,451 @@
+package simplehttp
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	RATE_LIMIT_TOKEN_BUCKET   = "token_bucket"   // RequestsPerSecond refill, BurstSize capacity, the default
+	RATE_LIMIT_FIXED_WINDOW   = "fixed_window"   // Limit requests per Window, counted from zero every Window
+	RATE_LIMIT_SLIDING_WINDOW = "sliding_window" // Limit requests in any Window, weighted from the previous one
+
+	HEADER_RATE_LIMIT_LIMIT     = "X-RateLimit-Limit"
+	HEADER_RATE_LIMIT_REMAINING = "X-RateLimit-Remaining"
+	HEADER_RATE_LIMIT_RESET     = "X-RateLimit-Reset" // seconds until the limit is fully available again
+	HEADER_RETRY_AFTER          = "Retry-After"
+
+	DEFAULT_RATE_LIMIT_TTL      = 10 * time.Minute
+	DEFAULT_RATE_LIMIT_MAX_KEYS = 100000
+)
+
+// Rate limit, remember burst is usually the one that taking effects (as maximum)
+// Tested OK, it works fine.
+// NOTE: make sure the cache middleware is not interfeering, because that can
+// effect the rateLimit. When it is returned from cache, it doesn't hit the
+// rate limit at all.
+type RateLimit struct {
+	config RateLimitConfig
+	store  RateLimitStore
+}
+
+// RateLimiter middleware configuration
+type RateLimitConfig struct {
+	// Algorithm is RATE_LIMIT_TOKEN_BUCKET (default), RATE_LIMIT_FIXED_WINDOW
+	// or RATE_LIMIT_SLIDING_WINDOW
+	Algorithm         string
+	RequestsPerSecond int // token bucket refill rate
+	BurstSize         int // token bucket capacity
+	// Limit requests per Window for the window algorithms. Window defaults
+	// to 1s, Limit to RequestsPerSecond per Window.
+	Limit  int
+	Window time.Duration
+	// ClientTimeout and MaxKeys bound the default memory store, see
+	// MemoryRateLimitStoreConfig.TTL and MaxKeys
+	ClientTimeout time.Duration
+	MaxKeys       int
+	KeyFunc       func(Context) string // Function to generate rate limit key, default RateLimitByIP
+	// KeyPrefix is prepended to the keys, so limiters sharing a Store keep
+	// independent counters, e.g. "login:" and "api:"
+	KeyPrefix string
+	// Store keeps the counters, nil means in memory, so per instance. Use a
+	// shared store like NewRedisRateLimitStore for one limit across replicas.
+	Store RateLimitStore
+	// OnStoreError is called when the store fails, the request is allowed
+	// then. Defaults to logging on the DefaultLogger.
+	OnStoreError func(key string, err error)
+	// HideHeaders leaves out the X-RateLimit-* headers, Retry-After is still
+	// sent on 429
+	HideHeaders bool
+	Clock       Clock // nil means the one of the server, DefaultClock for Take
+	Skipper     Skipper
+}
+
+// RateLimitResult is the outcome of one request against its key's limit
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int           // BurstSize or Limit
+	Remaining  int           // requests left right now
+	Reset      time.Duration // until the limit is fully available again
+	RetryAfter time.Duration // until the next request is allowed, 0 when Allowed
+}
+
+// RateLimitStore counts the requests of a key with config.Algorithm and
+// the limits of config, defaults already applied. Stores shared by several
+// limiters need distinct keys per limiter.
+type RateLimitStore interface {
+	Take(key string, config RateLimitConfig, now time.Time) (RateLimitResult, error)
+}
+
+// rateLimitState is the per-key state of an algorithm
+type rateLimitState interface {
+	take(now time.Time) RateLimitResult
+}
+
+func MiddlewareRateLimiter(config RateLimitConfig) Middleware {
+	return Skip(withDiagnose(WithName("rate limiter", RateLimiter(config)), rateLimitDiagnostics(config)), config.Skipper)
+}
+
+// RateLimiter returns a rate limiting middleware. Every response carries
+// X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset (seconds),
+// rejected requests get 429 with Retry-After:
+//
+//	server.Use(simplehttp.MiddlewareRateLimiter(simplehttp.RateLimitConfig{
+//		Algorithm: simplehttp.RATE_LIMIT_SLIDING_WINDOW,
+//		Limit:     100,
+//		Window:    time.Minute,
+//		KeyFunc:   func(c simplehttp.Context) string { return c.GetHeaders().IP() },
+//	}))
+//
+// Groups get their own limits by their own middleware, sharing one store
+// with distinct KeyPrefix:
+//
+//	store := simplehttp.NewMemoryRateLimitStore()
+//	auth.Use(simplehttp.MiddlewareRateLimiter(simplehttp.RateLimitConfig{
+//		Algorithm: simplehttp.RATE_LIMIT_FIXED_WINDOW, Limit: 5, Window: time.Minute,
+//		KeyPrefix: "login:", Store: store,
+//	}))
+//	api.Use(simplehttp.MiddlewareRateLimiter(simplehttp.RateLimitConfig{
+//		RequestsPerSecond: 100, BurstSize: 200,
+//		KeyFunc: simplehttp.RateLimitByHeader("X-API-Key"), KeyPrefix: "api:", Store: store,
+//	}))
+func RateLimiter(config RateLimitConfig) MiddlewareFunc {
+	limiter := newRateLimiter(config)
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			key := limiter.config.KeyFunc(c)
+			result, err := limiter.take(key, clockFor(c, limiter.config.Clock))
+			if err != nil {
+				// an unreachable store must not take the API down with it
+				limiter.config.OnStoreError(key, err)
+				return next(c)
+			}
+			if !config.HideHeaders {
+				c.SetResponseHeader(HEADER_RATE_LIMIT_LIMIT, strconv.Itoa(result.Limit))
+				c.SetResponseHeader(HEADER_RATE_LIMIT_REMAINING, strconv.Itoa(result.Remaining))
+				c.SetResponseHeader(HEADER_RATE_LIMIT_RESET, ceilSeconds(result.Reset))
+			}
+			if !result.Allowed {
+				c.SetResponseHeader(HEADER_RETRY_AFTER, ceilSeconds(result.RetryAfter))
+				return NewError(http.StatusTooManyRequests, "rate limit exceeded")
+			}
+			return next(c)
+		}
+	}
+}
+
+func newRateLimiter(config RateLimitConfig) *RateLimit {
+	if config.Algorithm == "" {
+		config.Algorithm = RATE_LIMIT_TOKEN_BUCKET
+	}
+	if config.KeyFunc == nil {
+		config.KeyFunc = RateLimitByIP()
+	}
+	if config.Window <= 0 {
+		config.Window = time.Second
+	}
+	if config.Limit <= 0 {
+		config.Limit = int(float64(config.RequestsPerSecond) * config.Window.Seconds())
+	}
+	switch config.Algorithm {
+	case RATE_LIMIT_TOKEN_BUCKET, RATE_LIMIT_FIXED_WINDOW, RATE_LIMIT_SLIDING_WINDOW:
+	default:
+		panic("simplehttp: unknown rate limit algorithm " + config.Algorithm)
+	}
+	if config.Store == nil {
+		config.Store = NewMemoryRateLimitStore(MemoryRateLimitStoreConfig{TTL: config.ClientTimeout, MaxKeys: config.MaxKeys})
+	}
+	if config.OnStoreError == nil {
+		logger := NewDefaultLogger()
+		config.OnStoreError = func(key string, err error) {
+			logger.Errorf("rate limit store: %s: %v", key, err)
+		}
+	}
+	return &RateLimit{config: config, store: config.Store}
+}
+
+// Take counts a request of key and reports whether it is allowed
+func (rl *RateLimit) Take(key string) (RateLimitResult, error) {
+	return rl.take(key, clockOr(rl.config.Clock))
+}
+
+func (rl *RateLimit) take(key string, clock Clock) (RateLimitResult, error) {
+	return rl.store.Take(rl.config.KeyPrefix+key, rl.config, clock.Now())
+}
+
+// RateLimitByIP keys the limit by client IP, see RequestHeader.IP
+func RateLimitByIP() func(Context) string {
+	return func(c Context) string {
+		if headers := c.GetHeaders(); headers != nil {
+			return "ip:" + StripPort(headers.IP())
+		}
+		return "ip:"
+	}
+}
+
+// RateLimitByHeader keys the limit by a request header, e.g. the API key,
+// so every key has its own limit. The value is hashed, the store never sees
+// the secret. Requests without the header fall back to their IP, instead of
+// sharing one limit.
+func RateLimitByHeader(name string) func(Context) string {
+	byIP := RateLimitByIP()
+	return func(c Context) string {
+		if value := c.GetHeader(name); value != "" {
+			sum := sha256.Sum256([]byte(value))
+			return "header:" + hex.EncodeToString(sum[:16])
+		}
+		return byIP(c)
+	}
+}
+
+func (rl *RateLimit) Allow(key string) error {
+	result, err := rl.Take(key)
+	if err != nil {
+		return err
+	}
+	if !result.Allowed {
+		return ErrRateLimitExceeded
+	}
+	return nil
+}
+
+// MemoryRateLimitStoreConfig bounds the keys of a MemoryRateLimitStore
+type MemoryRateLimitStoreConfig struct {
+	// TTL drops keys idle for longer, default 10m. Keep it above Window and
+	// BurstSize/RequestsPerSecond, a dropped key starts with a full limit.
+	TTL time.Duration
+	// MaxKeys drops the least recently used keys beyond it, default 100000
+	MaxKeys int
+}
+
+// RateLimitStats is reported by MemoryRateLimitStore.Stats, e.g. as an
+// AdminConfig metric
+type RateLimitStats struct {
+	Keys    int   `json:"keys"`
+	Expired int64 `json:"expired"` // dropped after TTL
+	Evicted int64 `json:"evicted"` // dropped over MaxKeys
+}
+
+// MemoryRateLimitStore keeps the counters in process, the default store.
+// Keys are dropped after TTL idle or beyond MaxKeys, so one entry per
+// client IP doesn't grow forever.
+type MemoryRateLimitStore struct {
+	config MemoryRateLimitStoreConfig
+	states map[string]*list.Element
+	lru    *list.List // *rateLimitEntry, most recently used first
+	stats  RateLimitStats
+	mu     sync.Mutex
+}
+
+type rateLimitEntry struct {
+	key      string
+	state    rateLimitState
+	lastSeen time.Time
+}
+
+func NewMemoryRateLimitStore(config ...MemoryRateLimitStoreConfig) *MemoryRateLimitStore {
+	s := &MemoryRateLimitStore{
+		states: make(map[string]*list.Element),
+		lru:    list.New(),
+	}
+	if len(config) > 0 {
+		s.config = config[0]
+	}
+	if s.config.TTL <= 0 {
+		s.config.TTL = DEFAULT_RATE_LIMIT_TTL
+	}
+	if s.config.MaxKeys <= 0 {
+		s.config.MaxKeys = DEFAULT_RATE_LIMIT_MAX_KEYS
+	}
+	return s
+}
+
+func (s *MemoryRateLimitStore) Take(key string, config RateLimitConfig, now time.Time) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the list is ordered by last use, so the expired keys are at its end
+	for back := s.lru.Back(); back != nil; back = s.lru.Back() {
+		if now.Sub(back.Value.(*rateLimitEntry).lastSeen) <= s.config.TTL {
+			break
+		}
+		s.remove(back)
+		s.stats.Expired++
+	}
+
+	element, exists := s.states[key]
+	if !exists {
+		element = s.lru.PushFront(&rateLimitEntry{key: key, state: newRateLimitState(config)})
+		s.states[key] = element
+		for s.lru.Len() > s.config.MaxKeys {
+			s.remove(s.lru.Back())
+			s.stats.Evicted++
+		}
+	}
+	s.lru.MoveToFront(element)
+	entry := element.Value.(*rateLimitEntry)
+	entry.lastSeen = now
+	return entry.state.take(now), nil
+}
+
+func (s *MemoryRateLimitStore) remove(element *list.Element) {
+	s.lru.Remove(element)
+	delete(s.states, element.Value.(*rateLimitEntry).key)
+}
+
+// Len returns the number of keys held
+func (s *MemoryRateLimitStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lru.Len()
+}
+
+func (s *MemoryRateLimitStore) Stats() RateLimitStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats
+	stats.Keys = s.lru.Len()
+	return stats
+}
+
+func newRateLimitState(config RateLimitConfig) rateLimitState {
+	switch config.Algorithm {
+	case RATE_LIMIT_FIXED_WINDOW:
+		return &fixedWindow{limit: config.Limit, window: config.Window}
+	case RATE_LIMIT_SLIDING_WINDOW:
+		return &slidingWindow{limit: config.Limit, window: config.Window}
+	default:
+		return &tokenBucket{limiter: rate.NewLimiter(rate.Limit(config.RequestsPerSecond), config.BurstSize)}
+	}
+}
+
+type tokenBucket struct {
+	limiter *rate.Limiter
+}
+
+func (b *tokenBucket) take(now time.Time) RateLimitResult {
+	allowed := b.limiter.AllowN(now, 1)
+	return tokenBucketResult(allowed, b.limiter.Burst(), float64(b.limiter.Limit()), b.limiter.TokensAt(now))
+}
+
+// tokenBucketResult reports a bucket of burst capacity refilled at
+// perSecond, holding tokens after the request
+func tokenBucketResult(allowed bool, burst int, perSecond, tokens float64) RateLimitResult {
+	result := RateLimitResult{Allowed: allowed, Limit: burst, Remaining: max(int(tokens), 0)}
+	if perSecond > 0 {
+		result.Reset = secondsDuration((float64(burst) - tokens) / perSecond)
+		if !allowed {
+			result.RetryAfter = secondsDuration((1 - tokens) / perSecond)
+		}
+	}
+	return result
+}
+
+// fixedWindow counts the requests of windows aligned to the clock, e.g.
+// 12:00:00-12:01:00 for a minute
+type fixedWindow struct {
+	limit  int
+	window time.Duration
+	start  time.Time
+	count  int
+}
+
+func (w *fixedWindow) take(now time.Time) RateLimitResult {
+	if start := now.Truncate(w.window); !start.Equal(w.start) {
+		w.start, w.count = start, 0
+	}
+	allowed := w.count < w.limit
+	if allowed {
+		w.count++
+	}
+	return fixedWindowResult(allowed, w.limit, w.count, w.start.Add(w.window).Sub(now))
+}
+
+// fixedWindowResult reports a window holding count requests after the
+// request, ending in reset
+func fixedWindowResult(allowed bool, limit, count int, reset time.Duration) RateLimitResult {
+	result := RateLimitResult{Allowed: allowed, Limit: limit, Remaining: max(limit-count, 0), Reset: reset}
+	if !allowed {
+		result.RetryAfter = reset
+	}
+	return result
+}
+
+// slidingWindow estimates the requests of the last window from the count of
+// the current clock-aligned window plus the previous one weighted by how
+// much of it still overlaps, smoothing the bursts a fixed window allows at
+// its edges
+type slidingWindow struct {
+	limit         int
+	window        time.Duration
+	start         time.Time
+	previous, cur int
+}
+
+func (w *slidingWindow) take(now time.Time) RateLimitResult {
+	if start := now.Truncate(w.window); !start.Equal(w.start) {
+		if start.Sub(w.start) == w.window {
+			w.previous = w.cur
+		} else {
+			w.previous = 0
+		}
+		w.start, w.cur = start, 0
+	}
+	elapsed := now.Sub(w.start)
+	allowed := slidingWindowEstimate(w.previous, w.cur, elapsed, w.window)+1 <= float64(w.limit)
+	if allowed {
+		w.cur++
+	}
+	return slidingWindowResult(allowed, w.limit, w.window, elapsed, w.previous, w.cur)
+}
+
+// slidingWindowEstimate weights the previous window by the part of it still
+// inside the sliding window
+func slidingWindowEstimate(previous, cur int, elapsed, window time.Duration) float64 {
+	return float64(previous)*(1-float64(elapsed)/float64(window)) + float64(cur)
+}
+
+// slidingWindowResult reports the counts of the previous and current window,
+// elapsed into the current one, after the request
+func slidingWindowResult(allowed bool, limit int, window, elapsed time.Duration, previous, cur int) RateLimitResult {
+	estimate := slidingWindowEstimate(previous, cur, elapsed, window)
+	result := RateLimitResult{Allowed: allowed, Limit: limit, Remaining: max(int(float64(limit)-estimate), 0)}
+	// the previous window has slid out at the end of the current one, the
+	// current one at the end of the next
+	result.Reset = window - elapsed
+	if cur > 0 {
+		result.Reset += window
+	}
+	if !allowed {
+		if cur+1 <= limit && previous > 0 {
+			// wait for enough of the previous window to slide out
+			overlap := 1 - float64(limit-cur-1)/float64(previous)
+			result.RetryAfter = time.Duration(overlap*float64(window)) - elapsed
+		} else if cur > 0 {
+			// the current window alone is full, wait into the next one
+			result.RetryAfter = window - elapsed + time.Duration((1-float64(limit-1)/float64(cur))*float64(window))
+		} else {
+			result.RetryAfter = result.Reset
+		}
+		result.RetryAfter = max(result.RetryAfter, 0)
+	}
+	return result
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// ceilSeconds formats d as whole seconds rounded up, for Retry-After and
+// X-RateLimit-Reset
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
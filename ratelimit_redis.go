@@ -0,0 +1,193 @@
+package simplehttp
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	DEFAULT_REDIS_RATE_LIMIT_PREFIX  = "ratelimit:"
+	DEFAULT_REDIS_RATE_LIMIT_TIMEOUT = 100 * time.Millisecond
+)
+
+// RedisEvaler runs a Lua script on Redis and returns its reply, integers as
+// int64 and strings as string or []byte. Wrap the client of your choice in
+// a RedisEvalFunc, e.g. for go-redis:
+//
+//	simplehttp.RedisEvalFunc(func(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+//		return rdb.Eval(ctx, script, keys, args...).Result()
+//	})
+type RedisEvaler interface {
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+}
+
+// RedisEvalFunc adapts a function to a RedisEvaler
+type RedisEvalFunc func(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+
+func (f RedisEvalFunc) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	return f(ctx, script, keys, args...)
+}
+
+// RedisRateLimitStoreConfig configures a RedisRateLimitStore
+type RedisRateLimitStoreConfig struct {
+	Client  RedisEvaler   // required
+	Prefix  string        // of the Redis keys, default "ratelimit:", distinct per limiter sharing a Redis
+	Timeout time.Duration // per request, default 100ms
+}
+
+// RedisRateLimitStore keeps the counters in Redis, so replicas share one
+// limit per key. Every request is one atomic script call. The time comes
+// from RateLimitConfig.Clock of each replica, keep their clocks in sync.
+//
+//	store := simplehttp.NewRedisRateLimitStore(simplehttp.RedisRateLimitStoreConfig{Client: evaler, Prefix: "api:"})
+//	server.Use(simplehttp.MiddlewareRateLimiter(simplehttp.RateLimitConfig{
+//		Algorithm: simplehttp.RATE_LIMIT_SLIDING_WINDOW,
+//		Limit:     100,
+//		Window:    time.Minute,
+//		KeyFunc:   func(c simplehttp.Context) string { return c.GetHeaders().IP() },
+//		Store:     store,
+//	}))
+type RedisRateLimitStore struct {
+	config RedisRateLimitStoreConfig
+}
+
+func NewRedisRateLimitStore(config RedisRateLimitStoreConfig) *RedisRateLimitStore {
+	if config.Client == nil {
+		panic("simplehttp: RedisRateLimitStoreConfig.Client is required")
+	}
+	if config.Prefix == "" {
+		config.Prefix = DEFAULT_REDIS_RATE_LIMIT_PREFIX
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DEFAULT_REDIS_RATE_LIMIT_TIMEOUT
+	}
+	return &RedisRateLimitStore{config: config}
+}
+
+// The scripts reply {allowed, ...counts}. Fractions are replied as strings,
+// Redis truncates Lua numbers to integers.
+const (
+	// KEYS[1] bucket hash, ARGV rate per second, burst, now in ms
+	redisTokenBucketScript = `
+local rate, burst, now = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens, ts = tonumber(state[1]), tonumber(state[2])
+if tokens == nil then
+  tokens, ts = burst, now
+end
+if now > ts then
+  tokens = math.min(burst, tokens + (now - ts) / 1000 * rate)
+  ts = now
+end
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(ts))
+if rate > 0 then
+  redis.call("PEXPIRE", KEYS[1], math.ceil((burst - tokens) / rate * 1000) + 1000)
+end
+return {allowed, tostring(tokens)}
+`
+	// KEYS[1] window counter, ARGV limit, ttl in ms
+	redisFixedWindowScript = `
+local limit, ttl = tonumber(ARGV[1]), tonumber(ARGV[2])
+local count = tonumber(redis.call("GET", KEYS[1]) or "0")
+if count >= limit then
+  return {0, count}
+end
+count = redis.call("INCR", KEYS[1])
+if count == 1 then
+  redis.call("PEXPIRE", KEYS[1], ttl)
+end
+return {1, count}
+`
+	// KEYS[1] current and KEYS[2] previous window counter, ARGV limit,
+	// weight of the previous window, ttl in ms
+	redisSlidingWindowScript = `
+local limit, weight, ttl = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local previous = tonumber(redis.call("GET", KEYS[2]) or "0")
+local cur = tonumber(redis.call("GET", KEYS[1]) or "0")
+local allowed = 0
+if previous * weight + cur + 1 <= limit then
+  cur = redis.call("INCR", KEYS[1])
+  redis.call("PEXPIRE", KEYS[1], ttl)
+  allowed = 1
+end
+return {allowed, previous, cur}
+`
+)
+
+func (s *RedisRateLimitStore) Take(key string, config RateLimitConfig, now time.Time) (RateLimitResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
+	defer cancel()
+
+	// the hash tag keeps the keys of one limiter key on one cluster slot
+	base := s.config.Prefix + "{" + key + "}"
+	switch config.Algorithm {
+	case RATE_LIMIT_FIXED_WINDOW:
+		start := now.Truncate(config.Window)
+		reset := start.Add(config.Window).Sub(now)
+		reply, err := s.eval(ctx, redisFixedWindowScript, 2, []string{base + ":" + strconv.FormatInt(start.UnixMilli(), 10)},
+			config.Limit, reset.Milliseconds()+1)
+		if err != nil {
+			return RateLimitResult{}, err
+		}
+		return fixedWindowResult(reply[0] == 1, config.Limit, int(reply[1]), reset), nil
+
+	case RATE_LIMIT_SLIDING_WINDOW:
+		start := now.Truncate(config.Window)
+		elapsed := now.Sub(start)
+		weight := 1 - float64(elapsed)/float64(config.Window)
+		reply, err := s.eval(ctx, redisSlidingWindowScript, 3, []string{
+			base + ":" + strconv.FormatInt(start.UnixMilli(), 10),
+			base + ":" + strconv.FormatInt(start.Add(-config.Window).UnixMilli(), 10),
+		}, config.Limit, strconv.FormatFloat(weight, 'f', -1, 64), (2 * config.Window).Milliseconds())
+		if err != nil {
+			return RateLimitResult{}, err
+		}
+		return slidingWindowResult(reply[0] == 1, config.Limit, config.Window, elapsed, int(reply[1]), int(reply[2])), nil
+
+	default:
+		reply, err := s.eval(ctx, redisTokenBucketScript, 2, []string{base},
+			config.RequestsPerSecond, config.BurstSize, now.UnixMilli())
+		if err != nil {
+			return RateLimitResult{}, err
+		}
+		return tokenBucketResult(reply[0] == 1, config.BurstSize, float64(config.RequestsPerSecond), reply[1]), nil
+	}
+}
+
+// eval runs a script replying an array of at least want numbers
+func (s *RedisRateLimitStore) eval(ctx context.Context, script string, want int, keys []string, args ...interface{}) ([]float64, error) {
+	reply, err := s.config.Client.Eval(ctx, script, keys, args...)
+	if err != nil {
+		return nil, err
+	}
+	values, ok := reply.([]interface{})
+	if !ok || len(values) < want {
+		return nil, fmt.Errorf("rate limit script: unexpected reply %v", reply)
+	}
+	numbers := make([]float64, len(values))
+	for i, value := range values {
+		var s string
+		switch v := value.(type) {
+		case int64:
+			numbers[i] = float64(v)
+			continue
+		case string:
+			s = v
+		case []byte:
+			s = string(v)
+		default:
+			return nil, fmt.Errorf("rate limit script: unexpected value %T", value)
+		}
+		if numbers[i], err = strconv.ParseFloat(s, 64); err != nil {
+			return nil, fmt.Errorf("rate limit script: %v", err)
+		}
+	}
+	return numbers, nil
+}
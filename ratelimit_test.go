@@ -0,0 +1,160 @@
+package simplehttp_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/medatechnology/simplehttp"
+)
+
+// rateLimitStart is aligned to the minute, so are the windows
+var rateLimitStart = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type rateLimitStep struct {
+	at         time.Duration // after rateLimitStart
+	allowed    bool
+	remaining  int
+	retryAfter time.Duration // checked when not allowed
+}
+
+func TestRateLimitAlgorithms(t *testing.T) {
+	tests := []struct {
+		name   string
+		config simplehttp.RateLimitConfig
+		steps  []rateLimitStep
+	}{
+		{
+			name:   "token bucket",
+			config: simplehttp.RateLimitConfig{Algorithm: simplehttp.RATE_LIMIT_TOKEN_BUCKET, RequestsPerSecond: 1, BurstSize: 2},
+			steps: []rateLimitStep{
+				{at: 0, allowed: true, remaining: 1},
+				{at: 0, allowed: true, remaining: 0},
+				{at: 0, allowed: false, retryAfter: time.Second},
+				{at: 500 * time.Millisecond, allowed: false, retryAfter: 500 * time.Millisecond},
+				{at: time.Second, allowed: true, remaining: 0},
+				{at: 10 * time.Second, allowed: true, remaining: 1}, // refilled up to the burst only
+			},
+		},
+		{
+			name:   "fixed window",
+			config: simplehttp.RateLimitConfig{Algorithm: simplehttp.RATE_LIMIT_FIXED_WINDOW, Limit: 2, Window: time.Minute},
+			steps: []rateLimitStep{
+				{at: 10 * time.Second, allowed: true, remaining: 1},
+				{at: 20 * time.Second, allowed: true, remaining: 0},
+				{at: 30 * time.Second, allowed: false, retryAfter: 30 * time.Second},
+				// a new window starts from zero, even right after the edge
+				{at: time.Minute, allowed: true, remaining: 1},
+				{at: time.Minute, allowed: true, remaining: 0},
+				{at: time.Minute, allowed: false, retryAfter: time.Minute},
+			},
+		},
+		{
+			name:   "sliding window smooths the edge",
+			config: simplehttp.RateLimitConfig{Algorithm: simplehttp.RATE_LIMIT_SLIDING_WINDOW, Limit: 2, Window: time.Minute},
+			steps: []rateLimitStep{
+				{at: 59 * time.Second, allowed: true, remaining: 1},
+				{at: 59 * time.Second, allowed: true, remaining: 0},
+				// the previous window still counts fully
+				{at: time.Minute, allowed: false, retryAfter: 30 * time.Second},
+				// half of it slid out
+				{at: 90 * time.Second, allowed: true, remaining: 0},
+				{at: 90 * time.Second, allowed: false},
+			},
+		},
+		{
+			name:   "sliding window after a gap",
+			config: simplehttp.RateLimitConfig{Algorithm: simplehttp.RATE_LIMIT_SLIDING_WINDOW, Limit: 2, Window: time.Minute},
+			steps: []rateLimitStep{
+				{at: 0, allowed: true, remaining: 1},
+				{at: 0, allowed: true, remaining: 0},
+				// two windows later nothing of the first one is left
+				{at: 2 * time.Minute, allowed: true, remaining: 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := simplehttp.NewMemoryRateLimitStore()
+			for i, step := range tt.steps {
+				result, err := store.Take("client", tt.config, rateLimitStart.Add(step.at))
+				if err != nil {
+					t.Fatalf("step %d: %v", i, err)
+				}
+				if result.Allowed != step.allowed {
+					t.Fatalf("step %d at %s: allowed = %v, want %v", i, step.at, result.Allowed, step.allowed)
+				}
+				if step.allowed && result.Remaining != step.remaining {
+					t.Errorf("step %d at %s: remaining = %d, want %d", i, step.at, result.Remaining, step.remaining)
+				}
+				if !step.allowed && step.retryAfter > 0 && result.RetryAfter != step.retryAfter {
+					t.Errorf("step %d at %s: retry after = %s, want %s", i, step.at, result.RetryAfter, step.retryAfter)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitStoreBounds(t *testing.T) {
+	config := simplehttp.RateLimitConfig{Algorithm: simplehttp.RATE_LIMIT_FIXED_WINDOW, Limit: 1, Window: time.Minute}
+	store := simplehttp.NewMemoryRateLimitStore(simplehttp.MemoryRateLimitStoreConfig{TTL: time.Hour, MaxKeys: 2})
+	for _, key := range []string{"a", "b", "c"} {
+		store.Take(key, config, rateLimitStart)
+	}
+	if stats := store.Stats(); stats.Keys != 2 || stats.Evicted != 1 {
+		t.Errorf("after MaxKeys: %+v, want 2 keys and 1 evicted", stats)
+	}
+	// "a" was evicted, it starts over with a full limit
+	if result, _ := store.Take("a", config, rateLimitStart); !result.Allowed {
+		t.Error("evicted key is still limited")
+	}
+	store.Take("d", config, rateLimitStart.Add(2*time.Hour))
+	if stats := store.Stats(); stats.Keys != 1 || stats.Expired != 2 {
+		t.Errorf("after TTL: %+v, want 1 key and 2 expired", stats)
+	}
+}
+
+// TestRateLimitMiddleware checks the headers and the 429 on every adapter
+func TestRateLimitMiddleware(t *testing.T) {
+	for name, newServer := range adapters {
+		t.Run(name, func(t *testing.T) {
+			clock := simplehttp.NewFakeClock(rateLimitStart.Add(15 * time.Second))
+			server := newServer(simplehttp.WithConfig(func(c *simplehttp.Config) { c.Clock = clock }))
+			server.GET("/limited", func(c simplehttp.Context) error {
+				return c.String(http.StatusOK, "ok")
+			}, simplehttp.MiddlewareRateLimiter(simplehttp.RateLimitConfig{
+				Algorithm: simplehttp.RATE_LIMIT_FIXED_WINDOW, Limit: 1, Window: time.Minute,
+			}))
+
+			get := func() *http.Response {
+				t.Helper()
+				resp, err := server.(simplehttp.Dispatcher).Dispatch(httptest.NewRequest(http.MethodGet, "/limited", nil))
+				if err != nil {
+					t.Fatalf("dispatch: %v", err)
+				}
+				resp.Body.Close()
+				return resp
+			}
+			resp := get()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("first: status = %d, want 200", resp.StatusCode)
+			}
+			if got := resp.Header.Get(simplehttp.HEADER_RATE_LIMIT_REMAINING); got != "0" {
+				t.Errorf("remaining = %q, want 0", got)
+			}
+			resp = get()
+			if resp.StatusCode != http.StatusTooManyRequests {
+				t.Fatalf("second: status = %d, want 429", resp.StatusCode)
+			}
+			if got := resp.Header.Get(simplehttp.HEADER_RETRY_AFTER); got != "45" {
+				t.Errorf("Retry-After = %q, want 45", got)
+			}
+			clock.Advance(45 * time.Second)
+			if resp = get(); resp.StatusCode != http.StatusOK {
+				t.Errorf("next window: status = %d, want 200", resp.StatusCode)
+			}
+		})
+	}
+}
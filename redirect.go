@@ -0,0 +1,119 @@
+package simplehttp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTPRedirector is a lightweight plain HTTP listener that sends every
+// request to the HTTPS server, keeping host, path and query. Servers create
+// them with RedirectHTTP and run them along their own Start/Shutdown.
+type HTTPRedirector struct {
+	address   string
+	httpsPort string
+	server    *http.Server
+	closed    bool
+	mu        sync.Mutex
+}
+
+func NewHTTPRedirector(address string) *HTTPRedirector {
+	if !strings.Contains(address, ":") {
+		address = ":" + address
+	}
+	return &HTTPRedirector{address: address}
+}
+
+func (r *HTTPRedirector) Address() string {
+	return r.address
+}
+
+// Start listens until Shutdown, httpsAddress is the address of the HTTPS
+// server ("443", ":8443", "example.com:443"), only its port is used
+func (r *HTTPRedirector) Start(httpsAddress string) error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	if _, port, err := net.SplitHostPort(httpsAddress); err == nil {
+		r.httpsPort = port
+	} else {
+		r.httpsPort = httpsAddress
+	}
+	r.server = &http.Server{
+		Addr:              r.address,
+		Handler:           r,
+		ReadHeaderTimeout: 5 * time.Second,
+		IdleTimeout:       30 * time.Second,
+	}
+	server := r.server
+	r.mu.Unlock()
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (r *HTTPRedirector) Shutdown(ctx context.Context) error {
+	r.mu.Lock()
+	r.closed = true
+	server := r.server
+	r.mu.Unlock()
+	if server == nil {
+		return nil
+	}
+	return server.Shutdown(ctx)
+}
+
+func (r *HTTPRedirector) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	host := StripPort(req.Host)
+	if host == "" {
+		http.Error(w, "missing host", http.StatusBadRequest)
+		return
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]" // IPv6
+	}
+	if r.httpsPort != "" && r.httpsPort != "443" {
+		host += ":" + r.httpsPort
+	}
+	// 301 would turn a POST into a GET, 308 keeps the method and body
+	status := http.StatusMovedPermanently
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		status = http.StatusPermanentRedirect
+	}
+	http.Redirect(w, req, "https://"+host+req.URL.RequestURI(), status)
+}
+
+// StartHTTPRedirectors runs the redirectors in the background for the HTTPS
+// server on httpsAddress, used by the servers' Start with the Logger of their
+// config, nil means NewDefaultLogger
+func StartHTTPRedirectors(redirectors []*HTTPRedirector, httpsAddress string, logger Logger) {
+	if logger == nil {
+		logger = NewDefaultLogger()
+	}
+	for _, r := range redirectors {
+		go func(r *HTTPRedirector) {
+			if err := r.Start(httpsAddress); err != nil {
+				logger.Errorf("HTTP redirector on %s stopped: %v", r.Address(), err)
+			}
+		}(r)
+	}
+}
+
+// ShutdownHTTPRedirectors stops the redirectors, used by the servers' Shutdown
+func ShutdownHTTPRedirectors(ctx context.Context, redirectors []*HTTPRedirector) error {
+	var errs []error
+	for _, r := range redirectors {
+		if err := r.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
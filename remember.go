@@ -0,0 +1,262 @@
+package simplehttp
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_REMEMBER_ME_COOKIE = "remember_me"
+	DEFAULT_REMEMBER_ME_TTL    = 30 * 24 * time.Hour
+	DEFAULT_REMEMBER_ME_GRACE  = time.Minute
+)
+
+var (
+	ErrRememberMeInvalid = errors.New("invalid remember-me token")
+	// ErrRememberMeTheft means a valid series was presented with an old token:
+	// the cookie was copied and used by someone else, all series of the
+	// identity are revoked.
+	ErrRememberMeTheft = errors.New("remember-me token reuse detected")
+)
+
+// RememberMeConfig configures persistent logins
+type RememberMeConfig struct {
+	// Store keeps the series, required. It must keep the data on the server
+	// (a SessionDeleter) like NewCacheSessionStore, a cookie can't tell a
+	// replayed token.
+	Store      SessionStore
+	TTL        time.Duration // lifetime of a series, defaults to 30 days
+	CookieName string        // defaults to "remember_me"
+	CookiePath string        // defaults to "/"
+	Secure     bool
+	SameSite   http.SameSite // defaults to Lax
+	Clock      Clock         // expires the series, nil means DefaultClock
+	// Grace is how long the token replaced by a rotation stays valid, so
+	// parallel requests sent with it (a page loading its assets) aren't taken
+	// for theft. Defaults to 1m, negative disables it.
+	Grace time.Duration
+}
+
+// RememberMe implements persistent login cookies with series/token rotation
+// (the "improved persistent login cookie" scheme). The cookie holds a series
+// id and a token, the token changes on every use, so presenting an old token
+// of a live series means the cookie was stolen.
+type RememberMe struct {
+	config  RememberMeConfig
+	deleter SessionDeleter
+	// mu makes the check and rotation of a series atomic in this process,
+	// across processes Grace covers the requests racing a rotation
+	mu sync.Mutex
+}
+
+// values of a series session
+const (
+	rememberMeIdentity = "identity"
+	rememberMeToken    = "token"    // hash of the current token
+	rememberMePrevious = "previous" // hash of the token replaced last
+	rememberMeRotated  = "rotated"  // unix seconds of the last rotation
+	rememberMeExpires  = "expires"  // unix seconds
+	rememberMeSeries   = "series"   // of an identity, separated by spaces
+)
+
+func NewRememberMe(config RememberMeConfig) *RememberMe {
+	if config.Store == nil {
+		panic("simplehttp: RememberMeConfig.Store is required")
+	}
+	deleter, ok := config.Store.(SessionDeleter)
+	if !ok {
+		panic("simplehttp: RememberMeConfig.Store must keep the series on the server, e.g. NewCacheSessionStore")
+	}
+	if config.TTL == 0 {
+		config.TTL = DEFAULT_REMEMBER_ME_TTL
+	}
+	if config.CookieName == "" {
+		config.CookieName = DEFAULT_REMEMBER_ME_COOKIE
+	}
+	if config.CookiePath == "" {
+		config.CookiePath = "/"
+	}
+	if config.SameSite == 0 {
+		config.SameSite = http.SameSiteLaxMode
+	}
+	if config.Grace == 0 {
+		config.Grace = DEFAULT_REMEMBER_ME_GRACE
+	}
+	return &RememberMe{config: config, deleter: deleter}
+}
+
+// Issue starts a new series for identity (after a login with "remember me"
+// checked) and sets the cookie
+func (r *RememberMe) Issue(c Context, identity string) error {
+	series, token := randomToken(), randomToken()
+	expires := clockOr(r.config.Clock).Now().Add(r.config.TTL)
+	entry := NewMemorySession(r.seriesKey(series))
+	entry.Set(rememberMeIdentity, identity)
+	entry.Set(rememberMeToken, hashToken(token))
+	entry.Set(rememberMeExpires, expires.Unix())
+	if err := r.save(entry, r.config.TTL); err != nil {
+		return err
+	}
+	if err := r.addToIdentity(identity, series); err != nil {
+		return err
+	}
+	r.setCookie(c, series+":"+token, expires)
+	return nil
+}
+
+// Authenticate checks the remember-me cookie and returns the identity, the
+// token is rotated on success. The token replaced last is accepted for Grace
+// after the rotation, without rotating again. On theft every series of the
+// identity is revoked and ErrRememberMeTheft returned.
+func (r *RememberMe) Authenticate(c Context) (string, error) {
+	cookie, err := c.Cookie(r.config.CookieName)
+	if err != nil {
+		return "", ErrUnauthorized
+	}
+	series, token, ok := strings.Cut(cookie.Value, ":")
+	if !ok || series == "" || token == "" {
+		r.clearCookie(c)
+		return "", ErrRememberMeInvalid
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := clockOr(r.config.Clock).Now()
+	entry, err := r.config.Store.Load(r.seriesKey(series))
+	if err != nil {
+		return "", err
+	}
+	var identity string
+	var expires int64
+	if entry != nil {
+		identity, _ = entry.Get(rememberMeIdentity).(string)
+		expires, _ = unixSeconds(entry.Get(rememberMeExpires))
+	}
+	if identity == "" || now.Unix() >= expires {
+		r.clearCookie(c)
+		return "", ErrRememberMeInvalid
+	}
+
+	hash := []byte(hashToken(token))
+	current, _ := entry.Get(rememberMeToken).(string)
+	if subtle.ConstantTimeCompare([]byte(current), hash) != 1 {
+		previous, _ := entry.Get(rememberMePrevious).(string)
+		rotated, _ := unixSeconds(entry.Get(rememberMeRotated))
+		if r.config.Grace > 0 && previous != "" && subtle.ConstantTimeCompare([]byte(previous), hash) == 1 &&
+			now.Sub(time.Unix(rotated, 0)) <= r.config.Grace {
+			// raced the rotation, the response of that request has the new cookie
+			return identity, nil
+		}
+		r.RevokeAll(identity)
+		r.clearCookie(c)
+		return "", ErrRememberMeTheft
+	}
+
+	// rotate, same series and expiry with a fresh token
+	token = randomToken()
+	entry.Set(rememberMePrevious, current)
+	entry.Set(rememberMeToken, hashToken(token))
+	entry.Set(rememberMeRotated, now.Unix())
+	if err := r.save(entry, time.Unix(expires, 0).Sub(now)); err != nil {
+		return "", err
+	}
+	r.setCookie(c, series+":"+token, time.Unix(expires, 0))
+	return identity, nil
+}
+
+// Forget ends the series of the current cookie (logout)
+func (r *RememberMe) Forget(c Context) error {
+	if cookie, err := c.Cookie(r.config.CookieName); err == nil {
+		if series, _, ok := strings.Cut(cookie.Value, ":"); ok {
+			r.deleter.Delete(r.seriesKey(series))
+		}
+	}
+	r.clearCookie(c)
+	return nil
+}
+
+// RevokeAll ends every series of identity, e.g. on password change
+func (r *RememberMe) RevokeAll(identity string) error {
+	for _, series := range r.identitySeries(identity) {
+		r.deleter.Delete(r.seriesKey(series))
+	}
+	return r.deleter.Delete(r.identityKey(identity))
+}
+
+func (r *RememberMe) identitySeries(identity string) []string {
+	index, err := r.config.Store.Load(r.identityKey(identity))
+	if err != nil || index == nil {
+		return nil
+	}
+	list, _ := index.Get(rememberMeSeries).(string)
+	return strings.Fields(list)
+}
+
+func (r *RememberMe) addToIdentity(identity, series string) error {
+	// drop series that already expired from the index
+	live := []string{series}
+	for _, s := range r.identitySeries(identity) {
+		if entry, err := r.config.Store.Load(r.seriesKey(s)); err == nil && entry != nil {
+			live = append(live, s)
+		}
+	}
+	index := NewMemorySession(r.identityKey(identity))
+	index.Set(rememberMeSeries, strings.Join(live, " "))
+	return r.save(index, r.config.TTL)
+}
+
+// save keeps a session under its id, the store must not hand back another
+// value like the cookie stores do
+func (r *RememberMe) save(session Session, ttl time.Duration) error {
+	value, err := r.config.Store.Save(session, ttl)
+	if err != nil {
+		return err
+	}
+	if value != session.ID() {
+		return errors.New("simplehttp: RememberMeConfig.Store doesn't keep sessions by id")
+	}
+	return nil
+}
+
+func (r *RememberMe) seriesKey(series string) string {
+	return "remember_me:series:" + series
+}
+
+func (r *RememberMe) identityKey(identity string) string {
+	return "remember_me:identity:" + identity
+}
+
+func (r *RememberMe) setCookie(c Context, value string, expires time.Time) {
+	c.SetCookie(&http.Cookie{
+		Name:     r.config.CookieName,
+		Value:    value,
+		Path:     r.config.CookiePath,
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.config.Secure,
+		SameSite: r.config.SameSite,
+	})
+}
+
+func (r *RememberMe) clearCookie(c Context) {
+	c.SetCookie(&http.Cookie{
+		Name:     r.config.CookieName,
+		Value:    "",
+		Path:     r.config.CookiePath,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.config.Secure,
+		SameSite: r.config.SameSite,
+	})
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
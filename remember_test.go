@@ -0,0 +1,130 @@
+package simplehttp_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/medatechnology/simplehttp"
+)
+
+// rememberClient dispatches requests with the remember-me cookie it was
+// given last
+type rememberClient struct {
+	t      *testing.T
+	server simplehttp.Server
+	cookie string
+}
+
+func (r *rememberClient) get(path, cookie string) int {
+	r.t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if cookie != "" {
+		req.AddCookie(&http.Cookie{Name: simplehttp.DEFAULT_REMEMBER_ME_COOKIE, Value: cookie})
+	}
+	resp, err := r.server.(simplehttp.Dispatcher).Dispatch(req)
+	if err != nil {
+		r.t.Fatalf("dispatch: %v", err)
+	}
+	resp.Body.Close()
+	for _, c := range resp.Cookies() {
+		if c.Name == simplehttp.DEFAULT_REMEMBER_ME_COOKIE {
+			r.cookie = c.Value
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestRememberMeRotation(t *testing.T) {
+	for name, newServer := range adapters {
+		t.Run(name, func(t *testing.T) {
+			clock := simplehttp.NewFakeClock(time.Now())
+			rm := simplehttp.NewRememberMe(simplehttp.RememberMeConfig{
+				Store: simplehttp.NewCacheSessionStore(simplehttp.NewMemoryCache()),
+				Clock: clock,
+				Grace: 30 * time.Second,
+			})
+			server := newServer()
+			server.GET("/login", func(c simplehttp.Context) error {
+				if err := rm.Issue(c, "ann"); err != nil {
+					return err
+				}
+				return c.String(http.StatusOK, "ok")
+			})
+			server.GET("/me", func(c simplehttp.Context) error {
+				identity, err := rm.Authenticate(c)
+				if err != nil {
+					return simplehttp.NewError(http.StatusUnauthorized, err.Error())
+				}
+				return c.String(http.StatusOK, identity)
+			})
+			client := &rememberClient{t: t, server: server}
+
+			client.get("/login", "")
+			issued := client.cookie
+			if status := client.get("/me", issued); status != http.StatusOK {
+				t.Fatalf("first use: status = %d, want 200", status)
+			}
+			rotated := client.cookie
+			if rotated == issued {
+				t.Fatal("token was not rotated")
+			}
+
+			// a parallel request with the token just replaced
+			if status := client.get("/me", issued); status != http.StatusOK {
+				t.Errorf("previous token in the grace window: status = %d, want 200", status)
+			}
+			if status := client.get("/me", rotated); status != http.StatusOK {
+				t.Fatalf("rotated token: status = %d, want 200", status)
+			}
+			latest := client.cookie
+
+			// replayed after the grace window, the cookie was stolen
+			clock.Advance(time.Minute)
+			if status := client.get("/me", rotated); status != http.StatusUnauthorized {
+				t.Errorf("replayed token: status = %d, want 401", status)
+			}
+			if status := client.get("/me", latest); status != http.StatusUnauthorized {
+				t.Errorf("series after theft: status = %d, want 401", status)
+			}
+		})
+	}
+}
+
+func TestRememberMeExpiry(t *testing.T) {
+	clock := simplehttp.NewFakeClock(time.Now())
+	rm := simplehttp.NewRememberMe(simplehttp.RememberMeConfig{
+		Store: simplehttp.NewCacheSessionStore(simplehttp.NewMemoryCache()),
+		TTL:   time.Hour,
+		Clock: clock,
+	})
+	server := adapters["echo"]()
+	server.GET("/login", func(c simplehttp.Context) error {
+		rm.Issue(c, "ann")
+		return c.String(http.StatusOK, "ok")
+	})
+	server.GET("/me", func(c simplehttp.Context) error {
+		if _, err := rm.Authenticate(c); err != nil {
+			return simplehttp.NewError(http.StatusUnauthorized, err.Error())
+		}
+		return c.String(http.StatusOK, "ok")
+	})
+	client := &rememberClient{t: t, server: server}
+	client.get("/login", "")
+	clock.Advance(2 * time.Hour)
+	if status := client.get("/me", client.cookie); status != http.StatusUnauthorized {
+		t.Errorf("expired series: status = %d, want 401", status)
+	}
+}
+
+func TestRememberMeCookieStore(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("a cookie store was accepted")
+		}
+	}()
+	simplehttp.NewRememberMe(simplehttp.RememberMeConfig{
+		Store: simplehttp.NewCookieSessionStore(simplehttp.CookieSessionConfig{Keys: [][]byte{[]byte("0123456789abcdef0123456789abcdef")}}),
+	})
+}
@@ -0,0 +1,219 @@
+package simplehttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	DEFAULT_RUNNER_SHUTDOWN_TIMEOUT = 30 * time.Second
+	DEFAULT_RUNNER_SHUTDOWN_RETRY   = 100 * time.Millisecond
+)
+
+// RunnerConfig configures a Runner
+type RunnerConfig struct {
+	// Logger and Metrics are given to the servers built with Runner.Shared,
+	// the logger also reports the servers starting and stopping. Logger
+	// defaults to NewDefaultLogger.
+	Logger  Logger
+	Metrics MetricsRecorder
+	// ShutdownTimeout bounds the Shutdown of Run, default 30s
+	ShutdownTimeout time.Duration
+	// Signals stopping Run, default SIGINT and SIGTERM
+	Signals []os.Signal
+}
+
+// Runner runs several servers of one process, e.g. the public API, the
+// admin UI and the metrics on their own ports, started and shut down
+// together. When one server fails the others are shut down.
+//
+//	runner := simplehttp.NewRunner(simplehttp.RunnerConfig{Logger: logger, Metrics: recorder})
+//	public := fiber.NewServer(config, runner.Shared())
+//	admin := fiber.NewServer(config, runner.Shared(), simplehttp.WithInternalAPI())
+//	runner.Add("public", ":8080", public)
+//	runner.Add("admin", "127.0.0.1:9090", admin)
+//	log.Fatal(runner.Run()) // until SIGINT or SIGTERM
+type Runner struct {
+	config  RunnerConfig
+	entries []*runnerEntry
+	mu      sync.Mutex
+	started bool
+	stopped chan struct{} // closed by the first Shutdown
+	stop    sync.Once
+}
+
+type runnerEntry struct {
+	name    string
+	address string
+	server  Server
+	done    chan struct{} // closed when Start of the server returned
+}
+
+func NewRunner(config ...RunnerConfig) *Runner {
+	r := &Runner{stopped: make(chan struct{})}
+	if len(config) > 0 {
+		r.config = config[0]
+	}
+	if r.config.Logger == nil {
+		r.config.Logger = NewDefaultLogger()
+	}
+	if r.config.ShutdownTimeout <= 0 {
+		r.config.ShutdownTimeout = DEFAULT_RUNNER_SHUTDOWN_TIMEOUT
+	}
+	if len(r.config.Signals) == 0 {
+		r.config.Signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	return r
+}
+
+// Shared is the ServerOption giving a server the Logger and Metrics of the
+// runner, the ones not set in RunnerConfig are left as they are
+func (r *Runner) Shared() ServerOption {
+	return WithConfig(func(c *Config) {
+		c.Logger = r.config.Logger
+		if r.config.Metrics != nil {
+			c.Metrics = r.config.Metrics
+		}
+	})
+}
+
+// Add registers a server started on address by Start, empty means the
+// address of its config. Servers are added before Start.
+func (r *Runner) Add(name, address string, server Server) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.started {
+		panic("simplehttp: Runner.Add after Start")
+	}
+	r.entries = append(r.entries, &runnerEntry{name: name, address: address, server: server, done: make(chan struct{})})
+}
+
+// Start starts all the servers and blocks until they stopped. A server
+// failing before Shutdown shuts the others down and its error is returned,
+// servers stopped by Shutdown return nil.
+func (r *Runner) Start() error {
+	r.mu.Lock()
+	if r.started {
+		r.mu.Unlock()
+		return fmt.Errorf("simplehttp: runner already started")
+	}
+	r.started = true
+	entries := r.entries
+	r.mu.Unlock()
+
+	errs := make(chan error, len(entries))
+	for _, entry := range entries {
+		go func(entry *runnerEntry) {
+			r.config.Logger.Infof("runner: starting %s on %s", entry.name, entry.address)
+			err := entry.server.Start(entry.address)
+			close(entry.done)
+			if err != nil && !errors.Is(err, http.ErrServerClosed) && !r.stopping() {
+				errs <- fmt.Errorf("%s: %w", entry.name, err)
+				return
+			}
+			r.config.Logger.Infof("runner: %s stopped", entry.name)
+			errs <- nil
+		}(entry)
+	}
+
+	var first error
+	for range entries {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+			r.config.Logger.Errorf("runner: %v, shutting down the other servers", err)
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), r.config.ShutdownTimeout)
+				defer cancel()
+				r.Shutdown(ctx)
+			}()
+		}
+	}
+	return first
+}
+
+// Shutdown gracefully shuts all the servers down at once, each drains as
+// set in its ShutdownConfig. The errors are joined.
+func (r *Runner) Shutdown(ctx context.Context) error {
+	r.stop.Do(func() { close(r.stopped) })
+	r.mu.Lock()
+	entries, started := r.entries, r.started
+	r.mu.Unlock()
+
+	errs := make([]error, len(entries))
+	var wg sync.WaitGroup
+	for i, entry := range entries {
+		wg.Add(1)
+		go func(i int, entry *runnerEntry) {
+			defer wg.Done()
+			if err := r.shutdown(ctx, entry, started); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", entry.name, err)
+			}
+		}(i, entry)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Run starts the servers and shuts them down on one of the Signals, or
+// when one of them fails. It returns once all stopped.
+func (r *Runner) Run() error {
+	ctx, cancel := signal.NotifyContext(context.Background(), r.config.Signals...)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- r.Start() }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+	r.config.Logger.Infof("runner: shutting down")
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), r.config.ShutdownTimeout)
+	defer cancelShutdown()
+	err := r.Shutdown(shutdownCtx)
+	if startErr := <-done; startErr != nil {
+		err = errors.Join(startErr, err)
+	}
+	return err
+}
+
+// shutdown shuts a server down, again while its Start hasn't returned: a
+// server still starting when Shutdown was first called may miss it
+func (r *Runner) shutdown(ctx context.Context, entry *runnerEntry, started bool) error {
+	err := entry.server.Shutdown(ctx)
+	if !started {
+		return err
+	}
+	ticker := time.NewTicker(DEFAULT_RUNNER_SHUTDOWN_RETRY)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-entry.done:
+			return err
+		case <-ctx.Done():
+			if err == nil {
+				err = ctx.Err()
+			}
+			return err
+		case <-ticker.C:
+			entry.server.Shutdown(ctx)
+		}
+	}
+}
+
+func (r *Runner) stopping() bool {
+	select {
+	case <-r.stopped:
+		return true
+	default:
+		return false
+	}
+}
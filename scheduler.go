@@ -0,0 +1,250 @@
+package simplehttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const DEFAULT_SCHEDULER_PREFIX = "/_scheduled"
+
+// DefaultScheduleExempt are the middleware scheduled requests skip, those
+// authenticating and authorizing clients
+var DefaultScheduleExempt = []string{"api key", "basic auth", "oidc", "acl", "authorize", "csrf", "captcha", "ip filter", "internal only", "brute force"}
+
+// scheduledKey holds the Scheduler in the context of the requests it
+// dispatches, something clients can't send
+type scheduledKey struct{}
+
+// SchedulerConfig configures NewScheduler
+type SchedulerConfig struct {
+	Server Server // required, must implement Dispatcher, the framework servers do
+	Prefix string // of the routes of the tasks, default "/_scheduled"
+	// Exempt are the names of the middleware skipped by scheduled requests
+	// under Prefix, default DefaultScheduleExempt. Use IsScheduled as Skipper
+	// of your own.
+	Exempt   []string
+	Location *time.Location // of the schedules, default time.Local
+	Logger   Logger         // reports failed runs, defaults to NewDefaultLogger
+}
+
+// Scheduler runs internal tasks on cron schedules. Every run is a request
+// dispatched in memory through the routes and middleware of the server, so
+// tasks are logged, measured and recovered like HTTP traffic, while the
+// middleware of Exempt let them through without credentials, on the routes
+// under Prefix only. A run still going when the next one is due is skipped.
+//
+//	scheduler := simplehttp.NewScheduler(simplehttp.SchedulerConfig{Server: server})
+//	scheduler.Schedule("cleanup", "*/15 * * * *", func(c simplehttp.Context) error {
+//		return sessions.Purge(c.Context())
+//	})
+//	scheduler.Start()
+//	defer scheduler.Stop(context.Background())
+type Scheduler struct {
+	config     SchedulerConfig
+	dispatcher Dispatcher
+	exempt     map[string]bool
+
+	mu      sync.Mutex
+	tasks   map[string]*scheduledTask
+	started bool
+	done    chan struct{}
+	stop    sync.Once
+	running sync.WaitGroup
+}
+
+type scheduledTask struct {
+	name     string
+	schedule *CronSchedule
+	busy     atomic.Bool
+}
+
+func NewScheduler(config SchedulerConfig) *Scheduler {
+	dispatcher, ok := config.Server.(Dispatcher)
+	if !ok {
+		panic("simplehttp: SchedulerConfig.Server must implement Dispatcher")
+	}
+	if config.Prefix == "" {
+		config.Prefix = DEFAULT_SCHEDULER_PREFIX
+	}
+	config.Prefix = "/" + strings.Trim(config.Prefix, "/")
+	if config.Exempt == nil {
+		config.Exempt = DefaultScheduleExempt
+	}
+	if config.Location == nil {
+		config.Location = time.Local
+	}
+	if config.Logger == nil {
+		config.Logger = NewDefaultLogger()
+	}
+	s := &Scheduler{
+		config:     config,
+		dispatcher: dispatcher,
+		exempt:     make(map[string]bool, len(config.Exempt)),
+		tasks:      make(map[string]*scheduledTask),
+		done:       make(chan struct{}),
+	}
+	for _, name := range config.Exempt {
+		s.exempt[name] = true
+	}
+	return s
+}
+
+// Schedule registers handler as POST Prefix/name, refused to anything but
+// the scheduler, and runs it on spec, see CronSchedule. Register the tasks
+// before the server starts.
+func (s *Scheduler) Schedule(name, spec string, handler HandlerFunc) error {
+	if name == "" || strings.ContainsAny(name, "/?#") {
+		return fmt.Errorf("scheduler: invalid task name %q", name)
+	}
+	schedule, err := ParseCron(spec)
+	if err != nil {
+		return fmt.Errorf("scheduler: %s: %w", name, err)
+	}
+	task := &scheduledTask{name: name, schedule: schedule}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.tasks[name]; exists {
+		return fmt.Errorf("scheduler: task %q already scheduled", name)
+	}
+	s.tasks[name] = task
+	s.config.Server.POST(s.config.Prefix+"/"+name, func(c Context) error {
+		if !IsScheduled(c) {
+			return NewError(http.StatusNotFound, "not found")
+		}
+		return handler(c)
+	})
+	if s.started {
+		s.running.Add(1)
+		go s.loop(task)
+	}
+	return nil
+}
+
+// Start runs the tasks on their schedules
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return
+	}
+	s.started = true
+	for _, task := range s.tasks {
+		s.running.Add(1)
+		go s.loop(task)
+	}
+}
+
+// Stop ends the schedules and waits for the runs in progress until ctx ends
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.stop.Do(func() { close(s.done) })
+	finished := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Run runs the task now, outside of its schedule, and returns the response
+// status
+func (s *Scheduler) Run(name string) (int, error) {
+	s.mu.Lock()
+	task, ok := s.tasks[name]
+	s.mu.Unlock()
+	if !ok {
+		return 0, fmt.Errorf("scheduler: unknown task %q", name)
+	}
+	select {
+	case <-s.done:
+		return 0, errors.New("scheduler: stopped")
+	default:
+	}
+	return s.run(task)
+}
+
+func (s *Scheduler) loop(task *scheduledTask) {
+	defer s.running.Done()
+	for {
+		now := time.Now().In(s.config.Location)
+		next := task.schedule.Next(now)
+		if next.IsZero() {
+			s.config.Logger.Errorf("scheduler: %s never runs", task.name)
+			return
+		}
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-timer.C:
+			if _, err := s.run(task); err != nil {
+				s.config.Logger.Errorf("scheduler: %s: %v", task.name, err)
+			}
+		case <-s.done:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+var errTaskBusy = errors.New("previous run still in progress, skipped")
+
+func (s *Scheduler) run(task *scheduledTask) (int, error) {
+	if !task.busy.CompareAndSwap(false, true) {
+		return 0, errTaskBusy
+	}
+	defer task.busy.Store(false)
+
+	ctx := context.WithValue(context.Background(), scheduledKey{}, s)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.Prefix+"/"+task.name, http.NoBody)
+	if err != nil {
+		return 0, err
+	}
+	req.Host = "localhost"
+	req.RemoteAddr = "127.0.0.1:0"
+	resp, err := s.dispatcher.Dispatch(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp.StatusCode, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp.StatusCode, nil
+}
+
+// IsScheduled reports whether the request was dispatched by a running
+// Scheduler, usable as Skipper
+func IsScheduled(c Context) bool {
+	return schedulerOf(c) != nil
+}
+
+func schedulerOf(c Context) *Scheduler {
+	ctx := c.Context()
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(scheduledKey{}).(*Scheduler)
+	return s
+}
+
+// scheduledExempt reports whether the middleware called name lets the
+// request of a Scheduler through, only on the routes of its tasks
+func scheduledExempt(c Context, name string) bool {
+	s := schedulerOf(c)
+	if s == nil || !s.exempt[name] {
+		return false
+	}
+	return strings.HasPrefix(c.Request().URL.Path, s.config.Prefix+"/")
+}
@@ -2,115 +2,174 @@ package simplehttp
 
 import (
 	"net/http"
-	"sync"
-	"time"
-
-	"golang.org/x/time/rate"
+	"strconv"
+	"strings"
 )
 
 // Security middleware configuration
 type SecurityConfig struct {
-	AllowedHosts          []string
-	SSLRedirect           bool
-	SSLHost               string
-	STSSeconds            int64
+	AllowedHosts          []string          // Host allow-list, "*.example.com" matches subdomains, empty allows all
+	SSLRedirect           bool              // redirect plain HTTP requests to HTTPS
+	SSLHost               string            // host to redirect to, defaults to the request host
+	SSLProxyHeaders       map[string]string // e.g. {"X-Forwarded-Proto": "https"}, set only behind a trusted proxy
+	STSSeconds            int64             // Strict-Transport-Security max-age, 0 disables, only sent over HTTPS
 	STSIncludeSubdomains  bool
 	FrameDeny             bool
 	ContentTypeNosniff    bool
 	BrowserXssFilter      bool
 	ContentSecurityPolicy string
+
+	// Below default to the DEFAULT_* values when empty, SECURITY_HEADER_OFF
+	// leaves the header out
+	ReferrerPolicy            string
+	PermissionsPolicy         string // e.g. "camera=(), microphone=(), geolocation=()", not sent by default
+	CrossOriginOpenerPolicy   string
+	CrossOriginEmbedderPolicy string // "require-corp" breaks cross-origin embeds without CORP, not sent by default
+	CrossOriginResourcePolicy string
+
+	Skipper Skipper
+}
+
+const (
+	SECURITY_HEADER_OFF = "off"
+
+	DEFAULT_REFERRER_POLICY              = "strict-origin-when-cross-origin"
+	DEFAULT_CROSS_ORIGIN_OPENER_POLICY   = "same-origin"
+	DEFAULT_CROSS_ORIGIN_RESOURCE_POLICY = "same-origin"
+)
+
+// WithDefaults fills the empty policy headers with their defaults
+func (config SecurityConfig) WithDefaults() SecurityConfig {
+	config.ReferrerPolicy = securityDefault(config.ReferrerPolicy, DEFAULT_REFERRER_POLICY)
+	config.PermissionsPolicy = securityDefault(config.PermissionsPolicy, SECURITY_HEADER_OFF)
+	config.CrossOriginOpenerPolicy = securityDefault(config.CrossOriginOpenerPolicy, DEFAULT_CROSS_ORIGIN_OPENER_POLICY)
+	config.CrossOriginEmbedderPolicy = securityDefault(config.CrossOriginEmbedderPolicy, SECURITY_HEADER_OFF)
+	config.CrossOriginResourcePolicy = securityDefault(config.CrossOriginResourcePolicy, DEFAULT_CROSS_ORIGIN_RESOURCE_POLICY)
+	return config
+}
+
+func securityDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
 }
 
 func MiddlewareSecurity(config SecurityConfig) Middleware {
-	return WithName("basic security", Security(config))
+	return Skip(WithName("basic security", Security(config)), config.Skipper)
 }
 
 // Security returns security middleware
 func Security(config SecurityConfig) MiddlewareFunc {
+	config = config.WithDefaults()
+	policies := [][2]string{
+		{"Referrer-Policy", config.ReferrerPolicy},
+		{"Permissions-Policy", config.PermissionsPolicy},
+		{"Cross-Origin-Opener-Policy", config.CrossOriginOpenerPolicy},
+		{"Cross-Origin-Embedder-Policy", config.CrossOriginEmbedderPolicy},
+		{"Cross-Origin-Resource-Policy", config.CrossOriginResourcePolicy},
+	}
+
+	sts := ""
+	if config.STSSeconds > 0 {
+		sts = "max-age=" + strconv.FormatInt(config.STSSeconds, 10)
+		if config.STSIncludeSubdomains {
+			sts += "; includeSubDomains"
+		}
+	}
+
 	return func(next HandlerFunc) HandlerFunc {
 		return func(c Context) error {
 			// fmt.Println("--- security middleware")
+			if len(config.AllowedHosts) > 0 {
+				host := requestHost(c)
+				if host == "" {
+					return NewError(http.StatusBadRequest, "missing host")
+				}
+				if !hostAllowed(host, config.AllowedHosts) {
+					return NewError(http.StatusMisdirectedRequest, "host not allowed", host)
+				}
+			}
+
+			secure := config.SSLRedirect || sts != ""
+			if secure {
+				secure = isSecureRequest(c, config.SSLProxyHeaders)
+			}
+			if config.SSLRedirect && !secure {
+				host := config.SSLHost
+				if host == "" {
+					host = requestHost(c)
+				}
+				// 301 would turn a POST into a GET, 308 keeps the method and body
+				status := http.StatusMovedPermanently
+				if c.GetMethod() != http.MethodGet && c.GetMethod() != http.MethodHead {
+					status = http.StatusPermanentRedirect
+				}
+				c.SetResponseHeader("Location", "https://"+host+c.Request().URL.RequestURI())
+				return c.String(status, "")
+			}
+			if sts != "" && secure {
+				c.SetResponseHeader("Strict-Transport-Security", sts)
+			}
+
 			if config.FrameDeny {
-				c.Response().Header().Set("X-Frame-Options", "DENY")
+				c.SetResponseHeader("X-Frame-Options", "DENY")
 			}
 			if config.ContentTypeNosniff {
-				c.Response().Header().Set("X-Content-Type-Options", "nosniff")
+				c.SetResponseHeader("X-Content-Type-Options", "nosniff")
 			}
 			if config.BrowserXssFilter {
-				c.Response().Header().Set("X-XSS-Protection", "1; mode=block")
+				c.SetResponseHeader("X-XSS-Protection", "1; mode=block")
 			}
 			if config.ContentSecurityPolicy != "" {
-				c.Response().Header().Set("Content-Security-Policy", config.ContentSecurityPolicy)
+				c.SetResponseHeader("Content-Security-Policy", config.ContentSecurityPolicy)
 			}
-			return next(c)
-		}
-	}
-}
-
-// Rate limit, remember burst is usually the one that taking effects (as maximum)
-// Tested OK, it works fine.
-// NOTE: make sure the cache middleware is not interfeering, because that can
-// effect the rateLimit. When it is returned from cache, it doesn't hit the
-// rate limit at all.
-type RateLimit struct {
-	requestsPerSecond int
-	burstSize         int
-	store             map[string]*rate.Limiter
-	mu                sync.RWMutex
-}
-
-// RateLimiter middleware configuration
-type RateLimitConfig struct {
-	RequestsPerSecond int
-	BurstSize         int
-	ClientTimeout     time.Duration
-	KeyFunc           func(Context) string // Function to generate rate limit key
-}
-
-func MiddlewareRateLimiter(config RateLimitConfig) Middleware {
-	return WithName("rate limiter", RateLimiter(config))
-}
-
-// RateLimiter returns a rate limiting middleware
-func RateLimiter(config RateLimitConfig) MiddlewareFunc {
-	limiter := newRateLimiter(config)
-	return func(next HandlerFunc) HandlerFunc {
-		return func(c Context) error {
-			key := config.KeyFunc(c)
-			if err := limiter.Allow(key); err != nil {
-				return NewError(http.StatusTooManyRequests, "rate limit exceeded")
+			for _, policy := range policies {
+				if policy[1] != SECURITY_HEADER_OFF {
+					c.SetResponseHeader(policy[0], policy[1])
+				}
 			}
 			return next(c)
 		}
 	}
 }
 
-func newRateLimiter(config RateLimitConfig) *RateLimit {
-	return &RateLimit{
-		requestsPerSecond: config.RequestsPerSecond,
-		burstSize:         config.BurstSize,
-		store:             make(map[string]*rate.Limiter),
+// requestHost returns the Host of the request, net/http moves it out of the
+// headers so fall back to the request itself
+func requestHost(c Context) string {
+	if host := c.GetHeader("Host"); host != "" {
+		return host
+	}
+	r := c.Request()
+	if r.Host != "" {
+		return r.Host
 	}
+	return r.URL.Host
 }
 
-func (rl *RateLimit) getLimiter(key string) *rate.Limiter {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	limiter, exists := rl.store[key]
-
-	if !exists {
-		limiter = rate.NewLimiter(rate.Limit(rl.requestsPerSecond), rl.burstSize)
-		rl.store[key] = limiter
+// hostAllowed matches host (with or without port) against the allow-list
+func hostAllowed(host string, allowed []string) bool {
+	host = strings.ToLower(host)
+	hostname := strings.ToLower(StripPort(host))
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		switch {
+		case a == host || a == hostname:
+			return true
+		case strings.HasPrefix(a, "*.") && strings.HasSuffix(hostname, a[1:]):
+			return true
+		}
 	}
-
-	return limiter
+	return false
 }
 
-func (rl *RateLimit) Allow(key string) error {
-	limiter := rl.getLimiter(key)
-	if !limiter.Allow() {
-		return ErrRateLimitExceeded
+// isSecureRequest checks SSLProxyHeaders, then the TLS of the connection or
+// the scheme forwarded by a trusted proxy
+func isSecureRequest(c Context, proxyHeaders map[string]string) bool {
+	for header, value := range proxyHeaders {
+		if strings.EqualFold(c.GetHeader(header), value) {
+			return true
+		}
 	}
-	return nil
+	return c.IsTLS()
 }
@@ -0,0 +1,351 @@
+package simplehttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_SENTRY_TIMEOUT    = 5 * time.Second
+	DEFAULT_SENTRY_QUEUE_SIZE = 100
+)
+
+// SentryConfig configures a SentryReporter
+type SentryConfig struct {
+	DSN         string // required, https://<key>@<host>/<project>
+	Environment string
+	Release     string
+	ServerName  string            // default the hostname
+	Tags        map[string]string // added to every event
+	// RedactHeaders and RedactParams (query) of the request are sent as
+	// [REDACTED], default DefaultRedactHeaders and DefaultAuditRedactParams
+	RedactHeaders []string
+	RedactParams  []string
+	// BeforeSend can change an event or drop it by returning nil
+	BeforeSend func(event *SentryEvent) *SentryEvent
+	Timeout    time.Duration // per event, default 5s
+	QueueSize  int           // events waiting to be sent, more are dropped, default 100
+	Client     *http.Client  // default one with Timeout
+}
+
+// SentryEvent is the subset of the Sentry event payload sent by the
+// reporter, see https://develop.sentry.dev/sdk/event-payloads/
+type SentryEvent struct {
+	EventID     string                 `json:"event_id"`
+	Timestamp   time.Time              `json:"timestamp"`
+	Platform    string                 `json:"platform"`
+	Level       string                 `json:"level"` // "fatal" for panics, "error" otherwise
+	ServerName  string                 `json:"server_name,omitempty"`
+	Environment string                 `json:"environment,omitempty"`
+	Release     string                 `json:"release,omitempty"`
+	Transaction string                 `json:"transaction,omitempty"`
+	Tags        map[string]string      `json:"tags,omitempty"`
+	Extra       map[string]interface{} `json:"extra,omitempty"`
+	User        *SentryUser            `json:"user,omitempty"`
+	Request     *SentryRequest         `json:"request,omitempty"`
+	Exception   SentryExceptions       `json:"exception"`
+}
+
+type SentryExceptions struct {
+	Values []SentryException `json:"values"`
+}
+
+type SentryUser struct {
+	ID        string `json:"id,omitempty"`
+	IPAddress string `json:"ip_address,omitempty"`
+}
+
+type SentryRequest struct {
+	URL         string            `json:"url"`
+	Method      string            `json:"method"`
+	QueryString string            `json:"query_string,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+type SentryException struct {
+	Type       string            `json:"type"`
+	Value      string            `json:"value"`
+	Mechanism  *SentryMechanism  `json:"mechanism,omitempty"`
+	Stacktrace *SentryStacktrace `json:"stacktrace,omitempty"`
+}
+
+type SentryStacktrace struct {
+	Frames []SentryFrame `json:"frames"`
+}
+
+type SentryMechanism struct {
+	Type    string `json:"type"`
+	Handled bool   `json:"handled"`
+}
+
+type SentryFrame struct {
+	Function string `json:"function"`
+	Module   string `json:"module,omitempty"`
+	AbsPath  string `json:"abs_path,omitempty"`
+	Lineno   int    `json:"lineno,omitempty"`
+	InApp    bool   `json:"in_app"`
+}
+
+// SentryReporter is a Reporter sending to Sentry's envelope API without the
+// Sentry SDK. Events are sent in the background, Flush waits for them.
+//
+//	sentry, err := simplehttp.NewSentryReporter(simplehttp.SentryConfig{DSN: os.Getenv("SENTRY_DSN"), Environment: "production"})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer sentry.Close()
+//	server.Use(simplehttp.MiddlewareRecover(simplehttp.RecoverConfig{Reporter: sentry}))
+type SentryReporter struct {
+	config   SentryConfig
+	endpoint string
+	auth     string
+	queue    chan *SentryEvent
+	pending  sync.WaitGroup
+	mu       sync.Mutex
+	closed   bool
+}
+
+func NewSentryReporter(config SentryConfig) (*SentryReporter, error) {
+	dsn, err := url.Parse(config.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("sentry DSN: %v", err)
+	}
+	// the project is the last path segment, self-hosted Sentry can have a
+	// prefix before it
+	path := strings.TrimSuffix(dsn.Path, "/")
+	slash := strings.LastIndex(path, "/")
+	if dsn.User == nil || dsn.User.Username() == "" || dsn.Host == "" || slash < 0 || slash == len(path)-1 {
+		return nil, errors.New("sentry DSN: want https://<key>@<host>/<project>")
+	}
+	prefix, project := path[:slash], path[slash+1:]
+	if config.ServerName == "" {
+		config.ServerName, _ = os.Hostname()
+	}
+	if config.RedactHeaders == nil {
+		config.RedactHeaders = DefaultRedactHeaders
+	}
+	if config.RedactParams == nil {
+		config.RedactParams = DefaultAuditRedactParams
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DEFAULT_SENTRY_TIMEOUT
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = DEFAULT_SENTRY_QUEUE_SIZE
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: config.Timeout}
+	}
+	r := &SentryReporter{
+		config:   config,
+		endpoint: dsn.Scheme + "://" + dsn.Host + prefix + "/api/" + project + "/envelope/",
+		auth:     "Sentry sentry_version=7, sentry_client=simplehttp/1.0, sentry_key=" + dsn.User.Username(),
+		queue:    make(chan *SentryEvent, config.QueueSize),
+	}
+	go r.run()
+	return r, nil
+}
+
+// Report queues the event of a panic (stack set) or error, dropped when the
+// queue is full or after Close
+func (r *SentryReporter) Report(c Context, err interface{}, stack []byte) {
+	event := r.newEvent(c, err, stack)
+	if r.config.BeforeSend != nil {
+		if event = r.config.BeforeSend(event); event == nil {
+			return
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	r.pending.Add(1)
+	select {
+	case r.queue <- event:
+	default:
+		r.pending.Done()
+	}
+}
+
+// Flush waits until the queued events are sent or timeout passed, false on
+// timeout
+func (r *SentryReporter) Flush(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		r.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Close sends the queued events, waiting at most Timeout, then stops the
+// reporter
+func (r *SentryReporter) Close() error {
+	r.Flush(r.config.Timeout)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.closed {
+		r.closed = true
+		close(r.queue)
+	}
+	return nil
+}
+
+func (r *SentryReporter) run() {
+	for event := range r.queue {
+		r.send(event)
+		r.pending.Done()
+	}
+}
+
+func (r *SentryReporter) send(event *SentryEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	header, _ := json.Marshal(map[string]interface{}{"event_id": event.EventID, "sent_at": time.Now().UTC()})
+	item, _ := json.Marshal(map[string]interface{}{"type": "event", "length": len(payload)})
+	var body bytes.Buffer
+	for _, line := range [][]byte{header, item, payload} {
+		body.Write(line)
+		body.WriteByte('\n')
+	}
+
+	req, err := http.NewRequest(http.MethodPost, r.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(HEADER_CONTENT_TYPE, "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", r.auth)
+	resp, err := r.config.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("sentry: %s", resp.Status)
+	}
+	return nil
+}
+
+func (r *SentryReporter) newEvent(c Context, err interface{}, stack []byte) *SentryEvent {
+	event := &SentryEvent{
+		EventID:     strings.ReplaceAll(NewUUIDv4(), "-", ""),
+		Timestamp:   time.Now().UTC(),
+		Platform:    "go",
+		Level:       "error",
+		ServerName:  r.config.ServerName,
+		Environment: r.config.Environment,
+		Release:     r.config.Release,
+		Tags:        make(map[string]string),
+	}
+	for k, v := range r.config.Tags {
+		event.Tags[k] = v
+	}
+	for k, v := range GetRequestTags(c) {
+		event.Tags[k] = v
+	}
+	if rid := RequestIDOf(c); rid != "" {
+		event.Tags["request_id"] = rid
+	}
+
+	exception := SentryException{Type: fmt.Sprintf("%T", err), Value: fmt.Sprint(err)}
+	if stack != nil {
+		event.Level = "fatal"
+		exception.Mechanism = &SentryMechanism{Type: "recover", Handled: false}
+		exception.Stacktrace = &SentryStacktrace{Frames: sentryFrames(stack)}
+	}
+	event.Exception.Values = []SentryException{exception}
+
+	event.Transaction = c.GetMethod() + " " + c.GetPath()
+	if req := c.Request(); req != nil {
+		event.Request = &SentryRequest{
+			URL:         c.Scheme() + "://" + c.Host() + req.URL.Path,
+			Method:      req.Method,
+			QueryString: redactQuery(req.URL.Query(), r.config.RedactParams),
+			Headers:     redactHeaders(req.Header, r.config.RedactHeaders),
+		}
+	}
+	user := &SentryUser{}
+	if headers := c.GetHeaders(); headers != nil {
+		user.IPAddress = StripPort(headers.IP())
+	}
+	if principal := GetPrincipal(c); principal != nil {
+		user.ID = fmt.Sprint(principal)
+	}
+	if *user != (SentryUser{}) {
+		event.User = user
+	}
+	return event
+}
+
+// sentryFrames parses a debug.Stack() trace into frames, Sentry wants the
+// oldest call first. The frames of the panic machinery and debug.Stack are
+// left out.
+func sentryFrames(stack []byte) []SentryFrame {
+	lines := strings.Split(string(stack), "\n")
+	var frames []SentryFrame
+	// lines[0] is "goroutine N [running]:", then function and location pairs
+	for i := 1; i+1 < len(lines); i += 2 {
+		function, location := lines[i], strings.TrimSpace(lines[i+1])
+		if function == "" || !strings.HasPrefix(lines[i+1], "\t") {
+			break
+		}
+		function = strings.TrimPrefix(function, "created by ")
+		if j := strings.Index(function, " in goroutine "); j >= 0 {
+			function = function[:j]
+		}
+		if j := strings.LastIndex(function, "("); j > 0 && strings.HasSuffix(function, ")") {
+			function = function[:j]
+		}
+		if function == "panic" || strings.HasPrefix(function, "runtime/debug.") || strings.HasPrefix(function, "runtime.gopanic") {
+			frames = frames[:0] // everything before belongs to the recover
+			continue
+		}
+		location, _, _ = strings.Cut(location, " +")
+		frame := SentryFrame{Function: function, AbsPath: location}
+		if j := strings.LastIndex(location, ":"); j > 0 {
+			frame.AbsPath = location[:j]
+			frame.Lineno, _ = strconv.Atoi(location[j+1:])
+		}
+		// github.com/org/repo/pkg.(*T).Method: module is up to the first
+		// dot after the last slash
+		slash := strings.LastIndex(function, "/") + 1
+		if dot := strings.Index(function[slash:], "."); dot >= 0 {
+			frame.Module, frame.Function = function[:slash+dot], function[slash+dot+1:]
+		}
+		// standard library packages have no dot in their first element
+		// (main aside), dependencies live in the module cache
+		first, _, _ := strings.Cut(frame.Module, "/")
+		frame.InApp = (first == "main" || strings.Contains(first, ".")) && !strings.Contains(frame.AbsPath, "/pkg/mod/")
+		frames = append(frames, frame)
+	}
+	for i, j := 0, len(frames)-1; i < j; i, j = i+1, j-1 {
+		frames[i], frames[j] = frames[j], frames[i]
+	}
+	return frames
+}
+
+func redactQuery(query url.Values, redact []string) string {
+	for key := range query {
+		if containsFold(redact, key) {
+			query[key] = []string{REDACTED}
+		}
+	}
+	return query.Encode()
+}
@@ -0,0 +1,230 @@
+package simplehttp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HEADER_ACCEPT_RANGES     = "Accept-Ranges"
+	HEADER_CONTENT_RANGE     = "Content-Range"
+	HEADER_CONTENT_LENGTH    = "Content-Length"
+	HEADER_RANGE             = "Range"
+	HEADER_IF_RANGE          = "If-Range"
+	HEADER_IF_NONE_MATCH     = "If-None-Match"
+	HEADER_IF_MODIFIED_SINCE = "If-Modified-Since"
+)
+
+// ServeFileOptions configures ServeFile and ServeContent
+type ServeFileOptions struct {
+	Attachment  bool   // Content-Disposition: attachment, a download
+	Filename    string // of the download and for the content type, defaults to the file name
+	ContentType string // defaults to the type of the Filename extension
+	// ETag replaces the one made from the modification time and size, e.g.
+	// the hash of the content
+	ETag string
+}
+
+// ServeFile sends a file the same way on every adapter, with the
+// conditional and range requests of RFC 9110: If-None-Match and
+// If-Modified-Since answer 304, If-Match and If-Unmodified-Since 412, and a
+// single byte Range 206 (honoring If-Range), so video seeking and resumed
+// downloads work. Several ranges get the whole file. A missing file is 404.
+// path is served as is, clean what comes from the request so "../" can't
+// leave the directory, or use FileHandler.HandleDownload which does:
+//
+//	server.GET("/videos/:name", func(c simplehttp.Context) error {
+//		name := filepath.Clean("/" + c.GetParam("name")) // rooted, ".." stops at /
+//		return simplehttp.ServeFile(c, filepath.Join("./videos", name), simplehttp.ServeFileOptions{})
+//	})
+func ServeFile(c Context, path string, opts ServeFileOptions) error {
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return NewError(http.StatusNotFound, "file not found")
+		}
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil || stat.IsDir() {
+		file.Close()
+		return NewError(http.StatusNotFound, "file not found")
+	}
+	if opts.Filename == "" {
+		opts.Filename = filepath.Base(path)
+	}
+	version := ResourceVersion{
+		ETag:         fileETag(stat.ModTime(), stat.Size()),
+		LastModified: stat.ModTime(),
+	}
+	return ServeContent(c, file, stat.Size(), version, opts)
+}
+
+// ServeContent is ServeFile for content that isn't a file of the disk.
+// content is closed once sent when it is an io.Closer.
+func ServeContent(c Context, content io.ReadSeeker, size int64, version ResourceVersion, opts ServeFileOptions) error {
+	body := &closeOnEOF{ReadCloser: readSeekCloser{content: content}}
+	sent := false
+	defer func() {
+		if !sent {
+			body.Close()
+		}
+	}()
+
+	if opts.ETag != "" {
+		version.ETag = opts.ETag
+	}
+	contentType := opts.ContentType
+	if contentType == "" {
+		contentType = ContentTypeByFilename(opts.Filename)
+	}
+	SetResourceVersion(c, version)
+	c.SetResponseHeader(HEADER_ACCEPT_RANGES, "bytes")
+
+	if err := CheckPreconditions(c, version); err != nil {
+		return err
+	}
+	if notModified(c, version) {
+		return c.Blob(http.StatusNotModified, contentType, nil)
+	}
+	if opts.Attachment {
+		c.SetResponseHeader(HEADER_CONTENT_DISPOSITION, ContentDisposition("attachment", opts.Filename))
+	}
+
+	code, start, length := http.StatusOK, int64(0), size
+	if header := c.GetHeader(HEADER_RANGE); header != "" && rangeApplies(c.GetHeader(HEADER_IF_RANGE), version) {
+		rangeStart, rangeLength, ok := parseByteRange(header, size)
+		if !ok {
+			c.SetResponseHeader(HEADER_CONTENT_RANGE, "bytes */"+strconv.FormatInt(size, 10))
+			return NewError(http.StatusRequestedRangeNotSatisfiable, "range not satisfiable")
+		}
+		if rangeLength >= 0 {
+			code, start, length = http.StatusPartialContent, rangeStart, rangeLength
+			c.SetResponseHeader(HEADER_CONTENT_RANGE, fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, size))
+		}
+	}
+	c.SetResponseHeader(HEADER_CONTENT_LENGTH, strconv.FormatInt(length, 10))
+
+	if c.GetMethod() == http.MethodHead {
+		return c.Blob(code, contentType, nil)
+	}
+	if start > 0 {
+		if _, err := content.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	body.ReadCloser = readSeekCloser{content: content, reader: io.LimitReader(content, length)}
+	sent = true
+	if err := c.Stream(code, contentType, body); err != nil {
+		body.Close()
+		return err
+	}
+	return nil
+}
+
+// fileETag is the ETag of a file without a content hash, from its
+// modification time and size
+func fileETag(modTime time.Time, size int64) string {
+	return fmt.Sprintf(`"%x-%x"`, modTime.UnixNano(), size)
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since without it, for
+// GET and HEAD with the weak comparison
+func notModified(c Context, version ResourceVersion) bool {
+	if method := c.GetMethod(); method != http.MethodGet && method != http.MethodHead {
+		return false
+	}
+	if ifNoneMatch := c.GetHeader(HEADER_IF_NONE_MATCH); ifNoneMatch != "" {
+		if version.ETag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(version.ETag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if since := c.GetHeader(HEADER_IF_MODIFIED_SINCE); since != "" && !version.LastModified.IsZero() {
+		t, err := http.ParseTime(since)
+		return err == nil && !version.LastModified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// rangeApplies evaluates If-Range: the range is only for the version the
+// client already has part of, a strong ETag or the exact Last-Modified
+func rangeApplies(ifRange string, version ResourceVersion) bool {
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return !strings.HasPrefix(ifRange, "W/") && ifRange == version.ETag
+	}
+	t, err := http.ParseTime(ifRange)
+	return err == nil && !version.LastModified.IsZero() && version.LastModified.Truncate(time.Second).Equal(t)
+}
+
+// parseByteRange reads a single "bytes=" range of content of size bytes.
+// length is -1 when the whole content is sent instead (several ranges, other
+// units), ok is false when the range can't be satisfied.
+func parseByteRange(header string, size int64) (start, length int64, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, -1, true
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, -1, true
+	}
+	if first == "" {
+		// the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false
+		}
+		n = min(n, size)
+		return size - n, n, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+	return start, end - start + 1, true
+}
+
+// readSeekCloser reads from reader, or content without it, and closes the
+// content when it has Close
+type readSeekCloser struct {
+	content io.ReadSeeker
+	reader  io.Reader
+}
+
+func (r readSeekCloser) Read(p []byte) (int, error) {
+	if r.reader != nil {
+		return r.reader.Read(p)
+	}
+	return r.content.Read(p)
+}
+
+func (r readSeekCloser) Close() error {
+	if closer, ok := r.content.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package simplehttp_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/medatechnology/simplehttp"
+)
+
+// TestServeFileRanges serves a compressible file behind the compression
+// middleware, ranges and full responses must go out uncompressed
+func TestServeFileRanges(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	path := filepath.Join(t.TempDir(), "numbers.txt")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		headers      map[string]string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{
+			name:   "whole file",
+			status: http.StatusOK,
+			body:   content,
+		},
+		{
+			name:         "first bytes",
+			headers:      map[string]string{"Range": "bytes=0-4"},
+			status:       http.StatusPartialContent,
+			body:         "01234",
+			contentRange: "bytes 0-4/10000",
+		},
+		{
+			name:         "open ended",
+			headers:      map[string]string{"Range": "bytes=9995-"},
+			status:       http.StatusPartialContent,
+			body:         "56789",
+			contentRange: "bytes 9995-9999/10000",
+		},
+		{
+			name:         "suffix",
+			headers:      map[string]string{"Range": "bytes=-3"},
+			status:       http.StatusPartialContent,
+			body:         "789",
+			contentRange: "bytes 9997-9999/10000",
+		},
+		{
+			name:         "end past the size",
+			headers:      map[string]string{"Range": "bytes=9998-20000"},
+			status:       http.StatusPartialContent,
+			body:         "89",
+			contentRange: "bytes 9998-9999/10000",
+		},
+		{
+			name:         "not satisfiable",
+			headers:      map[string]string{"Range": "bytes=10000-"},
+			status:       http.StatusRequestedRangeNotSatisfiable,
+			contentRange: "bytes */10000",
+		},
+		{
+			name:    "several ranges get the whole file",
+			headers: map[string]string{"Range": "bytes=0-1,5-6"},
+			status:  http.StatusOK,
+			body:    content,
+		},
+		{
+			name:    "If-Range of another version",
+			headers: map[string]string{"Range": "bytes=0-4", "If-Range": `"other"`},
+			status:  http.StatusOK,
+			body:    content,
+		},
+	}
+
+	for name, newServer := range adapters {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				server := newServer()
+				server.GET("/file", func(c simplehttp.Context) error {
+					return simplehttp.ServeFile(c, path, simplehttp.ServeFileOptions{})
+				}, simplehttp.MiddlewareCompress(simplehttp.CompressionConfig{}))
+
+				req := httptest.NewRequest(http.MethodGet, "/file", nil)
+				req.Header.Set("Accept-Encoding", "gzip")
+				for key, value := range tt.headers {
+					req.Header.Set(key, value)
+				}
+				resp, err := server.(simplehttp.Dispatcher).Dispatch(req)
+				if err != nil {
+					t.Fatalf("dispatch: %v", err)
+				}
+				defer resp.Body.Close()
+				body, _ := io.ReadAll(resp.Body)
+
+				if resp.StatusCode != tt.status {
+					t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+				}
+				if got := resp.Header.Get("Content-Range"); got != tt.contentRange {
+					t.Errorf("Content-Range = %q, want %q", got, tt.contentRange)
+				}
+				if got := resp.Header.Get("Content-Encoding"); got != "" {
+					t.Errorf("Content-Encoding = %q, want none", got)
+				}
+				if tt.body != "" && string(body) != tt.body {
+					t.Errorf("body = %.20q (%d bytes), want %.20q (%d bytes)", body, len(body), tt.body, len(tt.body))
+				}
+			})
+		}
+	}
+}
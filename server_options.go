@@ -0,0 +1,146 @@
+package simplehttp
+
+// ServerOption configures a server at creation, the counterpart of the
+// client's ClientOption:
+//
+//	server := fiber.NewServer(simplehttp.LoadConfig(),
+//		simplehttp.WithMiddleware(simplehttp.MiddlewareRequestID(), simplehttp.MiddlewareRecover()),
+//		simplehttp.WithDebug(),
+//		simplehttp.WithTLS("cert.pem", "key.pem"),
+//		simplehttp.WithInternalAPI("10.0.0.0/8"),
+//	)
+type ServerOption func(*ServerOptions)
+
+// ServerOptions is the result of the ServerOptions of a NewServer, read by
+// the framework adapters
+type ServerOptions struct {
+	// Config of the server, a copy when an option changes it, so the config
+	// passed to NewServer (often DefaultConfig) stays untouched
+	Config *Config
+	// Middleware used by the server, before the internal API
+	Middleware []Middleware
+	// InternalAPI creates the internal API, see CreateInternalAPI
+	InternalAPI      bool
+	InternalAPICIDRs []string
+	InternalAPIAuth  Middleware  // see InternalAPIConfig.Auth
+	InternalAPISLO   *SLOTracker // see InternalAPIConfig.SLO
+	// Jobs mounts the status endpoint of the registry, see WithJobs
+	Jobs *JobRegistry
+
+	copied bool
+}
+
+// ApplyServerOptions returns the options over config, nil means
+// DefaultConfig. Call it first in NewServer and build the server from the
+// returned Config, then call Setup.
+func ApplyServerOptions(config *Config, options ...ServerOption) *ServerOptions {
+	if config == nil {
+		config = DefaultConfig
+	}
+	o := &ServerOptions{Config: config}
+	for _, option := range options {
+		option(o)
+	}
+	return o
+}
+
+// Setup registers the middleware, the stub mode of Config.StubDir, the job
+// status endpoint and the internal API on the new server
+func (o *ServerOptions) Setup(s Server) {
+	if len(o.Middleware) > 0 {
+		s.Use(o.Middleware...)
+	}
+	if o.Jobs != nil {
+		s.Use(o.Jobs.Middleware())
+		o.Jobs.Mount(s)
+	}
+	if o.Config.StubDir != "" {
+		s.Use(MiddlewareStub(StubConfig{
+			Dir:    o.Config.StubDir,
+			Routes: o.Config.StubRoutes,
+			Record: o.Config.StubRecord,
+		}))
+	}
+	if o.InternalAPI {
+		MountInternalAPI(s, InternalAPIConfig{
+			TrustedCIDRs: o.InternalAPICIDRs,
+			Auth:         o.InternalAPIAuth,
+			Logger:       o.Config.Logger,
+			SLO:          o.InternalAPISLO,
+		})
+	}
+}
+
+// config returns the Config to modify, copied on the first call
+func (o *ServerOptions) config() *Config {
+	if !o.copied {
+		copied := *o.Config
+		o.Config = &copied
+		o.copied = true
+	}
+	return o.Config
+}
+
+// WithConfig changes the config of the server, e.g.
+// simplehttp.WithConfig(func(c *simplehttp.Config) { c.Port = "9090" })
+func WithConfig(change func(*Config)) ServerOption {
+	return func(o *ServerOptions) {
+		change(o.config())
+	}
+}
+
+// WithMiddleware uses the middleware on all routes, like server.Use
+func WithMiddleware(middleware ...Middleware) ServerOption {
+	return func(o *ServerOptions) {
+		o.Middleware = append(o.Middleware, middleware...)
+	}
+}
+
+// WithDebug turns on Debug, indented JSON and the framework request logs
+func WithDebug() ServerOption {
+	return func(o *ServerOptions) {
+		o.config().Debug = true
+	}
+}
+
+// WithTLS serves HTTPS with the certificate and key files
+func WithTLS(certFile, keyFile string) ServerOption {
+	return func(o *ServerOptions) {
+		config := o.config()
+		config.TLSCert = certFile
+		config.TLSKey = keyFile
+	}
+}
+
+// WithInternalAPI creates the internal API under PathInternalAPI, reachable
+// from trustedCIDRs, see CreateInternalAPI
+func WithInternalAPI(trustedCIDRs ...string) ServerOption {
+	return func(o *ServerOptions) {
+		o.InternalAPI = true
+		o.InternalAPICIDRs = append(o.InternalAPICIDRs, trustedCIDRs...)
+	}
+}
+
+// WithInternalAPIAuth guards switching middleware through the internal API
+// with auth, e.g. MiddlewareBasicAuth, see InternalAPIConfig
+func WithInternalAPIAuth(auth Middleware) ServerOption {
+	return func(o *ServerOptions) {
+		o.InternalAPIAuth = auth
+	}
+}
+
+// WithSLO serves the report of tracker on the internal API instead of the
+// one of DefaultSLOTracker, see InternalAPIConfig.SLO
+func WithSLO(tracker *SLOTracker) ServerOption {
+	return func(o *ServerOptions) {
+		o.InternalAPISLO = tracker
+	}
+}
+
+// WithJobs mounts the status endpoint of jobs, GET /jobs/:id by default, and
+// makes c.Accepted point to it
+func WithJobs(jobs *JobRegistry) ServerOption {
+	return func(o *ServerOptions) {
+		o.Jobs = jobs
+	}
+}
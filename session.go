@@ -1,5 +1,12 @@
 package simplehttp
 
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
 // Session defines the interface for session management
 type Session interface {
 	Get(key string) interface{}
@@ -10,10 +17,14 @@ type Session interface {
 	Save() error
 }
 
-// MemorySession provides a simple in-memory session implementation
+// MemorySession provides a simple in-memory session implementation, safe
+// for concurrent use
 type MemorySession struct {
+	mu   sync.RWMutex
 	id   string
 	data map[string]interface{}
+	// flashes read per key, dropped on save, see Flashes
+	flashesRead map[string]int
 }
 
 func NewMemorySession(id string) Session {
@@ -24,20 +35,28 @@ func NewMemorySession(id string) Session {
 }
 
 func (s *MemorySession) Get(key string) interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.data[key]
 }
 
 func (s *MemorySession) Set(key string, value interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.data[key] = value
 	return nil
 }
 
 func (s *MemorySession) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	delete(s.data, key)
 	return nil
 }
 
 func (s *MemorySession) Clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.data = make(map[string]interface{})
 	return nil
 }
@@ -47,6 +66,273 @@ func (s *MemorySession) ID() string {
 }
 
 func (s *MemorySession) Save() error {
-	// In memory implementation doesn't need to save
+	// In memory implementation doesn't need to save, only the flashes read
+	// are dropped
+	s.sweepFlashes()
 	return nil
 }
+
+// values returns a copy of the data, for the stores
+func (s *MemorySession) values() map[string]interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data := make(map[string]interface{}, len(s.data))
+	for key, v := range s.data {
+		data[key] = v
+	}
+	return data
+}
+
+func (s *MemorySession) len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.data)
+}
+
+const (
+	DEFAULT_SESSION_COOKIE = "session"
+	DEFAULT_SESSION_TTL    = 24 * time.Hour
+)
+
+var REQUEST_SESSION_STRING string = "session"
+
+// ErrSessionUnsupported is returned by the stores of this package for
+// sessions they didn't create
+var ErrSessionUnsupported = errors.New("session type not supported by the store")
+
+// SessionStore keeps the sessions of MiddlewareSession. The session cookie
+// holds the value returned by Save, the session id for stores keeping the
+// data on the server.
+type SessionStore interface {
+	// Load returns the session of a cookie value, nil when it is unknown or
+	// expired
+	Load(value string) (Session, error)
+	// Save keeps the session for ttl and returns the new cookie value
+	Save(session Session, ttl time.Duration) (string, error)
+}
+
+// SessionDeleter is implemented by the stores keeping the sessions on the
+// server, RegenerateSession drops the old session with it
+type SessionDeleter interface {
+	Delete(value string) error
+}
+
+// SessionConfig configures MiddlewareSession
+type SessionConfig struct {
+	Store      SessionStore  // defaults to a CacheSessionStore on a MemoryCache
+	TTL        time.Duration // idle lifetime, renewed by every request using the session, defaults to 24h
+	CookieName string        // defaults to "session"
+	CookiePath string        // defaults to "/"
+	Secure     bool
+	SameSite   http.SameSite // defaults to Lax
+	// GenerateID makes the ids of new sessions, 192 random bits by default.
+	// Like for CSRFTokenGenerator they must be unguessable.
+	GenerateID IDGenerator
+	Clock      Clock // for the cookie Expires, nil means the one of the server
+	Skipper    Skipper
+}
+
+func MiddlewareSession(config SessionConfig) Middleware {
+	return Skip(WithName("session", Sessions(config)), config.Skipper)
+}
+
+// Sessions loads the session of the request from its cookie on the first
+// SessionFromContext, and saves it after the handler. The response is
+// buffered so the cookie can be refreshed once the handler ran, skip
+// streaming and websocket routes. Concurrent requests of one session
+// don't see each other's changes, the last one saved wins.
+func Sessions(config SessionConfig) MiddlewareFunc {
+	if config.Store == nil {
+		config.Store = NewCacheSessionStore(NewMemoryCache())
+	}
+	if config.TTL == 0 {
+		config.TTL = DEFAULT_SESSION_TTL
+	}
+	if config.CookieName == "" {
+		config.CookieName = DEFAULT_SESSION_COOKIE
+	}
+	if config.CookiePath == "" {
+		config.CookiePath = "/"
+	}
+	if config.SameSite == 0 {
+		config.SameSite = http.SameSiteLaxMode
+	}
+	if config.GenerateID == nil {
+		config.GenerateID = randomToken
+	}
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			state := &sessionState{config: &config}
+			if cookie, err := c.Cookie(config.CookieName); err == nil {
+				state.value = cookie.Value
+			}
+			c.Set(REQUEST_SESSION_STRING, state)
+
+			c.BufferResponse()
+			err := next(c)
+			if saveErr := state.save(c); saveErr != nil && err == nil {
+				c.ResetResponse()
+				err = saveErr
+			}
+			if flushErr := c.FlushResponse(); err == nil {
+				err = flushErr
+			}
+			return err
+		}
+	}
+}
+
+// SessionFromContext returns the session of the request, loading it or
+// starting a new one on the first call. It is nil without MiddlewareSession.
+//
+//	server.Use(simplehttp.MiddlewareSession(simplehttp.SessionConfig{Secure: true}))
+//	server.POST("/cart", func(c simplehttp.Context) error {
+//		session := simplehttp.SessionFromContext(c)
+//		session.Set("cart", cart)
+//		return c.JSON(http.StatusOK, cart)
+//	})
+func SessionFromContext(c Context) Session {
+	state, ok := c.Get(REQUEST_SESSION_STRING).(*sessionState)
+	if !ok {
+		return nil
+	}
+	if state.session == nil {
+		state.load()
+	}
+	return state.session
+}
+
+// RegenerateSession moves the data of the session of the request to a new
+// id and drops the old one from the store. Call it on login, so a session id
+// planted in the browser before (session fixation) is worthless after it.
+// Use the returned session from then on, it is nil without MiddlewareSession.
+func RegenerateSession(c Context) (Session, error) {
+	state, ok := c.Get(REQUEST_SESSION_STRING).(*sessionState)
+	if !ok {
+		return nil, nil
+	}
+	return state.regenerate()
+}
+
+// sessionState is the session of one request, loaded on first use
+type sessionState struct {
+	config   *SessionConfig
+	value    string // of the request cookie
+	session  Session
+	created  bool
+	previous string // cookie value of the session replaced by regenerate
+}
+
+func (s *sessionState) load() {
+	if s.value != "" {
+		session, err := s.config.Store.Load(s.value)
+		if err != nil {
+			NewDefaultLogger().Errorf("session load failed, starting a new one: %v", err)
+		}
+		if session != nil {
+			s.session = session
+			return
+		}
+	}
+	s.session = NewMemorySession(s.config.GenerateID())
+	s.created = true
+}
+
+func (s *sessionState) regenerate() (Session, error) {
+	if s.session == nil {
+		s.load()
+	}
+	current, ok := s.session.(*MemorySession)
+	if !ok {
+		return nil, ErrSessionUnsupported
+	}
+	if !s.created {
+		s.previous = s.value
+	}
+	s.session = &MemorySession{id: s.config.GenerateID(), data: current.values()}
+	s.created = false
+	return s.session, nil
+}
+
+// save stores a used session and refreshes its cookie, a new session
+// nothing was put in is dropped
+func (s *sessionState) save(c Context) error {
+	if s.session == nil {
+		return nil
+	}
+	if m, ok := s.session.(*MemorySession); ok && s.created && m.len() == 0 {
+		return nil
+	}
+	value, err := s.config.Store.Save(s.session, s.config.TTL)
+	if err != nil {
+		return err
+	}
+	if deleter, ok := s.config.Store.(SessionDeleter); ok && s.previous != "" {
+		if err := deleter.Delete(s.previous); err != nil {
+			NewDefaultLogger().Errorf("session delete failed: %v", err)
+		}
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     s.config.CookieName,
+		Value:    value,
+		Path:     s.config.CookiePath,
+		MaxAge:   int(s.config.TTL / time.Second),
+		Expires:  clockFor(c, s.config.Clock).Now().Add(s.config.TTL),
+		HttpOnly: true,
+		Secure:   s.config.Secure,
+		SameSite: s.config.SameSite,
+	})
+	return nil
+}
+
+// CacheSessionStore keeps the data of MemorySessions in a CacheStore, the
+// cookie only holds the session id
+type CacheSessionStore struct {
+	store CacheStore
+}
+
+func NewCacheSessionStore(store CacheStore) *CacheSessionStore {
+	if store == nil {
+		panic("simplehttp: CacheSessionStore store is required")
+	}
+	return &CacheSessionStore{store: store}
+}
+
+func (s *CacheSessionStore) Load(id string) (Session, error) {
+	value, found := s.store.Get(s.key(id))
+	if !found {
+		return nil, nil
+	}
+	data, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	session := &MemorySession{id: id, data: make(map[string]interface{}, len(data))}
+	for key, v := range data {
+		session.data[key] = v
+	}
+	return session, nil
+}
+
+func (s *CacheSessionStore) Save(session Session, ttl time.Duration) (string, error) {
+	m, ok := session.(*MemorySession)
+	if !ok {
+		return "", ErrSessionUnsupported
+	}
+	m.sweepFlashes()
+	// a copy, in memory stores would share the map with the request
+	data := m.values()
+	if err := s.store.Set(s.key(m.id), data, ttl); err != nil {
+		return "", err
+	}
+	return m.id, nil
+}
+
+// Delete implements SessionDeleter
+func (s *CacheSessionStore) Delete(id string) error {
+	return s.store.Delete(s.key(id))
+}
+
+func (s *CacheSessionStore) key(id string) string {
+	return "session:" + id
+}
@@ -0,0 +1,168 @@
+package simplehttp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// DEFAULT_SESSION_COOKIE_MAX_SIZE keeps the cookie under the ~4KB browsers
+// accept, with room for its name and attributes
+const DEFAULT_SESSION_COOKIE_MAX_SIZE = 3800
+
+// ErrSessionTooLarge is returned by CookieSessionStore.Save when the session
+// doesn't fit in a cookie, keep big data server side with CacheSessionStore
+var ErrSessionTooLarge = errors.New("session too large for a cookie")
+
+// CookieSessionConfig configures NewCookieSessionStore
+type CookieSessionConfig struct {
+	// Keys sign the cookies (HMAC-SHA256), required. The first key signs,
+	// all of them are accepted: rotate by putting the new key first, and
+	// drop the old one once the sessions it signed expired.
+	Keys [][]byte
+	// EncryptionKeys encrypt the cookies with AES-GCM (16, 24 or 32 bytes),
+	// rotated like Keys. Without them the data is readable by the client,
+	// but still can't be changed.
+	EncryptionKeys [][]byte
+	MaxSize        int   // of the cookie value, defaults to DEFAULT_SESSION_COOKIE_MAX_SIZE
+	Clock          Clock // expires the sessions, nil means DefaultClock
+}
+
+// CookieSessionStore keeps the whole session in its cookie, signed and
+// optionally encrypted, so no server side store is needed. The values go
+// through JSON: numbers come back as float64 and structs as maps.
+// Sessions can't be revoked before they expire, a copied cookie stays
+// valid until then.
+type CookieSessionStore struct {
+	config CookieSessionConfig
+	aeads  []cipher.AEAD
+}
+
+// cookieSession is the payload of the cookie
+type cookieSession struct {
+	ID      string                 `json:"id"`
+	Expires int64                  `json:"exp"`
+	Data    map[string]interface{} `json:"data"`
+}
+
+func NewCookieSessionStore(config CookieSessionConfig) *CookieSessionStore {
+	if len(config.Keys) == 0 {
+		panic("simplehttp: CookieSessionConfig.Keys is required")
+	}
+	if config.MaxSize == 0 {
+		config.MaxSize = DEFAULT_SESSION_COOKIE_MAX_SIZE
+	}
+	s := &CookieSessionStore{config: config}
+	for _, key := range config.EncryptionKeys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			panic("simplehttp: CookieSessionConfig.EncryptionKeys: " + err.Error())
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			panic("simplehttp: CookieSessionConfig.EncryptionKeys: " + err.Error())
+		}
+		s.aeads = append(s.aeads, aead)
+	}
+	return s
+}
+
+// Load returns the session of a cookie, nil when it was tampered with, is
+// signed by an unknown key or has expired
+func (s *CookieSessionStore) Load(value string) (Session, error) {
+	enc := base64.RawURLEncoding
+	encoded, sig, found := strings.Cut(value, ".")
+	if !found {
+		return nil, nil
+	}
+	payload, err := enc.DecodeString(encoded)
+	if err != nil {
+		return nil, nil
+	}
+	mac, err := enc.DecodeString(sig)
+	if err != nil || !s.verify(payload, mac) {
+		return nil, nil
+	}
+	if len(s.aeads) > 0 {
+		if payload = s.decrypt(payload); payload == nil {
+			return nil, nil
+		}
+	}
+	var stored cookieSession
+	if err := json.Unmarshal(payload, &stored); err != nil {
+		return nil, nil
+	}
+	if clockOr(s.config.Clock).Now().Unix() >= stored.Expires {
+		return nil, nil
+	}
+	session := &MemorySession{id: stored.ID, data: stored.Data}
+	if session.data == nil {
+		session.data = make(map[string]interface{})
+	}
+	return session, nil
+}
+
+// Save returns the cookie value holding the session for ttl
+func (s *CookieSessionStore) Save(session Session, ttl time.Duration) (string, error) {
+	m, ok := session.(*MemorySession)
+	if !ok {
+		return "", ErrSessionUnsupported
+	}
+	m.sweepFlashes()
+	payload, err := json.Marshal(cookieSession{
+		ID:      m.id,
+		Expires: clockOr(s.config.Clock).Now().Add(ttl).Unix(),
+		Data:    m.values(),
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(s.aeads) > 0 {
+		nonce := make([]byte, s.aeads[0].NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		payload = s.aeads[0].Seal(nonce, nonce, payload, nil)
+	}
+	enc := base64.RawURLEncoding
+	value := enc.EncodeToString(payload) + "." + enc.EncodeToString(sign(s.config.Keys[0], payload))
+	if len(value) > s.config.MaxSize {
+		return "", ErrSessionTooLarge
+	}
+	return value, nil
+}
+
+func (s *CookieSessionStore) verify(payload, mac []byte) bool {
+	for _, key := range s.config.Keys {
+		if hmac.Equal(mac, sign(key, payload)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *CookieSessionStore) decrypt(sealed []byte) []byte {
+	for _, aead := range s.aeads {
+		size := aead.NonceSize()
+		if len(sealed) < size {
+			continue
+		}
+		if plain, err := aead.Open(nil, sealed[:size], sealed[size:], nil); err == nil {
+			return plain
+		}
+	}
+	return nil
+}
+
+func sign(key, payload []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(payload)
+	return h.Sum(nil)
+}
@@ -0,0 +1,211 @@
+package simplehttp
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_SESSION_GC_INTERVAL = time.Minute
+	// SESSION_METRIC_ACTIVE is the gauge of the active sessions
+	SESSION_METRIC_ACTIVE = "sessions.active"
+)
+
+// SessionManagerConfig configures NewSessionManager
+type SessionManagerConfig struct {
+	TTL time.Duration // idle lifetime of the sessions, defaults to 24h
+	// GCInterval is the period of the garbage collection of the expired
+	// sessions, defaults to a minute, negative means none
+	GCInterval time.Duration
+	GenerateID IDGenerator     // ids of the sessions of New, 192 random bits by default
+	Metrics    MetricsRecorder // receives the sessions.active gauge, e.g. Config.Metrics
+	Clock      Clock           // nil means DefaultClock
+}
+
+// SessionStats are the counters of a SessionManager
+type SessionStats struct {
+	Active  int   `json:"active"`
+	Created int64 `json:"created"`
+	Expired int64 `json:"expired"`
+}
+
+// SessionManager keeps MemorySessions in process with an idle TTL, expired
+// sessions are dropped when loaded and by a periodic garbage collection.
+// It is a SessionStore: the requests of one session share it, so their
+// changes are seen by each other right away. Stop the garbage collection
+// of a manager no longer used.
+type SessionManager struct {
+	config   SessionManagerConfig
+	mu       sync.Mutex
+	sessions map[string]*managedSession
+	stats    SessionStats
+	stop     chan struct{}
+	once     sync.Once
+}
+
+type managedSession struct {
+	session *MemorySession
+	expires time.Time
+}
+
+func NewSessionManager(config ...SessionManagerConfig) *SessionManager {
+	var cfg SessionManagerConfig
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+	if cfg.TTL == 0 {
+		cfg.TTL = DEFAULT_SESSION_TTL
+	}
+	if cfg.GCInterval == 0 {
+		cfg.GCInterval = DEFAULT_SESSION_GC_INTERVAL
+	}
+	if cfg.GenerateID == nil {
+		cfg.GenerateID = randomToken
+	}
+	m := &SessionManager{
+		config:   cfg,
+		sessions: make(map[string]*managedSession),
+		stop:     make(chan struct{}),
+	}
+	if cfg.GCInterval > 0 {
+		go m.gc(cfg.GCInterval)
+	}
+	return m
+}
+
+// New starts a session, kept for the TTL
+func (m *SessionManager) New() Session {
+	session := NewMemorySession(m.config.GenerateID()).(*MemorySession)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.put(session, m.config.TTL)
+	return session
+}
+
+// Get returns the session called id, nil when unknown or expired. It
+// doesn't renew the TTL, Save does.
+func (m *SessionManager) Get(id string) Session {
+	now := clockOr(m.config.Clock).Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.sessions[id]
+	if !ok {
+		return nil
+	}
+	if now.After(entry.expires) {
+		m.expire(id)
+		return nil
+	}
+	return entry.session
+}
+
+// Load implements SessionStore
+func (m *SessionManager) Load(id string) (Session, error) {
+	if session := m.Get(id); session != nil {
+		return session, nil
+	}
+	return nil, nil
+}
+
+// Save keeps the session for ttl, the TTL of the manager when 0, and
+// implements SessionStore
+func (m *SessionManager) Save(session Session, ttl time.Duration) (string, error) {
+	s, ok := session.(*MemorySession)
+	if !ok {
+		return "", ErrSessionUnsupported
+	}
+	s.sweepFlashes()
+	if ttl == 0 {
+		ttl = m.config.TTL
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.put(s, ttl)
+	return s.id, nil
+}
+
+// Destroy drops a session, e.g. on logout
+func (m *SessionManager) Destroy(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.sessions[id]; ok {
+		delete(m.sessions, id)
+		m.gauge()
+	}
+}
+
+// Delete drops a session and implements SessionDeleter
+func (m *SessionManager) Delete(id string) error {
+	m.Destroy(id)
+	return nil
+}
+
+// Active returns the number of sessions, expired ones not collected yet
+// included
+func (m *SessionManager) Active() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.sessions)
+}
+
+func (m *SessionManager) Stats() SessionStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := m.stats
+	stats.Active = len(m.sessions)
+	return stats
+}
+
+// DeleteExpired drops the expired sessions, run by the garbage collection
+func (m *SessionManager) DeleteExpired() {
+	now := clockOr(m.config.Clock).Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, entry := range m.sessions {
+		if now.After(entry.expires) {
+			m.expire(id)
+		}
+	}
+}
+
+func (m *SessionManager) Stop() {
+	m.once.Do(func() { close(m.stop) })
+}
+
+func (m *SessionManager) gc(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.DeleteExpired()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// put adds or renews a session, called with mu held
+func (m *SessionManager) put(session *MemorySession, ttl time.Duration) {
+	expires := clockOr(m.config.Clock).Now().Add(ttl)
+	if entry, ok := m.sessions[session.id]; ok {
+		entry.session, entry.expires = session, expires
+		return
+	}
+	m.sessions[session.id] = &managedSession{session: session, expires: expires}
+	m.stats.Created++
+	m.gauge()
+}
+
+// expire drops an expired session, called with mu held
+func (m *SessionManager) expire(id string) {
+	delete(m.sessions, id)
+	m.stats.Expired++
+	m.gauge()
+}
+
+func (m *SessionManager) gauge() {
+	if m.config.Metrics != nil {
+		m.config.Metrics.Gauge(SESSION_METRIC_ACTIVE, float64(len(m.sessions)), nil)
+	}
+}
@@ -2,9 +2,22 @@ package simplehttp
 
 import (
 	"context"
+	"crypto/tls"
 	"io"
 	"mime/multipart"
+	"net"
 	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	CONTENT_TYPE_JSON         = "application/json"
+	CONTENT_TYPE_OCTET_STREAM = "application/octet-stream"
+	DEFAULT_JSON_INDENT       = "  "
+
+	HEADER_CONTENT_DISPOSITION = "Content-Disposition"
+	HEADER_CONTENT_TYPE        = "Content-Type"
 )
 
 // Context represents our framework-agnostic request context
@@ -14,21 +27,71 @@ type Context interface {
 	GetMethod() string
 	GetHeader(key string) string
 	GetHeaders() *RequestHeader
+	// Connection, honoring the forwarded headers of Config.TrustedProxies.
+	// Protocol is the HTTP version between the server and its peer,
+	// "HTTP/1.1", "HTTP/2.0" or "HTTP/3.0".
+	Scheme() string // "http" or "https"
+	Host() string   // host[:port] the client asked for
+	IsTLS() bool    // the client used https
+	Protocol() string
+	// The connection itself, ignoring the forwarded headers: behind a proxy
+	// the peer is the proxy. TLSConnectionState is nil on plain connections,
+	// it has the TLS version, cipher suite and ALPN protocol. See Connection.
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr // nil when unknown
+	TLSConnectionState() *tls.ConnectionState
+	// Absolute URLs on the scheme and host above, see BuildURL
+	FullURL() string // of this request, with its query
+	BuildURL(path string, params map[string]string, query url.Values) string
 	SetRequestHeader(key, value string)
 	SetResponseHeader(key, value string)
 	SetHeader(key, value string)
+	Cookie(name string) (*http.Cookie, error) // http.ErrNoCookie when missing
+	SetCookie(cookie *http.Cookie)            // adds a Set-Cookie, other cookies are kept
+	GetParam(name string) string              // of the route, ":id" in "/users/:id", unescaped, "" when missing
 	GetQueryParam(key string) string
 	GetQueryParams() map[string][]string
 	GetBody() []byte
+	SetRequestBody(body []byte) // replaces the body seen by the next handlers and binding
 
 	// Added these two methods
 	Request() *http.Request
+	// Response is the same writer for the whole request on every adapter,
+	// changes to its Header() persist like SetResponseHeader does, also
+	// after next and on the error path, until the response is flushed
 	Response() http.ResponseWriter
 
 	// Response methods
 	JSON(code int, data interface{}) error
+	JSONBlob(code int, data []byte) error                        // already serialized JSON, no re-marshaling
+	RawJSON(code int, data string) error                         // same as JSONBlob, for string payloads
+	PrettyJSON(code int, data interface{}) error                 // indented JSON, handy in Debug mode
+	JSONPretty(code int, data interface{}, indent string) error  // indented JSON with custom indent
+	Encode(code int, contentType string, data interface{}) error // uses the codec registry (msgpack, protobuf, ...)
 	String(code int, data string) error
 	Stream(code int, contentType string, reader io.Reader) error
+	Blob(code int, contentType string, data []byte) error
+	Attachment(reader io.Reader, filename string) error // download, Content-Disposition: attachment
+	Inline(reader io.Reader, filename string) error     // displayed in browser, Content-Disposition: inline
+	Accepted(jobID string) error                        // 202 with the status URL of a job, see JobRegistry
+
+	// Response buffering, lets middleware inspect and rewrite what the handler
+	// produced (compression, cache, ...). Fiber and fasthttp always buffer, echo
+	// starts buffering on BufferResponse and sends it on the matching FlushResponse.
+	// ResetResponse drops the status, headers and body written since the
+	// outermost BufferResponse, headers set before it are kept.
+	BufferResponse()
+	FlushResponse() error
+	ResetResponse()
+	GetResponseStatus() int
+	GetResponseHeader(key string) string
+	GetResponseBody() []byte
+	SetResponseBody(body []byte)
+	GetResponseSize() int64 // body bytes written so far, streams count when known
+
+	// Cache directives, read by the cache middleware after the handler ran
+	SetCacheTTL(ttl time.Duration)
+	NoStore()
 
 	// File handling
 	GetFile(fieldName string) (*multipart.FileHeader, error)
@@ -41,6 +104,7 @@ type Context interface {
 	// Context handling
 	Context() context.Context
 	SetContext(ctx context.Context)
+	Deadline() (time.Time, bool) // remaining budget set by MiddlewareTimeout, if any
 	Set(key string, value interface{})
 	Get(key string) interface{}
 
@@ -48,6 +112,8 @@ type Context interface {
 	Bind(interface{}) error // Generic binding based on Content-Type
 	BindJSON(interface{}) error
 	BindForm(interface{}) error
+	BindCodec(interface{}) error       // Decode body with the codec registered for Content-Type
+	BindAndValidate(interface{}) error // Bind then run Config.Validator
 }
 
 // Websocket interface for websocket connections
@@ -73,13 +139,15 @@ type Middleware interface {
 
 // Router interface defines common routing operations
 type Router interface {
-	GET(path string, handler HandlerFunc)
-	POST(path string, handler HandlerFunc)
-	PUT(path string, handler HandlerFunc)
-	DELETE(path string, handler HandlerFunc)
-	PATCH(path string, handler HandlerFunc)
-	OPTIONS(path string, handler HandlerFunc)
-	HEAD(path string, handler HandlerFunc)
+	// Route middleware runs after the server and group middleware, the first
+	// one is outermost
+	GET(path string, handler HandlerFunc, middleware ...Middleware)
+	POST(path string, handler HandlerFunc, middleware ...Middleware)
+	PUT(path string, handler HandlerFunc, middleware ...Middleware)
+	DELETE(path string, handler HandlerFunc, middleware ...Middleware)
+	PATCH(path string, handler HandlerFunc, middleware ...Middleware)
+	OPTIONS(path string, handler HandlerFunc, middleware ...Middleware)
+	HEAD(path string, handler HandlerFunc, middleware ...Middleware)
 
 	// Static file serving
 	Static(prefix, root string)
@@ -97,6 +165,22 @@ type Server interface {
 	Router
 	Start(address string) error
 	Shutdown(ctx context.Context) error
+	// RedirectHTTP adds a plain HTTP listener on address that redirects to
+	// the HTTPS server, it runs between Start and Shutdown
+	RedirectHTTP(address string)
+}
+
+// RouteInfo is a registered route as listed by ServerInspector
+type RouteInfo struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+}
+
+// ServerInspector is implemented by the servers of the framework packages,
+// the admin UI uses it to list what was registered
+type ServerInspector interface {
+	Routes() []RouteInfo
+	MiddlewareNames() []string
 }
 
 // type newServerFunc func (*MedaConfig) (MedaServer, error)
@@ -0,0 +1,343 @@
+package simplehttp
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/medatechnology/simplehttp/client"
+)
+
+const (
+	DEFAULT_SLO_BUCKET         = 10 * time.Second
+	DEFAULT_SLO_ALERT_BURN     = 14.4 // spends 2% of a 30 day budget in one hour
+	DEFAULT_SLO_ALERT_COOLDOWN = 15 * time.Minute
+	DEFAULT_SLO_MIN_REQUESTS   = 20
+)
+
+var (
+	PathInternalSLO string = "/slo"
+
+	// DefaultSLOWindows are the short and long windows of the burn rate alert
+	DefaultSLOWindows = []time.Duration{5 * time.Minute, time.Hour}
+
+	// DefaultSLOTracker is served at PathInternalSLO by the internal API
+	// unless InternalAPIConfig.SLO names another one
+	DefaultSLOTracker = NewSLOTracker(SLOConfig{})
+)
+
+// SLOObjective is the target of one route group. A request is bad when it
+// fails with a 5xx, or when it is slower than Latency.
+type SLOObjective struct {
+	Name          string
+	Availability  float64       // e.g. 0.999, 0 disables the availability SLO
+	Latency       time.Duration // 0 disables the latency SLO
+	LatencyTarget float64       // fraction of requests faster than Latency, e.g. 0.99
+	// Routes are the route patterns of the objective in the metrics, "*"
+	// ends a prefix as in SkipPaths, e.g. "/api/*". Empty means every route.
+	Routes []string
+}
+
+// SLOConfig configures an SLOTracker
+type SLOConfig struct {
+	// Objectives are fed by the metrics, see SLOTracker.Track
+	Objectives    []SLOObjective
+	Windows       []time.Duration // burn rate windows, defaults to DefaultSLOWindows
+	AlertBurnRate float64         // alert when every window burns faster, default 14.4
+	AlertCooldown time.Duration   // minimum time between alerts of one objective, default 15m
+	// MinRequests every window needs before it can alert, so a handful of
+	// requests at night don't page anyone, default 20
+	MinRequests int64
+	// Metric is the timing of MiddlewareMetrics the tracker reads, default
+	// "http.request.duration", change it along MetricsConfig.Prefix
+	Metric  string
+	Webhook string         // optional, receives the SLOAlert as JSON POST
+	OnAlert func(SLOAlert) // optional, called along the webhook
+}
+
+// SLOBurn is the state of one SLO over one window. A burn rate of 1 spends
+// exactly the error budget over the SLO period, above 1 spends it faster.
+type SLOBurn struct {
+	Window   time.Duration `json:"window"`
+	Requests int64         `json:"requests"`
+	Bad      int64         `json:"bad"`
+	BurnRate float64       `json:"burn_rate"`
+}
+
+// SLOStatus is the report of one objective
+type SLOStatus struct {
+	Name         string    `json:"name"`
+	Availability []SLOBurn `json:"availability,omitempty"`
+	Latency      []SLOBurn `json:"latency,omitempty"`
+}
+
+// SLOAlert is sent when an objective burns its budget too fast
+type SLOAlert struct {
+	Name     string    `json:"name"`
+	SLO      string    `json:"slo"` // "availability" or "latency"
+	BurnRate float64   `json:"burn_rate"`
+	Windows  []SLOBurn `json:"windows"`
+	Time     time.Time `json:"time"`
+}
+
+// SLOTracker counts good and bad requests per objective in time buckets and
+// computes burn rates over sliding windows. It is a MetricsRecorder: put it
+// next to the recorder of MiddlewareMetrics and the request durations feed
+// the objectives of Track, by their route tag.
+type SLOTracker struct {
+	config     SLOConfig
+	mu         sync.Mutex
+	objectives map[string]*sloCounter
+	client     *client.Client
+}
+
+type sloBucket struct {
+	start    int64
+	requests int64
+	errors   int64
+	slow     int64
+}
+
+type sloCounter struct {
+	objective SLOObjective
+	match     func(route string) bool // nil when fed by Middleware
+	buckets   []sloBucket
+	lastCheck atomic.Int64
+	lastAlert map[string]time.Time
+}
+
+func NewSLOTracker(config SLOConfig) *SLOTracker {
+	if len(config.Windows) == 0 {
+		config.Windows = DefaultSLOWindows
+	}
+	if config.AlertBurnRate <= 0 {
+		config.AlertBurnRate = DEFAULT_SLO_ALERT_BURN
+	}
+	if config.AlertCooldown == 0 {
+		config.AlertCooldown = DEFAULT_SLO_ALERT_COOLDOWN
+	}
+	if config.MinRequests == 0 {
+		config.MinRequests = DEFAULT_SLO_MIN_REQUESTS
+	}
+	if config.Metric == "" {
+		config.Metric = DEFAULT_METRICS_PREFIX + ".request.duration"
+	}
+	t := &SLOTracker{config: config, objectives: make(map[string]*sloCounter)}
+	if config.Webhook != "" {
+		t.client = client.NewClient(client.WithTimeout(10*time.Second), client.WithMaxRetries(1))
+	}
+	for _, objective := range config.Objectives {
+		t.Track(objective)
+	}
+	return t
+}
+
+// Track adds an objective fed by the metrics stream:
+//
+//	config.Metrics = simplehttp.MultiRecorder(prometheus, simplehttp.DefaultSLOTracker)
+//	server.Use(simplehttp.MiddlewareMetrics(simplehttp.MetricsConfig{Recorder: config.Metrics}))
+//	simplehttp.DefaultSLOTracker.Track(simplehttp.SLOObjective{Name: "api", Availability: 0.999, Routes: []string{"/api/*"}})
+//
+// Don't use Middleware for the same objective, its requests would count twice.
+func (t *SLOTracker) Track(objective SLOObjective) {
+	if objective.Name == "" {
+		panic("simplehttp: SLOObjective.Name is required")
+	}
+	counter := t.counter(objective)
+	match := func(string) bool { return true }
+	if len(objective.Routes) > 0 {
+		match = matchPaths(objective.Routes...)
+	}
+	t.mu.Lock()
+	counter.match = match
+	t.mu.Unlock()
+}
+
+// Timing implements MetricsRecorder, the durations of Metric are recorded
+// for the objectives of Track matching the route tag
+func (t *SLOTracker) Timing(name string, d time.Duration, tags map[string]string) {
+	if name != t.config.Metric {
+		return
+	}
+	status, _ := strconv.Atoi(tags["status"])
+	route := tags["route"]
+	var counters []*sloCounter
+	t.mu.Lock()
+	for _, counter := range t.objectives {
+		if counter.match != nil && counter.match(route) {
+			counters = append(counters, counter)
+		}
+	}
+	t.mu.Unlock()
+	for _, counter := range counters {
+		t.record(counter, status >= http.StatusInternalServerError, d)
+	}
+}
+
+// Count implements MetricsRecorder, the tracker only reads the timings
+func (t *SLOTracker) Count(name string, value int64, tags map[string]string) {}
+
+// Gauge implements MetricsRecorder, the tracker only reads the timings
+func (t *SLOTracker) Gauge(name string, value float64, tags map[string]string) {}
+
+// Middleware tracks the routes it wraps under the objective, use one per
+// route group, when the server has no MiddlewareMetrics to feed Track:
+//
+//	api.Use(tracker.Middleware(simplehttp.SLOObjective{Name: "api", Availability: 0.999}))
+func (t *SLOTracker) Middleware(objective SLOObjective) Middleware {
+	if objective.Name == "" {
+		panic("simplehttp: SLOObjective.Name is required")
+	}
+	counter := t.counter(objective)
+	return WithName("slo "+objective.Name, func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			start := time.Now()
+			err := next(c)
+			t.record(counter, statusOf(c, err) >= http.StatusInternalServerError, time.Since(start))
+			return err
+		}
+	})
+}
+
+// Report returns the burn rates of every objective, sorted by name
+func (t *SLOTracker) Report() []SLOStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]SLOStatus, 0, len(t.objectives))
+	for _, counter := range t.objectives {
+		out = append(out, t.status(counter, time.Now()))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Handler serves the report. The internal API mounts the one of
+// InternalAPIConfig.SLO at PathInternalSLO, use it to serve another.
+func (t *SLOTracker) Handler() HandlerFunc {
+	return func(c Context) error {
+		return c.JSON(http.StatusOK, t.Report())
+	}
+}
+
+func (t *SLOTracker) counter(objective SLOObjective) *sloCounter {
+	longest := t.config.Windows[0]
+	for _, w := range t.config.Windows {
+		longest = max(longest, w)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if counter, ok := t.objectives[objective.Name]; ok {
+		return counter
+	}
+	counter := &sloCounter{
+		objective: objective,
+		buckets:   make([]sloBucket, int(longest/DEFAULT_SLO_BUCKET)+1),
+		lastAlert: make(map[string]time.Time),
+	}
+	t.objectives[objective.Name] = counter
+	return counter
+}
+
+func (t *SLOTracker) record(counter *sloCounter, failed bool, elapsed time.Duration) {
+	now := time.Now()
+	slot := now.UnixNano() / int64(DEFAULT_SLO_BUCKET)
+
+	t.mu.Lock()
+	b := &counter.buckets[slot%int64(len(counter.buckets))]
+	if b.start != slot {
+		*b = sloBucket{start: slot}
+	}
+	b.requests++
+	if failed {
+		b.errors++
+	}
+	if counter.objective.Latency > 0 && elapsed > counter.objective.Latency {
+		b.slow++
+	}
+	t.mu.Unlock()
+
+	// evaluate alerts at most once per bucket, off the request path
+	last := counter.lastCheck.Load()
+	if slot > last && counter.lastCheck.CompareAndSwap(last, slot) {
+		go t.checkAlerts(counter, now)
+	}
+}
+
+func (t *SLOTracker) status(counter *sloCounter, now time.Time) SLOStatus {
+	status := SLOStatus{Name: counter.objective.Name}
+	slot := now.UnixNano() / int64(DEFAULT_SLO_BUCKET)
+	for _, window := range t.config.Windows {
+		oldest := slot - int64(window/DEFAULT_SLO_BUCKET)
+		var requests, errors, slow int64
+		for _, b := range counter.buckets {
+			if b.start > oldest && b.start <= slot {
+				requests += b.requests
+				errors += b.errors
+				slow += b.slow
+			}
+		}
+		if counter.objective.Availability > 0 {
+			status.Availability = append(status.Availability,
+				SLOBurn{Window: window, Requests: requests, Bad: errors, BurnRate: burnRate(errors, requests, counter.objective.Availability)})
+		}
+		if counter.objective.Latency > 0 && counter.objective.LatencyTarget > 0 {
+			status.Latency = append(status.Latency,
+				SLOBurn{Window: window, Requests: requests, Bad: slow, BurnRate: burnRate(slow, requests, counter.objective.LatencyTarget)})
+		}
+	}
+	return status
+}
+
+func (t *SLOTracker) checkAlerts(counter *sloCounter, now time.Time) {
+	if t.config.Webhook == "" && t.config.OnAlert == nil {
+		return
+	}
+	t.mu.Lock()
+	status := t.status(counter, now)
+	var alerts []SLOAlert
+	for slo, burns := range map[string][]SLOBurn{"availability": status.Availability, "latency": status.Latency} {
+		if len(burns) == 0 || now.Sub(counter.lastAlert[slo]) < t.config.AlertCooldown {
+			continue
+		}
+		quiet := false
+		for _, b := range burns {
+			quiet = quiet || b.Requests < t.config.MinRequests
+		}
+		if quiet {
+			continue
+		}
+		// every window must burn too fast: the long one proves it is
+		// significant, the short one that it is still going on
+		lowest := burns[0].BurnRate
+		for _, b := range burns {
+			lowest = min(lowest, b.BurnRate)
+		}
+		if lowest >= t.config.AlertBurnRate {
+			counter.lastAlert[slo] = now
+			alerts = append(alerts, SLOAlert{Name: status.Name, SLO: slo, BurnRate: lowest, Windows: burns, Time: now})
+		}
+	}
+	t.mu.Unlock()
+
+	for _, alert := range alerts {
+		if t.config.OnAlert != nil {
+			t.config.OnAlert(alert)
+		}
+		if t.client != nil {
+			if resp, err := t.client.Request(http.MethodPost, t.config.Webhook, alert); err == nil {
+				resp.Body.Close()
+			}
+		}
+	}
+}
+
+// burnRate is the observed bad fraction divided by the allowed one
+func burnRate(bad, total int64, target float64) float64 {
+	if total == 0 || target >= 1 {
+		return 0
+	}
+	return (float64(bad) / float64(total)) / (1 - target)
+}
@@ -0,0 +1,105 @@
+package simplehttp_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/medatechnology/simplehttp"
+)
+
+// TestSLOFromMetrics feeds the tracker through MiddlewareMetrics and reads
+// the report on the internal API
+func TestSLOFromMetrics(t *testing.T) {
+	for name, newServer := range adapters {
+		t.Run(name, func(t *testing.T) {
+			tracker := simplehttp.NewSLOTracker(simplehttp.SLOConfig{
+				Objectives: []simplehttp.SLOObjective{
+					{Name: "api", Availability: 0.99, Routes: []string{"/api/*"}},
+					{Name: "all", Availability: 0.99},
+				},
+			})
+			server := newServer(simplehttp.WithInternalAPI(), simplehttp.WithSLO(tracker))
+			server.Use(simplehttp.MiddlewareMetrics(simplehttp.MetricsConfig{Recorder: tracker}))
+			server.GET("/api/users/:id", func(c simplehttp.Context) error {
+				if c.GetParam("id") == "0" {
+					return simplehttp.NewError(http.StatusInternalServerError, "boom")
+				}
+				return c.String(http.StatusOK, "ok")
+			})
+			server.GET("/health", func(c simplehttp.Context) error {
+				return c.String(http.StatusOK, "ok")
+			})
+
+			dispatch := func(path string) *http.Response {
+				t.Helper()
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				req.RemoteAddr = "127.0.0.1:1234"
+				resp, err := server.(simplehttp.Dispatcher).Dispatch(req)
+				if err != nil {
+					t.Fatalf("dispatch: %v", err)
+				}
+				return resp
+			}
+			for _, path := range []string{"/api/users/1", "/api/users/0", "/health"} {
+				dispatch(path).Body.Close()
+			}
+
+			resp := dispatch(simplehttp.PathInternalAPI + simplehttp.PathInternalSLO)
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("report: status = %d, want 200", resp.StatusCode)
+			}
+			var report []simplehttp.SLOStatus
+			if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+				t.Fatal(err)
+			}
+			want := map[string][2]int64{"all": {3, 1}, "api": {2, 1}} // requests, bad
+			if len(report) != len(want) {
+				t.Fatalf("report has %d objectives, want %d", len(report), len(want))
+			}
+			for _, status := range report {
+				burn := status.Availability[0]
+				if got := [2]int64{burn.Requests, burn.Bad}; got != want[status.Name] {
+					t.Errorf("%s: requests, bad = %v, want %v", status.Name, got, want[status.Name])
+				}
+			}
+		})
+	}
+}
+
+// TestSLOMinRequests doesn't alert before every window saw MinRequests
+func TestSLOMinRequests(t *testing.T) {
+	tests := []struct {
+		name        string
+		minRequests int64
+		alert       bool
+	}{
+		{name: "default", alert: false},
+		{name: "one", minRequests: 1, alert: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alerts := make(chan simplehttp.SLOAlert, 1)
+			tracker := simplehttp.NewSLOTracker(simplehttp.SLOConfig{
+				MinRequests: tt.minRequests,
+				OnAlert:     func(a simplehttp.SLOAlert) { alerts <- a },
+				Objectives:  []simplehttp.SLOObjective{{Name: "api", Availability: 0.999}},
+			})
+			tracker.Timing("http.request.duration", time.Millisecond, map[string]string{"route": "/api", "status": "500"})
+
+			select {
+			case alert := <-alerts:
+				if !tt.alert {
+					t.Errorf("alerted on one request: %+v", alert)
+				}
+			case <-time.After(200 * time.Millisecond):
+				if tt.alert {
+					t.Error("no alert")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package simplehttp
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	REQUEST_TIMINGS_STRING string = "request_timings"
+	REQUEST_SLOWLOG_STRING string = "request_slowlog"
+
+	// Headers that never show up in the slow request log
+	DefaultRedactHeaders = []string{
+		HEADER_AUTHORIZATION, "Cookie", "Set-Cookie", "Proxy-Authorization",
+		HEADER_API_KEY, HEADER_MEDA_API_KEY, HEADER_PRIVATE_TOKEN,
+	}
+)
+
+const REDACTED = "[REDACTED]"
+
+// SlowLogConfig configures MiddlewareSlowLog. Apply it to the server for a
+// default threshold and to route groups to override it for that group.
+type SlowLogConfig struct {
+	Threshold     time.Duration   // requests taking at least this long are logged
+	SampleRate    float64         // fraction of slow requests logged (0-1), 0 means all
+	RedactHeaders []string        // defaults to DefaultRedactHeaders
+	Sink          SlowRequestSink // defaults to JSON lines on stderr
+	Skipper       Skipper
+}
+
+// SlowRequest is one entry of the slow request log
+type SlowRequest struct {
+	Time      time.Time                `json:"time"`
+	RequestID string                   `json:"request_id,omitempty"`
+	Method    string                   `json:"method"`
+	Path      string                   `json:"path"`
+	Status    int                      `json:"status"`
+	Duration  time.Duration            `json:"duration"`
+	Threshold time.Duration            `json:"threshold"`
+	Timings   map[string]time.Duration `json:"timings,omitempty"`
+	RemoteIP  string                   `json:"remote_ip,omitempty"`
+	Principal interface{}              `json:"principal,omitempty"`
+	Tags      map[string]string        `json:"tags,omitempty"`
+	Headers   map[string]string        `json:"headers,omitempty"`
+	Error     string                   `json:"error,omitempty"`
+}
+
+// SlowRequestSink receives slow request entries, kept apart from the access
+// log so performance regressions are easy to find
+type SlowRequestSink interface {
+	WriteSlowRequest(entry SlowRequest)
+}
+
+// WriterSlowSink writes entries as JSON lines
+type WriterSlowSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterSlowSink(w io.Writer) *WriterSlowSink {
+	return &WriterSlowSink{w: w}
+}
+
+func (s *WriterSlowSink) WriteSlowRequest(entry SlowRequest) {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w.Write(append(b, '\n'))
+}
+
+// LoggerSlowSink writes entries to a Logger as warnings
+type LoggerSlowSink struct {
+	Logger Logger
+}
+
+func (s LoggerSlowSink) WriteSlowRequest(entry SlowRequest) {
+	b, _ := json.Marshal(entry)
+	s.Logger.Warnf("slow request %s %s (%s): %s", entry.Method, entry.Path, entry.Duration, b)
+}
+
+// requestTimings collects the named phases of a request, see StartTiming
+type requestTimings struct {
+	mu     sync.Mutex
+	phases map[string]time.Duration
+}
+
+// AddTiming adds d to the named phase of the request timing breakdown
+func AddTiming(c Context, name string, d time.Duration) {
+	t, ok := c.Get(REQUEST_TIMINGS_STRING).(*requestTimings)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	t.phases[name] += d
+	t.mu.Unlock()
+}
+
+// StartTiming times a phase of the request, call the returned func when done
+//
+//	defer simplehttp.StartTiming(c, "db")()
+func StartTiming(c Context, name string) func() {
+	start := time.Now()
+	return func() {
+		AddTiming(c, name, time.Since(start))
+	}
+}
+
+func MiddlewareSlowLog(config SlowLogConfig) Middleware {
+	return Skip(WithName("slow log", SlowLog(config)), config.Skipper)
+}
+
+// SlowLog logs requests slower than the threshold with their timing breakdown,
+// redacted headers and identity. When nested, the innermost config wins so
+// groups can have their own threshold.
+func SlowLog(config SlowLogConfig) MiddlewareFunc {
+	if config.Sink == nil {
+		config.Sink = NewWriterSlowSink(os.Stderr)
+	}
+	if config.RedactHeaders == nil {
+		config.RedactHeaders = DefaultRedactHeaders
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			_, nested := c.Get(REQUEST_SLOWLOG_STRING).(*SlowLogConfig)
+			c.Set(REQUEST_SLOWLOG_STRING, &config)
+			if nested {
+				return next(c)
+			}
+
+			timings := &requestTimings{phases: make(map[string]time.Duration)}
+			c.Set(REQUEST_TIMINGS_STRING, timings)
+
+			start := time.Now()
+			err := next(c)
+			duration := time.Since(start)
+
+			cfg, _ := c.Get(REQUEST_SLOWLOG_STRING).(*SlowLogConfig)
+			if cfg == nil || duration < cfg.Threshold {
+				return err
+			}
+			if cfg.SampleRate > 0 && cfg.SampleRate < 1 && rand.Float64() >= cfg.SampleRate {
+				return err
+			}
+			cfg.Sink.WriteSlowRequest(buildSlowRequest(c, cfg, timings, start, duration, err))
+			return err
+		}
+	}
+}
+
+func buildSlowRequest(c Context, cfg *SlowLogConfig, timings *requestTimings, start time.Time, duration time.Duration, err error) SlowRequest {
+	entry := SlowRequest{
+		Time:      start,
+		RequestID: RequestIDOf(c),
+		Method:    c.GetMethod(),
+		Path:      c.GetPath(),
+		Status:    c.GetResponseStatus(),
+		Duration:  duration,
+		Threshold: cfg.Threshold,
+		Principal: GetPrincipal(c),
+		Tags:      GetRequestTags(c),
+		Headers:   redactHeaders(c.Request().Header, cfg.RedactHeaders),
+	}
+	if headers := c.GetHeaders(); headers != nil {
+		entry.RemoteIP = StripPort(headers.RemoteIP)
+	}
+	if err != nil {
+		entry.Error = err.Error()
+		var httpErr *SimpleHttpError
+		if errors.As(err, &httpErr) {
+			entry.Status = httpErr.Code
+		}
+	}
+
+	timings.mu.Lock()
+	if len(timings.phases) > 0 {
+		entry.Timings = make(map[string]time.Duration, len(timings.phases))
+		for k, v := range timings.phases {
+			entry.Timings[k] = v
+		}
+	}
+	timings.mu.Unlock()
+	return entry
+}
+
+func redactHeaders(header http.Header, redact []string) map[string]string {
+	out := make(map[string]string, len(header))
+	for k, v := range header {
+		value := strings.Join(v, ", ")
+		for _, r := range redact {
+			if strings.EqualFold(k, r) {
+				value = REDACTED
+				break
+			}
+		}
+		out[k] = value
+	}
+	return out
+}
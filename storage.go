@@ -0,0 +1,141 @@
+package simplehttp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrFileNotFound is returned by a Storage for names it doesn't have
+	ErrFileNotFound = errors.New("file not found")
+	// ErrStorageName is returned for names escaping the storage, e.g. "../x"
+	ErrStorageName = errors.New("invalid file name")
+	// ErrSignedURLUnsupported is returned by SignedURL of storages without
+	// direct downloads, like DiskStorage
+	ErrSignedURLUnsupported = errors.New("signed urls not supported")
+)
+
+// Storage keeps the files of a FileHandler. Names are relative and use /,
+// e.g. "avatars/42.png".
+type Storage interface {
+	// Save stores r as name, info has its Size and ContentType when known
+	// (Size 0 means unknown)
+	Save(ctx context.Context, name string, r io.Reader, info FileInfo) error
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+	// Delete removes name, a missing file is not an error
+	Delete(ctx context.Context, name string) error
+	Stat(ctx context.Context, name string) (FileInfo, error)
+	// SignedURL lets a client download name directly from the storage for
+	// expires
+	SignedURL(ctx context.Context, name string, expires time.Duration) (string, error)
+}
+
+// DiskStorage keeps the files in a directory of the local disk
+type DiskStorage struct {
+	Dir string
+}
+
+func NewDiskStorage(dir string) *DiskStorage {
+	return &DiskStorage{Dir: dir}
+}
+
+// Save writes to a temporary file renamed once complete, readers never see
+// a partial file
+func (s *DiskStorage) Save(ctx context.Context, name string, r io.Reader, info FileInfo) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(tmp, r); err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (s *DiskStorage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return nil, ErrFileNotFound
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrFileNotFound
+	}
+	return file, err
+}
+
+func (s *DiskStorage) Delete(ctx context.Context, name string) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Stat has the content type of the extension, disk files have no other
+func (s *DiskStorage) Stat(ctx context.Context, name string) (FileInfo, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	stat, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && stat.IsDir()) {
+		return FileInfo{}, ErrFileNotFound
+	}
+	if err != nil {
+		return FileInfo{}, err
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = CONTENT_TYPE_OCTET_STREAM
+	}
+	return FileInfo{
+		Filename:     name,
+		Size:         stat.Size(),
+		ContentType:  contentType,
+		LastModified: stat.ModTime(),
+	}, nil
+}
+
+func (s *DiskStorage) SignedURL(ctx context.Context, name string, expires time.Duration) (string, error) {
+	return "", ErrSignedURLUnsupported
+}
+
+// path is the file of name, which must stay in Dir
+func (s *DiskStorage) path(name string) (string, error) {
+	if name == "" || strings.ContainsRune(name, 0) {
+		return "", ErrStorageName
+	}
+	path := filepath.Join(s.Dir, filepath.FromSlash(name))
+	if !withinDir(s.Dir, path) || path == filepath.Clean(s.Dir) {
+		return "", ErrStorageName
+	}
+	return path, nil
+}
@@ -0,0 +1,305 @@
+package simplehttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_S3_REGION = "us-east-1"
+	// MAX_S3_SIGNED_URL_EXPIRY is the longest validity SigV4 allows
+	MAX_S3_SIGNED_URL_EXPIRY = 7 * 24 * time.Hour
+
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+	s3EmptyHash       = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	s3MetaSHA256      = "X-Amz-Meta-Sha256"
+)
+
+// S3Config configures NewS3Storage, it works with any S3 compatible object
+// storage: AWS, MinIO, or GCS with HMAC keys (interoperability mode)
+type S3Config struct {
+	Bucket string // required
+	Region string // defaults to us-east-1
+	// Endpoint defaults to https://s3.<region>.amazonaws.com, e.g.
+	// http://localhost:9000 for MinIO or https://storage.googleapis.com
+	Endpoint     string
+	AccessKey    string // required
+	SecretKey    string // required
+	SessionToken string // of temporary credentials
+	// PathStyle puts the bucket in the path instead of the host name, MinIO
+	// needs it
+	PathStyle bool
+	Prefix    string       // of every object name, e.g. "uploads/"
+	Client    *http.Client // defaults to http.DefaultClient
+	Clock     Clock        // signing time, nil means DefaultClock
+}
+
+// S3Storage keeps the files in an S3 bucket, requests are signed with
+// AWS Signature Version 4
+type S3Storage struct {
+	config   S3Config
+	endpoint *url.URL
+}
+
+func NewS3Storage(config S3Config) *S3Storage {
+	if config.Bucket == "" {
+		panic("simplehttp: S3Config.Bucket is required")
+	}
+	if config.AccessKey == "" || config.SecretKey == "" {
+		panic("simplehttp: S3Config.AccessKey and SecretKey are required")
+	}
+	if config.Region == "" {
+		config.Region = DEFAULT_S3_REGION
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = "https://s3." + config.Region + ".amazonaws.com"
+	}
+	if config.Client == nil {
+		config.Client = http.DefaultClient
+	}
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		panic("simplehttp: S3Config.Endpoint is not a URL: " + config.Endpoint)
+	}
+	return &S3Storage{config: config, endpoint: endpoint}
+}
+
+// Save uploads r without buffering when info.Size is known, the payload is
+// sent unsigned (TLS protects it). info.Hash is kept as metadata for Stat.
+func (s *S3Storage) Save(ctx context.Context, name string, r io.Reader, info FileInfo) error {
+	size := info.Size
+	if size <= 0 {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		r, size = bytes.NewReader(data), int64(len(data))
+	}
+	header := make(http.Header)
+	if info.ContentType != "" {
+		header.Set(HEADER_CONTENT_TYPE, info.ContentType)
+	}
+	if info.Hash != "" {
+		header.Set(s3MetaSHA256, info.Hash)
+	}
+	resp, err := s.do(ctx, http.MethodPut, name, r, size, header)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Storage) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, name, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, name string) error {
+	resp, err := s.do(ctx, http.MethodDelete, name, nil, 0, nil)
+	if err == ErrFileNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Storage) Stat(ctx context.Context, name string) (FileInfo, error) {
+	resp, err := s.do(ctx, http.MethodHead, name, nil, 0, nil)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	resp.Body.Close()
+	info := FileInfo{
+		Filename:    name,
+		Size:        resp.ContentLength,
+		ContentType: resp.Header.Get(HEADER_CONTENT_TYPE),
+		Hash:        resp.Header.Get(s3MetaSHA256),
+	}
+	info.LastModified, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return info, nil
+}
+
+// SignedURL is a presigned GET, valid for at most 7 days
+func (s *S3Storage) SignedURL(ctx context.Context, name string, expires time.Duration) (string, error) {
+	if expires <= 0 || expires > MAX_S3_SIGNED_URL_EXPIRY {
+		return "", fmt.Errorf("signed url expiry must be between 1s and %s", MAX_S3_SIGNED_URL_EXPIRY)
+	}
+	u, err := s.objectURL(name)
+	if err != nil {
+		return "", err
+	}
+	now := clockOr(s.config.Clock).Now().UTC()
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.config.AccessKey+"/"+s.scope(now))
+	query.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires/time.Second)))
+	query.Set("X-Amz-SignedHeaders", "host")
+	if s.config.SessionToken != "" {
+		query.Set("X-Amz-Security-Token", s.config.SessionToken)
+	}
+	header := http.Header{"Host": {u.Host}}
+	signature := s.signature(http.MethodGet, u, query, header, s3UnsignedPayload, now)
+	u.RawQuery = awsQuery(query) + "&X-Amz-Signature=" + signature
+	return u.String(), nil
+}
+
+// do sends a signed request, 404 is ErrFileNotFound and other failures an
+// error with the message of S3
+func (s *S3Storage) do(ctx context.Context, method, name string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	u, err := s.objectURL(name)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	payloadHash := s3EmptyHash
+	if body != nil {
+		req.ContentLength = size
+		payloadHash = s3UnsignedPayload
+	}
+	now := clockOr(s.config.Clock).Now().UTC()
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.config.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.config.SessionToken)
+	}
+	signed := http.Header{"Host": {u.Host}}
+	for key, values := range req.Header {
+		if strings.HasPrefix(strings.ToLower(key), "x-amz-") {
+			signed[key] = values
+		}
+	}
+	signature := s.signature(method, u, nil, signed, payloadHash, now)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.config.AccessKey, s.scope(now), awsSignedHeaders(signed), signature))
+
+	resp, err := s.config.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrFileNotFound
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	return nil, fmt.Errorf("s3 %s %s: %s %s", method, name, resp.Status, strings.TrimSpace(string(message)))
+}
+
+func (s *S3Storage) objectURL(name string) (*url.URL, error) {
+	if name == "" || strings.Contains(name, "..") {
+		return nil, ErrStorageName
+	}
+	u := *s.endpoint
+	key := strings.TrimPrefix(s.config.Prefix+name, "/")
+	if s.config.PathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.config.Bucket + "/" + key
+	} else {
+		u.Host = s.config.Bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key
+	}
+	u.RawPath = awsURIEncode(u.Path, false)
+	return &u, nil
+}
+
+func (s *S3Storage) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.config.Region + "/s3/aws4_request"
+}
+
+// signature of a request, see "Signature Version 4 signing process"
+func (s *S3Storage) signature(method string, u *url.URL, query url.Values, header http.Header, payloadHash string, now time.Time) string {
+	var canonicalHeaders strings.Builder
+	for _, key := range awsHeaderNames(header) {
+		canonicalHeaders.WriteString(key + ":" + strings.TrimSpace(header.Get(key)) + "\n")
+	}
+	canonicalRequest := strings.Join([]string{
+		method,
+		awsURIEncode(u.Path, false),
+		awsQuery(query),
+		canonicalHeaders.String(),
+		awsSignedHeaders(header),
+		payloadHash,
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + s.scope(now) + "\n" + hex.EncodeToString(hash[:])
+
+	key := sign([]byte("AWS4"+s.config.SecretKey), []byte(now.Format("20060102")))
+	key = sign(key, []byte(s.config.Region))
+	key = sign(key, []byte("s3"))
+	key = sign(key, []byte("aws4_request"))
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(stringToSign))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func awsHeaderNames(header http.Header) []string {
+	names := make([]string, 0, len(header))
+	for key := range header {
+		names = append(names, strings.ToLower(key))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func awsSignedHeaders(header http.Header) string {
+	return strings.Join(awsHeaderNames(header), ";")
+}
+
+// awsQuery is the canonical query string, sorted and strictly encoded
+func awsQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, awsURIEncode(key, true)+"="+awsURIEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsURIEncode escapes everything but the unreserved characters of RFC 3986,
+// and / unless encodeSlash
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
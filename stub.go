@@ -0,0 +1,256 @@
+package simplehttp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// HEADER_STUB names the fixture file that answered a stubbed request
+const HEADER_STUB = "X-Simplehttp-Stub"
+
+// StubFixture is a recorded response, the files of StubConfig.Dir hold one
+// fixture or an array of them:
+//
+//	{
+//	  "method": "GET",
+//	  "path": "/users/42",
+//	  "query": {"expand": "orders"},
+//	  "status": 200,
+//	  "headers": {"Content-Type": "application/json"},
+//	  "body": {"id": 42, "name": "Ada"}
+//	}
+type StubFixture struct {
+	Method  string            `json:"method,omitempty"` // empty matches every method
+	Path    string            `json:"path"`             // exact, or a prefix ending with *
+	Query   map[string]string `json:"query,omitempty"`  // parameters the request must have
+	Status  int               `json:"status,omitempty"` // defaults to 200
+	Headers map[string]string `json:"headers,omitempty"`
+	// Body is sent as is, a JSON string is sent unquoted when the
+	// Content-Type isn't JSON
+	Body json.RawMessage `json:"body,omitempty"`
+
+	file string
+}
+
+// StubConfig configures MiddlewareStub
+type StubConfig struct {
+	Dir string // fixture files (*.json), required
+	// Routes are the stubbed routes (SkipPaths patterns), their handlers
+	// never run and requests without a fixture get 404. Empty means the
+	// fixtures answer what they match and the handlers the rest.
+	Routes []string
+	// Record runs the handler when no fixture matches and saves its
+	// response as a new fixture in Dir
+	Record  bool
+	Skipper Skipper
+}
+
+// MiddlewareStub serves responses from fixture files instead of the
+// handlers, so frontends can run against the API without its backends.
+// Servers turn it on with Config.StubDir (SIMPLEHTTP_STUB_DIR).
+func MiddlewareStub(config StubConfig) Middleware {
+	return Skip(WithName("stub", Stub(config)), config.Skipper)
+}
+
+// Stub loads the fixtures of config.Dir when called, a fixture that can't
+// be read is a configuration error and panics
+func Stub(config StubConfig) MiddlewareFunc {
+	if config.Dir == "" {
+		panic("simplehttp: StubConfig.Dir is required")
+	}
+	stubs := &stubFixtures{dir: config.Dir}
+	if err := stubs.load(config.Record); err != nil {
+		panic("simplehttp: stub fixtures: " + err.Error())
+	}
+	stubbed := func(Context) bool { return false }
+	if len(config.Routes) > 0 {
+		stubbed = SkipPaths(config.Routes...)
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			r := c.Request()
+			if fixture := stubs.match(r.Method, r.URL.Path, r.URL.Query()); fixture != nil {
+				return fixture.respond(c)
+			}
+			if config.Record {
+				return stubs.record(c, next)
+			}
+			if stubbed(c) {
+				return NewError(http.StatusNotFound, "no stub for "+r.Method+" "+r.URL.RequestURI())
+			}
+			return next(c)
+		}
+	}
+}
+
+type stubFixtures struct {
+	dir      string
+	mu       sync.RWMutex
+	fixtures []*StubFixture
+}
+
+// load reads every *.json file of the directory, in name order
+func (s *stubFixtures) load(create bool) error {
+	if create {
+		if err := os.MkdirAll(s.dir, 0o755); err != nil {
+			return err
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var fixtures []*StubFixture
+		if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "[") {
+			err = json.Unmarshal(data, &fixtures)
+		} else {
+			var fixture StubFixture
+			err = json.Unmarshal(data, &fixture)
+			fixtures = append(fixtures, &fixture)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		for _, fixture := range fixtures {
+			if fixture.Path == "" {
+				return fmt.Errorf("%s: fixture without path", file)
+			}
+			fixture.file = filepath.Base(file)
+		}
+		s.fixtures = append(s.fixtures, fixtures...)
+	}
+	return nil
+}
+
+// match returns the most specific fixture: exact paths before prefixes,
+// longer prefixes first, then the most query parameters
+func (s *stubFixtures) match(method, path string, query url.Values) *StubFixture {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var best *StubFixture
+	bestPath, bestQuery := -1, -1
+	for _, fixture := range s.fixtures {
+		if fixture.Method != "" && !strings.EqualFold(fixture.Method, method) {
+			continue
+		}
+		pathScore := -1
+		if prefix, ok := strings.CutSuffix(fixture.Path, "*"); ok {
+			if strings.HasPrefix(path, prefix) {
+				pathScore = len(prefix)
+			}
+		} else if fixture.Path == path {
+			pathScore = len(path) + 1
+		}
+		if pathScore < 0 || !stubQueryMatches(fixture.Query, query) {
+			continue
+		}
+		if pathScore > bestPath || (pathScore == bestPath && len(fixture.Query) > bestQuery) {
+			best, bestPath, bestQuery = fixture, pathScore, len(fixture.Query)
+		}
+	}
+	return best
+}
+
+func stubQueryMatches(want map[string]string, query url.Values) bool {
+	for key, value := range want {
+		if !query.Has(key) || query.Get(key) != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *StubFixture) respond(c Context) error {
+	status := f.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	contentType := CONTENT_TYPE_JSON
+	for key, value := range f.Headers {
+		if strings.EqualFold(key, HEADER_CONTENT_TYPE) {
+			contentType = value
+			continue
+		}
+		c.SetResponseHeader(key, value)
+	}
+	c.SetResponseHeader(HEADER_STUB, f.file)
+	body := []byte(f.Body)
+	var text string
+	if !strings.Contains(contentType, "json") && json.Unmarshal(f.Body, &text) == nil {
+		body = []byte(text)
+	}
+	return c.Blob(status, contentType, body)
+}
+
+// record runs the handler and saves its response as a fixture
+func (s *stubFixtures) record(c Context, next HandlerFunc) error {
+	c.BufferResponse()
+	if err := next(c); err != nil {
+		c.FlushResponse()
+		return err
+	}
+	r := c.Request()
+	fixture := &StubFixture{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Status: c.GetResponseStatus(),
+	}
+	for key := range r.URL.Query() {
+		if fixture.Query == nil {
+			fixture.Query = make(map[string]string)
+		}
+		fixture.Query[key] = r.URL.Query().Get(key)
+	}
+	contentType := c.GetResponseHeader(HEADER_CONTENT_TYPE)
+	if contentType != "" {
+		fixture.Headers = map[string]string{HEADER_CONTENT_TYPE: contentType}
+	}
+	body := c.GetResponseBody()
+	if strings.Contains(contentType, "json") && json.Valid(body) {
+		fixture.Body = body
+	} else if len(body) > 0 {
+		fixture.Body, _ = json.Marshal(string(body))
+	}
+	if err := s.save(fixture); err != nil {
+		NewDefaultLogger().Errorf("stub record of %s %s failed: %v", r.Method, r.URL.RequestURI(), err)
+	}
+	return c.FlushResponse()
+}
+
+func (s *stubFixtures) save(fixture *StubFixture) error {
+	name := strings.ToLower(fixture.Method) + strings.ReplaceAll(fixture.Path, "/", "_")
+	if len(fixture.Query) > 0 {
+		// the map is encoded with sorted keys
+		query, _ := json.Marshal(fixture.Query)
+		sum := sha256.Sum256(query)
+		name += "-" + hex.EncodeToString(sum[:4])
+	}
+	fixture.file = name + ".json"
+	data, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, fixture.file), data, 0o644); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.fixtures = append(s.fixtures, fixture)
+	s.mu.Unlock()
+	return nil
+}
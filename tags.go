@@ -0,0 +1,167 @@
+package simplehttp
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var REQUEST_TAGS_STRING string = "request_tags"
+
+// TagConfig labels requests (team, product, ...) for cost attribution
+type TagConfig struct {
+	// Static tags, usually set per route group, e.g. {"team": "payments"}
+	Tags map[string]string
+	// TagFunc returns extra tags for the request, e.g. looked up by API key.
+	// They override the static ones.
+	TagFunc func(c Context) map[string]string
+	// Stats aggregates the usage per tag set, defaults to DefaultTagStats
+	Stats   *TagStats
+	Skipper Skipper
+}
+
+// TagUsage is the aggregated usage of one tag set
+type TagUsage struct {
+	Tags        map[string]string `json:"tags"`
+	Requests    int64             `json:"requests"`
+	BytesIn     int64             `json:"bytes_in"`
+	BytesOut    int64             `json:"bytes_out"`
+	ComputeTime time.Duration     `json:"compute_time"`
+}
+
+// TagStats keeps per tag set counters, safe for concurrent use
+type TagStats struct {
+	mu    sync.Mutex
+	usage map[string]*TagUsage
+}
+
+// DefaultTagStats is used when TagConfig.Stats is nil
+var DefaultTagStats = NewTagStats()
+
+func NewTagStats() *TagStats {
+	return &TagStats{usage: make(map[string]*TagUsage)}
+}
+
+// Record adds one request to the counters of its tag set
+func (s *TagStats) Record(tags map[string]string, bytesIn, bytesOut int64, elapsed time.Duration) {
+	key := tagKey(tags)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.usage[key]
+	if !ok {
+		copied := make(map[string]string, len(tags))
+		for k, v := range tags {
+			copied[k] = v
+		}
+		u = &TagUsage{Tags: copied}
+		s.usage[key] = u
+	}
+	u.Requests++
+	u.BytesIn += bytesIn
+	u.BytesOut += bytesOut
+	u.ComputeTime += elapsed
+}
+
+// Snapshot returns a copy of the counters keyed by "k1=v1,k2=v2" (sorted)
+func (s *TagStats) Snapshot() map[string]TagUsage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]TagUsage, len(s.usage))
+	for k, u := range s.usage {
+		out[k] = *u
+	}
+	return out
+}
+
+// Reset clears the counters, e.g. after each chargeback report
+func (s *TagStats) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.usage = make(map[string]*TagUsage)
+}
+
+func MiddlewareRequestTags(config TagConfig) Middleware {
+	return Skip(WithName("request tags", RequestTags(config)), config.Skipper)
+}
+
+// RequestTags attaches tags to the request and records requests, bytes and
+// compute time per tag set. It can be nested (server, group, route): inner
+// layers add their tags to the same set and only the outermost one records.
+func RequestTags(config TagConfig) MiddlewareFunc {
+	stats := config.Stats
+	if stats == nil {
+		stats = DefaultTagStats
+	}
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			tags, nested := c.Get(REQUEST_TAGS_STRING).(map[string]string)
+			if !nested {
+				tags = make(map[string]string)
+				c.Set(REQUEST_TAGS_STRING, tags)
+			}
+			for k, v := range config.Tags {
+				tags[k] = v
+			}
+			if config.TagFunc != nil {
+				for k, v := range config.TagFunc(c) {
+					tags[k] = v
+				}
+			}
+			if nested {
+				return next(c)
+			}
+
+			start := time.Now()
+			err := next(c)
+			stats.Record(tags, contentLength(c.GetHeader("Content-Length")), responseSize(c), time.Since(start))
+			return err
+		}
+	}
+}
+
+// GetRequestTags returns the tags set by RequestTags, nil if there are none
+func GetRequestTags(c Context) map[string]string {
+	tags, _ := c.Get(REQUEST_TAGS_STRING).(map[string]string)
+	return tags
+}
+
+// APIKeyTags returns a TagFunc that maps the API key of the request (API_KEY
+// or MEDA_API_KEY header) to its tags
+func APIKeyTags(keys map[string]map[string]string) func(c Context) map[string]string {
+	return func(c Context) map[string]string {
+		headers := c.GetHeaders()
+		if tags, ok := keys[headers.APIKey]; ok && headers.APIKey != "" {
+			return tags
+		}
+		if tags, ok := keys[headers.MedaAPIKey]; ok && headers.MedaAPIKey != "" {
+			return tags
+		}
+		return nil
+	}
+}
+
+func tagKey(tags map[string]string) string {
+	parts := make([]string, 0, len(tags))
+	for k, v := range tags {
+		parts = append(parts, k+"="+v)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func contentLength(value string) int64 {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+func responseSize(c Context) int64 {
+	if n := contentLength(c.GetResponseHeader("Content-Length")); n > 0 {
+		return n
+	}
+	return c.GetResponseSize()
+}
@@ -0,0 +1,238 @@
+package simplehttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+const HEADER_TRUNCATED = "X-Truncated"
+
+var REQUEST_TIER_STRING string = "tier"
+
+// TierPolicy shapes the responses of the API keys of a tier. MaxItems runs
+// first, then Fields, then Response.
+type TierPolicy struct {
+	Headers map[string]string // set on every response, e.g. {"X-Plan": "free"}
+	// MaxItems truncates the array at ItemsPath (a dotted path, empty for a
+	// top level array), truncated responses get X-Truncated: true. 0 keeps
+	// every item.
+	MaxItems  int
+	ItemsPath string
+	// Fields are the only fields kept, dotted paths as in FieldMapping,
+	// empty keeps them all
+	Fields   []string
+	Response FieldMapping
+}
+
+// Tiered is implemented by principals that know their tier
+type Tiered interface {
+	Tier() string
+}
+
+// TierConfig configures MiddlewareTiers
+type TierConfig struct {
+	Tiers map[string]TierPolicy // required, by tier name
+	// Keys maps identities, the API key principal or the "sub" claim, to
+	// their tier when the principal isn't Tiered
+	Keys map[string]string
+	// Resolver replaces the lookup of the tier through Tiered and Keys
+	Resolver func(c Context) string
+	Default  string // tier of the requests without one, empty passes them through
+	// Paths scopes the middleware, exact paths or prefixes ending with "*"
+	// as in SkipPaths, empty means every request
+	Paths   []string
+	Skipper Skipper
+}
+
+// MiddlewareTiers shapes JSON responses by the tier of the API key, so plans
+// are enforced in one place instead of in every handler. It runs after the
+// authentication middleware:
+//
+//	api.Use(simplehttp.MiddlewareAPIKey(apiKeyConfig))
+//	api.Use(simplehttp.MiddlewareTiers(simplehttp.TierConfig{
+//		Tiers: map[string]simplehttp.TierPolicy{
+//			"free":    {MaxItems: 10, ItemsPath: "results", Response: simplehttp.FieldMapping{Remove: []string{"results.score"}}},
+//			"premium": {Headers: map[string]string{"X-Plan": "premium"}},
+//		},
+//		Default: "free",
+//	}))
+func MiddlewareTiers(config TierConfig) Middleware {
+	var outOfScope Skipper
+	if len(config.Paths) > 0 {
+		inScope := SkipPaths(config.Paths...)
+		outOfScope = func(c Context) bool { return !inScope(c) }
+	}
+	return Skip(WithName("tiers", Tiers(config)), config.Skipper, outOfScope)
+}
+
+// Tiers applies the policy of the tier of the request to successful JSON
+// responses. It fails closed: with a policy shaping the body, a successful
+// response that isn't JSON or can't be shaped is replaced by a 500. The tier
+// is available to handlers through GetTier.
+func Tiers(config TierConfig) MiddlewareFunc {
+	if len(config.Tiers) == 0 {
+		panic("simplehttp: TierConfig.Tiers is required")
+	}
+	resolve := config.Resolver
+	if resolve == nil {
+		resolve = func(c Context) string {
+			if tiered, ok := GetPrincipal(c).(Tiered); ok {
+				return tiered.Tier()
+			}
+			return config.Keys[requestSubject(c)]
+		}
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			tier := resolve(c)
+			if tier == "" {
+				tier = config.Default
+			}
+			policy, ok := config.Tiers[tier]
+			if !ok {
+				return next(c)
+			}
+			c.Set(REQUEST_TIER_STRING, tier)
+			for key, value := range policy.Headers {
+				c.SetResponseHeader(key, value)
+			}
+			if policy.MaxItems <= 0 && len(policy.Fields) == 0 && policy.Response.empty() {
+				return next(c)
+			}
+
+			c.BufferResponse()
+			err := next(c)
+			status := c.GetResponseStatus()
+			if err == nil && status >= 200 && status < 300 {
+				// fail closed, the tier must not get what it can't be shaped into
+				if shapeErr := shapeResponse(c, policy); shapeErr != nil {
+					c.ResetResponse()
+					err = shapeErr
+				}
+			}
+			if flushErr := c.FlushResponse(); err == nil {
+				err = flushErr
+			}
+			return err
+		}
+	}
+}
+
+// shapeResponse applies policy to the buffered body, an error when it isn't
+// JSON
+func shapeResponse(c Context, policy TierPolicy) error {
+	body := c.GetResponseBody()
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	if !isJSONContentType(c.GetResponseHeader(HEADER_CONTENT_TYPE)) {
+		return NewError(http.StatusInternalServerError, "response can't be shaped for the tier", "not JSON")
+	}
+	shaped, truncated, err := policy.Apply(body)
+	if err != nil {
+		return NewError(http.StatusInternalServerError, "response can't be shaped for the tier", err.Error())
+	}
+	c.SetResponseBody(shaped)
+	if truncated {
+		c.SetResponseHeader(HEADER_TRUNCATED, "true")
+	}
+	return nil
+}
+
+// GetTier returns the tier MiddlewareTiers applied to this request
+func GetTier(c Context) string {
+	tier, _ := c.Get(REQUEST_TIER_STRING).(string)
+	return tier
+}
+
+// Apply shapes a JSON document, truncated reports whether MaxItems dropped
+// items. An empty body is returned as is.
+func (p TierPolicy) Apply(body []byte) (shaped []byte, truncated bool, err error) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return body, false, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber() // keep large integers intact
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, false, err
+	}
+
+	if p.MaxItems > 0 {
+		doc, truncated = truncateItems(doc, p.ItemsPath, p.MaxItems)
+	}
+	if len(p.Fields) > 0 {
+		paths := make([][]string, len(p.Fields))
+		for i, field := range p.Fields {
+			paths[i] = strings.Split(field, ".")
+		}
+		doc = pickFields(doc, paths)
+	}
+	if shaped, err = json.Marshal(doc); err != nil {
+		return nil, false, err
+	}
+	if !p.Response.empty() {
+		shaped, err = p.Response.Apply(shaped)
+	}
+	return shaped, truncated, err
+}
+
+// truncateItems cuts the array at the dotted path to max items
+func truncateItems(doc interface{}, path string, max int) (interface{}, bool) {
+	if path == "" {
+		items, ok := doc.([]interface{})
+		if !ok || len(items) <= max {
+			return doc, false
+		}
+		return items[:max], true
+	}
+	truncated := false
+	walkParents(doc, strings.Split(path, "."), func(obj map[string]interface{}, key string) {
+		if items, ok := obj[key].([]interface{}); ok && len(items) > max {
+			obj[key] = items[:max]
+			truncated = true
+		}
+	})
+	return doc, truncated
+}
+
+// pickFields returns a copy of doc with only the fields of paths, arrays on
+// the way are walked element by element
+func pickFields(doc interface{}, paths [][]string) interface{} {
+	switch node := doc.(type) {
+	case []interface{}:
+		picked := make([]interface{}, len(node))
+		for i, item := range node {
+			picked[i] = pickFields(item, paths)
+		}
+		return picked
+	case map[string]interface{}:
+		picked := make(map[string]interface{})
+		children := make(map[string][][]string)
+		for _, path := range paths {
+			value, ok := node[path[0]]
+			if !ok {
+				continue
+			}
+			if len(path) == 1 {
+				picked[path[0]] = value
+				continue
+			}
+			children[path[0]] = append(children[path[0]], path[1:])
+		}
+		for key, childPaths := range children {
+			if _, whole := picked[key]; whole {
+				continue
+			}
+			switch child := node[key].(type) {
+			case map[string]interface{}, []interface{}:
+				picked[key] = pickFields(child, childPaths)
+			}
+		}
+		return picked
+	}
+	return doc
+}
@@ -0,0 +1,154 @@
+package simplehttp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var REQUEST_TIMEOUT_STRING string = "request_timeout"
+
+func MiddlewareTimeout(config TimeOutConfig) Middleware {
+	return WithName("timeout middleware", Timeout(config))
+}
+
+// Timeout middleware adds a timeout to the request context
+func Timeout(config TimeOutConfig) MiddlewareFunc {
+	return timeout(config.ReadTimeout)
+}
+
+// WithTimeout overrides the timeout of a group or route, it can shorten or
+// extend the one of an outer MiddlewareTimeout and is measured from the start
+// of the request. Without an outer timeout it works as one.
+//
+//	server.Use(simplehttp.MiddlewareTimeout(timeoutConfig))
+//	server.POST("/reports", buildReport, simplehttp.WithTimeout(2*time.Minute))
+func WithTimeout(d time.Duration) Middleware {
+	return WithName("timeout "+d.String(), timeout(d))
+}
+
+// timeout runs the handler on the request goroutine with a context that is
+// cancelled at the deadline, a handler can't outlive the request and write
+// to a response that was already sent. The response is buffered and dropped
+// when the deadline passed, the client gets a 504 instead. Handlers that
+// ignore c.Context() delay the 504 until they return.
+//
+// Streaming requests, websocket upgrades and text/event-stream, run without
+// the timeout, their responses are neither held in memory nor cut off.
+func timeout(d time.Duration) MiddlewareFunc {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			if IsStreamingRequest(c) {
+				return next(c)
+			}
+			if outer, ok := c.Get(REQUEST_TIMEOUT_STRING).(*requestTimeout); ok {
+				outer.reset(d)
+				return next(c)
+			}
+
+			ctx := newRequestTimeout(c.Context(), d)
+			defer ctx.stop()
+
+			c.Set(REQUEST_TIMEOUT_STRING, ctx)
+			c.SetContext(ctx)
+			defer cancelBodyRead(c, ctx)()
+
+			c.BufferResponse()
+			err := next(c)
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				c.ResetResponse()
+				c.FlushResponse()
+				return NewError(http.StatusGatewayTimeout, "request timeout")
+			}
+			if flushErr := c.FlushResponse(); err == nil {
+				err = flushErr
+			}
+			return err
+		}
+	}
+}
+
+// requestTimeout is the request context of the timeout middleware, unlike
+// context.WithTimeout its deadline can be moved by inner WithTimeout
+type requestTimeout struct {
+	context.Context
+	cancel   context.CancelCauseFunc
+	start    time.Time
+	mu       sync.Mutex
+	deadline time.Time
+	timer    *time.Timer
+}
+
+func newRequestTimeout(parent context.Context, d time.Duration) *requestTimeout {
+	ctx, cancel := context.WithCancelCause(parent)
+	t := &requestTimeout{Context: ctx, cancel: cancel, start: time.Now()}
+	t.deadline = t.start.Add(d)
+	t.timer = time.AfterFunc(d, func() { cancel(context.DeadlineExceeded) })
+	return t
+}
+
+func (t *requestTimeout) reset(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Context.Err() != nil {
+		return
+	}
+	t.deadline = t.start.Add(d)
+	t.timer.Reset(time.Until(t.deadline))
+}
+
+func (t *requestTimeout) stop() {
+	t.timer.Stop()
+	t.cancel(nil)
+}
+
+func (t *requestTimeout) Deadline() (time.Time, bool) {
+	t.mu.Lock()
+	deadline := t.deadline
+	t.mu.Unlock()
+	if parent, ok := t.Context.Deadline(); ok && parent.Before(deadline) {
+		return parent, true
+	}
+	return deadline, true
+}
+
+func (t *requestTimeout) Err() error {
+	err := t.Context.Err()
+	if err != nil && errors.Is(context.Cause(t.Context), context.DeadlineExceeded) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// cancelBodyRead makes reads of a streamed request body (echo) fail once ctx
+// is done, a read blocked on a slow client is released through the connection
+// read deadline. Fiber and fasthttp have read the whole body before the
+// handler runs. The returned func disarms it when the handler finished in time.
+func cancelBodyRead(c Context, ctx context.Context) func() bool {
+	r := c.Request()
+	if r == nil || r.Body == nil || r.Body == http.NoBody {
+		return func() bool { return false }
+	}
+	rc := http.NewResponseController(c.Response())
+	r.Body = &contextBody{ctx: ctx, ReadCloser: r.Body}
+	return context.AfterFunc(ctx, func() { rc.SetReadDeadline(time.Now()) })
+}
+
+type contextBody struct {
+	ctx context.Context
+	io.ReadCloser
+}
+
+func (b *contextBody) Read(p []byte) (int, error) {
+	if err := b.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && b.ctx.Err() != nil {
+		return n, b.ctx.Err()
+	}
+	return n, err
+}
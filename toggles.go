@@ -0,0 +1,142 @@
+package simplehttp
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// PathInternalMiddleware lists and switches the middleware under
+// PathInternalAPI, see CreateInternalAPI
+var PathInternalMiddleware string = "/middleware"
+
+// ProtectedMiddleware can't be switched off through the internal API, they
+// authenticate and protect the clients. DefaultMiddlewareToggles still
+// switches them from code.
+var ProtectedMiddleware = []string{
+	"internal only", "api key", "basic auth", "oidc", "acl", "authorize", "csrf", "captcha",
+	"ip filter", "brute force", "session", "security", "basic security",
+}
+
+// MiddlewareToggles turns middleware off and on by name at runtime, e.g.
+// the cache during an incident. A switched off middleware passes the
+// requests straight to the next handler, on every route using it. Only
+// NamedMiddleware (all the Middleware* of this package) and the native
+// middleware of the fiber package are switched.
+type MiddlewareToggles struct {
+	// the names switched off, replaced as a whole on every change so the
+	// changes of one Set apply together
+	disabled atomic.Pointer[map[string]bool]
+	mu       sync.Mutex
+}
+
+// DefaultMiddlewareToggles is read by every NamedMiddleware
+var DefaultMiddlewareToggles = &MiddlewareToggles{}
+
+// Enabled reports whether the middleware called name runs
+func (t *MiddlewareToggles) Enabled(name string) bool {
+	disabled := t.disabled.Load()
+	return disabled == nil || !(*disabled)[name]
+}
+
+// Set switches several middleware at once, name to enabled
+func (t *MiddlewareToggles) Set(states map[string]bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	disabled := make(map[string]bool)
+	if current := t.disabled.Load(); current != nil {
+		for name := range *current {
+			disabled[name] = true
+		}
+	}
+	for name, enabled := range states {
+		if enabled {
+			delete(disabled, name)
+		} else {
+			disabled[name] = true
+		}
+	}
+	t.disabled.Store(&disabled)
+}
+
+func (t *MiddlewareToggles) Enable(names ...string) {
+	t.set(names, true)
+}
+
+// Disable switches middleware off, also before they are registered, e.g.
+// to register a debugging middleware to enable during an incident
+func (t *MiddlewareToggles) Disable(names ...string) {
+	t.set(names, false)
+}
+
+func (t *MiddlewareToggles) set(names []string, enabled bool) {
+	states := make(map[string]bool, len(names))
+	for _, name := range names {
+		states[name] = enabled
+	}
+	t.Set(states)
+}
+
+// Disabled returns the names switched off, sorted
+func (t *MiddlewareToggles) Disabled() []string {
+	var names []string
+	if disabled := t.disabled.Load(); disabled != nil {
+		for name := range *disabled {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MiddlewareState is a middleware listed by the internal API
+type MiddlewareState struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+}
+
+// middlewareStates lists the middleware of the server and the ones switched
+// off, which may be used by groups only
+func middlewareStates(s Server, toggles *MiddlewareToggles) []MiddlewareState {
+	seen := make(map[string]bool)
+	var states []MiddlewareState
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			states = append(states, MiddlewareState{Name: name, Enabled: toggles.Enabled(name)})
+		}
+	}
+	if inspector, ok := s.(ServerInspector); ok {
+		for _, name := range inspector.MiddlewareNames() {
+			add(name)
+		}
+	}
+	for _, name := range toggles.Disabled() {
+		add(name)
+	}
+	return states
+}
+
+// middlewareTogglesHandler lists the middleware on GET and switches them on
+// POST of {"name": enabled, ...}, but for ProtectedMiddleware
+func middlewareTogglesHandler(s Server, toggles *MiddlewareToggles, logger Logger) HandlerFunc {
+	return func(c Context) error {
+		if c.GetMethod() == http.MethodPost {
+			var states map[string]bool
+			if err := c.BindJSON(&states); err != nil {
+				return NewError(http.StatusBadRequest, "expected {\"name\": enabled}")
+			}
+			for _, name := range ProtectedMiddleware {
+				if enabled, ok := states[name]; ok && !enabled {
+					return NewError(http.StatusBadRequest, name+" can't be switched off")
+				}
+			}
+			toggles.Set(states)
+			logger.Infof("middleware switched by %s: %v", c.GetHeaders().IP(), states)
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"middleware": middlewareStates(s, toggles),
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package simplehttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// FieldMapping rewrites the fields of a JSON body. Fields are dotted paths
+// into nested objects ("user.name"), arrays on the way are walked element by
+// element, a top level array is mapped per element. Remove runs first, then
+// Rename, then Add.
+type FieldMapping struct {
+	Remove []string
+	Rename map[string]string      // old path to new path
+	Add    map[string]interface{} // set, overwriting existing values
+}
+
+// TransformConfig holds the mappings of MiddlewareTransform
+type TransformConfig struct {
+	// Paths scopes the middleware, exact paths or prefixes ending with "*"
+	// as in SkipPaths, empty means every request
+	Paths    []string
+	Request  FieldMapping // applied to JSON request bodies before the handler
+	Response FieldMapping // applied to JSON responses of the handler
+	Skipper  Skipper
+}
+
+// MiddlewareTransform maps JSON fields of requests and responses, e.g. to
+// give a legacy handler or backend the field names of the public API:
+//
+//	legacy.Use(simplehttp.MiddlewareTransform(simplehttp.TransformConfig{
+//		Paths:    []string{"/v2/users*"},
+//		Request:  simplehttp.FieldMapping{Rename: map[string]string{"email": "mail"}},
+//		Response: simplehttp.FieldMapping{Rename: map[string]string{"mail": "email"}, Remove: []string{"password_hash"}},
+//	}))
+func MiddlewareTransform(config TransformConfig) Middleware {
+	var outOfScope Skipper
+	if len(config.Paths) > 0 {
+		inScope := SkipPaths(config.Paths...)
+		outOfScope = func(c Context) bool { return !inScope(c) }
+	}
+	return Skip(WithName("transform", Transform(config)), config.Skipper, outOfScope)
+}
+
+// Transform applies the request and response mappings, bodies that are not
+// JSON are passed through
+func Transform(config TransformConfig) MiddlewareFunc {
+	mapRequest := !config.Request.empty()
+	mapResponse := !config.Response.empty()
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(c Context) error {
+			if mapRequest && isJSONContentType(c.GetHeader(HEADER_CONTENT_TYPE)) {
+				mapped, err := config.Request.Apply(c.GetBody())
+				if err != nil {
+					return NewError(http.StatusBadRequest, "invalid JSON body", err.Error())
+				}
+				c.SetRequestBody(mapped)
+			}
+			if !mapResponse {
+				return next(c)
+			}
+
+			c.BufferResponse()
+			err := next(c)
+			if err == nil && isJSONContentType(c.GetResponseHeader(HEADER_CONTENT_TYPE)) {
+				if mapped, mapErr := config.Response.Apply(c.GetResponseBody()); mapErr == nil {
+					c.SetResponseBody(mapped)
+				}
+			}
+			if flushErr := c.FlushResponse(); err == nil {
+				err = flushErr
+			}
+			return err
+		}
+	}
+}
+
+func (m FieldMapping) empty() bool {
+	return len(m.Remove) == 0 && len(m.Rename) == 0 && len(m.Add) == 0
+}
+
+// Apply maps a JSON document, an empty body is returned as is
+func (m FieldMapping) Apply(body []byte) ([]byte, error) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return body, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber() // keep large integers intact
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	for _, path := range m.Remove {
+		removeField(doc, strings.Split(path, "."))
+	}
+	for from, to := range m.Rename {
+		renameField(doc, strings.Split(from, "."), strings.Split(to, "."))
+	}
+	for path, v := range m.Add {
+		setField(doc, strings.Split(path, "."), v)
+	}
+	return json.Marshal(doc)
+}
+
+func removeField(doc interface{}, path []string) {
+	walkParents(doc, path, func(obj map[string]interface{}, key string) {
+		delete(obj, key)
+	})
+}
+
+// renameField moves the field within the object where both paths part, so
+// "items.price" to "items.cost" renames the field in every item
+func renameField(doc interface{}, from, to []string) {
+	switch node := doc.(type) {
+	case []interface{}:
+		for _, item := range node {
+			renameField(item, from, to)
+		}
+	case map[string]interface{}:
+		if len(from) > 1 && len(to) > 1 && from[0] == to[0] {
+			if child, ok := node[from[0]]; ok {
+				renameField(child, from[1:], to[1:])
+			}
+			return
+		}
+		switch values := takeField(node, from); len(values) {
+		case 0:
+		case 1:
+			setField(node, to, values[0])
+		default:
+			setField(node, to, values) // the field was collected from an array
+		}
+	}
+}
+
+// takeField removes the field and returns its values, one per object found
+func takeField(doc interface{}, path []string) []interface{} {
+	var values []interface{}
+	walkParents(doc, path, func(obj map[string]interface{}, key string) {
+		if v, ok := obj[key]; ok {
+			values = append(values, v)
+			delete(obj, key)
+		}
+	})
+	return values
+}
+
+// setField sets the field on every object the parent path leads to, missing
+// objects on the way are created
+func setField(doc interface{}, path []string, value interface{}) {
+	switch node := doc.(type) {
+	case []interface{}:
+		for _, item := range node {
+			setField(item, path, value)
+		}
+	case map[string]interface{}:
+		if len(path) == 1 {
+			node[path[0]] = value
+			return
+		}
+		child, ok := node[path[0]]
+		if !ok {
+			child = make(map[string]interface{})
+			node[path[0]] = child
+		}
+		setField(child, path[1:], value)
+	}
+}
+
+// walkParents calls fn with every object holding the last path element
+func walkParents(doc interface{}, path []string, fn func(obj map[string]interface{}, key string)) {
+	switch node := doc.(type) {
+	case []interface{}:
+		for _, item := range node {
+			walkParents(item, path, fn)
+		}
+	case map[string]interface{}:
+		if len(path) == 1 {
+			fn(node, path[0])
+			return
+		}
+		if child, ok := node[path[0]]; ok {
+			walkParents(child, path[1:], fn)
+		}
+	}
+}
+
+func isJSONContentType(contentType string) bool {
+	mediaType := normalizeContentType(contentType)
+	return mediaType == CONTENT_TYPE_JSON || strings.HasSuffix(mediaType, "+json")
+}
@@ -0,0 +1,266 @@
+package simplehttp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DEFAULT_TUNNEL_PARAM        = "target"
+	DEFAULT_TUNNEL_IDLE_TIMEOUT = 5 * time.Minute
+	DEFAULT_TUNNEL_DIAL_TIMEOUT = 10 * time.Second
+
+	// websocket message types and close codes (RFC 6455)
+	wsBinaryMessage       = 2
+	wsCloseMessage        = 8
+	wsClosePolicyViolated = 1008
+	wsCloseGoingAway      = 1001
+)
+
+var (
+	ErrTunnelQuota = errors.New("tunnel byte quota exceeded")
+	ErrTunnelIdle  = errors.New("tunnel idle timeout")
+)
+
+// TunnelConfig configures TCPTunnel and TCPTunnelWebSocket
+type TunnelConfig struct {
+	// Targets are the only destinations of the tunnel, by name: "host:port"
+	// for TCP or "udp://host:port", e.g. {"redis": "localhost:6379"}
+	Targets map[string]string
+	// Param is the query parameter naming the target, defaults to "target"
+	Param string
+	// MaxBytes per tunnel, both directions together, 0 means no limit
+	MaxBytes int64
+	// IdleTimeout closes tunnels without traffic, defaults to 5m
+	IdleTimeout time.Duration
+	DialTimeout time.Duration // defaults to 10s
+	// Authorize is called before the tunnel opens, on top of the middleware
+	// of the route, e.g. RequireRoles, an error refuses the tunnel
+	Authorize func(c Context, target string) error
+	// AllowedOrigins may open a tunnel from a browser besides the origin of
+	// the server, e.g. "https://console.example.com", "*" allows any. Other
+	// origins get 403, so a page of another site can't use the cookies of
+	// the victim to reach the targets.
+	AllowedOrigins []string
+	// Dial replaces net.Dialer, e.g. for tests or a proxy
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// TCPTunnel opens a tunnel between a WebSocket and an allowlisted TCP (or UDP)
+// destination, for debugging consoles like a Redis CLI in the browser. It is
+// a normal route, so the middleware of the server and the route (auth,
+// sessions, audit) run before the upgrade. The bytes of the binary messages
+// go to the destination and back, with UDP one message is one datagram.
+//
+//	admin.GET("/tunnel", simplehttp.TCPTunnel(simplehttp.TunnelConfig{
+//		Targets:  map[string]string{"redis": "localhost:6379"},
+//		MaxBytes: 10 << 20,
+//	}), simplehttp.RequireRoles("ops"))
+//
+// Browsers from other origins than the server and AllowedOrigins get 403,
+// unknown targets 404 and destinations that can't be reached 502. It is
+// echo only: it needs Context.Upgrade, which fiber and fasthttp don't
+// implement, use TCPTunnelWebSocket on WebSocket routes there.
+func TCPTunnel(config TunnelConfig) HandlerFunc {
+	config = tunnelDefaults(config)
+	return func(c Context) error {
+		if err := checkWebSocketOrigin(c, config.AllowedOrigins); err != nil {
+			return err
+		}
+		target := c.GetQueryParam(config.Param)
+		if config.Authorize != nil {
+			if _, ok := config.Targets[target]; ok {
+				if err := config.Authorize(c, target); err != nil {
+					return err
+				}
+			}
+		}
+		conn, err := dialTunnel(c.Context(), config, target)
+		if err != nil {
+			return err
+		}
+		ws, err := c.Upgrade()
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		// the response is gone with the upgrade, Tunnel logs why it closed
+		Tunnel(ws, conn, config, target)
+		return nil
+	}
+}
+
+// TCPTunnelWebSocket is TCPTunnel to a single target on a WebSocket route,
+// which works on every adapter but doesn't run middleware, nor checks
+// AllowedOrigins:
+//
+//	server.WebSocket("/tunnel/redis", simplehttp.TCPTunnelWebSocket(config, "redis"))
+func TCPTunnelWebSocket(config TunnelConfig, target string) func(Websocket) error {
+	config = tunnelDefaults(config)
+	if _, ok := config.Targets[target]; !ok {
+		panic("simplehttp: tunnel target " + target + " is not in TunnelConfig.Targets")
+	}
+	return func(ws Websocket) error {
+		conn, err := dialTunnel(context.Background(), config, target)
+		if err != nil {
+			ws.WriteMessage(wsCloseMessage, wsClosePayload(wsCloseGoingAway, err.Error()))
+			ws.Close()
+			return err
+		}
+		Tunnel(ws, conn, config, target)
+		return nil
+	}
+}
+
+// Tunnel copies between ws and conn until one of them closes, the quota is
+// used up or the tunnel is idle, then closes both
+func Tunnel(ws Websocket, conn net.Conn, config TunnelConfig, target string) error {
+	config = tunnelDefaults(config)
+	var total, lastActive atomic.Int64
+	lastActive.Store(time.Now().UnixNano())
+	use := func(n int) bool {
+		lastActive.Store(time.Now().UnixNano())
+		return config.MaxBytes <= 0 || total.Add(int64(n)) <= config.MaxBytes
+	}
+
+	done := make(chan error, 2)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		// websocket to destination
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				done <- nil
+				return
+			}
+			if !use(len(data)) {
+				done <- ErrTunnelQuota
+				return
+			}
+			if _, err := conn.Write(data); err != nil {
+				done <- nil
+				return
+			}
+		}
+	}()
+	go func() {
+		// destination to websocket, the only writer until it returns
+		defer wg.Done()
+		buf := make([]byte, 32<<10)
+		for {
+			n, err := conn.Read(buf)
+			if n > 0 {
+				if !use(n) {
+					done <- ErrTunnelQuota
+					return
+				}
+				if ws.WriteMessage(wsBinaryMessage, buf[:n]) != nil {
+					done <- nil
+					return
+				}
+			}
+			if err != nil {
+				done <- nil
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(config.IdleTimeout / 4)
+	defer ticker.Stop()
+	var reason error
+wait:
+	for {
+		select {
+		case reason = <-done:
+			break wait
+		case <-ticker.C:
+			if time.Since(time.Unix(0, lastActive.Load())) >= config.IdleTimeout {
+				reason = ErrTunnelIdle
+				break wait
+			}
+		}
+	}
+
+	conn.Close()
+	wg.Wait()
+	if reason != nil {
+		ws.WriteMessage(wsCloseMessage, wsClosePayload(wsClosePolicyViolated, reason.Error()))
+	}
+	ws.Close()
+	message := "closed"
+	if reason != nil {
+		message = reason.Error()
+	}
+	NewDefaultLogger().Infof("tunnel to %s after %d bytes: %s", target, total.Load(), message)
+	return reason
+}
+
+func tunnelDefaults(config TunnelConfig) TunnelConfig {
+	if config.Param == "" {
+		config.Param = DEFAULT_TUNNEL_PARAM
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = DEFAULT_TUNNEL_IDLE_TIMEOUT
+	}
+	if config.DialTimeout <= 0 {
+		config.DialTimeout = DEFAULT_TUNNEL_DIAL_TIMEOUT
+	}
+	if config.Dial == nil {
+		config.Dial = (&net.Dialer{}).DialContext
+	}
+	return config
+}
+
+func dialTunnel(ctx context.Context, config TunnelConfig, target string) (net.Conn, error) {
+	address, ok := config.Targets[target]
+	if !ok {
+		return nil, NewError(http.StatusNotFound, "unknown tunnel target "+target)
+	}
+	network := "tcp"
+	if rest, ok := strings.CutPrefix(address, "udp://"); ok {
+		network, address = "udp", rest
+	} else {
+		address = strings.TrimPrefix(address, "tcp://")
+	}
+	ctx, cancel := context.WithTimeout(ctx, config.DialTimeout)
+	defer cancel()
+	conn, err := config.Dial(ctx, network, address)
+	if err != nil {
+		return nil, NewError(http.StatusBadGateway, "tunnel target "+target+" unreachable", err.Error())
+	}
+	return conn, nil
+}
+
+// checkWebSocketOrigin refuses cross-site upgrades: the Origin browsers send
+// must be the one of the server or in allowed, "*" allows any. Requests
+// without Origin don't come from a browser page and pass.
+func checkWebSocketOrigin(c Context, allowed []string) error {
+	origin := c.GetHeader("Origin")
+	if origin == "" || strings.EqualFold(origin, c.Scheme()+"://"+c.Host()) {
+		return nil
+	}
+	for _, a := range allowed {
+		if a == "*" || strings.EqualFold(a, origin) {
+			return nil
+		}
+	}
+	return NewError(http.StatusForbidden, "origin not allowed", origin)
+}
+
+// wsClosePayload is the body of a close message, the code then the reason
+func wsClosePayload(code uint16, reason string) []byte {
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	payload := binary.BigEndian.AppendUint16(nil, code)
+	return append(payload, reason...)
+}
@@ -0,0 +1,65 @@
+package simplehttp
+
+import (
+	"net/url"
+	"strings"
+)
+
+// AbsoluteURL joins the scheme and host the client used (see Context.Scheme
+// and Context.Host) with uri, a path with an optional query
+func AbsoluteURL(c Context, uri string) string {
+	if !strings.HasPrefix(uri, "/") {
+		uri = "/" + uri
+	}
+	return c.Scheme() + "://" + c.Host() + uri
+}
+
+// BuildURL returns the absolute URL of a route for Location headers, emails
+// or links in responses. The ":name" and "{name}" segments of path are
+// replaced by the escaped params, query is appended:
+//
+//	simplehttp.BuildURL(c, "/orders/:id/items", map[string]string{"id": "42"}, url.Values{"page": {"2"}})
+//	// https://api.example.com/orders/42/items?page=2
+//
+// Segments without a param are kept as they are. The adapters implement
+// Context.BuildURL with it.
+func BuildURL(c Context, path string, params map[string]string, query url.Values) string {
+	path = ExpandPath(path, params)
+	if len(query) > 0 {
+		sep := "?"
+		if strings.Contains(path, "?") {
+			sep = "&"
+		}
+		path += sep + query.Encode()
+	}
+	return AbsoluteURL(c, path)
+}
+
+// ExpandPath replaces the ":name" and "{name}" segments of a route path by
+// the path-escaped params
+func ExpandPath(path string, params map[string]string) string {
+	if len(params) == 0 {
+		return path
+	}
+	route, rest, _ := strings.Cut(path, "?")
+	segments := strings.Split(route, "/")
+	for i, segment := range segments {
+		var name string
+		switch {
+		case strings.HasPrefix(segment, ":"):
+			name = segment[1:]
+		case strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}"):
+			name = segment[1 : len(segment)-1]
+		default:
+			continue
+		}
+		if value, ok := params[name]; ok {
+			segments[i] = url.PathEscape(value)
+		}
+	}
+	route = strings.Join(segments, "/")
+	if rest != "" {
+		return route + "?" + rest
+	}
+	return route
+}
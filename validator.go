@@ -0,0 +1,126 @@
+package simplehttp
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Validator validates a bound request payload. Set Config.Validator to plug in
+// a different implementation, otherwise go-playground/validator is used.
+type Validator interface {
+	Validate(i interface{}) error
+}
+
+// FieldError describes a single field that failed validation
+type FieldError struct {
+	Field   string `json:"field"`
+	Tag     string `json:"tag,omitempty"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// DefaultValidator wraps go-playground/validator. Field names are reported
+// using the json tag when present.
+type DefaultValidator struct {
+	validate *validator.Validate
+}
+
+var (
+	defaultValidator     Validator
+	defaultValidatorOnce sync.Once
+)
+
+func NewDefaultValidator() *DefaultValidator {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	v.RegisterTagNameFunc(func(fld reflect.StructField) string {
+		name := strings.SplitN(fld.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return fld.Name
+		}
+		return name
+	})
+	return &DefaultValidator{validate: v}
+}
+
+// Engine returns the underlying validator so custom rules can be registered
+func (v *DefaultValidator) Engine() *validator.Validate {
+	return v.validate
+}
+
+func (v *DefaultValidator) Validate(i interface{}) error {
+	return v.validate.Struct(i)
+}
+
+func getDefaultValidator() Validator {
+	defaultValidatorOnce.Do(func() {
+		defaultValidator = NewDefaultValidator()
+	})
+	return defaultValidator
+}
+
+// ValidateStruct runs the configured validator (or the default one) against v
+// and converts failures into a SimpleHttpError with per-field details.
+// Adapters call this from BindAndValidate after binding.
+func ValidateStruct(config *Config, v interface{}) error {
+	val := getDefaultValidator()
+	if config != nil && config.Validator != nil {
+		val = config.Validator
+	}
+
+	err := val.Validate(v)
+	if err == nil {
+		return nil
+	}
+
+	// Custom validators may already return a well formed error
+	var httpErr *SimpleHttpError
+	if errors.As(err, &httpErr) {
+		return httpErr
+	}
+
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		fields := make([]FieldError, 0, len(validationErrs))
+		for _, fe := range validationErrs {
+			fields = append(fields, FieldError{
+				Field:   fe.Field(),
+				Tag:     fe.Tag(),
+				Param:   fe.Param(),
+				Message: fieldErrorMessage(fe),
+			})
+		}
+		return NewError(http.StatusBadRequest, "validation failed", fields)
+	}
+
+	// InvalidValidationError (e.g. non-struct) or a custom validator error
+	return NewError(http.StatusBadRequest, err.Error())
+}
+
+func fieldErrorMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return fe.Field() + " is required"
+	case "email":
+		return fe.Field() + " must be a valid email address"
+	case "min":
+		return fe.Field() + " must be at least " + fe.Param()
+	case "max":
+		return fe.Field() + " must be at most " + fe.Param()
+	case "len":
+		return fe.Field() + " must have length " + fe.Param()
+	case "oneof":
+		return fe.Field() + " must be one of [" + fe.Param() + "]"
+	}
+	if fe.Param() != "" {
+		return fe.Field() + " failed on " + fe.Tag() + "=" + fe.Param()
+	}
+	return fe.Field() + " failed on " + fe.Tag()
+}
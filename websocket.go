@@ -0,0 +1,62 @@
+package simplehttp
+
+import (
+	"sort"
+	"sync"
+)
+
+// WebSocketStats counts websocket connections per route, the framework
+// servers register every WebSocket route with DefaultWebSocketStats
+type WebSocketStats struct {
+	mu     sync.Mutex
+	routes map[string]*WebSocketRoute
+}
+
+// WebSocketRoute is the connection count of one websocket route
+type WebSocketRoute struct {
+	Path  string `json:"path"`
+	Open  int64  `json:"open"`
+	Total int64  `json:"total"`
+}
+
+var DefaultWebSocketStats = NewWebSocketStats()
+
+func NewWebSocketStats() *WebSocketStats {
+	return &WebSocketStats{routes: make(map[string]*WebSocketRoute)}
+}
+
+// Track wraps the handler of the websocket route on path, the connection
+// counts as open until the handler returns
+func (s *WebSocketStats) Track(path string, handler func(Websocket) error) func(Websocket) error {
+	s.mu.Lock()
+	if _, ok := s.routes[path]; !ok {
+		s.routes[path] = &WebSocketRoute{Path: path}
+	}
+	route := s.routes[path]
+	s.mu.Unlock()
+
+	return func(ws Websocket) error {
+		s.mu.Lock()
+		route.Open++
+		route.Total++
+		s.mu.Unlock()
+		defer func() {
+			s.mu.Lock()
+			route.Open--
+			s.mu.Unlock()
+		}()
+		return handler(ws)
+	}
+}
+
+// Snapshot returns the counts of every route, sorted by path
+func (s *WebSocketStats) Snapshot() []WebSocketRoute {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]WebSocketRoute, 0, len(s.routes))
+	for _, route := range s.routes {
+		out = append(out, *route)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}